@@ -0,0 +1,169 @@
+package main
+
+import (
+	"api-service/config"
+	"api-service/db"
+	"api-service/services"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// runCommand runs a maintenance command given on the command line instead of starting the server, and returns the
+// exit code.
+//
+//	api-service audit verify       Check the hash chain of the audit log; exits with 1 if it is broken
+//	api-service config print       Print the effective configuration with secrets redacted; exits with 1 if it is invalid
+//	api-service migrate up         Apply the pending schema migrations
+//	api-service migrate down [N]   Revert the last N applied migrations, 1 by default
+//	api-service migrate redo       Revert the last applied migration and apply it again
+//	api-service migrate status     Print the state of every migration; exits with 1 if one was modified or is unknown
+func runCommand(cfg *config.Config, args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "audit" && args[1] == "verify":
+		return verifyAudit(db.InitDB(cfg.Database.Driver, cfg.Database.URL, cfg.Database.Migrate))
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		return printConfig(cfg)
+	case len(args) >= 2 && args[0] == "migrate":
+		return migrate(cfg, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+const commandUsage = "usage: api-service [flags] [audit verify | config print | migrate up | migrate down [N] | migrate redo | migrate status]"
+
+// printConfig prints the effective configuration, followed by its problems on stderr
+func printConfig(cfg *config.Config) int {
+	cfg.Print(os.Stdout)
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// verifyAudit prints the result of checking the audit chain as JSON
+func verifyAudit(dbConn *gorm.DB) int {
+	auditService := &services.AuditService{DB: dbConn}
+	// The command is run by an operator of the deployment, who sees every organization
+	result, err := auditService.Verify(services.Tenant{CrossTenant: true})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the audit log: %v\n", err)
+		return 2
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(result)
+	if !result.Valid {
+		return 1
+	}
+	return 0
+}
+
+// migrate runs a migrate subcommand against the configured database
+func migrate(cfg *config.Config, args []string) int {
+	steps := 1
+	switch {
+	case len(args) == 1 && (args[0] == "up" || args[0] == "redo" || args[0] == "status"):
+	case len(args) <= 2 && args[0] == "down":
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "migrate down: %q is not a positive number of migrations\n", args[1])
+				return 2
+			}
+			steps = n
+		}
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+	if cfg.Database.Driver == db.DriverMemory {
+		fmt.Fprintln(os.Stderr, "The memory database only exists inside the server, which migrates it at startup")
+		return 2
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	dbConn, err := db.Open(cfg.Database.Driver, cfg.Database.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+		return 2
+	}
+	migrator, err := db.NewMigrator(dbConn, cfg.Database.Driver)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid migrations: %v\n", err)
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		printMigrations("Applied", applied)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("The database is up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down(steps)
+		printMigrations("Reverted", reverted)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revert: %v\n", err)
+			return 1
+		}
+	case "redo":
+		redone, err := migrator.Redo()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to redo: %v\n", err)
+			return 1
+		}
+		printMigrations("Redid", []db.Migration{redone})
+	case "status":
+		return migrationStatus(migrator)
+	}
+	return 0
+}
+
+func printMigrations(verb string, migrations []db.Migration) {
+	for _, m := range migrations {
+		fmt.Printf("%s %d_%s\n", verb, m.Version, m.Name)
+	}
+}
+
+// migrationStatus prints a table of the migrations and their state
+func migrationStatus(migrator *db.Migrator) int {
+	statuses, err := migrator.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the migrations of the database: %v\n", err)
+		return 2
+	}
+
+	code := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, status.State, appliedAt)
+		if status.State == db.MigrationModified || status.State == db.MigrationUnknown {
+			code = 1
+		}
+	}
+	w.Flush()
+	return code
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/**
+The configuration file is decoded with gopkg.in/yaml.v3 or github.com/BurntSushi/toml, then flattened into a map from
+dotted keys ("jwt.key_files") to a string or a []string: a setting is a scalar or a list of scalars, under nested
+mappings or tables. Scalars are kept as text for the setting to parse; YAML integers, floats and booleans are written
+in their canonical form ("0x1F" as "31", "True" as "true"). Lists of mappings, nested lists and TOML dates are
+rejected.
+*/
+
+// readFile - Read a YAML or TOML configuration file, chosen by its extension
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	case ".toml":
+		values, err = parseTOML(data)
+	default:
+		return nil, fmt.Errorf("%s: unsupported configuration file format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseYAML - Flatten a YAML document of nested mappings. An empty document has no values.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	var doc yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]interface{}{}, nil
+		}
+		return nil, err
+	}
+	var extra yaml.Node
+	if err := decoder.Decode(&extra); err == nil {
+		return nil, fmt.Errorf("line %d: only one document is allowed", extra.Line)
+	} else if !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	root := doc.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return values, nil
+	}
+	if err := flattenYAML(values, "", root); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if value == nil {
+			delete(values, key)
+		}
+	}
+	return values, nil
+}
+
+// flattenYAML - Store the scalars and lists of a mapping under their dotted keys
+func flattenYAML(values map[string]interface{}, prefix string, node *yaml.Node) error {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of settings", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := resolveAlias(node.Content[i]), resolveAlias(node.Content[i+1])
+		if keyNode.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: keys must be scalars", keyNode.Line)
+		}
+		if keyNode.Tag == "!!merge" {
+			return fmt.Errorf("line %d: merge keys are not supported", keyNode.Line)
+		}
+		key := prefix + keyNode.Value
+		if err := setOnce(values, key); err != nil {
+			return fmt.Errorf("line %d: %w", keyNode.Line, err)
+		}
+
+		switch valueNode.Kind {
+		case yaml.MappingNode:
+			values[key] = nil // Marks the section as set until parseYAML removes it
+			if err := flattenYAML(values, key+".", valueNode); err != nil {
+				return err
+			}
+		case yaml.SequenceNode:
+			list := []string{}
+			for _, item := range valueNode.Content {
+				item = resolveAlias(item)
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: %s: list items must be scalars", item.Line, key)
+				}
+				text, ok, err := yamlScalar(item)
+				if err != nil {
+					return fmt.Errorf("line %d: %s: %w", item.Line, key, err)
+				}
+				if !ok {
+					return fmt.Errorf("line %d: %s: list items must not be null", item.Line, key)
+				}
+				list = append(list, text)
+			}
+			values[key] = list
+		default:
+			text, ok, err := yamlScalar(valueNode)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", valueNode.Line, key, err)
+			}
+			if ok {
+				values[key] = text
+			}
+		}
+	}
+	return nil
+}
+
+// resolveAlias - Return the node an alias (*name) refers to
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// yamlScalar - Return the text of a scalar, in canonical form for integers, floats and booleans. Null returns false.
+func yamlScalar(node *yaml.Node) (string, bool, error) {
+	switch node.Tag {
+	case "!!null":
+		return "", false, nil
+	case "!!str", "!!timestamp":
+		return node.Value, true, nil
+	case "!!int":
+		var value int64
+		if err := node.Decode(&value); err != nil {
+			return "", false, err
+		}
+		return strconv.FormatInt(value, 10), true, nil
+	case "!!float":
+		var value float64
+		if err := node.Decode(&value); err != nil {
+			return "", false, err
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64), true, nil
+	case "!!bool":
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			return "", false, err
+		}
+		return strconv.FormatBool(value), true, nil
+	default:
+		return "", false, fmt.Errorf("unsupported value of type %s", node.Tag)
+	}
+}
+
+// parseTOML - Flatten a TOML document of nested tables
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	if err := flattenTOML(values, "", doc); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// flattenTOML - Store the values of a table under their dotted keys
+func flattenTOML(values map[string]interface{}, prefix string, table map[string]interface{}) error {
+	for name, value := range table {
+		key := prefix + name
+		if err := setOnce(values, key); err != nil {
+			return err
+		}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if err := flattenTOML(values, key+".", value); err != nil {
+				return err
+			}
+		case []map[string]interface{}:
+			return fmt.Errorf("%s: arrays of tables are not supported", key)
+		case []interface{}:
+			list := []string{}
+			for _, item := range value {
+				text, err := tomlScalar(item)
+				if err != nil {
+					return fmt.Errorf("%s: list items: %w", key, err)
+				}
+				list = append(list, text)
+			}
+			values[key] = list
+		default:
+			text, err := tomlScalar(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			values[key] = text
+		}
+	}
+	return nil
+}
+
+// tomlScalar - Return the text of a string, integer, float or boolean
+func tomlScalar(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case time.Time:
+		return "", fmt.Errorf("dates are not supported")
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// setOnce - Fail if a YAML mapping or TOML table has already set a key, such as a section given twice
+func setOnce(values map[string]interface{}, key string) error {
+	if _, dup := values[key]; dup {
+		return fmt.Errorf("%s is set twice", key)
+	}
+	return nil
+}
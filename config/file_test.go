@@ -0,0 +1,267 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	values, err := parseYAML([]byte(`# Comment
+server:
+  addr: ":9090"   # Quoted, since a plain value may not start with a colon
+  issuer: https://auth.example.com:8443/path#fragment
+jwt:
+  signing_alg: 'ES256'
+  key_files:
+    - /etc/keys/a.pem
+    - "/etc/keys/\u00e9.pem"
+  rotation_interval: ~
+smtp:
+  from: "Name \"Quoted\"\ttab\x21"
+  user: 'it''s'
+email:
+  verification_ttl: &ttl 24h
+password_reset:
+  ttl: *ttl
+login:
+  max_failures: 0x1F
+  lockout: 15m
+rate_limit:
+  enabled: True
+  trusted_proxies: [10.0.0.0/8, "192.168.0.0/16",]
+metrics:
+  path: !!str 404
+policy:
+  weight: 1.50
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"server.addr":                ":9090",
+		"server.issuer":              "https://auth.example.com:8443/path#fragment",
+		"jwt.signing_alg":            "ES256",
+		"jwt.key_files":              []string{"/etc/keys/a.pem", "/etc/keys/é.pem"},
+		"smtp.from":                  "Name \"Quoted\"\ttab!",
+		"smtp.user":                  "it's",
+		"email.verification_ttl":     "24h",
+		"password_reset.ttl":         "24h",
+		"login.max_failures":         "31",
+		"login.lockout":              "15m",
+		"rate_limit.enabled":         "true",
+		"rate_limit.trusted_proxies": []string{"10.0.0.0/8", "192.168.0.0/16"},
+		"metrics.path":               "404",
+		"policy.weight":              "1.5",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("parsed\n%v\nwant\n%v", values, want)
+	}
+}
+
+func TestParseYAMLRejectsUnsupportedDocuments(t *testing.T) {
+	tests := map[string]struct {
+		doc, err string
+	}{
+		"duplicate key":     {"jwt:\n  signing_alg: RS256\n  signing_alg: ES256\n", "set twice"},
+		"duplicate section": {"jwt:\n  signing_alg: RS256\njwt:\n  key_files: []\n", "set twice"},
+		"value and section": {"jwt: RS256\njwt:\n  key_files: []\n", "set twice"},
+		"bad indentation":   {"jwt:\n  signing_alg: RS256\n    key_files: []\n", "line 3"},
+		"tab indentation":   {"jwt:\n\tsigning_alg: RS256\n", "line 2"},
+		"unclosed quote":    {"server:\n  addr: \":8080\n", "line 2"},
+		"two documents":     {"server: {}\n---\nserver: {}\n", "only one document"},
+		"nested list":       {"jwt:\n  key_files: [[a]]\n", "list items must be scalars"},
+		"list of mappings":  {"jwt:\n  key_files:\n    - file: a\n", "list items must be scalars"},
+		"null list item":    {"jwt:\n  key_files: [a, ~]\n", "must not be null"},
+		"binary value":      {"jwt:\n  key: !!binary aGVsbG8=\n", "unsupported value of type !!binary"},
+		"top-level list":    {"- a\n", "expected a mapping"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseYAML([]byte(test.doc))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseYAMLAcceptsEmptyDocuments(t *testing.T) {
+	for _, doc := range []string{"", "# Only a comment\n", "---\n", "~\n"} {
+		values, err := parseYAML([]byte(doc))
+		if err != nil || len(values) != 0 {
+			t.Errorf("%q: %v, %v, want no values", doc, values, err)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML([]byte(`# Comment
+jwt.signing_alg = "ES256" # Dotted keys name a setting of a table
+jwt.key_files = [
+  "/etc/keys/a.pem", # Arrays may span lines
+  '/etc/keys/b.pem',
+]
+
+[server]
+addr = ":9090"#No space is needed before a comment
+issuer = "https://auth.example.com/\u00e9"
+
+[smtp]
+from = "Name \"Quoted\"\ttab"
+password_file = 'C:\secrets\smtp'
+user = """
+multi
+line"""
+
+[login]
+max_failures = 1_000
+lockout_hex = 0x1F
+weight = 1.50
+enabled = true
+
+[ rate_limit . redis ]
+addr = "redis:6379"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"jwt.signing_alg":       "ES256",
+		"jwt.key_files":         []string{"/etc/keys/a.pem", "/etc/keys/b.pem"},
+		"server.addr":           ":9090",
+		"server.issuer":         "https://auth.example.com/é",
+		"smtp.from":             "Name \"Quoted\"\ttab",
+		"smtp.password_file":    `C:\secrets\smtp`,
+		"smtp.user":             "multi\nline",
+		"login.max_failures":    "1000",
+		"login.lockout_hex":     "31",
+		"login.weight":          "1.5",
+		"login.enabled":         "true",
+		"rate_limit.redis.addr": "redis:6379",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("parsed\n%v\nwant\n%v", values, want)
+	}
+}
+
+func TestParseTOMLRejectsInvalidDocuments(t *testing.T) {
+	tests := map[string]struct {
+		doc, err string
+	}{
+		// Invalid TOML that a lenient parser would accept
+		"bare string":        {"[jwt]\nsigning_alg = ES256\n", "line 2"},
+		"go escape":          {"[smtp]\nfrom = \"\\x41\"\n", "line 2"},
+		"doubled quote":      {"[smtp]\nuser = 'it''s'\n", "line 2"},
+		"leading zero":       {"[login]\nmax_failures = 010\n", "line 2"},
+		"duplicate key":      {"[jwt]\nsigning_alg = \"RS256\"\nsigning_alg = \"ES256\"\n", "line 3"},
+		"duplicate table":    {"[jwt]\n[jwt]\n", "line 2"},
+		"unterminated array": {"[jwt]\nkey_files = [\"a\"\n", "line"},
+		// Valid TOML a setting cannot hold
+		"array of tables": {"[[jwt]]\nsigning_alg = \"RS256\"\n", "arrays of tables are not supported"},
+		"date":            {"[login]\nsince = 2024-01-02\n", "dates are not supported"},
+		"nested array":    {"[jwt]\nkey_files = [[\"a\"]]\n", "list items"},
+		"inline table":    {"[jwt]\nkey_files = [{ file = \"a\" }]\n", "list items"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseTOML([]byte(test.doc))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLoadReadsTheConfigurationFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"api.yaml": "jwt:\n  signing_alg: ES256\n  key_files: [a.pem, b.pem]\nlogin:\n  max_failures: 7\n",
+		"api.toml": "[jwt]\nsigning_alg = \"ES256\"\nkey_files = [\"a.pem\", \"b.pem\"]\n[login]\nmax_failures = 7\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, _, err := Load([]string{"-config", path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.JWT.SigningAlg != "ES256" || !reflect.DeepEqual(cfg.JWT.KeyFiles, []string{"a.pem", "b.pem"}) || cfg.Login.MaxFailures != 7 {
+				t.Fatalf("loaded jwt %+v, login.max_failures %d", cfg.JWT, cfg.Login.MaxFailures)
+			}
+			if source := cfg.Source("jwt.signing_alg"); source != SourceFile {
+				t.Fatalf("source of jwt.signing_alg %q, want the file", source)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "api.json")
+	os.WriteFile(path, []byte("{}"), 0o600)
+	if _, _, err := Load([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), "unsupported configuration file format") {
+		t.Fatalf("JSON file: error %v", err)
+	}
+}
+
+func TestValidateRejectsRotatingKeysLoadedFromFiles(t *testing.T) {
+	for name, test := range map[string]struct {
+		args []string
+		want bool
+	}{
+		"explicit rotation":      {[]string{"-jwt.key-files", "a.pem", "-jwt.key-rotation", "1h"}, true},
+		"default rotation":       {[]string{"-jwt.key-files", "a.pem"}, false},
+		"rotation disabled":      {[]string{"-jwt.key-files", "a.pem", "-jwt.key-rotation", "0"}, false},
+		"rotating generated key": {[]string{"-jwt.key-rotation", "1h"}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg, _, err := Load(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.Validate()
+			if got := err != nil && strings.Contains(err.Error(), "jwt.key_rotation (flag -jwt.key-rotation): must be 0 while jwt.key_files is set"); got != test.want {
+				t.Fatalf("error %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateKeepsMetricsOffThePublicListener(t *testing.T) {
+	for name, test := range map[string]struct {
+		args  []string
+		token string
+		want  string
+	}{
+		"default admin listener":            {nil, "", ""},
+		"main listener without a token":     {[]string{"-metrics.addr="}, "", "metrics.token (default): must be set while metrics.addr is empty"},
+		"main listener with a token":        {[]string{"-metrics.addr="}, "scrape-token", ""},
+		"metrics disabled":                  {[]string{"-metrics.addr=", "-metrics.enabled=false"}, "", ""},
+		"port of the main listener":         {[]string{"-metrics.addr", ":8080"}, "", "metrics.addr (flag -metrics.addr): must differ from server.addr"},
+		"port of the main listener on lo":   {[]string{"-metrics.addr", "127.0.0.1:8080"}, "", "metrics.addr (flag -metrics.addr): must differ from server.addr"},
+		"other port on the main interfaces": {[]string{"-metrics.addr", ":9090"}, "", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("METRICS_TOKEN", test.token)
+			cfg, _, err := Load(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.Validate()
+			var problems []string
+			if err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					if strings.HasPrefix(strings.TrimSpace(line), "metrics.") {
+						problems = append(problems, strings.TrimSpace(line))
+					}
+				}
+			}
+			if test.want == "" && len(problems) > 0 || test.want != "" && (len(problems) != 1 || !strings.HasPrefix(problems[0], test.want)) {
+				t.Fatalf("metrics problems %q, want %q", problems, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where a setting got its value, as reported by `config print`
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// setting is one field of Config with the names it is set by
+type setting struct {
+	Key     string // Key in the configuration file, such as "jwt.signing_alg"
+	Env     string // Environment variable, such as "JWT_SIGNING_ALG"
+	Default string
+	Secret  bool
+	value   reflect.Value
+}
+
+// Flag returns the name of the command-line flag of the setting, such as "jwt.signing-alg"
+func (s *setting) Flag() string {
+	return strings.ReplaceAll(s.Key, "_", "-")
+}
+
+// set - Parse a value given as text and store it in the field
+func (s *setting) set(text string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(text)
+	case []string:
+		s.value.Set(reflect.ValueOf(splitList(text)))
+	case bool:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, expected true or false", text)
+		}
+		s.value.SetBool(value)
+	case int:
+		value, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", text)
+		}
+		s.value.SetInt(int64(value))
+	case float64:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		s.value.SetFloat(value)
+	case time.Duration:
+		value, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, expected a number with a unit such as 30s, 15m or 24h", text)
+		}
+		s.value.SetInt(int64(value))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// setFileValue - Store a value of the configuration file, which is either text or a list
+func (s *setting) setFileValue(value interface{}) error {
+	if list, ok := value.([]string); ok {
+		if _, isList := s.value.Interface().([]string); !isList {
+			return errors.New("expected a single value, not a list")
+		}
+		s.value.Set(reflect.ValueOf(list))
+		return nil
+	}
+	return s.set(value.(string))
+}
+
+// settings - Return the settings of every field of cfg, in the order of the struct
+func settings(cfg *Config) []*setting {
+	var result []*setting
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		sectionKey := sections.Type().Field(i).Tag.Get("config")
+		if sectionKey == "" {
+			continue
+		}
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			result = append(result, &setting{
+				Key:     sectionKey + "." + field.Tag.Get("config"),
+				Env:     field.Tag.Get("env"),
+				Default: field.Tag.Get("default"),
+				Secret:  field.Tag.Get("secret") == "true",
+				value:   section.Field(j),
+			})
+		}
+	}
+	return result
+}
+
+/*
+*
+Load
+
+func Load(args []string) (*Config, []string, error)
+Description: Builds the configuration from the defaults, the configuration file, the environment variables and the command-line flags in args, each overriding the ones before. The file is given with -config or CONFIG_FILE and may be YAML (.yaml, .yml) or TOML (.toml):
+
+	server:
+	  addr: ":8443"
+	jwt:
+	  key_files: [/etc/api-service/signing.pem]
+	database:
+	  url_file: /run/secrets/db-url
+
+It returns the arguments left after the flags, such as a command ("audit verify"). Values that cannot be parsed are reported together with where they were set; call Validate to check the resulting configuration.
+*/
+func Load(args []string) (*Config, []string, error) {
+	cfg := &Config{sources: make(map[string]string)}
+	all := settings(cfg)
+
+	// The flags are parsed first, since they name the configuration file, but applied last
+	fs := flag.NewFlagSet("api-service", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file (env CONFIG_FILE)")
+	flags := make(map[string]string)
+	for _, s := range all {
+		if s.Secret {
+			continue
+		}
+		name := s.Flag()
+		fs.Func(name, "env "+s.Env, func(value string) error {
+			flags[name] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("%v (run with -help to list the flags)", err)
+	}
+
+	var problems []error
+	for _, s := range all {
+		cfg.sources[s.Key] = SourceDefault
+		if s.Default == "" {
+			continue
+		}
+		if err := s.set(s.Default); err != nil {
+			problems = append(problems, fmt.Errorf("%s: default: %w", s.Key, err))
+		}
+	}
+
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		problems = append(problems, cfg.applyFile(all, values, *configFile)...)
+	}
+
+	for _, s := range all {
+		value, err := lookupEnv(s)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", s.Key, err))
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", s.Env, err))
+			continue
+		}
+		cfg.sources[s.Key] = SourceEnv
+	}
+
+	for _, s := range all {
+		value, ok := flags[s.Flag()]
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			problems = append(problems, fmt.Errorf("-%s: %w", s.Flag(), err))
+			continue
+		}
+		cfg.sources[s.Key] = SourceFlag
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(problems...))
+	}
+	cfg.resolve()
+	return cfg, fs.Args(), nil
+}
+
+// Usage - Write the list of command-line flags with their environment variables and defaults
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: api-service [flags] [command]")
+	fmt.Fprintln(w, "\n  -config file\n\tYAML or TOML configuration file (env CONFIG_FILE)")
+	for _, s := range settings(&Config{}) {
+		if s.Secret {
+			fmt.Fprintf(w, "  (secret %s: env %s or %s_FILE, or %s_file in the configuration file)\n", s.Key, s.Env, s.Env, s.Key)
+			continue
+		}
+		fmt.Fprintf(w, "  -%s value\n\tenv %s", s.Flag(), s.Env)
+		if s.Default != "" {
+			fmt.Fprintf(w, ", default %q", s.Default)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// applyFile - Store the values of the configuration file. Keys that match no setting are reported, so that typos do
+// not go unnoticed.
+func (cfg *Config) applyFile(all []*setting, values map[string]interface{}, path string) []error {
+	byKey := make(map[string]*setting, len(all))
+	for _, s := range all {
+		byKey[s.Key] = s
+		if s.Secret {
+			byKey[s.Key+"_file"] = s
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []error
+	for _, key := range keys {
+		s, ok := byKey[key]
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s: unknown setting %s", path, key))
+			continue
+		}
+		value := values[key]
+		if key != s.Key {
+			if _, dup := values[s.Key]; dup {
+				problems = append(problems, fmt.Errorf("%s: set %s or %s, not both", path, s.Key, key))
+				continue
+			}
+			text, isText := value.(string)
+			if !isText {
+				problems = append(problems, fmt.Errorf("%s: %s: expected a file name", path, key))
+				continue
+			}
+			secret, err := readSecret(text)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s: %s: %w", path, key, err))
+				continue
+			}
+			value = secret
+		}
+		if err := s.setFileValue(value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %s: %w", path, key, err))
+			continue
+		}
+		cfg.sources[s.Key] = SourceFile
+	}
+	return problems
+}
+
+// lookupEnv - Return the environment variable of a setting, or for secrets the content of the file named by the
+// variable with a _FILE suffix. Empty variables count as unset.
+func lookupEnv(s *setting) (string, error) {
+	value := os.Getenv(s.Env)
+	if !s.Secret {
+		return value, nil
+	}
+	file := os.Getenv(s.Env + "_FILE")
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("set %s or %s_FILE, not both", s.Env, s.Env)
+	}
+	secret, err := readSecret(file)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", s.Env, err)
+	}
+	return secret, nil
+}
+
+// readSecret - Read a secret from a file, without the trailing newline editors and `echo` add
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// splitList - Split a comma separated list, dropping blank items
+func splitList(text string) []string {
+	items := []string{}
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resolve - Fill in the settings whose defaults derive from other settings
+func (cfg *Config) resolve() {
+	cfg.Server.Issuer = strings.TrimSuffix(cfg.Server.Issuer, "/")
+	// The default URL is for PostgreSQL
+	if cfg.Database.Driver == "sqlite" && cfg.Source("database.url") == SourceDefault {
+		cfg.Database.URL = "api-service.db"
+	}
+	if cfg.Email.VerificationURL == "" {
+		cfg.Email.VerificationURL = cfg.Server.Issuer + "/email/verify"
+	}
+	if cfg.PasswordReset.URL == "" {
+		cfg.PasswordReset.URL = cfg.Server.Issuer + "/password/reset"
+	}
+	if cfg.Invitations.URL == "" {
+		cfg.Invitations.URL = cfg.Server.Issuer + "/invitations/accept"
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the values of secrets in `config print`
+const Redacted = "<redacted>"
+
+/*
+*
+Print
+
+func (cfg *Config) Print(w io.Writer)
+Description: Writes the effective configuration as YAML, with the source of every value as a comment. Secrets that are set are replaced by "<redacted>", so the output can be shared when reporting a problem. Apart from the secrets, the output can be used as a configuration file:
+
+	server:
+	  addr: ":8443" # flag
+	  issuer: "https://auth.example.com" # env
+	database:
+	  url: "<redacted>" # file
+*/
+func (cfg *Config) Print(w io.Writer) {
+	section := ""
+	for _, s := range settings(cfg) {
+		name, key, _ := strings.Cut(s.Key, ".")
+		if name != section {
+			fmt.Fprintf(w, "%s:\n", name)
+			section = name
+		}
+		fmt.Fprintf(w, "  %s: %s # %s\n", key, formatValue(s), cfg.Source(s.Key))
+	}
+}
+
+// formatValue - Format the value of a setting as YAML, redacting secrets
+func formatValue(s *setting) string {
+	switch value := s.value.Interface().(type) {
+	case string:
+		if s.Secret && value != "" {
+			return strconv.Quote(Redacted)
+		}
+		return strconv.Quote(value)
+	case []string:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case time.Duration:
+		return strconv.Quote(value.String())
+	default:
+		return fmt.Sprint(value)
+	}
+}
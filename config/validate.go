@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+)
+
+/*
+*
+Validate
+
+func (cfg *Config) Validate() error
+Description: Checks the settings that Load could parse but that make no sense together or for the service, such as an unknown signing algorithm or a lockout shorter than zero. Every problem is reported, each with the key of the setting and where its value came from:
+
+	invalid configuration:
+	jwt.signing_alg (env JWT_SIGNING_ALG): must be RS256, ES256 or EdDSA, not "HS256"
+	login.lockout_max (default): must not be shorter than login.lockout
+*/
+func (cfg *Config) Validate() error {
+	v := validator{cfg: cfg}
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		v.fail("server.addr", "must be host:port or :port, not %q", cfg.Server.Addr)
+	}
+	v.url("server.issuer", cfg.Server.Issuer)
+	v.notNegative("server.read_timeout", int64(cfg.Server.ReadTimeout))
+	v.notNegative("server.read_header_timeout", int64(cfg.Server.ReadHeaderTimeout))
+	v.notNegative("server.write_timeout", int64(cfg.Server.WriteTimeout))
+	v.notNegative("server.idle_timeout", int64(cfg.Server.IdleTimeout))
+	if cfg.Server.ShutdownTimeout <= 0 {
+		v.fail("server.shutdown_timeout", "must be longer than 0")
+	}
+	v.notNegative("server.shutdown_delay", int64(cfg.Server.ShutdownDelay))
+	v.oneOf("database.driver", cfg.Database.Driver, "postgres", "sqlite", "memory")
+	if cfg.Database.URL == "" && cfg.Database.Driver != "memory" {
+		v.fail("database.url", "must be set")
+	}
+
+	v.oneOf("jwt.signing_alg", cfg.JWT.SigningAlg, "RS256", "ES256", "EdDSA")
+	v.notNegative("jwt.key_rotation", int64(cfg.JWT.KeyRotation))
+	if len(cfg.JWT.KeyFiles) > 0 && cfg.JWT.KeyRotation > 0 && cfg.Source("jwt.key_rotation") != SourceDefault {
+		v.fail("jwt.key_rotation", "must be 0 while jwt.key_files is set, keys loaded from files are never rotated")
+	}
+	v.notNegative("jwt.key_overlap", int64(cfg.JWT.KeyOverlap))
+	v.oneOf("revocation.store", cfg.Revocation.Store, "postgres", "memory")
+
+	if cfg.WebAuthn.RPID == "" {
+		v.fail("webauthn.rp_id", "must be set")
+	}
+	if len(cfg.WebAuthn.Origins) == 0 {
+		v.fail("webauthn.origins", "must list at least one origin")
+	}
+	for _, origin := range cfg.WebAuthn.Origins {
+		v.url("webauthn.origins", origin)
+	}
+
+	if cfg.SMTP.Host != "" && (cfg.SMTP.Port < 1 || cfg.SMTP.Port > 65535) {
+		v.fail("smtp.port", "must be between 1 and 65535, not %d", cfg.SMTP.Port)
+	}
+	v.url("email.verification_url", cfg.Email.VerificationURL)
+	v.url("password_reset.url", cfg.PasswordReset.URL)
+	v.url("invitations.url", cfg.Invitations.URL)
+
+	v.notNegative("login.max_failures", int64(cfg.Login.MaxFailures))
+	v.notNegative("login.ip_max_failures", int64(cfg.Login.IPMaxFailures))
+	v.notNegative("login.mfa_max_failures", int64(cfg.Login.MFAMaxFailures))
+	if cfg.Login.MaxFailures > 0 || cfg.Login.IPMaxFailures > 0 || cfg.Login.MFAMaxFailures > 0 {
+		if cfg.Login.Lockout <= 0 {
+			v.fail("login.lockout", "must be longer than 0 while a lockout is enabled")
+		}
+		if cfg.Login.LockoutMax < cfg.Login.Lockout {
+			v.fail("login.lockout_max", "must not be shorter than login.lockout")
+		}
+		if cfg.Login.FailureWindow <= 0 {
+			v.fail("login.failure_window", "must be longer than 0 while a lockout is enabled")
+		}
+	}
+
+	v.oneOf("rate_limit.store", cfg.RateLimit.Store, "memory", "redis")
+	if cfg.RateLimit.Store == "redis" {
+		if _, _, err := net.SplitHostPort(cfg.RateLimit.RedisAddr); err != nil {
+			v.fail("rate_limit.redis_addr", "must be host:port, not %q", cfg.RateLimit.RedisAddr)
+		}
+	}
+	v.oneOf("rate_limit.algorithm", cfg.RateLimit.Algorithm, "token_bucket", "sliding_window")
+
+	if cfg.Health.CheckTimeout <= 0 {
+		v.fail("health.check_timeout", "must be longer than 0")
+	}
+	v.notNegative("health.cache_ttl", int64(cfg.Health.CacheTTL))
+	if cfg.Metrics.Addr != "" {
+		if _, _, err := net.SplitHostPort(cfg.Metrics.Addr); err != nil {
+			v.fail("metrics.addr", "must be host:port or :port, not %q", cfg.Metrics.Addr)
+		} else if sameListener(cfg.Metrics.Addr, cfg.Server.Addr) {
+			v.fail("metrics.addr", "must differ from server.addr")
+		}
+	} else if cfg.Metrics.Enabled && cfg.Metrics.Token == "" {
+		v.fail("metrics.token", "must be set while metrics.addr is empty, /metrics would be public on server.addr")
+	}
+
+	v.oneOf("log.format", cfg.Log.Format, "json", "text")
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		v.fail("log.level", "must be debug, info, warn or error, not %q", cfg.Log.Level)
+	}
+	for _, entry := range cfg.Log.Levels {
+		pkg, name, ok := strings.Cut(entry, "=")
+		if err := level.UnmarshalText([]byte(name)); !ok || pkg == "" || err != nil {
+			v.fail("log.levels", "must be <package>=<level>, such as db=debug, not %q", entry)
+		}
+	}
+
+	v.oneOf("tracing.exporter", cfg.Tracing.Exporter, "none", "otlp", "stdout")
+	if cfg.Tracing.Exporter == "otlp" {
+		v.url("tracing.endpoint", cfg.Tracing.Endpoint)
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		v.fail("tracing.sample_ratio", "must be between 0 and 1, not %g", cfg.Tracing.SampleRatio)
+	}
+
+	if len(v.problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(v.problems...))
+	}
+	return nil
+}
+
+// validator collects the problems of a configuration
+type validator struct {
+	cfg      *Config
+	problems []error
+}
+
+// fail - Record a problem with a setting, naming where its value came from
+func (v *validator) fail(key, format string, args ...interface{}) {
+	source := v.cfg.Source(key)
+	if source == SourceEnv || source == SourceFlag {
+		for _, s := range settings(v.cfg) {
+			if s.Key != key {
+				continue
+			}
+			if source == SourceEnv {
+				source += " " + s.Env
+			} else {
+				source += " -" + s.Flag()
+			}
+		}
+	}
+	v.problems = append(v.problems, fmt.Errorf("%s (%s): %s", key, source, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	last := len(allowed) - 1
+	v.fail(key, "must be %s or %s, not %q", strings.Join(allowed[:last], ", "), allowed[last], value)
+}
+
+func (v *validator) notNegative(key string, value int64) {
+	if value < 0 {
+		v.fail(key, "must not be negative")
+	}
+}
+
+// url - Check that a value is an absolute http or https URL
+func (v *validator) url(key, value string) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		v.fail(key, "must be an absolute http or https URL, not %q", value)
+	}
+}
+
+// sameListener - Report whether two listen addresses would take the same port, such as :9090 and 127.0.0.1:9090
+func sameListener(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return a == b
+	}
+	wildcard := func(host string) bool { return host == "" || host == "0.0.0.0" || host == "::" }
+	return hostA == hostB || wildcard(hostA) || wildcard(hostB)
+}
+
+// Source - Return where a setting got its value: SourceDefault, SourceFile, SourceEnv or SourceFlag
+func (cfg *Config) Source(key string) string {
+	if source, ok := cfg.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
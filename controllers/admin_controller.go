@@ -1,10 +1,15 @@
 package controllers
 
 /**The AdminController is responsible for handling HTTP requests related to user management from an admin perspective. It interacts with the AdminService to perform actions such as creating users, retrieving all users, deleting users, and revoking user tokens.
- */
+Every request works on the organization of the calling admin: the AdminService is scoped to the tenant claim of the token, and users of other organizations are reported as not found. Super admins work across organizations.
+*/
 import (
+	"api-service/models"
 	"api-service/services"
+	"api-service/storage"
+	"api-service/utils"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -16,6 +21,14 @@ type AdminController struct {
 	AdminService: The AdminService is a service layer that handles the business logic related to user management. It is injected into the controller to perform database operations.
 	*/
 	AdminService *services.AdminService
+	/**
+	PolicyService: Decides, per user, which users the admin may see or delete and which fields are hidden.
+	*/
+	PolicyService *services.PolicyService
+	/**
+	AuditService: Records who created or deleted users and who revoked tokens.
+	*/
+	AuditService *services.AuditService
 }
 
 /*
@@ -32,15 +45,27 @@ json
 	  "username": "user1",
 	  "password": "password123",
 	  "role": "user",
-	  "email": "user1@example.com"
+	  "email": "user1@example.com",
+	  "region": "eu",
+	  "organization_id": 2
 	}
+
+The role defaults to "user" and must name an existing role. The "admin" and "super_admin" roles are rejected with 400 Bad Request; admins are added through /api/admin/invitations.
+The user joins the organization of the calling admin. Only super admins may name another organization with "organization_id"; for others it is rejected with 403 Forbidden.
 */
 func (ac *AdminController) CreateUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ac.adminService(w, r)
+	if !ok {
+		return
+	}
+
 	var data struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Role     string `json:"role"`
-		Email    string `json:"email"`
+		Username       string `json:"username"`
+		Password       string `json:"password"`
+		Role           string `json:"role"`
+		Email          string `json:"email"`
+		Region         string `json:"region"`
+		OrganizationID uint   `json:"organization_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
@@ -48,18 +73,56 @@ func (ac *AdminController) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := ac.AdminService.CreateUser(data.Username, data.Password, data.Role, data.Email)
+	user, err := admin.CreateUser(data.Username, data.Password, data.Role, data.Email, data.Region, data.OrganizationID)
 	if err != nil {
+		if errors.Is(err, services.ErrAdminRoleByInvite) || errors.Is(err, services.ErrRoleNotFound) ||
+			errors.Is(err, services.ErrOrganizationNotFound) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrCrossTenant) {
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	event := onUser(auditEvent(r, models.AuditUserCreated), user)
+	event.Details = map[string]string{"role": user.Role}
+	recordAudit(r, ac.AuditService, event)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
 }
 
+/*
+*
+This endpoint lists the users of the caller's organization that the policies allow the caller to read ("users:read"), with the fields the policies redact removed.
+Super admins see the users of every organization, or of one with ?organization_id=2.
+
+Method: GET
+Endpoint: /api/admin/users
+*/
 func (ac *AdminController) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := ac.AdminService.GetAllUsers()
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var organizationID uint64
+	if value := r.URL.Query().Get("organization_id"); value != "" {
+		if organizationID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := ac.AdminService.ForTenant(services.TenantFromClaims(claims)).WithContext(r.Context()).GetAllUsers(uint(organizationID))
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+	users, err = ac.PolicyService.WithContext(r.Context()).Filter(claims, services.ActionUsersRead, users)
 	if err != nil {
 		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
 		return
@@ -69,28 +132,173 @@ func (ac *AdminController) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
+/*
+*
+This endpoint deletes a user of the caller's organization if the policies allow the caller the "users:delete" action on that user, and revokes the user's tokens.
+
+On error: 409 Conflict for the last admin of an organization or the last super admin, 403 Forbidden for a super admin unless the caller is one
+
+Method: DELETE
+Endpoint: /api/admin/users/{id}
+*/
 func (ac *AdminController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, _ := strconv.Atoi(vars["id"])
 
-	if err := ac.AdminService.DeleteUser(uint(userID)); err != nil {
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	admin := ac.AdminService.ForTenant(services.TenantFromClaims(claims)).WithContext(r.Context())
+	user, err := admin.GetUser(uint(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if _, err := ac.PolicyService.WithContext(r.Context()).Authorize(claims, services.ActionUsersDelete, user); err != nil {
+		if errors.Is(err, services.ErrPolicyDenied) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
 		return
 	}
 
+	if err := admin.DeleteUser(uint(userID)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrLastAdmin), errors.Is(err, services.ErrLastSuperAdmin):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrSuperAdminRole):
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		}
+		return
+	}
+	recordAudit(r, ac.AuditService, onUser(auditEvent(r, models.AuditUserDeleted), user))
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted"})
 }
 
+// This endpoint lifts the login and second factor lockouts of a user of the caller's organization after too many failed logins. Method: POST, Endpoint: /api/admin/users/{id}/unlock
+func (ac *AdminController) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	admin, ok := ac.adminService(w, r)
+	if !ok {
+		return
+	}
+	user, err := admin.UnlockUser(uint(userID))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, ac.AuditService, onUser(auditEvent(r, models.AuditUserUnlocked), user))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User unlocked"})
+}
+
+// This endpoint revokes every access and refresh token of a user of the caller's organization. Method: POST, Endpoint: /api/admin/users/{id}/revoke
 func (ac *AdminController) RevokeToken(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, _ := strconv.Atoi(vars["id"])
 
-	if err := ac.AdminService.RevokeToken(uint(userID)); err != nil {
+	admin, ok := ac.adminService(w, r)
+	if !ok {
+		return
+	}
+	if err := admin.RevokeToken(uint(userID)); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
 		return
 	}
+	event := auditEvent(r, models.AuditTokensRevoked)
+	event.TargetType, event.TargetID = "user", strconv.Itoa(userID)
+	recordAudit(r, ac.AuditService, event)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "User's token revoked"})
 }
+
+/*
+*
+This endpoint revokes a single access token by its token ID (jti). The token is rejected by JWTMiddleware until it expires.
+A jti does not reveal the organization of the token, so only super admins may use this endpoint; others get 403 Forbidden.
+
+Method: POST
+Endpoint: /api/admin/tokens/{jti}/revoke
+*/
+func (ac *AdminController) RevokeTokenByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	admin, ok := ac.adminService(w, r)
+	if !ok {
+		return
+	}
+	if err := admin.RevokeTokenByID(vars["jti"]); err != nil {
+		if errors.Is(err, services.ErrCrossTenant) {
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	event := auditEvent(r, models.AuditTokenRevoked)
+	event.TargetType, event.TargetID = "token", vars["jti"]
+	recordAudit(r, ac.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked"})
+}
+
+/*
+*
+This endpoint revokes every access and refresh token issued so far in the caller's organization, including those of its OAuth2 clients. Every user of the organization, including the calling admin, has to log in again.
+For super admins it revokes the tokens of every organization.
+
+Method: POST
+Endpoint: /api/admin/tokens/revoke-all
+*/
+func (ac *AdminController) RevokeAllTokens(w http.ResponseWriter, r *http.Request) {
+	admin, ok := ac.adminService(w, r)
+	if !ok {
+		return
+	}
+	if err := admin.RevokeAllTokens(); err != nil {
+		http.Error(w, "Failed to revoke tokens", http.StatusInternalServerError)
+		return
+	}
+	event := auditEvent(r, models.AuditAllTokensRevoked)
+	event.Details = map[string]string{"scope": "organization"}
+	if admin.Tenant.CrossTenant {
+		event.Details["scope"] = "all"
+	}
+	recordAudit(r, ac.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "All tokens revoked"})
+}
+
+// adminService returns the AdminService scoped to the caller's organization. Without claims it writes 401 Unauthorized and returns false.
+func (ac *AdminController) adminService(w http.ResponseWriter, r *http.Request) (*services.AdminService, bool) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return ac.AdminService.ForTenant(tenant).WithContext(r.Context()), true
+}
@@ -0,0 +1,244 @@
+package controllers
+
+/**
+The EmailController confirms the email addresses of users. Registration emails a signed verification link to the new address, /email/verify confirms it, and /api/profile/email changes the address of the logged-in user once the new address is confirmed through the same link.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var verifyEmailPage = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Verify email address</title><meta name="referrer" content="no-referrer"></head>
+<body>
+<h1>Verify your email address</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Done}}<p>{{.Email}} has been verified. You can now sign in.</p>
+{{else if not .Invalid}}<form method="POST" action="/email/verify">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Verify email address</button>
+</form>{{end}}
+</body></html>`))
+
+type EmailController struct {
+	/**
+	The EmailVerificationService signs and checks verification links and applies email changes.
+	*/
+	EmailVerificationService *services.EmailVerificationService
+	/**
+	The AuditService records requested email changes.
+	*/
+	AuditService *services.AuditService
+}
+
+/*
+*
+VerifyEmailPage
+
+func (ec *EmailController) VerifyEmailPage(w http.ResponseWriter, r *http.Request)
+Description: This endpoint is the page verification emails link to when EMAIL_VERIFICATION_URL is not set. It shows a button that posts the token from the link to /email/verify, so that mail scanners following the link do not verify the address.
+
+Method: GET
+Endpoint: /email/verify?token=...
+*/
+func (ec *EmailController) VerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, verifyEmailPage, map[string]interface{}{"Token": r.URL.Query().Get("token")})
+}
+
+/*
+*
+VerifyEmail
+
+func (ec *EmailController) VerifyEmail(w http.ResponseWriter, r *http.Request)
+Description: This endpoint confirms an email address with the token from a verification link.
+
+Request:
+
+Method: POST
+Endpoint: /email/verify
+Body (JSON format, or the form of the verification page):
+
+	{
+	  "token": "token_from_the_email"
+	}
+
+Logic:
+
+The token is signed by the service, expires after 24 hours and names one user and one address.
+If the address is the user's current address, it is marked verified.
+If the address is the user's pending address from /api/profile/email, it replaces the current address and is marked verified.
+Links for any other address, for example one that was replaced by a newer change request, are rejected.
+
+Response:
+
+On success:
+
+	{
+	  "message": "Email address verified",
+	  "email": "user1@example.com"
+	}
+
+On error: 400 Bad Request if the token is invalid, 409 Conflict if another account took the address in the meantime
+*/
+func (ec *EmailController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		ec.verifyEmailForm(w, r)
+		return
+	}
+
+	var req models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, err := ec.EmailVerificationService.WithContext(r.Context()).Verify(req.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to verify email address", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified", "email": user.Email})
+}
+
+func (ec *EmailController) verifyEmailForm(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	user, err := ec.EmailVerificationService.WithContext(r.Context()).Verify(token)
+	switch {
+	case err == nil:
+		renderPage(w, http.StatusOK, verifyEmailPage, map[string]interface{}{"Done": true, "Email": user.Email})
+	case errors.Is(err, services.ErrInvalidVerificationToken):
+		renderPage(w, http.StatusBadRequest, verifyEmailPage, map[string]interface{}{"Invalid": true, "Error": "This verification link is invalid or has expired. Please request a new one."})
+	case errors.Is(err, services.ErrEmailTaken):
+		renderPage(w, http.StatusConflict, verifyEmailPage, map[string]interface{}{"Invalid": true, "Error": "This email address is already used by another account."})
+	default:
+		renderPage(w, http.StatusInternalServerError, verifyEmailPage, map[string]interface{}{"Token": token, "Error": "Failed to verify the email address. Please try again."})
+	}
+}
+
+/*
+*
+ResendVerification
+
+func (ec *EmailController) ResendVerification(w http.ResponseWriter, r *http.Request)
+Description: This endpoint emails a new verification link to an address that has not been verified yet.
+
+Request:
+
+Method: POST
+Endpoint: /email/verify/resend
+Body (JSON format):
+
+	{
+	  "email": "user1@example.com"
+	}
+
+Logic:
+
+The response is the same whether or not an unverified account uses the address, so the endpoint cannot be used to find out which addresses are registered.
+
+Response:
+
+	{
+	  "message": "If an unverified account with this email exists, a verification link has been sent"
+	}
+*/
+func (ec *EmailController) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := ec.EmailVerificationService.WithContext(r.Context()).Resend(req.Email); err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If an unverified account with this email exists, a verification link has been sent"})
+}
+
+/*
+*
+ChangeEmail
+
+func (ec *EmailController) ChangeEmail(w http.ResponseWriter, r *http.Request)
+Description: This endpoint starts changing the email address of the logged-in user.
+
+Request:
+
+Method: PUT
+Endpoint: /api/profile/email
+Headers: Must contain a valid JWT token in the Authorization header.
+Body (JSON format):
+
+	{
+	  "email": "new@example.com"
+	}
+
+Logic:
+
+The new address is stored as pending_email and a verification link is emailed to it. The current address is notified of the request.
+The current address stays in use, for logins and password resets, until the link is opened. A newer request replaces the pending address and invalidates older links.
+
+Response:
+
+On success: the profile with the pending address
+
+	{
+	  "username": "user1",
+	  "email": "user1@example.com",
+	  "pending_email": "new@example.com"
+	}
+
+On error: 400 Bad Request if the address is invalid or unchanged, 409 Conflict if another account uses it
+*/
+func (ec *EmailController) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := ec.EmailVerificationService.WithContext(r.Context()).RequestEmailChange(user.ID, req.Email)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmail) || errors.Is(err, services.ErrEmailUnchanged) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to change email address", http.StatusInternalServerError)
+		return
+	}
+	event := onUser(auditEvent(r, models.AuditEmailChangeRequested), profile)
+	event.Details = map[string]string{"email": profile.Email, "pending_email": profile.PendingEmail}
+	recordAudit(r, ec.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(profile)
+}
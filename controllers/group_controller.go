@@ -0,0 +1,312 @@
+package controllers
+
+/**
+The GroupController lets admins manage groups of users within their organization. Roles given to a group are inherited by its members and by the members of every group nested in it; tokens carry the resulting effective roles. Routes are guarded in main.go with the groups:read and groups:manage permissions.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type GroupController struct {
+	/**
+	The GroupService stores groups, their nesting, their roles and their members.
+	*/
+	GroupService *services.GroupService
+	/**
+	The AuditService records changes to groups and their members, which change the roles of the members.
+	*/
+	AuditService *services.AuditService
+}
+
+// This endpoint lists the groups of the caller's organization with their roles. Method: GET, Endpoint: /api/admin/groups
+func (gc *GroupController) ListGroups(w http.ResponseWriter, r *http.Request) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	groups, err := gc.GroupService.WithContext(r.Context()).ListGroups(tenant)
+	if err != nil {
+		http.Error(w, "Failed to fetch groups", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(groups)
+}
+
+// This endpoint returns a group with its roles. Method: GET, Endpoint: /api/admin/groups/{id}
+func (gc *GroupController) GetGroup(w http.ResponseWriter, r *http.Request) {
+	tenant, id, ok := groupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := gc.GroupService.WithContext(r.Context()).GetGroup(tenant, id)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(group)
+}
+
+/*
+*
+CreateGroup
+
+func (gc *GroupController) CreateGroup(w http.ResponseWriter, r *http.Request)
+Description: This endpoint creates a group in the caller's organization.
+
+Request:
+
+Method: POST
+Endpoint: /api/admin/groups
+Body (JSON format):
+
+	{
+	  "name": "backend",
+	  "description": "Backend team",
+	  "parent_id": 1,
+	  "roles": ["support"]
+	}
+
+Logic:
+
+Group names are unique within an organization. With "parent_id" the group is nested in another group of the same organization, and its members inherit the roles of that group too.
+The admin and super_admin roles cannot be given to groups. Super admins may create the group in another organization with "organization_id".
+
+Response:
+
+On success (201 Created): the group with its roles
+
+On error: 400 Bad Request for a missing name, an unknown role or parent, or an admin role, 409 Conflict if the name is taken
+*/
+func (gc *GroupController) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	group, err := gc.GroupService.WithContext(r.Context()).CreateGroup(tenant, req)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	recordAudit(r, gc.AuditService, onGroup(auditEvent(r, models.AuditGroupCreated), group))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+/*
+*
+UpdateGroup
+
+func (gc *GroupController) UpdateGroup(w http.ResponseWriter, r *http.Request)
+Description: This endpoint replaces the name, description, parent and roles of a group. The body is the same as for POST /api/admin/groups; a missing "parent_id" makes the group top-level.
+
+Method: PUT
+Endpoint: /api/admin/groups/{id}
+
+Logic:
+
+A group cannot be nested in itself or in one of its subgroups. The access tokens of the members of the group and of its subgroups are revoked, so that their inherited roles change at once; their next /token/refresh issues a token with the new roles.
+
+On error: 400 Bad Request for an invalid body, an unknown parent or a cycle, 404 Not Found if the group does not exist, 409 Conflict if the name is taken
+*/
+func (gc *GroupController) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	tenant, id, ok := groupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	group, err := gc.GroupService.WithContext(r.Context()).UpdateGroup(tenant, id, req)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	recordAudit(r, gc.AuditService, onGroup(auditEvent(r, models.AuditGroupUpdated), group))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(group)
+}
+
+// This endpoint deletes a group without subgroups; its members lose the roles inherited from it. Method: DELETE, Endpoint: /api/admin/groups/{id}
+func (gc *GroupController) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	tenant, id, ok := groupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := gc.GroupService.WithContext(r.Context()).DeleteGroup(tenant, id); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	event := auditEvent(r, models.AuditGroupDeleted)
+	event.TargetType, event.TargetID = "group", strconv.FormatUint(uint64(id), 10)
+	recordAudit(r, gc.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Group deleted"})
+}
+
+// This endpoint lists the direct members of a group. Method: GET, Endpoint: /api/admin/groups/{id}/members
+func (gc *GroupController) ListMembers(w http.ResponseWriter, r *http.Request) {
+	tenant, id, ok := groupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := gc.GroupService.WithContext(r.Context()).ListMembers(tenant, id)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(members)
+}
+
+/*
+*
+AddMembers
+
+func (gc *GroupController) AddMembers(w http.ResponseWriter, r *http.Request)
+Description: This endpoint adds users of the group's organization to a group. Their access tokens are revoked, so that their next /token/refresh issues a token with the inherited roles.
+
+Method: POST
+Endpoint: /api/admin/groups/{id}/members
+Body (JSON format):
+
+	{
+	  "user_ids": [7, 12]
+	}
+
+On error: 400 Bad Request if a user does not exist in the group's organization, 404 Not Found if the group does not exist
+*/
+func (gc *GroupController) AddMembers(w http.ResponseWriter, r *http.Request) {
+	tenant, id, ok := groupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.GroupMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := gc.GroupService.WithContext(r.Context()).AddMembers(tenant, id, req.UserIDs); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	userIDs := make([]string, len(req.UserIDs))
+	for i, userID := range req.UserIDs {
+		userIDs[i] = strconv.FormatUint(uint64(userID), 10)
+	}
+	event := auditEvent(r, models.AuditGroupMembersAdded)
+	event.TargetType, event.TargetID = "group", strconv.FormatUint(uint64(id), 10)
+	event.Details = map[string]string{"user_ids": strings.Join(userIDs, ",")}
+	recordAudit(r, gc.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Members added"})
+}
+
+// This endpoint removes a user from a group. Method: DELETE, Endpoint: /api/admin/groups/{id}/members/{user_id}
+func (gc *GroupController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	tenant, id, ok := groupRequest(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(mux.Vars(r)["user_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := gc.GroupService.WithContext(r.Context()).RemoveMember(tenant, id, uint(userID)); err != nil {
+		if errors.Is(err, services.ErrUnknownGroupMember) {
+			http.Error(w, "User is not a member of the group", http.StatusNotFound)
+			return
+		}
+		writeGroupError(w, err)
+		return
+	}
+	event := auditEvent(r, models.AuditGroupMemberRemoved)
+	event.TargetType, event.TargetID = "group", strconv.FormatUint(uint64(id), 10)
+	event.Details = map[string]string{"user_id": strconv.FormatUint(userID, 10)}
+	recordAudit(r, gc.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+}
+
+// onGroup makes a group the target of an event and records the roles its members inherit from it
+func onGroup(event models.AuditEvent, group models.Group) models.AuditEvent {
+	event.TargetType = "group"
+	event.TargetID = strconv.FormatUint(uint64(group.ID), 10)
+	event.TargetName = group.Name
+	event.OrganizationID = group.OrganizationID
+	event.Details = map[string]string{"roles": strings.Join(group.RoleNames(), ",")}
+	if group.ParentID != nil {
+		event.Details["parent_id"] = strconv.FormatUint(uint64(*group.ParentID), 10)
+	}
+	return event
+}
+
+// groupRequest returns the caller's tenant and the group ID of the URL. On failure it writes the error response and returns false.
+func groupRequest(w http.ResponseWriter, r *http.Request) (services.Tenant, uint, bool) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return services.Tenant{}, 0, false
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return services.Tenant{}, 0, false
+	}
+	return tenant, uint(id), true
+}
+
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrGroupNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrGroupExists), errors.Is(err, services.ErrGroupHasSubgroups):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrGroupNameRequired), errors.Is(err, services.ErrGroupCycle), errors.Is(err, services.ErrParentGroupNotFound),
+		errors.Is(err, services.ErrPrivilegedGroupRole), errors.Is(err, services.ErrRoleNotFound),
+		errors.Is(err, services.ErrUnknownGroupMember), errors.Is(err, services.ErrOrganizationNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrCrossTenant):
+		http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to update groups", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+/**
+The HealthController answers the probes of orchestrators such as Kubernetes. Liveness only tells that the process serves requests; readiness also runs the checks of the dependencies, so that an instance that cannot reach its database, or is shutting down, stops receiving traffic without being restarted.
+*/
+import (
+	"api-service/health"
+	"encoding/json"
+	"net/http"
+)
+
+type HealthController struct {
+	/**
+	The Checker runs the readiness checks and knows whether the server is draining.
+	*/
+	Checker *health.Checker
+}
+
+/*
+*
+Liveness
+
+func (hc *HealthController) Liveness(w http.ResponseWriter, r *http.Request)
+Description: This endpoint answers as long as the server handles requests. It checks no dependency, since restarting the service does not fix a database that is down.
+
+Request:
+
+Method: GET
+Endpoint: /healthz
+
+Response:
+
+On success (200 OK):
+
+	{"status": "ok"}
+*/
+func (hc *HealthController) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": health.StatusOK})
+}
+
+/*
+*
+Readiness
+
+func (hc *HealthController) Readiness(w http.ResponseWriter, r *http.Request)
+Description: This endpoint runs the readiness checks (database, migrations, signing key) and returns the result of each. Results are cached for health.cache_ttl and every check is cut off after health.check_timeout.
+
+Request:
+
+Method: GET
+Endpoint: /readyz
+
+Response:
+
+On success (200 OK):
+
+	{
+	  "status": "ok",
+	  "checks": {
+	    "database": {"status": "ok", "duration": "412µs", "checked_at": "2024-05-02T09:14:31Z"},
+	    "migrations": {"status": "ok", "duration": "1.2ms", "checked_at": "2024-05-02T09:14:31Z"},
+	    "signing_key": {"status": "ok", "duration": "3µs", "checked_at": "2024-05-02T09:14:31Z"}
+	  }
+	}
+
+On error: 503 Service Unavailable with the same body, the status "failing" and an error on the failing checks, or the status "draining" while the server shuts down
+*/
+func (hc *HealthController) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := hc.Checker.Check(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != health.StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
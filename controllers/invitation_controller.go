@@ -0,0 +1,250 @@
+package controllers
+
+/**
+The InvitationController lets admins invite new admins. The invitee receives a signed, single-use link that expires after 72 hours and accepts it by choosing their own username and password at /invitations/accept.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var acceptInvitationPage = template.Must(template.New("invitation").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Accept invitation</title><meta name="referrer" content="no-referrer"></head>
+<body>
+<h1>Create your administrator account</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Done}}<p>Your account has been created. You can now sign in as {{.Username}}.</p>
+{{else if not .Invalid}}<form method="POST" action="/invitations/accept">
+<input type="hidden" name="token" value="{{.Token}}">
+<label>Username <input name="username" autocomplete="username" value="{{.Username}}" required></label>
+<label>Password <input name="password" type="password" autocomplete="new-password" minlength="8" required></label>
+<button type="submit">Create account</button>
+</form>{{end}}
+</body></html>`))
+
+type InvitationController struct {
+	/**
+	The InvitationService sends, lists, withdraws and accepts admin invitations.
+	*/
+	InvitationService *services.InvitationService
+}
+
+/*
+*
+Invite
+
+func (ic *InvitationController) Invite(w http.ResponseWriter, r *http.Request)
+Description: This endpoint emails an invitation to become an admin of the caller's organization. Super admins may invite the admin of another organization, such as the first admin of a new one, with "organization_id".
+
+Request:
+
+Method: POST
+Endpoint: /api/admin/invitations
+Headers: Must contain a valid JWT token of an admin in the Authorization header. OAuth2 clients cannot invite admins.
+Body (JSON format):
+
+	{
+	  "email": "admin2@example.com",
+	  "organization_id": 2
+	}
+
+Response:
+
+On success (201 Created):
+
+	{
+	  "id": 1,
+	  "email": "admin2@example.com",
+	  "invited_by": 1,
+	  "organization_id": 2,
+	  "expires_at": "2024-01-04T12:00:00Z",
+	  "created_at": "2024-01-01T12:00:00Z"
+	}
+
+On error: 400 Bad Request if the address or organization is invalid, 403 Forbidden for another organization unless the caller is a super admin, 409 Conflict if an account already uses the address
+*/
+func (ic *InvitationController) Invite(w http.ResponseWriter, r *http.Request) {
+	inviter, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Forbidden - Only admins can invite admins", http.StatusForbidden)
+		return
+	}
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := ic.InvitationService.WithContext(r.Context()).Invite(*inviter, tenant, req.Email, req.OrganizationID)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmail) || errors.Is(err, services.ErrOrganizationNotFound) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrCrossTenant) {
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, services.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to send invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// This endpoint lists the admin invitations of the caller's organization. Method: GET, Endpoint: /api/admin/invitations
+func (ic *InvitationController) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitations, err := ic.InvitationService.WithContext(r.Context()).ListInvitations(tenant)
+	if err != nil {
+		http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// This endpoint withdraws an invitation of the caller's organization that has not been accepted. Method: DELETE, Endpoint: /api/admin/invitations/{id}
+func (ic *InvitationController) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := ic.InvitationService.WithContext(r.Context()).RevokeInvitation(tenant, uint(id)); err != nil {
+		if errors.Is(err, services.ErrInvitationNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation revoked"})
+}
+
+/*
+*
+AcceptInvitationPage
+
+func (ic *InvitationController) AcceptInvitationPage(w http.ResponseWriter, r *http.Request)
+Description: This endpoint is the page invitation emails link to when INVITATION_URL is not set. It shows a form that posts the token from the link together with the chosen username and password to /invitations/accept.
+
+Method: GET
+Endpoint: /invitations/accept?token=...
+*/
+func (ic *InvitationController) AcceptInvitationPage(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, acceptInvitationPage, map[string]interface{}{"Token": r.URL.Query().Get("token")})
+}
+
+/*
+*
+AcceptInvitation
+
+func (ic *InvitationController) AcceptInvitation(w http.ResponseWriter, r *http.Request)
+Description: This endpoint creates the invited admin.
+
+Request:
+
+Method: POST
+Endpoint: /invitations/accept
+Body (JSON format, or the form of the invitation page):
+
+	{
+	  "token": "token_from_the_email",
+	  "username": "admin2",
+	  "password": "admin12345"
+	}
+
+Logic:
+
+The token must be signed by the service, must not have expired, and the invitation must not have been accepted or withdrawn.
+The password must be at least 8 characters long. The invited address becomes the admin's verified email address.
+
+Response:
+
+On success (201 Created): the new admin
+
+On error: 400 Bad Request if the invitation is invalid or the username or password is rejected, 409 Conflict if the username or address is taken
+*/
+func (ic *InvitationController) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		ic.acceptInvitationForm(w, r)
+		return
+	}
+
+	var req models.AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	admin, err := ic.InvitationService.WithContext(r.Context()).Accept(req.Token, req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidInvitation), errors.Is(err, services.ErrUsernameRequired), errors.Is(err, services.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrEmailTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(admin)
+}
+
+func (ic *InvitationController) acceptInvitationForm(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	username := r.PostFormValue("username")
+	admin, err := ic.InvitationService.WithContext(r.Context()).Accept(token, username, r.PostFormValue("password"))
+	switch {
+	case err == nil:
+		renderPage(w, http.StatusCreated, acceptInvitationPage, map[string]interface{}{"Done": true, "Username": admin.Username})
+	case errors.Is(err, services.ErrInvalidInvitation):
+		renderPage(w, http.StatusBadRequest, acceptInvitationPage, map[string]interface{}{"Invalid": true, "Error": "This invitation is invalid, has expired or was already used."})
+	case errors.Is(err, services.ErrEmailTaken):
+		renderPage(w, http.StatusConflict, acceptInvitationPage, map[string]interface{}{"Invalid": true, "Error": "An account with this email address already exists."})
+	case errors.Is(err, services.ErrUsernameTaken):
+		renderPage(w, http.StatusConflict, acceptInvitationPage, map[string]interface{}{"Token": token, "Error": "This username is already taken."})
+	case errors.Is(err, services.ErrUsernameRequired), errors.Is(err, services.ErrWeakPassword):
+		renderPage(w, http.StatusBadRequest, acceptInvitationPage, map[string]interface{}{"Token": token, "Username": username, "Error": "Please enter a username and a password of at least 8 characters."})
+	default:
+		renderPage(w, http.StatusInternalServerError, acceptInvitationPage, map[string]interface{}{"Token": token, "Username": username, "Error": "Failed to create the account. Please try again."})
+	}
+}
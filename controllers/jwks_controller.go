@@ -0,0 +1,40 @@
+package controllers
+
+/**
+The JWKSController publishes the public signing keys of the service so that other services can verify its JWT tokens offline.
+*/
+import (
+	"api-service/utils"
+	"encoding/json"
+	"net/http"
+)
+
+type JWKSController struct {
+	Keys *utils.KeyManager
+}
+
+/*
+*
+JWKS
+
+func (jc *JWKSController) JWKS(w http.ResponseWriter, r *http.Request)
+Description: This endpoint returns the JSON Web Key Set with every public key currently accepted for verification, including keys that were rotated out but are still within their overlap window. Verifiers should select the key by the kid header of the token and refetch the set when they meet an unknown kid.
+
+Request:
+
+Method: GET
+Endpoint: /.well-known/jwks.json
+
+Response:
+
+	{
+	  "keys": [
+	    {"kty": "RSA", "kid": "...", "use": "sig", "alg": "RS256", "n": "...", "e": "AQAB"}
+	  ]
+	}
+*/
+func (jc *JWKSController) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jc.Keys.JWKS())
+}
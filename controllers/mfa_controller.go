@@ -0,0 +1,278 @@
+package controllers
+
+/**
+The MFAController lets users enroll a TOTP authenticator (RFC 6238) as a second factor, and lets admins reset the enrollment of a user who lost their authenticator. Once MFA is enabled, /login returns an mfa_pending challenge token that is exchanged for a JWT token at /login/mfa.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type MFAController struct {
+	/**
+	The MFAService manages TOTP secrets, recovery codes and login challenges.
+	*/
+	MFAService *services.MFAService
+}
+
+/*
+*
+GetStatus
+
+func (mc *MFAController) GetStatus(w http.ResponseWriter, r *http.Request)
+Description: This endpoint reports whether the logged-in user has MFA enabled.
+
+Request:
+
+Method: GET
+Endpoint: /api/profile/mfa
+Headers: Must contain a valid JWT token in the Authorization header.
+
+Response:
+
+	{
+	  "enabled": true,
+	  "pending": false,
+	  "confirmed_at": "2024-01-01T12:00:00Z",
+	  "recovery_codes_remaining": 9
+	}
+*/
+func (mc *MFAController) GetStatus(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	status, err := mc.MFAService.WithContext(r.Context()).Status(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch MFA status", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+/*
+*
+StartEnrollment
+
+func (mc *MFAController) StartEnrollment(w http.ResponseWriter, r *http.Request)
+Description: This endpoint generates a new TOTP secret for the logged-in user. MFA is not enforced until the enrollment is confirmed.
+
+Request:
+
+Method: POST
+Endpoint: /api/profile/mfa
+Headers: Must contain a valid JWT token in the Authorization header.
+
+Response:
+
+On success:
+
+	{
+	  "secret": "JBSWY3DPEHPK3PXP...",
+	  "otpauth_uri": "otpauth://totp/api-service:user1?secret=...&issuer=api-service"
+	}
+
+On error: 409 Conflict if MFA is already enabled
+*/
+func (mc *MFAController) StartEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := mc.MFAService.WithContext(r.Context()).StartEnrollment(*user)
+	if err != nil {
+		if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+			http.Error(w, "MFA is already enabled", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to start MFA enrollment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+/*
+*
+ConfirmEnrollment
+
+func (mc *MFAController) ConfirmEnrollment(w http.ResponseWriter, r *http.Request)
+Description: This endpoint enables MFA once the user enters a code from the authenticator. It returns one-time recovery codes, which are shown only once.
+
+Request:
+
+Method: POST
+Endpoint: /api/profile/mfa/confirm
+Headers: Must contain a valid JWT token in the Authorization header.
+Body (JSON format):
+
+	{
+	  "code": "123456"
+	}
+
+Response:
+
+On success:
+
+	{
+	  "recovery_codes": ["ABCDEFGH-IJKLMNOP", "..."]
+	}
+
+On error: 400 Bad Request if the code is invalid or no enrollment was started
+*/
+func (mc *MFAController) ConfirmEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body models.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := mc.MFAService.WithContext(r.Context()).ConfirmEnrollment(user.ID, body.Code)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+/*
+*
+RegenerateRecoveryCodes
+
+func (mc *MFAController) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+Description: This endpoint replaces the user's recovery codes. It requires a valid TOTP or recovery code in the body ({"code": "123456"}).
+
+Method: POST
+Endpoint: /api/profile/mfa/recovery-codes
+
+On error: 400 Bad Request for a wrong code, or 429 Too Many Requests while the second factor is locked after too many wrong codes
+*/
+func (mc *MFAController) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body models.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := mc.MFAService.WithContext(r.Context()).RegenerateRecoveryCodes(user.ID, body.Code)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+/*
+*
+Disable
+
+func (mc *MFAController) Disable(w http.ResponseWriter, r *http.Request)
+Description: This endpoint turns MFA off for the logged-in user. It requires a valid TOTP or recovery code in the body ({"code": "123456"}).
+
+Method: DELETE
+Endpoint: /api/profile/mfa
+
+On error: 400 Bad Request for a wrong code, or 429 Too Many Requests while the second factor is locked after too many wrong codes
+*/
+func (mc *MFAController) Disable(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var body models.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := mc.MFAService.WithContext(r.Context()).Disable(user.ID, body.Code); err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "MFA disabled"})
+}
+
+/*
+*
+AdminReset
+
+func (mc *MFAController) AdminReset(w http.ResponseWriter, r *http.Request)
+Description: This endpoint lets an admin remove the MFA enrollment of a user of their organization who lost their authenticator and recovery codes. The user can log in with their password alone and enroll again.
+
+Method: DELETE
+Endpoint: /api/admin/users/{id}/mfa
+*/
+func (mc *MFAController) AdminReset(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, _ := strconv.Atoi(vars["id"])
+
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := mc.MFAService.WithContext(r.Context()).AdminReset(services.TenantFromClaims(claims), uint(userID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to reset MFA", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User's MFA reset"})
+}
+
+func writeMFAError(w http.ResponseWriter, err error) {
+	var locked *services.LockedError
+	switch {
+	case errors.As(err, &locked):
+		writeLocked(w, locked)
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrMFANotEnrolled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to update MFA", http.StatusInternalServerError)
+	}
+}
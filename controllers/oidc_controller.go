@@ -0,0 +1,635 @@
+package controllers
+
+/**
+The OIDCController lets other applications sign users in against this service as an OpenID Connect provider. It implements the authorization code flow with PKCE: the user logs in with the credentials checked by UserService.Authenticate, consents to the requested scopes, and the client exchanges the resulting code for an access token and an ID token signed with the service's keys.
+*/
+import (
+	"api-service/metrics"
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type OIDCController struct {
+	/**
+	The OIDCService manages registered clients, authorization requests, consents and token issuance.
+	*/
+	OIDCService *services.OIDCService
+	/**
+	The UserService authenticates the credentials entered on the login page.
+	*/
+	UserService *services.UserService
+	/**
+	The MFAService checks the authentication code of users who have MFA enabled.
+	*/
+	MFAService *services.MFAService
+	/**
+	The EmailVerificationService decides whether a user with an unverified email address may sign in.
+	*/
+	EmailVerificationService *services.EmailVerificationService
+	/**
+	The Keys sign the tokens; the algorithm of the active key is advertised in the discovery document.
+	*/
+	Keys *utils.KeyManager
+	/**
+	The AuditService records sign-ins on the login page.
+	*/
+	AuditService *services.AuditService
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.Client.Name}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="POST" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Username <input name="username" autocomplete="username" required></label>
+<label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+<label>Authentication code (if enabled) <input name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+</form>
+</body></html>`))
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Authorize {{.Client.Name}}</title></head>
+<body>
+<h1>{{.Client.Name}} wants to access your account</h1>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="POST" action="/oauth/consent">
+<input type="hidden" name="consent_challenge" value="{{.Challenge}}">
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body></html>`))
+
+/*
+*
+Discovery
+
+func (oc *OIDCController) Discovery(w http.ResponseWriter, r *http.Request)
+Description: This endpoint returns the OpenID Provider metadata so that clients can configure themselves.
+
+Request:
+
+Method: GET
+Endpoint: /.well-known/openid-configuration
+*/
+func (oc *OIDCController) Discovery(w http.ResponseWriter, r *http.Request) {
+	key, err := oc.Keys.ActiveKey()
+	if err != nil {
+		http.Error(w, "No signing key available", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oc.OIDCService.Discovery(key.Algorithm))
+}
+
+/*
+*
+Authorize
+
+func (oc *OIDCController) Authorize(w http.ResponseWriter, r *http.Request)
+Description: This endpoint starts the authorization code flow and shows the login page.
+
+Request:
+
+Method: GET
+Endpoint: /oauth/authorize?response_type=code&client_id=...&redirect_uri=...&scope=openid%20profile&state=...&nonce=...&code_challenge=...&code_challenge_method=S256
+
+Logic:
+
+If the client ID or redirect URI is invalid, a 400 Bad Request error is shown and the user is not redirected.
+Any other invalid parameter is reported to the client by redirecting to its redirect URI with an error.
+Otherwise the login page is rendered, carrying the authorization parameters along.
+*/
+func (oc *OIDCController) Authorize(w http.ResponseWriter, r *http.Request) {
+	params := authorizeParams(r.URL.Query())
+	client, err := oc.OIDCService.WithContext(r.Context()).ValidateAuthorizeRequest(params)
+	if err != nil {
+		oc.authorizeError(w, r, client, params, err)
+		return
+	}
+
+	renderPage(w, http.StatusOK, loginPage, map[string]interface{}{
+		"Client": client,
+		"Params": hiddenParams(params),
+	})
+}
+
+/*
+*
+AuthorizeSubmit
+
+func (oc *OIDCController) AuthorizeSubmit(w http.ResponseWriter, r *http.Request)
+Description: This endpoint receives the login form.
+
+Request:
+
+Method: POST
+Endpoint: /oauth/authorize
+Body (form encoded): the authorization parameters together with username and password.
+
+Logic:
+
+The credentials are checked with UserService.Authenticate. On failure the login page is shown again with a 401 Unauthorized status, or 429 Too Many Requests while the username or IP address is locked out.
+If EMAIL_VERIFICATION_REQUIRED is set, users who have not verified their email address cannot sign in.
+Users with MFA enabled must also enter a valid TOTP or recovery code. Wrong codes are counted per user together with those entered at /login/mfa; once LOGIN_MFA_MAX_FAILURES is reached the page answers 429 Too Many Requests.
+If the user has already consented to the requested scopes, the user is redirected back to the client with an authorization code.
+Otherwise the consent page is shown.
+*/
+func (oc *OIDCController) AuthorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	params := authorizeParams(r.PostForm)
+	client, err := oc.OIDCService.WithContext(r.Context()).ValidateAuthorizeRequest(params)
+	if err != nil {
+		oc.authorizeError(w, r, client, params, err)
+		return
+	}
+
+	user, err := oc.UserService.WithContext(r.Context()).Authenticate(r.PostForm.Get("username"), r.PostForm.Get("password"), utils.ClientIP(r))
+	if errors.Is(err, services.ErrLoginLocked) {
+		recordLoginFailure(oc.AuditService, r, r.PostForm.Get("username"), "oidc", "locked")
+		renderPage(w, http.StatusTooManyRequests, loginPage, map[string]interface{}{
+			"Client": client,
+			"Params": hiddenParams(params),
+			"Error":  "Too many failed sign-in attempts, please try again later",
+		})
+		return
+	}
+	if err != nil {
+		recordLoginFailure(oc.AuditService, r, r.PostForm.Get("username"), "oidc", "invalid credentials")
+		renderPage(w, http.StatusUnauthorized, loginPage, map[string]interface{}{
+			"Client": client,
+			"Params": hiddenParams(params),
+			"Error":  "Invalid credentials",
+		})
+		return
+	}
+	if err := oc.EmailVerificationService.WithContext(r.Context()).CheckLogin(user); err != nil {
+		recordLoginFailure(oc.AuditService, r, user.Username, "oidc", "email not verified")
+		renderPage(w, http.StatusForbidden, loginPage, map[string]interface{}{
+			"Client": client,
+			"Params": hiddenParams(params),
+			"Error":  "Please verify your email address before signing in",
+		})
+		return
+	}
+
+	mfaEnabled, err := oc.MFAService.WithContext(r.Context()).Enabled(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to authorize", http.StatusInternalServerError)
+		return
+	}
+	if mfaEnabled {
+		err := oc.MFAService.WithContext(r.Context()).VerifyAttempt(user.ID, r.PostForm.Get("otp"))
+		if errors.Is(err, services.ErrLoginLocked) {
+			recordLoginFailure(oc.AuditService, r, user.Username, "oidc", "locked")
+			renderPage(w, http.StatusTooManyRequests, loginPage, map[string]interface{}{
+				"Client": client,
+				"Params": hiddenParams(params),
+				"Error":  "Too many invalid authentication codes, please try again later",
+			})
+			return
+		}
+		if err != nil {
+			recordLoginFailure(oc.AuditService, r, user.Username, "oidc", "invalid authentication code")
+			renderPage(w, http.StatusUnauthorized, loginPage, map[string]interface{}{
+				"Client": client,
+				"Params": hiddenParams(params),
+				"Error":  "Invalid authentication code",
+			})
+			return
+		}
+	}
+
+	event := withUser(auditEvent(r, models.AuditLoginSucceeded), *user)
+	event.Details = map[string]string{"method": "oidc", "client_id": params.ClientID}
+	recordAudit(r, oc.AuditService, event)
+	metrics.Logins.Inc("oidc", metrics.LoginSucceeded)
+
+	result, err := oc.OIDCService.WithContext(r.Context()).Authorize(params, user)
+	if err != nil {
+		http.Error(w, "Failed to authorize", http.StatusInternalServerError)
+		return
+	}
+	if result.Code != "" {
+		redirectWithParams(w, r, params.RedirectURI, url.Values{"code": {result.Code}, "state": {params.State}})
+		return
+	}
+
+	renderPage(w, http.StatusOK, consentPage, map[string]interface{}{
+		"Client":    client,
+		"Scopes":    strings.Fields(params.Scope),
+		"Challenge": result.ConsentChallenge,
+	})
+}
+
+/*
+*
+Consent
+
+func (oc *OIDCController) Consent(w http.ResponseWriter, r *http.Request)
+Description: This endpoint receives the decision from the consent page.
+
+Request:
+
+Method: POST
+Endpoint: /oauth/consent
+Body (form encoded): consent_challenge and decision ("approve" or "deny").
+
+Logic:
+
+On approval the consent is remembered and the user is redirected back to the client with an authorization code.
+On denial the user is redirected back with error=access_denied.
+*/
+func (oc *OIDCController) Consent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	req, code, err := oc.OIDCService.WithContext(r.Context()).DecideConsent(r.PostForm.Get("consent_challenge"), r.PostForm.Get("decision") == "approve")
+	if err != nil {
+		oerr, ok := services.IsOAuthError(err)
+		if req != nil && ok {
+			redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {oerr.Code}, "state": {req.State}})
+			return
+		}
+		if ok {
+			http.Error(w, oerr.Description, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to record consent", http.StatusInternalServerError)
+		return
+	}
+
+	redirectWithParams(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+/*
+*
+Token
+
+func (oc *OIDCController) Token(w http.ResponseWriter, r *http.Request)
+Description: This endpoint issues tokens. It supports the authorization_code grant for applications that sign users in, and the client_credentials grant for batch jobs and internal services that act on their own behalf.
+
+Request:
+
+Method: POST
+Endpoint: /oauth/token
+Headers: Authorization: Basic base64(client_id:client_secret), unless the client is public or sends its credentials in the body.
+Body (form encoded):
+
+	grant_type=authorization_code&code=...&redirect_uri=...&code_verifier=...
+
+or
+
+	grant_type=client_credentials&scope=users:read
+
+Logic:
+
+For the client_credentials grant the client must be confidential and registered with that grant type. The requested scope must be a subset of the client's allowed scopes; without a scope parameter all allowed scopes are granted. The token carries the client ID as subject and no user claims, and no ID token is returned.
+
+Response:
+
+On success:
+
+	{
+	  "access_token": "...",
+	  "token_type": "Bearer",
+	  "expires_in": 900,
+	  "id_token": "...",
+	  "scope": "openid profile"
+	}
+
+On error: 400 Bad Request or 401 Unauthorized with an OAuth2 error body
+*/
+func (oc *OIDCController) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &services.OAuthError{Code: "invalid_request", Description: "malformed request body"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	} else {
+		// Credentials in the Basic header are form-urlencoded (RFC 6749 section 2.3.1)
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+
+	client, err := oc.OIDCService.WithContext(r.Context()).AuthenticateClient(clientID, clientSecret)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		resp, err := oc.OIDCService.WithContext(r.Context()).ExchangeCode(client, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+		if err != nil {
+			writeOAuthError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resp)
+	case models.GrantClientCredentials:
+		resp, err := oc.OIDCService.WithContext(r.Context()).ClientCredentials(client, r.PostForm.Get("scope"))
+		if err != nil {
+			writeOAuthError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resp)
+	default:
+		writeOAuthError(w, &services.OAuthError{Code: "unsupported_grant_type", Description: "unsupported grant_type"})
+	}
+}
+
+/*
+*
+UserInfo
+
+func (oc *OIDCController) UserInfo(w http.ResponseWriter, r *http.Request)
+Description: This endpoint returns claims about the signed in user, limited to the scopes of the access token.
+
+Request:
+
+Method: GET or POST
+Endpoint: /userinfo
+Headers: Authorization: Bearer <access_token>
+
+Response:
+
+	{
+	  "sub": "42",
+	  "name": "User One",
+	  "preferred_username": "user1",
+	  "email": "user1@example.com"
+	}
+*/
+func (oc *OIDCController) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	info, err := oc.OIDCService.WithContext(r.Context()).UserInfo(claims)
+	if err != nil {
+		if oerr, ok := services.IsOAuthError(err); ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="`+oerr.Code+`"`)
+			http.Error(w, oerr.Description, http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to load user info", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+/*
+*
+RegisterClient
+
+func (oc *OIDCController) RegisterClient(w http.ResponseWriter, r *http.Request)
+Description: This endpoint lets an admin register a new client application.
+
+Request:
+
+Method: POST
+Endpoint: /api/admin/clients
+Body (JSON format):
+
+	{
+	  "client_name": "Wiki",
+	  "redirect_uris": ["https://wiki.internal/callback"],
+	  "scope": "openid profile email",
+	  "grant_types": ["authorization_code"],
+	  "public": false
+	}
+
+A machine client uses "grant_types": ["client_credentials"] and needs no redirect URIs. Its scopes name roles, e.g. "admin" to call the admin API, or single permissions such as "users:read"; other scopes are refused with 400 invalid_client_metadata, and so are scopes granting a permission the caller does not hold.
+The client belongs to the caller's organization. Its client credentials tokens carry that organization as tenant, so a machine client never works across organizations.
+
+Response:
+
+On success (201 Created), the client secret is only shown in this response:
+
+	{
+	  "client_id": "...",
+	  "client_secret": "...",
+	  "client_name": "Wiki",
+	  "redirect_uris": ["https://wiki.internal/callback"],
+	  "scope": "openid profile email",
+	  "public": false
+	}
+*/
+func (oc *OIDCController) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var reg models.ClientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := oc.OIDCService.WithContext(r.Context()).RegisterClient(tenant, claims, reg)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// This endpoint lists the clients registered in the caller's organization. Method: GET, Endpoint: /api/admin/clients
+func (oc *OIDCController) ListClients(w http.ResponseWriter, r *http.Request) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	clients, err := oc.OIDCService.WithContext(r.Context()).ListClients(tenant)
+	if err != nil {
+		http.Error(w, "Failed to fetch clients", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(clients)
+}
+
+// This endpoint removes a client registered in the caller's organization. Method: DELETE, Endpoint: /api/admin/clients/{client_id}
+func (oc *OIDCController) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := oc.OIDCService.WithContext(r.Context()).DeleteClient(tenant, mux.Vars(r)["client_id"]); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete client", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Client deleted"})
+}
+
+// This endpoint lists the clients the logged-in user has consented to. Method: GET, Endpoint: /api/consents
+func (oc *OIDCController) ListConsents(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	consents, err := oc.OIDCService.WithContext(r.Context()).ListConsents(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch consents", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(consents)
+}
+
+// This endpoint withdraws the logged-in user's consent for a client. Method: DELETE, Endpoint: /api/consents/{client_id}
+func (oc *OIDCController) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := oc.OIDCService.WithContext(r.Context()).RevokeConsent(user.ID, mux.Vars(r)["client_id"]); err != nil {
+		http.Error(w, "Failed to revoke consent", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Consent revoked"})
+}
+
+func (oc *OIDCController) authorizeError(w http.ResponseWriter, r *http.Request, client *models.RegisteredClient, params services.AuthorizeParams, err error) {
+	oerr, ok := services.IsOAuthError(err)
+	if !ok {
+		http.Error(w, "Failed to authorize", http.StatusInternalServerError)
+		return
+	}
+	if client == nil {
+		// Never redirect to an unverified redirect URI
+		http.Error(w, oerr.Description, http.StatusBadRequest)
+		return
+	}
+	redirectWithParams(w, r, params.RedirectURI, url.Values{
+		"error":             {oerr.Code},
+		"error_description": {oerr.Description},
+		"state":             {params.State},
+	})
+}
+
+func authorizeParams(values url.Values) services.AuthorizeParams {
+	return services.AuthorizeParams{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+func hiddenParams(p services.AuthorizeParams) map[string]string {
+	return map[string]string{
+		"response_type":         p.ResponseType,
+		"client_id":             p.ClientID,
+		"redirect_uri":          p.RedirectURI,
+		"scope":                 p.Scope,
+		"state":                 p.State,
+		"nonce":                 p.Nonce,
+		"code_challenge":        p.CodeChallenge,
+		"code_challenge_method": p.CodeChallengeMethod,
+	}
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			q.Set(key, values[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func renderPage(w http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	page.Execute(w, data)
+}
+
+func writeOAuthError(w http.ResponseWriter, err error) {
+	oerr, ok := services.IsOAuthError(err)
+	if !ok {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusBadRequest
+	if oerr.Code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             oerr.Code,
+		"error_description": oerr.Description,
+	})
+}
@@ -0,0 +1,157 @@
+package controllers
+
+/**
+The OrganizationController lets super admins manage organizations, the customer companies users belong to. Admins of an organization only see and manage its users; the first admin of a new organization is added through /api/admin/invitations with its organization_id.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type OrganizationController struct {
+	/**
+	The OrganizationService stores the organizations.
+	*/
+	OrganizationService *services.OrganizationService
+}
+
+// This endpoint lists every organization. Method: GET, Endpoint: /api/admin/organizations
+func (oc *OrganizationController) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	orgs, err := oc.OrganizationService.WithContext(r.Context()).ListOrganizations()
+	if err != nil {
+		http.Error(w, "Failed to fetch organizations", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(orgs)
+}
+
+/*
+*
+CreateOrganization
+
+func (oc *OrganizationController) CreateOrganization(w http.ResponseWriter, r *http.Request)
+Description: This endpoint creates an organization.
+
+Request:
+
+Method: POST
+Endpoint: /api/admin/organizations
+Headers: Must contain a valid JWT token of a super admin in the Authorization header.
+Body (JSON format):
+
+	{
+	  "name": "Acme Corp",
+	  "slug": "acme"
+	}
+
+Logic:
+
+Slugs may only contain lowercase letters, digits and '-'. The organization starts without users; invite its first admin with POST /api/admin/invitations and "organization_id".
+
+Response:
+
+On success (201 Created):
+
+	{
+	  "id": 2,
+	  "name": "Acme Corp",
+	  "slug": "acme",
+	  "created_at": "2024-01-01T12:00:00Z"
+	}
+
+On error: 400 Bad Request for a missing name or invalid slug, 403 Forbidden unless the caller is a super admin, 409 Conflict if the slug is taken
+*/
+func (oc *OrganizationController) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	var req models.OrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	org, err := oc.OrganizationService.WithContext(r.Context()).CreateOrganization(req)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
+
+// This endpoint deletes an organization without users, together with its clients and invitations. Method: DELETE, Endpoint: /api/admin/organizations/{id}
+func (oc *OrganizationController) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := oc.OrganizationService.WithContext(r.Context()).DeleteOrganization(uint(id)); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Organization deleted"})
+}
+
+// requestTenant returns the tenant of the caller, taken from the claims of its token
+func requestTenant(r *http.Request) (services.Tenant, error) {
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		return services.Tenant{}, err
+	}
+	return services.TenantFromClaims(claims), nil
+}
+
+// requireCrossTenant lets super admins through. Changes that affect every organization, such as organizations
+// themselves and the shared role and policy catalogs, are reserved to them. Other callers get 403 Forbidden.
+func requireCrossTenant(w http.ResponseWriter, r *http.Request) bool {
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if !tenant.CrossTenant {
+		http.Error(w, "Forbidden - "+services.ErrCrossTenant.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func writeOrganizationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrOrganizationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrOrganizationExists), errors.Is(err, services.ErrOrganizationNotEmpty),
+		errors.Is(err, services.ErrDefaultOrganization):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrOrganizationNameMissing), errors.Is(err, services.ErrInvalidOrganizationSlug):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrCrossTenant):
+		http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to update organizations", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,376 @@
+package controllers
+
+/**
+The PasskeyController lets users register passkeys (WebAuthn credentials) on their profile and log in with them instead of a password. Both ceremonies take two requests: "begin" returns the options for the browser's WebAuthn API together with a session ID, and "finish" submits the authenticator's response for that session.
+*/
+import (
+	"api-service/metrics"
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type PasskeyController struct {
+	/**
+	The PasskeyService runs the WebAuthn ceremonies and stores the registered credentials.
+	*/
+	PasskeyService *services.PasskeyService
+	/**
+	The UserService is used to store the latest token of a user that logged in with a passkey.
+	*/
+	UserService *services.UserService
+	/**
+	The TokenService issues access tokens together with refresh tokens.
+	*/
+	TokenService *services.TokenService
+	/**
+	The MFAService decides whether a passkey login needs a second factor.
+	*/
+	MFAService *services.MFAService
+	/**
+	The EmailVerificationService decides whether a user with an unverified email address may log in.
+	*/
+	EmailVerificationService *services.EmailVerificationService
+	/**
+	The AuditService records passkey logins.
+	*/
+	AuditService *services.AuditService
+}
+
+/*
+*
+BeginRegistration
+
+func (pc *PasskeyController) BeginRegistration(w http.ResponseWriter, r *http.Request)
+Description: This endpoint starts the registration of a new passkey for the logged-in user.
+
+Request:
+
+Method: POST
+Endpoint: /api/profile/passkeys/register/begin
+Headers: Must contain a valid JWT token in the Authorization header.
+
+Response:
+
+The publicKey object is passed to navigator.credentials.create({publicKey}) after decoding its base64url fields. Passkeys the user already registered are listed in excludeCredentials.
+
+	{
+	  "session_id": "your_session_id_here",
+	  "publicKey": {
+	    "challenge": "...",
+	    "rp": {"id": "example.com", "name": "api-service"},
+	    "user": {"id": "...", "name": "user1", "displayName": "User One"},
+	    "pubKeyCredParams": [{"type": "public-key", "alg": -7}, ...],
+	    "timeout": 300000,
+	    "attestation": "none",
+	    "excludeCredentials": [],
+	    "authenticatorSelection": {"residentKey": "preferred", "userVerification": "preferred"}
+	  }
+	}
+*/
+func (pc *PasskeyController) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	profile, err := pc.UserService.WithContext(r.Context()).GetUser(user.ID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	options, err := pc.PasskeyService.WithContext(r.Context()).BeginRegistration(profile)
+	if err != nil {
+		http.Error(w, "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(options)
+}
+
+/*
+*
+FinishRegistration
+
+func (pc *PasskeyController) FinishRegistration(w http.ResponseWriter, r *http.Request)
+Description: This endpoint verifies the authenticator's response and stores the new passkey.
+
+Request:
+
+Method: POST
+Endpoint: /api/profile/passkeys/register/finish
+Headers: Must contain a valid JWT token in the Authorization header.
+Body (JSON format):
+
+	{
+	  "session_id": "your_session_id_here",
+	  "name": "MacBook Touch ID",
+	  "credential": {
+	    "id": "...",
+	    "rawId": "...",
+	    "type": "public-key",
+	    "response": {"clientDataJSON": "...", "attestationObject": "...", "transports": ["internal"]}
+	  }
+	}
+
+Logic:
+
+The session must have been started by the same user, is single use and expires after 5 minutes.
+The client data must carry the session's challenge and an allowed origin, and the authenticator data the hash of the relying party ID.
+Attestation formats "none" and "packed" are accepted; ES256, EdDSA and RS256 keys are supported.
+
+Response:
+
+On success: 201 Created with the stored passkey
+
+On error: 400 Bad Request if verification fails, 409 Conflict if the passkey is already registered
+*/
+func (pc *PasskeyController) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.PasskeyRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := pc.PasskeyService.WithContext(r.Context()).FinishRegistration(user.ID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPasskeySession), errors.Is(err, services.ErrInvalidPasskey):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrPasskeyExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to register passkey", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+/*
+*
+ListPasskeys
+
+func (pc *PasskeyController) ListPasskeys(w http.ResponseWriter, r *http.Request)
+Description: This endpoint lists the passkeys of the logged-in user.
+
+Method: GET
+Endpoint: /api/profile/passkeys
+*/
+func (pc *PasskeyController) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := pc.PasskeyService.WithContext(r.Context()).List(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(credentials)
+}
+
+/*
+*
+DeletePasskey
+
+func (pc *PasskeyController) DeletePasskey(w http.ResponseWriter, r *http.Request)
+Description: This endpoint removes a passkey of the logged-in user. The passkey can no longer be used to log in.
+
+Method: DELETE
+Endpoint: /api/profile/passkeys/{id}
+*/
+func (pc *PasskeyController) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user, err := utils.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	if err := pc.PasskeyService.WithContext(r.Context()).Delete(user.ID, uint(id)); err != nil {
+		if errors.Is(err, services.ErrPasskeyNotFound) {
+			http.Error(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete passkey", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Passkey deleted"})
+}
+
+/*
+*
+BeginLogin
+
+func (pc *PasskeyController) BeginLogin(w http.ResponseWriter, r *http.Request)
+Description: This endpoint starts a passkey login.
+
+Request:
+
+Method: POST
+Endpoint: /login/passkey/begin
+Body (JSON format, optional):
+
+	{
+	  "username": "user1"
+	}
+
+Logic:
+
+With a username, the user's passkeys are returned in allowCredentials. Without one, allowCredentials is empty and the browser offers the discoverable passkeys it holds for the relying party.
+An unknown username gets the same response as a request without username, so the endpoint does not reveal which accounts exist.
+
+Response:
+
+The publicKey object is passed to navigator.credentials.get({publicKey}) after decoding its base64url fields.
+
+	{
+	  "session_id": "your_session_id_here",
+	  "publicKey": {
+	    "challenge": "...",
+	    "timeout": 300000,
+	    "rpId": "example.com",
+	    "allowCredentials": [],
+	    "userVerification": "preferred"
+	  }
+	}
+*/
+func (pc *PasskeyController) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.PasskeyLoginBegin
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	options, err := pc.PasskeyService.WithContext(r.Context()).BeginLogin(req.Username)
+	if err != nil {
+		http.Error(w, "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(options)
+}
+
+/*
+*
+FinishLogin
+
+func (pc *PasskeyController) FinishLogin(w http.ResponseWriter, r *http.Request)
+Description: This endpoint verifies a passkey assertion and logs the user in.
+
+Request:
+
+Method: POST
+Endpoint: /login/passkey/finish
+Body (JSON format):
+
+	{
+	  "session_id": "your_session_id_here",
+	  "credential": {
+	    "id": "...",
+	    "rawId": "...",
+	    "type": "public-key",
+	    "response": {"clientDataJSON": "...", "authenticatorData": "...", "signature": "...", "userHandle": "..."}
+	  }
+	}
+
+Logic:
+
+The signature is checked with the stored public key, and the authenticator's signature counter must have increased since the last login, which detects cloned authenticators.
+A passkey with user verification (PIN or biometrics) counts as two factors. If the user has MFA enabled and the authenticator only proved user presence, the "mfa_pending" challenge of /login is returned instead of a token pair.
+As with /login, users who have not verified their email address are rejected when EMAIL_VERIFICATION_REQUIRED is set.
+
+Response:
+
+On success: same as /login
+
+On error: 401 Unauthorized, or 403 Forbidden if the email address is not verified
+*/
+func (pc *PasskeyController) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.PasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	user, userVerified, err := pc.PasskeyService.WithContext(r.Context()).FinishLogin(req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPasskeySession) || errors.Is(err, services.ErrInvalidPasskey) {
+			recordLoginFailure(pc.AuditService, r, "", "passkey", err.Error())
+			http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to verify passkey", http.StatusInternalServerError)
+		return
+	}
+	if err := pc.EmailVerificationService.WithContext(r.Context()).CheckLogin(user); err != nil {
+		recordLoginFailure(pc.AuditService, r, user.Username, "passkey", "email not verified")
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	if !userVerified {
+		mfaEnabled, err := pc.MFAService.WithContext(r.Context()).Enabled(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		if mfaEnabled {
+			challenge, err := pc.MFAService.WithContext(r.Context()).IssueChallenge(*user)
+			if err != nil {
+				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+				return
+			}
+			metrics.Logins.Inc("passkey", metrics.LoginMFARequired)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(challenge)
+			return
+		}
+	}
+
+	pair, err := pc.TokenService.WithContext(r.Context()).IssueTokens(*user)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	pc.UserService.WithContext(r.Context()).SaveToken(user, pair.Token)
+
+	event := withUser(auditEvent(r, models.AuditLoginSucceeded), *user)
+	event.Details = map[string]string{"method": "passkey"}
+	recordAudit(r, pc.AuditService, event)
+	metrics.Logins.Inc("passkey", metrics.LoginSucceeded)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
+}
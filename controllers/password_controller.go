@@ -0,0 +1,166 @@
+package controllers
+
+/**
+The PasswordController lets users who forgot their password set a new one. /password/forgot emails a single-use reset link through the configured Mailer, and /password/reset sets the new password with the token from that link.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var resetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Reset password</title><meta name="referrer" content="no-referrer"></head>
+<body>
+<h1>Choose a new password</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Done}}<p>Your password has been reset. You can now sign in with the new password.</p>
+{{else if not .Invalid}}<form method="POST" action="/password/reset">
+<input type="hidden" name="token" value="{{.Token}}">
+<label>New password <input name="password" type="password" autocomplete="new-password" minlength="8" required></label>
+<button type="submit">Reset password</button>
+</form>{{end}}
+</body></html>`))
+
+type PasswordController struct {
+	/**
+	The PasswordService issues reset tokens, sends them by email and sets new passwords.
+	*/
+	PasswordService *services.PasswordService
+}
+
+/*
+*
+ForgotPassword
+
+func (pc *PasswordController) ForgotPassword(w http.ResponseWriter, r *http.Request)
+Description: This endpoint emails a password reset link to the account with the given address.
+
+Request:
+
+Method: POST
+Endpoint: /password/forgot
+Body (JSON format):
+
+	{
+	  "email": "user1@example.com"
+	}
+
+Logic:
+
+A random reset token is generated and only its hash is stored. The token expires after 30 minutes, can be used once, and requesting a new link invalidates older ones.
+The response is the same whether or not an account uses the address, so the endpoint cannot be used to find out which addresses are registered. The link is stored and emailed in the background after the response, so the response time does not tell either.
+
+Response:
+
+	{
+	  "message": "If an account with this email exists, a password reset link has been sent"
+	}
+*/
+func (pc *PasswordController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := pc.PasswordService.WithContext(r.Context()).RequestReset(req.Email); err != nil {
+		http.Error(w, "Failed to request password reset", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If an account with this email exists, a password reset link has been sent"})
+}
+
+/*
+*
+ResetPasswordPage
+
+func (pc *PasswordController) ResetPasswordPage(w http.ResponseWriter, r *http.Request)
+Description: This endpoint is the page the reset email links to when PASSWORD_RESET_URL is not set. It shows a form that posts the token from the link and the new password to /password/reset.
+
+Method: GET
+Endpoint: /password/reset?token=...
+*/
+func (pc *PasswordController) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, resetPage, map[string]interface{}{"Token": r.URL.Query().Get("token")})
+}
+
+/*
+*
+ResetPassword
+
+func (pc *PasswordController) ResetPassword(w http.ResponseWriter, r *http.Request)
+Description: This endpoint sets a new password with the token from a reset link.
+
+Request:
+
+Method: POST
+Endpoint: /password/reset
+Body (JSON format, or the form of the reset page):
+
+	{
+	  "token": "token_from_the_email",
+	  "password": "new_password123"
+	}
+
+Logic:
+
+The token must exist, must not have expired and must not have been used before.
+The new password is hashed with bcrypt and must be at least 8 characters long.
+Every JWT token and refresh token of the user is revoked, so sessions started with the old password end.
+
+Response:
+
+On success:
+
+	{
+	  "message": "Password has been reset"
+	}
+
+On error: 400 Bad Request if the token is invalid or the password too short
+*/
+func (pc *PasswordController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		pc.resetPasswordForm(w, r)
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := pc.PasswordService.WithContext(r.Context()).ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) || errors.Is(err, services.ErrWeakPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
+
+func (pc *PasswordController) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	err := pc.PasswordService.WithContext(r.Context()).ResetPassword(token, r.PostFormValue("password"))
+	switch {
+	case err == nil:
+		renderPage(w, http.StatusOK, resetPage, map[string]interface{}{"Done": true})
+	case errors.Is(err, services.ErrWeakPassword):
+		renderPage(w, http.StatusBadRequest, resetPage, map[string]interface{}{"Token": token, "Error": "The password must be at least 8 characters long."})
+	case errors.Is(err, services.ErrInvalidResetToken):
+		renderPage(w, http.StatusBadRequest, resetPage, map[string]interface{}{"Invalid": true, "Error": "This reset link is invalid or has expired. Please request a new one."})
+	default:
+		renderPage(w, http.StatusInternalServerError, resetPage, map[string]interface{}{"Token": token, "Error": "Failed to reset password. Please try again."})
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+/**
+The PolicyController exposes the attribute-based authorization policies to admins: listing them, changing them when they are stored in the database, and a dry-run endpoint that shows the decision the policies would make for a subject, resource and action.
+*/
+import (
+	"api-service/models"
+	"api-service/policy"
+	"api-service/services"
+	"api-service/storage"
+	"api-service/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type PolicyController struct {
+	/**
+	The PolicyService loads and evaluates the policies.
+	*/
+	PolicyService *services.PolicyService
+	/**
+	The UserService looks up the users named in a dry run.
+	*/
+	UserService *services.UserService
+}
+
+// This endpoint lists the policies in evaluation order. Method: GET, Endpoint: /api/admin/policies
+func (pc *PolicyController) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pc.PolicyService.ListPolicies())
+}
+
+/*
+*
+SavePolicy
+
+func (pc *PolicyController) SavePolicy(w http.ResponseWriter, r *http.Request)
+Description: This endpoint creates or replaces a policy. It is only available when the policies are stored in the database, not when they are loaded from POLICY_FILE. Policies apply to every organization, so only super admins may change them.
+
+Request:
+
+Method: PUT
+Endpoint: /api/admin/policies/{name}
+Body (JSON format):
+
+	{
+	  "name": "support-regional-read",
+	  "effect": "allow",
+	  "priority": 50,
+	  "actions": ["users:read"],
+	  "conditions": [
+	    {"attribute": "subject.roles", "operator": "contains", "value": "support"},
+	    {"attribute": "resource.region", "operator": "equals", "ref": "subject.region"}
+	  ],
+	  "redact": ["address"]
+	}
+
+Response:
+
+On success: the stored policy
+
+On error: 400 Bad Request for an invalid policy, 403 Forbidden unless the caller is a super admin, 409 Conflict when the policies come from a file
+*/
+func (pc *PolicyController) SavePolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	var p models.Policy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if p.Name == "" {
+		p.Name = name
+	}
+	if p.Name != name {
+		http.Error(w, services.ErrInvalidPolicyName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved, err := pc.PolicyService.WithContext(r.Context()).SavePolicy(p)
+	if err != nil {
+		writePolicyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(saved)
+}
+
+// This endpoint deletes a policy stored in the database; only super admins may. Method: DELETE, Endpoint: /api/admin/policies/{name}
+func (pc *PolicyController) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	if err := pc.PolicyService.WithContext(r.Context()).DeletePolicy(mux.Vars(r)["name"]); err != nil {
+		writePolicyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Policy deleted"})
+}
+
+/*
+*
+Evaluate
+
+func (pc *PolicyController) Evaluate(w http.ResponseWriter, r *http.Request)
+Description: This endpoint is a dry run for debugging policies. It returns the decision the policies would make, without performing the action.
+
+Request:
+
+Method: POST
+Endpoint: /api/admin/policies/evaluate
+Body (JSON format):
+
+	{
+	  "action": "users:read",
+	  "subject_user_id": 7,
+	  "resource_user_id": 12
+	}
+
+Logic:
+
+The subject is taken from "subject" (a map of attributes), from the user with "subject_user_id" as if that user had just logged in, or else from the caller's token.
+The resource is taken from "resource" (a map of attributes) or from the user with "resource_user_id".
+Only users of the caller's organization can be named by ID, unless the caller is a super admin.
+
+Response:
+
+	{
+	  "allowed": true,
+	  "policy": "support-regional-read",
+	  "redact": ["address"],
+	  "subject": {"id": "7", "roles": ["user", "support"], "region": "eu", "permissions": []},
+	  "resource": {"id": "12", "region": "eu", "roles": ["user"]}
+	}
+*/
+func (pc *PolicyController) Evaluate(w http.ResponseWriter, r *http.Request) {
+	var req models.PolicyEvaluationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Action == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subject := policy.Attributes(req.Subject)
+	if subject == nil {
+		var err error
+		if req.SubjectUserID != 0 {
+			var user models.User
+			if user, err = pc.UserService.WithContext(r.Context()).GetUserByID(tenant, req.SubjectUserID); err == nil {
+				subject, err = pc.PolicyService.WithContext(r.Context()).SubjectForUser(user)
+			}
+		} else {
+			var claims *models.JWTClaims
+			if claims, err = utils.GetClaimsFromContext(r.Context()); err == nil {
+				subject, err = pc.PolicyService.WithContext(r.Context()).Subject(claims)
+			}
+		}
+		if err != nil {
+			writePolicyError(w, err)
+			return
+		}
+	}
+
+	resource := policy.Attributes(req.Resource)
+	if resource == nil {
+		resource = policy.Attributes{}
+		if req.ResourceUserID != 0 {
+			user, err := pc.UserService.WithContext(r.Context()).GetUserByID(tenant, req.ResourceUserID)
+			if err == nil {
+				resource, err = pc.PolicyService.WithContext(r.Context()).ResourceForUser(user)
+			}
+			if err != nil {
+				writePolicyError(w, err)
+				return
+			}
+		}
+	}
+
+	decision := pc.PolicyService.Evaluate(req.Action, subject, resource)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.PolicyEvaluationResponse{PolicyDecision: decision, Subject: subject, Resource: resource})
+}
+
+func writePolicyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrPolicyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidPolicy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrPoliciesReadOnly):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to evaluate policies", http.StatusInternalServerError)
+	}
+}
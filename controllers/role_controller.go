@@ -0,0 +1,338 @@
+package controllers
+
+/**
+The RoleController lets admins manage roles, the permissions bound to them, and the roles users hold. Routes are guarded in main.go with the roles:read and roles:manage permissions.
+Roles are shared by every organization, so only super admins create, change or delete them. Admins assign them to the users of their own organization.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type RoleController struct {
+	/**
+	The RBACService stores roles, permissions and role assignments.
+	*/
+	RBACService *services.RBACService
+	/**
+	The AuditService records changes to roles and to the roles of users.
+	*/
+	AuditService *services.AuditService
+}
+
+// This endpoint lists the permissions roles can be bound to. Method: GET, Endpoint: /api/admin/permissions
+func (rc *RoleController) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := rc.RBACService.WithContext(r.Context()).ListPermissions()
+	if err != nil {
+		http.Error(w, "Failed to fetch permissions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(perms)
+}
+
+// This endpoint lists every role with its permissions. Method: GET, Endpoint: /api/admin/roles
+func (rc *RoleController) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := rc.RBACService.WithContext(r.Context()).ListRoles()
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+/*
+*
+CreateRole
+
+func (rc *RoleController) CreateRole(w http.ResponseWriter, r *http.Request)
+Description: This endpoint creates a role.
+
+Request:
+
+Method: POST
+Endpoint: /api/admin/roles
+Body (JSON format):
+
+	{
+	  "name": "support",
+	  "description": "Support staff",
+	  "permissions": ["users:read", "mfa:reset"]
+	}
+
+Logic:
+
+Role names may only contain lowercase letters, digits, '-' and '_'. Every permission must be one of /api/admin/permissions.
+
+Response:
+
+On success (201 Created): the role with its permissions
+
+On error: 400 Bad Request for an invalid name or unknown permission, 403 Forbidden unless the caller is a super admin, 409 Conflict if the role exists
+*/
+func (rc *RoleController) CreateRole(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	var req models.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	role, err := rc.RBACService.WithContext(r.Context()).CreateRole(req)
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	recordAudit(r, rc.AuditService, onRole(auditEvent(r, models.AuditRoleCreated), role))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+/*
+*
+UpdateRole
+
+func (rc *RoleController) UpdateRole(w http.ResponseWriter, r *http.Request)
+Description: This endpoint replaces the description and permissions of a role. The change applies to tokens that were already issued, since permissions are looked up on every request. The admin and super_admin roles cannot be changed.
+
+Request:
+
+Method: PUT
+Endpoint: /api/admin/roles/{name}
+Body (JSON format):
+
+	{
+	  "description": "Support staff",
+	  "permissions": ["users:read"]
+	}
+
+Response:
+
+On success: the role with its permissions
+
+On error: 400 Bad Request for an unknown permission or a built-in admin role, 403 Forbidden unless the caller is a super admin, 404 Not Found if the role does not exist
+*/
+func (rc *RoleController) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	var req models.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	role, err := rc.RBACService.WithContext(r.Context()).UpdateRole(mux.Vars(r)["name"], req)
+	if err != nil {
+		if errors.Is(err, services.ErrRoleNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeRoleError(w, err)
+		return
+	}
+	recordAudit(r, rc.AuditService, onRole(auditEvent(r, models.AuditRoleUpdated), role))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(role)
+}
+
+// This endpoint deletes a role that is not built in; users holding it lose it. Method: DELETE, Endpoint: /api/admin/roles/{name}
+func (rc *RoleController) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if !requireCrossTenant(w, r) {
+		return
+	}
+
+	if err := rc.RBACService.WithContext(r.Context()).DeleteRole(mux.Vars(r)["name"]); err != nil {
+		if errors.Is(err, services.ErrRoleNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeRoleError(w, err)
+		return
+	}
+	recordAudit(r, rc.AuditService, onRole(auditEvent(r, models.AuditRoleDeleted), models.Role{Name: mux.Vars(r)["name"]}))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role deleted"})
+}
+
+// This endpoint lists the roles a user of the caller's organization holds directly. Method: GET, Endpoint: /api/admin/users/{id}/roles
+func (rc *RoleController) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roles, err := rc.RBACService.WithContext(r.Context()).UserRoles(tenant, uint(userID))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+/*
+*
+GetEffectiveRoles
+
+func (rc *RoleController) GetEffectiveRoles(w http.ResponseWriter, r *http.Request)
+Description: This endpoint explains where each role of a user comes from: the roles the user holds directly and those inherited from groups. These are the roles the user's next access token carries.
+
+Method: GET
+Endpoint: /api/admin/users/{id}/effective-roles
+
+Response:
+
+	[
+	  {"role": "user", "grants": [{"source": "direct"}]},
+	  {"role": "support", "grants": [
+	    {"source": "direct"},
+	    {"source": "group", "group_id": 1, "group": "engineering", "path": ["backend", "engineering"]}
+	  ]}
+	]
+
+The path lists the groups from the one the user is a member of up to the group the role is given to.
+
+On error: 404 Not Found if the user does not exist or belongs to another organization
+*/
+func (rc *RoleController) GetEffectiveRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roles, err := rc.RBACService.WithContext(r.Context()).EffectiveRoles(tenant, uint(userID))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+/*
+*
+SetUserRoles
+
+func (rc *RoleController) SetUserRoles(w http.ResponseWriter, r *http.Request)
+Description: This endpoint replaces the roles of a user of the caller's organization.
+
+Request:
+
+Method: PUT
+Endpoint: /api/admin/users/{id}/roles
+Body (JSON format):
+
+	{
+	  "roles": ["user", "support"]
+	}
+
+Logic:
+
+The admin role cannot be given here; admins join through /api/admin/invitations. The last admin of an organization cannot lose the admin role.
+Only super admins give or take the super_admin role, and the last super admin keeps it.
+The user's access tokens are revoked, so removed roles stop working at once. The user's next /token/refresh issues a token with the new roles.
+
+Response:
+
+On success: the roles the user now holds
+
+On error: 400 Bad Request for an unknown role or the admin role, 403 Forbidden for the super_admin role unless the caller is a super admin, 404 Not Found if the user does not exist or belongs to another organization, 409 Conflict for the last admin of the organization or the last super admin
+*/
+func (rc *RoleController) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UserRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	roles, err := rc.RBACService.WithContext(r.Context()).SetUserRoles(tenant, uint(userID), req.Roles)
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = role.Name
+	}
+	event := auditEvent(r, models.AuditUserRolesChanged)
+	event.TargetType, event.TargetID = "user", strconv.FormatUint(userID, 10)
+	event.Details = map[string]string{"roles": strings.Join(names, ",")}
+	recordAudit(r, rc.AuditService, event)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+// onRole makes a role the target of an event and records the permissions it grants
+func onRole(event models.AuditEvent, role models.Role) models.AuditEvent {
+	event.TargetType = "role"
+	event.TargetID = role.Name
+	event.TargetName = role.Name
+	if len(role.Permissions) > 0 {
+		event.Details = map[string]string{"permissions": strings.Join(role.PermissionNames(), ",")}
+	}
+	return event
+}
+
+func writeRoleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrRoleExists), errors.Is(err, services.ErrLastAdmin), errors.Is(err, services.ErrLastSuperAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrSuperAdminRole):
+		http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrRoleNotFound), errors.Is(err, services.ErrInvalidRoleName),
+		errors.Is(err, services.ErrUnknownPermission), errors.Is(err, services.ErrBuiltinRole),
+		errors.Is(err, services.ErrAdminRoleByInvite):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update roles", http.StatusInternalServerError)
+	}
+}
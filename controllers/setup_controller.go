@@ -0,0 +1,76 @@
+package controllers
+
+/**
+The SetupController creates the first admin of a new deployment. It only works while no admin exists, and only with the one-time setup token that is printed to the log at startup or read from BOOTSTRAP_TOKEN_FILE.
+*/
+import (
+	"api-service/models"
+	"api-service/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type SetupController struct {
+	/**
+	The BootstrapService holds the setup token and creates the first admin.
+	*/
+	BootstrapService *services.BootstrapService
+}
+
+/*
+*
+Setup
+
+func (sc *SetupController) Setup(w http.ResponseWriter, r *http.Request)
+Description: This endpoint creates the first admin with the setup token.
+
+Request:
+
+Method: POST
+Endpoint: /setup
+Body (JSON format):
+
+	{
+	  "token": "setup_token_from_the_log",
+	  "username": "admin1",
+	  "password": "admin12345",
+	  "email": "admin1@example.com"
+	}
+
+Logic:
+
+The token is compared with the setup token of this start. It works once: setup closes as soon as an admin exists, and the endpoint answers 410 Gone from then on.
+The password must be at least 8 characters long. The email address is marked verified.
+
+Response:
+
+On success (201 Created): the new admin
+
+On error: 401 Unauthorized if the token is wrong, 400 Bad Request for an invalid username or password, 410 Gone once setup is closed
+*/
+func (sc *SetupController) Setup(w http.ResponseWriter, r *http.Request) {
+	var req models.SetupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	admin, err := sc.BootstrapService.WithContext(r.Context()).CreateAdmin(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSetupClosed):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, services.ErrInvalidSetupToken):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, services.ErrUsernameRequired), errors.Is(err, services.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to create admin", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(admin)
+}
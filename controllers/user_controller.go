@@ -4,11 +4,17 @@ package controllers
 The UserController manages user-related operations such as user registration, login, profile viewing, profile updates, and JWT token management. It interacts with the UserService to perform business logic, including authentication and profile management.
 */
 import (
+	"api-service/metrics"
 	"api-service/models"
 	"api-service/services"
 	"api-service/utils"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
 )
 
 type UserController struct {
@@ -16,6 +22,26 @@ type UserController struct {
 	The UserService handles the core business logic for user management, including creating users, authenticating logins, and managing user profiles.
 	*/
 	UserService *services.UserService
+	/**
+	The TokenService issues access tokens together with refresh tokens and rotates refresh tokens.
+	*/
+	TokenService *services.TokenService
+	/**
+	The MFAService decides whether a login needs a second factor and verifies it.
+	*/
+	MFAService *services.MFAService
+	/**
+	The EmailVerificationService emails verification links to new accounts and decides whether an unverified user may log in.
+	*/
+	EmailVerificationService *services.EmailVerificationService
+	/**
+	The PolicyService decides which profiles the caller may read or update and which fields are hidden.
+	*/
+	PolicyService *services.PolicyService
+	/**
+	The AuditService records logins, registrations and profile changes.
+	*/
+	AuditService *services.AuditService
 }
 
 /*
@@ -33,6 +59,7 @@ Logic:
 
 The JWT token is extracted from the request using utils.GetUserIDFromRequest.
 The username is retrieved from the token and used to fetch the user profile from the UserService.
+The policies must allow the "profile:read" action on the profile; fields they redact are removed.
 If the profile is found, the user data is returned with a 200 OK status.
 If the user is not found, a 404 Not Found error is returned.
 
@@ -52,11 +79,15 @@ On error: 404 Not Found
 func (uc *UserController) GetProfile(w http.ResponseWriter, r *http.Request) {
 	username, _ := utils.GetUserIDFromRequest(r)
 
-	profile, err := uc.UserService.GetProfile(username)
+	profile, err := uc.UserService.WithContext(r.Context()).GetProfile(username)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	profile, ok := uc.authorize(w, r, services.ActionProfileRead, profile)
+	if !ok {
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(profile)
@@ -84,6 +115,7 @@ Body (JSON format):
 Logic:
 
 Extracts the username from the JWT token.
+The policies must allow the "profile:update" action on the profile.
 Decodes the request body to get the updated mobile and address.
 The UpdateProfile function in UserService updates the user's profile with the new data.
 If the update is successful, the updated profile is returned with a 200 OK status.
@@ -115,16 +147,132 @@ func (uc *UserController) UpdateProfile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	profile, err := uc.UserService.UpdateProfile(username, updateData.Mobile, updateData.Address)
+	current, err := uc.UserService.WithContext(r.Context()).GetProfile(username)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if _, ok := uc.authorize(w, r, services.ActionProfileUpdate, current); !ok {
+		return
+	}
+
+	profile, err := uc.UserService.WithContext(r.Context()).UpdateProfile(username, updateData.Mobile, updateData.Address)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, uc.AuditService, onUser(auditEvent(r, models.AuditProfileUpdated), profile))
+	profile, ok := uc.authorize(w, r, services.ActionProfileRead, profile)
+	if !ok {
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(profile)
 }
 
+/*
+*
+ListUsers
+
+func (uc *UserController) ListUsers(w http.ResponseWriter, r *http.Request)
+Description: This endpoint lists the users the policies allow the caller to read, such as support staff reading the users of their own region.
+
+Request:
+
+Method: GET
+Endpoint: /api/users
+Headers: Must contain a valid JWT token in the Authorization header.
+
+Logic:
+
+Only users of the caller's organization are considered, unless the caller is a super admin. Every user is checked against the policies with the "users:read" action. Users the caller may not read are left out, and fields the deciding policy redacts are removed.
+
+Response:
+
+On success: a list of users, possibly empty
+*/
+func (uc *UserController) ListUsers(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := uc.UserService.WithContext(r.Context()).ListUsers(services.TenantFromClaims(claims))
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+	users, err = uc.PolicyService.WithContext(r.Context()).Filter(claims, services.ActionUsersRead, users)
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+/*
+*
+GetUser
+
+func (uc *UserController) GetUser(w http.ResponseWriter, r *http.Request)
+Description: This endpoint returns a single user if the policies allow the caller the "users:read" action on it, with the fields the deciding policy redacts removed.
+
+Method: GET
+Endpoint: /api/users/{id}
+Headers: Must contain a valid JWT token in the Authorization header.
+
+On error: 403 Forbidden if the policies deny access, 404 Not Found if the user does not exist or belongs to another organization
+*/
+func (uc *UserController) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := requestTenant(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := uc.UserService.WithContext(r.Context()).GetUserByID(tenant, uint(userID))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	user, ok := uc.authorize(w, r, services.ActionUsersRead, user)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// authorize asks the policies whether the caller may perform the action on the user and returns the user with
+// redactions applied. On a denial or failure it writes the error response and returns false.
+func (uc *UserController) authorize(w http.ResponseWriter, r *http.Request, action string, user models.User) (models.User, bool) {
+	claims, err := utils.GetClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return models.User{}, false
+	}
+	user, err = uc.PolicyService.WithContext(r.Context()).Authorize(claims, action, user)
+	if err != nil {
+		if errors.Is(err, services.ErrPolicyDenied) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return models.User{}, false
+		}
+		http.Error(w, "Failed to check policies", http.StatusInternalServerError)
+		return models.User{}, false
+	}
+	return user, true
+}
+
 /*
 * Register
 
@@ -145,9 +293,10 @@ Body (JSON format):
 
 Logic:
 
-Decodes the request body into a User model.
-Sets the user's role to user by default.
-Calls the CreateUser function in UserService to register the user.
+Decodes the request body into a RegistrationRequest; other fields, such as a role or an email verification time, are ignored.
+Sets the user's role to user. Admins are created at /setup or by invitation.
+Calls the CreateUser function in UserService to register the user. The email address starts unverified.
+A verification link is emailed to the address. A failure to send it does not fail the registration; a new link can be requested at /email/verify/resend.
 If successful, the newly created user data is returned with a 201 Created status.
 If an error occurs, a 500 Internal Server Error is returned.
 Response:
@@ -163,130 +312,322 @@ On success:
 On error: 500 Internal Server Error
 */
 func (uc *UserController) Register(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	json.NewDecoder(r.Body).Decode(&user)
-	user.Role = "user" // Default role is 'user'
+	var req models.RegistrationRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	// Default role is 'user'. Regions are assigned by admins, and self-registered users join the default organization.
+	user := models.User{
+		Name:     req.Name,
+		Email:    req.Email,
+		Username: req.Username,
+		Password: req.Password,
+		Mobile:   req.Mobile,
+		Address:  req.Address,
+		Role:     models.RoleUser,
+	}
 
-	err := uc.UserService.CreateUser(&user)
+	err := uc.UserService.WithContext(r.Context()).CreateUser(&user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, uc.AuditService, withUser(auditEvent(r, models.AuditUserRegistered), user))
+	uc.sendVerification(r, user)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
 }
 
+func (uc *UserController) sendVerification(r *http.Request, user models.User) {
+	if user.Email == "" {
+		return
+	}
+	if err := uc.EmailVerificationService.WithContext(r.Context()).SendVerification(user); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to send verification email", "user_id", user.ID, "error", err)
+	}
+}
+
 /*
 *
-RegisterAdmin
+Login
 
-func (uc *UserController) RegisterAdmin(w http.ResponseWriter, r *http.Request)
-Description: This endpoint allows an admin to register another admin by setting the role to "admin".
+func (uc *UserController) Login(w http.ResponseWriter, r *http.Request)
+Description: This endpoint allows users and admins to log in by providing their username and password. If successful, it returns a short-lived JWT token for authentication and a refresh token that can be exchanged for a new token pair at /token/refresh.
 
 Request:
 
 Method: POST
-Endpoint: /register/admin
+Endpoint: /login
 Body (JSON format):
 
 	{
-	  "username": "admin1",
-	  "password": "admin123",
-	  "email": "admin1@example.com"
+	  "username": "user1",
+	  "password": "password123"
 	}
 
 Logic:
 
-Decodes the request body into a User model.
-Sets the user's role to admin.
-Calls the CreateUser function in UserService to register the admin.
-If successful, the newly created admin user data is returned with a 201 Created status.
-If an error occurs, a 500 Internal Server Error is returned.
+The request body is decoded into a LoginCredentials structure containing the username and password.
+The Authenticate function in UserService is called to verify the credentials.
+Failed logins are counted per username and per client IP address. Once either reaches its limit (LOGIN_MAX_FAILURES, LOGIN_IP_MAX_FAILURES), logins are refused with 429 Too Many Requests and a Retry-After header, without checking the password. The lock doubles with every further failure and is lifted once it expires; an admin can lift it early at /api/admin/users/{id}/unlock. Unknown usernames are counted and locked like existing ones, so neither the status nor the timing of a response reveals whether a username exists.
+If EMAIL_VERIFICATION_REQUIRED is set and the user has not verified their email address, a 403 Forbidden error is returned.
+If the user has MFA enabled, no token is issued yet. Instead a short-lived "mfa_pending" challenge token is returned, which has to be exchanged together with a code at /login/mfa.
+If the credentials are valid, the TokenService generates a JWT token using utils.GenerateJWT and stores a new refresh token.
+The token pair is returned in the response with a 200 OK status.
+If authentication fails, a 401 Unauthorized error is returned.
 Response:
 
 On success:
 
 	{
-	  "username": "admin1",
-	  "email": "admin1@example.com",
-	  "role": "admin"
+	  "token": "your_jwt_token_here",
+	  "refresh_token": "your_refresh_token_here",
+	  "expires_in": 900
 	}
 
-On error: 500 Internal Server Error
+On success with MFA enabled:
+
+	{
+	  "mfa_required": true,
+	  "mfa_token": "your_challenge_token_here",
+	  "expires_in": 300
+	}
+
+On error: 401 Unauthorized, 403 Forbidden if the email address is not verified, or 429 Too Many Requests while the username or IP address is locked
+*/
+func (uc *UserController) Login(w http.ResponseWriter, r *http.Request) {
+	var credentials models.LoginCredentials
+	json.NewDecoder(r.Body).Decode(&credentials)
+
+	user, err := uc.UserService.WithContext(r.Context()).Authenticate(credentials.Username, credentials.Password, utils.ClientIP(r))
+	if err != nil {
+		var locked *services.LockedError
+		if errors.As(err, &locked) {
+			recordLoginFailure(uc.AuditService, r, credentials.Username, "password", "locked")
+			writeLocked(w, locked)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			recordLoginFailure(uc.AuditService, r, credentials.Username, "password", "invalid credentials")
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if err := uc.EmailVerificationService.WithContext(r.Context()).CheckLogin(user); err != nil {
+		recordLoginFailure(uc.AuditService, r, user.Username, "password", "email not verified")
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	// Users with MFA enabled receive a challenge token instead of a JWT token
+	mfaEnabled, err := uc.MFAService.WithContext(r.Context()).Enabled(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	if mfaEnabled {
+		challenge, err := uc.MFAService.WithContext(r.Context()).IssueChallenge(*user)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		metrics.Logins.Inc("password", metrics.LoginMFARequired)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	uc.issueTokens(w, r, user, "password")
+}
+
+/*
+*
+LoginMFA
+
+func (uc *UserController) LoginMFA(w http.ResponseWriter, r *http.Request)
+Description: This endpoint completes the login of a user with MFA enabled. It exchanges the "mfa_pending" challenge token returned by /login, together with a TOTP code or a recovery code, for a JWT token and a refresh token.
+
+Request:
+
+Method: POST
+Endpoint: /login/mfa
+Body (JSON format):
+
+	{
+	  "mfa_token": "your_challenge_token_here",
+	  "code": "123456"
+	}
+
+Logic:
+
+The challenge token is verified and must not have been used before. Each challenge token can complete one login.
+The code is checked against the user's authenticator; a code that was already accepted cannot be replayed. A recovery code is accepted instead and is used up.
+After too many wrong codes the challenge token is revoked and the user has to log in again.
+Wrong codes are also counted per user, across challenges and the OpenID Connect login page. Once LOGIN_MFA_MAX_FAILURES is reached, codes are refused without being checked until the lock expires.
+
+Response:
+
+On success: same as /login
+
+On error: 401 Unauthorized, or 429 Too Many Requests with a Retry-After header while the second factor of the user is locked
 */
-func (uc *UserController) RegisterAdmin(w http.ResponseWriter, r *http.Request) {
-	var admin models.User
-	json.NewDecoder(r.Body).Decode(&admin)
-	admin.Role = "admin" // Set role to 'admin'
+func (uc *UserController) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
 
-	err := uc.UserService.CreateUser(&admin)
+	user, err := uc.MFAService.WithContext(r.Context()).CompleteChallenge(req.MFAToken, req.Code)
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		recordLoginFailure(uc.AuditService, r, "", "mfa", "locked")
+		writeLocked(w, locked)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, services.ErrInvalidMFAToken) || errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFANotEnrolled) {
+			recordLoginFailure(uc.AuditService, r, "", "mfa", err.Error())
+			http.Error(w, "Invalid MFA code", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to verify MFA code", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(admin)
+	uc.issueTokens(w, r, user, "mfa")
+}
+
+// writeLocked answers a login refused by a lockout with 429 Too Many Requests. The answer is the same whether or not
+// the username exists.
+func writeLocked(w http.ResponseWriter, locked *services.LockedError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+func (uc *UserController) issueTokens(w http.ResponseWriter, r *http.Request, user *models.User, method string) {
+	// Generate JWT token and refresh token
+	pair, err := uc.TokenService.WithContext(r.Context()).IssueTokens(*user)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	uc.UserService.WithContext(r.Context()).SaveToken(user, pair.Token)
+
+	event := withUser(auditEvent(r, models.AuditLoginSucceeded), *user)
+	event.Details = map[string]string{"method": method}
+	recordAudit(r, uc.AuditService, event)
+	metrics.Logins.Inc(method, metrics.LoginSucceeded)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
 }
 
 /*
 *
-Login
+RefreshToken
 
-func (uc *UserController) Login(w http.ResponseWriter, r *http.Request)
-Description: This endpoint allows users and admins to log in by providing their username and password. If successful, it returns a JWT token for authentication.
+func (uc *UserController) RefreshToken(w http.ResponseWriter, r *http.Request)
+Description: This endpoint exchanges a refresh token for a new JWT token and a new refresh token. Each refresh token can be used only once.
 
 Request:
 
 Method: POST
-Endpoint: /login
+Endpoint: /token/refresh
 Body (JSON format):
 
 	{
-	  "username": "user1",
-	  "password": "password123"
+	  "refresh_token": "your_refresh_token_here"
 	}
 
 Logic:
 
-The request body is decoded into a LoginCredentials structure containing the username and password.
-The Authenticate function in UserService is called to verify the credentials.
-If the credentials are valid, a JWT token is generated using utils.GenerateJWT.
-The JWT token is returned in the response with a 200 OK status.
-If authentication fails, a 401 Unauthorized error is returned.
+The RotateRefreshToken function in TokenService marks the presented refresh token as used and issues a new pair in the same token family.
+If a refresh token that was already used is presented again, the whole token family is revoked and a 401 Unauthorized error is returned. The user has to log in again.
+If the refresh token is unknown, expired or revoked, a 401 Unauthorized error is returned.
+
 Response:
 
 On success:
 
 	{
-	  "token": "your_jwt_token_here"
+	  "token": "your_new_jwt_token_here",
+	  "refresh_token": "your_new_refresh_token_here",
+	  "expires_in": 900
 	}
 
 On error: 401 Unauthorized
 */
-func (uc *UserController) Login(w http.ResponseWriter, r *http.Request) {
-	var credentials models.LoginCredentials
-	json.NewDecoder(r.Body).Decode(&credentials)
+func (uc *UserController) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
 
-	user, err := uc.UserService.Authenticate(credentials.Username, credentials.Password)
+	pair, err := uc.TokenService.WithContext(r.Context()).RotateRefreshToken(req.RefreshToken)
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReuse) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(*user)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
+}
+
+/*
+*
+Logout
+
+func (uc *UserController) Logout(w http.ResponseWriter, r *http.Request)
+Description: This endpoint revokes the JWT token used to call it. If a refresh token is passed in the body, its token family is revoked as well.
+
+Request:
+
+Method: POST
+Endpoint: /api/logout
+Headers: Must contain a valid JWT token in the Authorization header.
+Body (JSON format, optional):
+
+	{
+	  "refresh_token": "your_refresh_token_here"
+	}
+
+Logic:
+
+The claims of the token are read from the request context, where they were stored by JWTMiddleware.
+The Logout function in TokenService adds the token ID (jti) to the revocation store and revokes the refresh token family.
+
+Response:
+
+On success:
+
+	{
+	  "message": "Logged out"
+	}
+
+On error: 500 Internal Server Error
+*/
+func (uc *UserController) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetClaimsFromContext(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	user.Token = token
-	// print(token)
-	uc.UserService.DB.Save(&user)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": token,
-	})
+	var req models.RefreshRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if err := uc.TokenService.WithContext(r.Context()).Logout(claims, req.RefreshToken); err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
 }
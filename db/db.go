@@ -1,26 +1,85 @@
 package db
 
 import (
-	"api-service/models"
-	"log"
+	"api-service/logging"
+	"fmt"
+	"strings"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+var logger = logging.For("db")
+
+// Database drivers
+const (
+	DriverPostgres = "postgres" // PostgreSQL; dsn is a URL or key=value pairs
+	DriverSQLite   = "sqlite"   // SQLite through a pure Go driver; dsn is the path of the database file
+	DriverMemory   = "memory"   // SQLite in memory, lost when the process exits; dsn is ignored
+)
+
 var DB *gorm.DB
 
-// InitDB initializes the database connection
-func InitDB() *gorm.DB {
-	dsn := "host=localhost user=postgres password=XXXXXX dbname=api_service port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Open opens a database with the given driver. Unique violations are reported as gorm.ErrDuplicatedKey on every
+// driver, which the storage package relies on.
+func Open(driver, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	case DriverSQLite:
+		dialector = sqlite.Open(sqliteDSN("file:"+dsn, "_pragma=journal_mode(WAL)"))
+	case DriverMemory:
+		// Every connection of the pool shares the same database through the shared cache
+		dialector = sqlite.Open(sqliteDSN("file:api-service?mode=memory&cache=shared"))
+	default:
+		return nil, fmt.Errorf("unknown database driver %q, expected postgres, sqlite or memory", driver)
+	}
+	return gorm.Open(dialector, &gorm.Config{TranslateError: true, Logger: gormLogger{}})
+}
+
+// sqliteDSN - Add the pragmas every SQLite connection needs: foreign keys, and waiting for locks instead of failing.
+// Transactions take the write lock when they begin, so that two of them cannot both read and then fail to write.
+func sqliteDSN(dsn string, pragmas ...string) string {
+	pragmas = append(pragmas, "_pragma=foreign_keys(1)", "_pragma=busy_timeout(5000)", "_txlock=immediate")
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + strings.Join(pragmas, "&")
+}
+
+// InitDB opens the database and brings its schema up to date. With migrate false the schema is only checked, and
+// the server refuses to start until `api-service migrate up` has been run. The memory database is always migrated,
+// since no other process can reach it.
+func InitDB(driver, dsn string, migrate bool) *gorm.DB {
+	db, err := Open(driver, dsn)
 	if err != nil {
-		log.Fatal("Error connecting to the database: ", err)
+		logging.Fatal(logger, "Error connecting to the database", "error", err)
 	}
-	// Migrate the schema
-	err = db.AutoMigrate(&models.User{})
+	migrator, err := NewMigrator(db, driver)
 	if err != nil {
-		log.Fatalf("Failed to auto-migrate: %v", err)
+		logging.Fatal(logger, "Invalid migrations", "error", err)
+	}
+	if migrate || driver == DriverMemory {
+		applied, err := migrator.Up()
+		if err != nil {
+			logging.Fatal(logger, "Failed to migrate the database", "error", err)
+		}
+		for _, m := range applied {
+			logger.Info("Applied migration", "version", m.Version, "name", m.Name)
+		}
+	} else {
+		statuses, err := migrator.Status()
+		if err != nil {
+			logging.Fatal(logger, "Failed to read the migrations of the database", "error", err)
+		}
+		for _, status := range statuses {
+			if status.State != MigrationApplied {
+				logging.Fatal(logger, "Migration is not applied; run `api-service migrate up` or enable database.migrate", "version", status.Version, "name", status.Name, "state", status.State)
+			}
+		}
 	}
 	DB = db
 	return db
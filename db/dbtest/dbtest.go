@@ -0,0 +1,85 @@
+package dbtest
+
+/**
+The dbtest package opens databases with the schema migrated for tests. SQLite opens a fresh database file for every test; Postgres opens the database of TEST_POSTGRES_URL, which the tests empty, and skips the test when it is not set. Unmigrated opens either without the schema, for tests of the migrations themselves:
+
+	func TestSomething(t *testing.T) {
+		conn := dbtest.SQLite(t)
+		...
+	}
+*/
+import (
+	"api-service/db"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// PostgresURLEnv names the variable holding the URL of a PostgreSQL database the tests may empty
+const PostgresURLEnv = "TEST_POSTGRES_URL"
+
+// SQLite - Open a migrated SQLite database in a temporary directory, closed when the test ends
+func SQLite(t testing.TB) *gorm.DB {
+	t.Helper()
+	conn := Unmigrated(t, db.DriverSQLite)
+	migrate(t, conn, db.DriverSQLite)
+	return conn
+}
+
+// Postgres - Open the migrated PostgreSQL database of TEST_POSTGRES_URL after dropping every table in it, or skip
+// the test when the variable is not set. Tests using it must not run in parallel.
+func Postgres(t testing.TB) *gorm.DB {
+	t.Helper()
+	conn := Unmigrated(t, db.DriverPostgres)
+	migrate(t, conn, db.DriverPostgres)
+	return conn
+}
+
+// Unmigrated - Open an empty database without any schema, for tests of the migrations: a fresh SQLite database, or
+// the emptied PostgreSQL database of TEST_POSTGRES_URL as with Postgres
+func Unmigrated(t testing.TB, driver string) *gorm.DB {
+	t.Helper()
+	if driver == db.DriverSQLite {
+		conn, err := db.Open(driver, filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("open %s: %v", driver, err)
+		}
+		closeOnCleanup(t, conn)
+		return conn
+	}
+
+	url := os.Getenv(PostgresURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", PostgresURLEnv)
+	}
+	conn, err := db.Open(db.DriverPostgres, url)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	if err := conn.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error; err != nil {
+		t.Fatalf("empty postgres: %v", err)
+	}
+	closeOnCleanup(t, conn)
+	return conn
+}
+
+func migrate(t testing.TB, conn *gorm.DB, driver string) {
+	t.Helper()
+	migrator, err := db.NewMigrator(conn, driver)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
+
+func closeOnCleanup(t testing.TB, conn *gorm.DB) {
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
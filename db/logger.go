@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQuery is the duration above which a query is logged at the warn level
+const slowQuery = 200 * time.Millisecond
+
+// gormLogger passes the logs of gorm to the db logger: failed and slow queries at the warn level and every other
+// query at the debug level, so that db=debug traces them. Queries are logged without their parameters, which may
+// hold password hashes and tokens.
+type gormLogger struct {
+	silent bool
+}
+
+func (l gormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	return gormLogger{silent: level == gormlogger.Silent}
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelInfo, msg, args)
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelWarn, msg, args)
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx, slog.LevelError, msg, args)
+}
+
+func (l gormLogger) log(ctx context.Context, level slog.Level, msg string, args []interface{}) {
+	if !l.silent && logger.Enabled(ctx, level) {
+		logger.Log(ctx, level, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	level, msg := slog.LevelDebug, "Query"
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		// The caller handles the error; many, such as unique violations, are answered with a client error
+		level, msg = slog.LevelWarn, "Query failed"
+	case elapsed > slowQuery:
+		level, msg = slog.LevelWarn, "Slow query"
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter - Leave the parameters out of the logged queries
+func (l gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
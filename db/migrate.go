@@ -0,0 +1,411 @@
+package db
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles holds the migrations of every dialect, as migrations/<dialect>/<version>_<name>.<up|down>.sql.
+// Statements end with a semicolon at the end of a line.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var (
+	ErrMigrationModified = errors.New("an applied migration was modified")
+	ErrMigrationUnknown  = errors.New("the database has a migration this binary does not know; it was migrated by a newer version")
+	ErrNothingToRevert   = errors.New("no migration has been applied")
+)
+
+// migrationLockID is the key of the PostgreSQL advisory lock held while migrating
+const migrationLockID = 4_851_170_233
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one step of the schema, with the SQL that applies it and the SQL that reverts it.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of Up and Down; an applied migration must not change
+}
+
+// Migration states reported by Status
+const (
+	MigrationApplied  = "applied"
+	MigrationPending  = "pending"
+	MigrationModified = "modified" // Applied, but the migration changed since
+	MigrationUnknown  = "unknown"  // Applied, but not part of this binary
+)
+
+// MigrationStatus is the state of a migration in the database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	State     string
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of schema_migrations, the record of the applied migrations
+type schemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	Checksum  string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+// Migrator applies and reverts the migrations of one database. Every change holds a lock, so replicas starting at
+// the same time do not race: a PostgreSQL advisory lock, and on SQLite the write lock of the transaction each
+// migration runs in, after which the migration is skipped if another process applied it meanwhile.
+type Migrator struct {
+	DB         *gorm.DB
+	Dialect    string // postgres or sqlite
+	Migrations []Migration
+}
+
+// NewMigrator returns a migrator with the embedded migrations of the driver's dialect
+func NewMigrator(db *gorm.DB, driver string) (*Migrator, error) {
+	dialect := DriverPostgres
+	if driver == DriverSQLite || driver == DriverMemory {
+		dialect = DriverSQLite
+	}
+	sub, err := fs.Sub(migrationFiles, path.Join("migrations", dialect))
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := LoadMigrations(sub)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Dialect: dialect, Migrations: migrations}, nil
+}
+
+// LoadMigrations reads the migrations of a directory, ordered by version. Every version needs both an up and a
+// down file.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>.<up|down>.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", m.Version, m.Name)
+		}
+		sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+		m.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in order and returns the ones it applied. It refuses to run when an applied
+// migration was modified or is unknown to this binary.
+func (m *Migrator) Up() ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(func(conn *gorm.DB) error {
+		done, err := m.check(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			ran, err := m.apply(conn, migration)
+			if err != nil {
+				return err
+			}
+			if ran {
+				applied = append(applied, migration)
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns the ones it reverted
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(func(conn *gorm.DB) error {
+		done, err := m.check(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		if len(reverted) == 0 && steps > 0 {
+			return ErrNothingToRevert
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Redo reverts the last applied migration and applies it again, which helps while writing a migration
+func (m *Migrator) Redo() (Migration, error) {
+	var redone Migration
+	err := m.withLock(func(conn *gorm.DB) error {
+		done, err := m.check(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			if _, ok := done[m.Migrations[i].Version]; ok {
+				redone = m.Migrations[i]
+				if err := m.revert(conn, redone); err != nil {
+					return err
+				}
+				_, err := m.apply(conn, redone)
+				return err
+			}
+		}
+		return ErrNothingToRevert
+	})
+	return redone, err
+}
+
+// Status returns the state of every migration of the binary and of every applied migration, ordered by version
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	done, err := m.applied(m.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.Migrations))
+	known := make(map[int64]bool, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		known[int64(migration.Version)] = true
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, State: MigrationPending}
+		if row, ok := done[migration.Version]; ok {
+			status.State = MigrationApplied
+			if row.Checksum != migration.Checksum {
+				status.State = MigrationModified
+			}
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range done {
+		if !known[row.Version] {
+			appliedAt := row.AppliedAt
+			statuses = append(statuses, MigrationStatus{Version: int(row.Version), Name: row.Name, State: MigrationUnknown, AppliedAt: &appliedAt})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// withLock - Run fn on one connection of the pool while holding the migration lock, after creating
+// schema_migrations if needed
+func (m *Migrator) withLock(fn func(conn *gorm.DB) error) error {
+	return m.DB.Connection(func(conn *gorm.DB) error {
+		if m.Dialect == DriverPostgres {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+				return fmt.Errorf("failed to take the migration lock: %w", err)
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+		}
+		err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`).Error
+		if err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+// applied - Return the rows of schema_migrations by version. A database without the table has none.
+func (m *Migrator) applied(conn *gorm.DB) (map[int]schemaMigration, error) {
+	done := make(map[int]schemaMigration)
+	if !conn.Migrator().HasTable(&schemaMigration{}) {
+		return done, nil
+	}
+	var rows []schemaMigration
+	if err := conn.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		done[int(row.Version)] = row
+	}
+	return done, nil
+}
+
+// check - Return the applied migrations, or an error if one of them was modified or is unknown
+func (m *Migrator) check(conn *gorm.DB) (map[int]schemaMigration, error) {
+	done, err := m.applied(conn)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int]Migration, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		known[migration.Version] = migration
+	}
+	for version, row := range done {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s: %w", version, row.Name, ErrMigrationUnknown)
+		}
+		if migration.Checksum != row.Checksum {
+			return nil, fmt.Errorf("migration %d_%s: %w", version, row.Name, ErrMigrationModified)
+		}
+	}
+	return done, nil
+}
+
+// apply - Run the up SQL of a migration and record it, in one transaction. It reports false if another process
+// applied the migration first.
+func (m *Migrator) apply(conn *gorm.DB, migration Migration) (bool, error) {
+	ran := false
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&schemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		if migration.Version == initialSchemaVersion {
+			if err := adoptAutoMigrateSchema(tx, m.Dialect); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		if err := execScript(tx, migration.Up); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		ran = true
+		return tx.Create(&schemaMigration{
+			Version:   int64(migration.Version),
+			Name:      migration.Name,
+			Checksum:  migration.Checksum,
+			AppliedAt: time.Now().UTC(),
+		}).Error
+	})
+	return ran, err
+}
+
+// initialSchemaVersion is the migration that creates the schema AutoMigrate used to create
+const initialSchemaVersion = 1
+
+// legacyColumn is a column the users table of a database created by AutoMigrate may lack
+type legacyColumn struct {
+	name, postgresType, sqliteType string
+}
+
+// legacyUserColumns were added to the users table after the first release, which created it with AutoMigrate. The
+// CREATE TABLE IF NOT EXISTS of the initial schema keeps such a table as it is, so they are added before it runs.
+var legacyUserColumns = []legacyColumn{
+	{"email_verified_at", "timestamptz", "datetime"},
+	{"pending_email", "text", "text"},
+	{"region", "text", "text"},
+	{"organization_id", "bigint", "integer"},
+}
+
+// adoptAutoMigrateSchema - Add the missing columns to a users table created by AutoMigrate. A new database has no
+// users table yet, and one created by a later release has every column, so nothing changes for them. The users are
+// moved to the default organization when the service seeds it.
+func adoptAutoMigrateSchema(tx *gorm.DB, dialect string) error {
+	if !tx.Migrator().HasTable("users") {
+		return nil
+	}
+	for _, column := range legacyUserColumns {
+		if tx.Migrator().HasColumn("users", column.name) {
+			continue
+		}
+		columnType := column.postgresType
+		if dialect == DriverSQLite {
+			columnType = column.sqliteType
+		}
+		if err := tx.Exec(fmt.Sprintf(`ALTER TABLE "users" ADD COLUMN "%s" %s`, column.name, columnType)).Error; err != nil {
+			return fmt.Errorf("failed to add users.%s: %w", column.name, err)
+		}
+	}
+	return nil
+}
+
+// revert - Run the down SQL of a migration and remove its record, in one transaction
+func (m *Migrator) revert(conn *gorm.DB, migration Migration) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := execScript(tx, migration.Down); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		return tx.Delete(&schemaMigration{}, migration.Version).Error
+	})
+}
+
+// execScript - Run the statements of a migration one by one. Lines starting with "--" are comments.
+func execScript(tx *gorm.DB, script string) error {
+	var statement strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if err := tx.Exec(statement.String()).Error; err != nil {
+				return err
+			}
+			statement.Reset()
+		}
+	}
+	if strings.TrimSpace(statement.String()) != "" {
+		return tx.Exec(statement.String()).Error
+	}
+	return nil
+}
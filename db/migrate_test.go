@@ -0,0 +1,128 @@
+package db_test
+
+import (
+	"api-service/db"
+	"api-service/db/dbtest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// baselineUser is the user model of the first release, whose schema AutoMigrate created
+type baselineUser struct {
+	ID       uint `gorm:"primaryKey"`
+	Name     string
+	Email    string `gorm:"unique"`
+	Username string `gorm:"unique"`
+	Password string
+	Mobile   string
+	Address  string
+	Role     string
+	Token    string
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+func TestMigrateAdoptsTheAutoMigrateSchema(t *testing.T) {
+	for _, driver := range []string{db.DriverSQLite, db.DriverPostgres} {
+		t.Run(driver, func(t *testing.T) {
+			conn := dbtest.Unmigrated(t, driver)
+			if err := conn.AutoMigrate(&baselineUser{}); err != nil {
+				t.Fatal(err)
+			}
+			admin := baselineUser{Name: "Admin", Email: "admin@example.com", Username: "admin", Password: "hash", Role: "admin"}
+			if err := conn.Create(&admin).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			migrator, err := db.NewMigrator(conn, driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applied, err := migrator.Up()
+			if err != nil {
+				t.Fatalf("migrate the baseline schema: %v", err)
+			}
+			if len(applied) != len(migrator.Migrations) {
+				t.Fatalf("applied %d of %d migrations", len(applied), len(migrator.Migrations))
+			}
+			assertAllApplied(t, migrator)
+
+			for _, column := range []string{"email_verified_at", "pending_email", "region", "organization_id"} {
+				if !conn.Migrator().HasColumn("users", column) {
+					t.Errorf("users.%s is missing", column)
+				}
+			}
+			if !conn.Migrator().HasIndex("users", "idx_users_organization_id") {
+				t.Error("the index on users.organization_id is missing")
+			}
+
+			// The existing user is kept, and the new columns can be written
+			var user struct {
+				ID              uint
+				Username        string
+				Role            string
+				OrganizationID  *uint
+				EmailVerifiedAt *time.Time
+			}
+			if err := conn.Table("users").First(&user, admin.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if user.Username != "admin" || user.Role != "admin" || user.OrganizationID != nil || user.EmailVerifiedAt != nil {
+				t.Fatalf("adopted user %+v", user)
+			}
+			err = conn.Transaction(func(tx *gorm.DB) error {
+				org := map[string]interface{}{"name": "Default", "slug": "default"}
+				if err := tx.Table("organizations").Create(org).Error; err != nil {
+					return err
+				}
+				return tx.Table("users").Where("id = ?", admin.ID).
+					Updates(map[string]interface{}{"organization_id": 1, "region": "eu", "email_verified_at": time.Now()}).Error
+			})
+			if err != nil {
+				t.Fatalf("write the adopted columns: %v", err)
+			}
+
+			// Migrating again changes nothing
+			if applied, err := migrator.Up(); err != nil || len(applied) != 0 {
+				t.Fatalf("second migration: applied %d, %v", len(applied), err)
+			}
+		})
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	conn := dbtest.Unmigrated(t, db.DriverSQLite)
+	migrator, err := db.NewMigrator(conn, db.DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	assertAllApplied(t, migrator)
+	if _, err := migrator.Down(len(migrator.Migrations)); err != nil {
+		t.Fatalf("revert every migration: %v", err)
+	}
+	if conn.Migrator().HasTable("users") {
+		t.Fatal("users is left after reverting every migration")
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+	assertAllApplied(t, migrator)
+}
+
+func assertAllApplied(t *testing.T, migrator *db.Migrator) {
+	t.Helper()
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.State != db.MigrationApplied {
+			t.Errorf("migration %d_%s is %s", status.Version, status.Name, status.State)
+		}
+	}
+}
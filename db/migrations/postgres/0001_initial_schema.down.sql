@@ -0,0 +1,24 @@
+DROP TABLE IF EXISTS "login_lockouts";
+DROP TABLE IF EXISTS "audit_chain_heads";
+DROP TABLE IF EXISTS "audit_events";
+DROP TABLE IF EXISTS "policies";
+DROP TABLE IF EXISTS "admin_invitations";
+DROP TABLE IF EXISTS "password_reset_tokens";
+DROP TABLE IF EXISTS "web_authn_sessions";
+DROP TABLE IF EXISTS "web_authn_credentials";
+DROP TABLE IF EXISTS "recovery_codes";
+DROP TABLE IF EXISTS "mfa_enrollments";
+DROP TABLE IF EXISTS "consents";
+DROP TABLE IF EXISTS "authorization_requests";
+DROP TABLE IF EXISTS "registered_clients";
+DROP TABLE IF EXISTS "token_revocations";
+DROP TABLE IF EXISTS "refresh_tokens";
+DROP TABLE IF EXISTS "group_roles";
+DROP TABLE IF EXISTS "group_members";
+DROP TABLE IF EXISTS "groups";
+DROP TABLE IF EXISTS "user_roles";
+DROP TABLE IF EXISTS "users";
+DROP TABLE IF EXISTS "organizations";
+DROP TABLE IF EXISTS "role_permissions";
+DROP TABLE IF EXISTS "roles";
+DROP TABLE IF EXISTS "permissions";
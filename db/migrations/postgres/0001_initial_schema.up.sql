@@ -0,0 +1,313 @@
+-- The schema as the service created it with AutoMigrate before versioned migrations. IF NOT EXISTS keeps the
+-- tables of a database created that way. The first release created only the users table, without the columns
+-- added since (email_verified_at, pending_email, region and organization_id); the migrator adds those before this
+-- script runs, in the same transaction, so that the index on organization_id below can be created.
+
+CREATE TABLE IF NOT EXISTS "permissions" (
+    "id" bigserial,
+    "name" text NOT NULL,
+    "description" text,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_permissions_name" ON "permissions" ("name");
+
+CREATE TABLE IF NOT EXISTS "roles" (
+    "id" bigserial,
+    "name" text NOT NULL,
+    "description" text,
+    "builtin" boolean,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_roles_name" ON "roles" ("name");
+
+CREATE TABLE IF NOT EXISTS "role_permissions" (
+    "role_id" bigint,
+    "permission_id" bigint,
+    PRIMARY KEY ("role_id","permission_id"),
+    CONSTRAINT "fk_role_permissions_permission" FOREIGN KEY ("permission_id") REFERENCES "permissions"("id"),
+    CONSTRAINT "fk_role_permissions_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "organizations" (
+    "id" bigserial,
+    "name" text NOT NULL,
+    "slug" text NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_organizations_slug" ON "organizations" ("slug");
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" bigserial,
+    "name" text,
+    "email" text,
+    "email_verified_at" timestamptz,
+    "pending_email" text,
+    "username" text,
+    "password" text,
+    "mobile" text,
+    "address" text,
+    "region" text,
+    "organization_id" bigint,
+    "role" text,
+    "token" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email"),
+    CONSTRAINT "uni_users_username" UNIQUE ("username")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_organization_id" ON "users" ("organization_id");
+
+CREATE TABLE IF NOT EXISTS "user_roles" (
+    "user_id" bigint,
+    "role_id" bigint,
+    PRIMARY KEY ("user_id","role_id"),
+    CONSTRAINT "fk_user_roles_user" FOREIGN KEY ("user_id") REFERENCES "users"("id"),
+    CONSTRAINT "fk_user_roles_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "groups" (
+    "id" bigserial,
+    "organization_id" bigint NOT NULL,
+    "name" text NOT NULL,
+    "description" text,
+    "parent_id" bigint,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_groups_parent_id" ON "groups" ("parent_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_groups_organization_name" ON "groups" ("organization_id","name");
+
+CREATE TABLE IF NOT EXISTS "group_members" (
+    "group_id" bigint,
+    "user_id" bigint,
+    PRIMARY KEY ("group_id","user_id"),
+    CONSTRAINT "fk_group_members_group" FOREIGN KEY ("group_id") REFERENCES "groups"("id"),
+    CONSTRAINT "fk_group_members_user" FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "group_roles" (
+    "group_id" bigint,
+    "role_id" bigint,
+    PRIMARY KEY ("group_id","role_id"),
+    CONSTRAINT "fk_group_roles_group" FOREIGN KEY ("group_id") REFERENCES "groups"("id"),
+    CONSTRAINT "fk_group_roles_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "refresh_tokens" (
+    "id" bigserial,
+    "user_id" bigint NOT NULL,
+    "family_id" text NOT NULL,
+    "token_hash" text NOT NULL,
+    "expires_at" timestamptz,
+    "used_at" timestamptz,
+    "revoked_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_refresh_tokens_family_id" ON "refresh_tokens" ("family_id");
+CREATE INDEX IF NOT EXISTS "idx_refresh_tokens_user_id" ON "refresh_tokens" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_refresh_tokens_token_hash" ON "refresh_tokens" ("token_hash");
+
+CREATE TABLE IF NOT EXISTS "token_revocations" (
+    "id" bigserial,
+    "kind" text NOT NULL,
+    "subject" text NOT NULL,
+    "revoked_before" timestamptz,
+    "expires_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_token_revocations_expires_at" ON "token_revocations" ("expires_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_revocation_kind_subject" ON "token_revocations" ("kind","subject");
+
+CREATE TABLE IF NOT EXISTS "registered_clients" (
+    "id" bigserial,
+    "client_id" text NOT NULL,
+    "client_secret_hash" text,
+    "name" text,
+    "redirect_uris" text,
+    "scopes" text,
+    "grant_types" text DEFAULT 'authorization_code',
+    "public" boolean,
+    "organization_id" bigint,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_registered_clients_organization_id" ON "registered_clients" ("organization_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_registered_clients_client_id" ON "registered_clients" ("client_id");
+
+CREATE TABLE IF NOT EXISTS "authorization_requests" (
+    "id" bigserial,
+    "challenge_hash" text NOT NULL,
+    "code_hash" text,
+    "client_id" text NOT NULL,
+    "user_id" bigint NOT NULL,
+    "redirect_uri" text NOT NULL,
+    "scope" text NOT NULL,
+    "state" text,
+    "nonce" text,
+    "code_challenge" text NOT NULL,
+    "code_challenge_method" text NOT NULL,
+    "auth_time" timestamptz,
+    "expires_at" timestamptz,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_authorization_requests_code_hash" ON "authorization_requests" ("code_hash");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_authorization_requests_challenge_hash" ON "authorization_requests" ("challenge_hash");
+CREATE INDEX IF NOT EXISTS "idx_authorization_requests_client_id" ON "authorization_requests" ("client_id");
+
+CREATE TABLE IF NOT EXISTS "consents" (
+    "id" bigserial,
+    "user_id" bigint NOT NULL,
+    "client_id" text NOT NULL,
+    "scope" text,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_consent_user_client" ON "consents" ("user_id","client_id");
+
+CREATE TABLE IF NOT EXISTS "mfa_enrollments" (
+    "id" bigserial,
+    "user_id" bigint NOT NULL,
+    "secret" text NOT NULL,
+    "confirmed_at" timestamptz,
+    "last_used_step" bigint,
+    "challenge_id" text,
+    "challenge_failures" bigint,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_mfa_enrollments_user_id" ON "mfa_enrollments" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" bigserial,
+    "user_id" bigint NOT NULL,
+    "code_hash" text NOT NULL,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "web_authn_credentials" (
+    "id" bigserial,
+    "user_id" bigint NOT NULL,
+    "credential_id" text NOT NULL,
+    "public_key" bytea NOT NULL,
+    "algorithm" bigint,
+    "sign_count" bigint,
+    "transports" text,
+    "aa_guid" text,
+    "name" text,
+    "backup_eligible" boolean,
+    "backup_state" boolean,
+    "last_used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_web_authn_credentials_credential_id" ON "web_authn_credentials" ("credential_id");
+CREATE INDEX IF NOT EXISTS "idx_web_authn_credentials_user_id" ON "web_authn_credentials" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "web_authn_sessions" (
+    "id" bigserial,
+    "session_hash" text NOT NULL,
+    "kind" text NOT NULL,
+    "user_id" bigint,
+    "challenge" text NOT NULL,
+    "expires_at" timestamptz,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_web_authn_sessions_session_hash" ON "web_authn_sessions" ("session_hash");
+
+CREATE TABLE IF NOT EXISTS "password_reset_tokens" (
+    "id" bigserial,
+    "user_id" bigint NOT NULL,
+    "token_hash" text NOT NULL,
+    "expires_at" timestamptz,
+    "used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_password_reset_tokens_token_hash" ON "password_reset_tokens" ("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_password_reset_tokens_user_id" ON "password_reset_tokens" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "admin_invitations" (
+    "id" bigserial,
+    "email" text NOT NULL,
+    "invited_by" bigint NOT NULL,
+    "organization_id" bigint,
+    "expires_at" timestamptz,
+    "accepted_at" timestamptz,
+    "revoked_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_admin_invitations_organization_id" ON "admin_invitations" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_admin_invitations_email" ON "admin_invitations" ("email");
+
+CREATE TABLE IF NOT EXISTS "policies" (
+    "id" bigserial,
+    "name" text NOT NULL,
+    "description" text,
+    "effect" text NOT NULL,
+    "priority" bigint,
+    "actions" text,
+    "conditions" text,
+    "redact" text,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_policies_name" ON "policies" ("name");
+
+CREATE TABLE IF NOT EXISTS "audit_events" (
+    "id" bigint,
+    "created_at" timestamptz NOT NULL,
+    "type" text NOT NULL,
+    "outcome" text NOT NULL,
+    "actor_id" text,
+    "actor_name" text,
+    "target_type" text,
+    "target_id" text,
+    "target_name" text,
+    "organization_id" bigint,
+    "ip" text,
+    "user_agent" text,
+    "request_id" text,
+    "details" text,
+    "prev_hash" text,
+    "hash" text NOT NULL,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events" ("created_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_audit_events_hash" ON "audit_events" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_organization_id" ON "audit_events" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_target_id" ON "audit_events" ("target_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_actor_id" ON "audit_events" ("actor_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_type" ON "audit_events" ("type");
+
+CREATE TABLE IF NOT EXISTS "audit_chain_heads" (
+    "id" bigserial,
+    "last_id" bigint NOT NULL,
+    "last_hash" text NOT NULL,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "login_lockouts" (
+    "id" bigserial,
+    "kind" text NOT NULL,
+    "subject" text NOT NULL,
+    "failures" bigint,
+    "last_failure_at" timestamptz,
+    "locked_until" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_login_lockouts_last_failure_at" ON "login_lockouts" ("last_failure_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_lockout_kind_subject" ON "login_lockouts" ("kind","subject");
+<nil>;
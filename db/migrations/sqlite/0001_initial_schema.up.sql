@@ -0,0 +1,293 @@
+-- The schema as the service created it with AutoMigrate before versioned migrations. IF NOT EXISTS keeps the
+-- tables of a database created that way. The first release created only the users table, without the columns
+-- added since (email_verified_at, pending_email, region and organization_id); the migrator adds those before this
+-- script runs, in the same transaction, so that the index on organization_id below can be created.
+
+CREATE TABLE IF NOT EXISTS "permissions" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "name" text NOT NULL,
+    "description" text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_permissions_name" ON "permissions" ("name");
+
+CREATE TABLE IF NOT EXISTS "roles" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "name" text NOT NULL,
+    "description" text,
+    "builtin" numeric,
+    "created_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_roles_name" ON "roles" ("name");
+
+CREATE TABLE IF NOT EXISTS "role_permissions" (
+    "role_id" integer,
+    "permission_id" integer,
+    PRIMARY KEY ("role_id","permission_id"),
+    CONSTRAINT "fk_role_permissions_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id"),
+    CONSTRAINT "fk_role_permissions_permission" FOREIGN KEY ("permission_id") REFERENCES "permissions"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "organizations" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "name" text NOT NULL,
+    "slug" text NOT NULL,
+    "created_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_organizations_slug" ON "organizations" ("slug");
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "name" text,
+    "email" text,
+    "email_verified_at" datetime,
+    "pending_email" text,
+    "username" text,
+    "password" text,
+    "mobile" text,
+    "address" text,
+    "region" text,
+    "organization_id" integer,
+    "role" text,
+    "token" text,
+    CONSTRAINT "uni_users_email" UNIQUE ("email"),
+    CONSTRAINT "uni_users_username" UNIQUE ("username")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_organization_id" ON "users" ("organization_id");
+
+CREATE TABLE IF NOT EXISTS "user_roles" (
+    "user_id" integer,
+    "role_id" integer,
+    PRIMARY KEY ("user_id","role_id"),
+    CONSTRAINT "fk_user_roles_user" FOREIGN KEY ("user_id") REFERENCES "users"("id"),
+    CONSTRAINT "fk_user_roles_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "groups" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "organization_id" integer NOT NULL,
+    "name" text NOT NULL,
+    "description" text,
+    "parent_id" integer,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_groups_parent_id" ON "groups" ("parent_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_groups_organization_name" ON "groups" ("organization_id","name");
+
+CREATE TABLE IF NOT EXISTS "group_members" (
+    "group_id" integer,
+    "user_id" integer,
+    PRIMARY KEY ("group_id","user_id"),
+    CONSTRAINT "fk_group_members_group" FOREIGN KEY ("group_id") REFERENCES "groups"("id"),
+    CONSTRAINT "fk_group_members_user" FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "group_roles" (
+    "group_id" integer,
+    "role_id" integer,
+    PRIMARY KEY ("group_id","role_id"),
+    CONSTRAINT "fk_group_roles_group" FOREIGN KEY ("group_id") REFERENCES "groups"("id"),
+    CONSTRAINT "fk_group_roles_role" FOREIGN KEY ("role_id") REFERENCES "roles"("id")
+);
+
+CREATE TABLE IF NOT EXISTS "refresh_tokens" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" integer NOT NULL,
+    "family_id" text NOT NULL,
+    "token_hash" text NOT NULL,
+    "expires_at" datetime,
+    "used_at" datetime,
+    "revoked_at" datetime,
+    "created_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_refresh_tokens_token_hash" ON "refresh_tokens" ("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_refresh_tokens_family_id" ON "refresh_tokens" ("family_id");
+CREATE INDEX IF NOT EXISTS "idx_refresh_tokens_user_id" ON "refresh_tokens" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "token_revocations" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "kind" text NOT NULL,
+    "subject" text NOT NULL,
+    "revoked_before" datetime,
+    "expires_at" datetime,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_token_revocations_expires_at" ON "token_revocations" ("expires_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_revocation_kind_subject" ON "token_revocations" ("kind","subject");
+
+CREATE TABLE IF NOT EXISTS "registered_clients" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "client_id" text NOT NULL,
+    "client_secret_hash" text,
+    "name" text,
+    "redirect_uris" text,
+    "scopes" text,
+    "grant_types" text DEFAULT 'authorization_code',
+    "public" numeric,
+    "organization_id" integer,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_registered_clients_organization_id" ON "registered_clients" ("organization_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_registered_clients_client_id" ON "registered_clients" ("client_id");
+
+CREATE TABLE IF NOT EXISTS "authorization_requests" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "challenge_hash" text NOT NULL,
+    "code_hash" text,
+    "client_id" text NOT NULL,
+    "user_id" integer NOT NULL,
+    "redirect_uri" text NOT NULL,
+    "scope" text NOT NULL,
+    "state" text,
+    "nonce" text,
+    "code_challenge" text NOT NULL,
+    "code_challenge_method" text NOT NULL,
+    "auth_time" datetime,
+    "expires_at" datetime,
+    "used_at" datetime,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_authorization_requests_client_id" ON "authorization_requests" ("client_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_authorization_requests_code_hash" ON "authorization_requests" ("code_hash");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_authorization_requests_challenge_hash" ON "authorization_requests" ("challenge_hash");
+
+CREATE TABLE IF NOT EXISTS "consents" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" integer NOT NULL,
+    "client_id" text NOT NULL,
+    "scope" text,
+    "created_at" datetime,
+    "updated_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_consent_user_client" ON "consents" ("user_id","client_id");
+
+CREATE TABLE IF NOT EXISTS "mfa_enrollments" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" integer NOT NULL,
+    "secret" text NOT NULL,
+    "confirmed_at" datetime,
+    "last_used_step" integer,
+    "challenge_id" text,
+    "challenge_failures" integer,
+    "created_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_mfa_enrollments_user_id" ON "mfa_enrollments" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" integer NOT NULL,
+    "code_hash" text NOT NULL,
+    "used_at" datetime,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "web_authn_credentials" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" integer NOT NULL,
+    "credential_id" text NOT NULL,
+    "public_key" blob NOT NULL,
+    "algorithm" integer,
+    "sign_count" integer,
+    "transports" text,
+    "aa_guid" text,
+    "name" text,
+    "backup_eligible" numeric,
+    "backup_state" numeric,
+    "last_used_at" datetime,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_web_authn_credentials_user_id" ON "web_authn_credentials" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_web_authn_credentials_credential_id" ON "web_authn_credentials" ("credential_id");
+
+CREATE TABLE IF NOT EXISTS "web_authn_sessions" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "session_hash" text NOT NULL,
+    "kind" text NOT NULL,
+    "user_id" integer,
+    "challenge" text NOT NULL,
+    "expires_at" datetime,
+    "used_at" datetime,
+    "created_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_web_authn_sessions_session_hash" ON "web_authn_sessions" ("session_hash");
+
+CREATE TABLE IF NOT EXISTS "password_reset_tokens" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" integer NOT NULL,
+    "token_hash" text NOT NULL,
+    "expires_at" datetime,
+    "used_at" datetime,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_password_reset_tokens_user_id" ON "password_reset_tokens" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_password_reset_tokens_token_hash" ON "password_reset_tokens" ("token_hash");
+
+CREATE TABLE IF NOT EXISTS "admin_invitations" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "email" text NOT NULL,
+    "invited_by" integer NOT NULL,
+    "organization_id" integer,
+    "expires_at" datetime,
+    "accepted_at" datetime,
+    "revoked_at" datetime,
+    "created_at" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_admin_invitations_email" ON "admin_invitations" ("email");
+CREATE INDEX IF NOT EXISTS "idx_admin_invitations_organization_id" ON "admin_invitations" ("organization_id");
+
+CREATE TABLE IF NOT EXISTS "policies" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "name" text NOT NULL,
+    "description" text,
+    "effect" text NOT NULL,
+    "priority" integer,
+    "actions" text,
+    "conditions" text,
+    "redact" text,
+    "created_at" datetime,
+    "updated_at" datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_policies_name" ON "policies" ("name");
+
+CREATE TABLE IF NOT EXISTS "audit_events" (
+    "id" integer,
+    "created_at" datetime NOT NULL,
+    "type" text NOT NULL,
+    "outcome" text NOT NULL,
+    "actor_id" text,
+    "actor_name" text,
+    "target_type" text,
+    "target_id" text,
+    "target_name" text,
+    "organization_id" integer,
+    "ip" text,
+    "user_agent" text,
+    "request_id" text,
+    "details" text,
+    "prev_hash" text,
+    "hash" text NOT NULL,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_events_type" ON "audit_events" ("type");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events" ("created_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_audit_events_hash" ON "audit_events" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_organization_id" ON "audit_events" ("organization_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_target_id" ON "audit_events" ("target_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_actor_id" ON "audit_events" ("actor_id");
+
+CREATE TABLE IF NOT EXISTS "audit_chain_heads" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "last_id" integer NOT NULL,
+    "last_hash" text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "login_lockouts" (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "kind" text NOT NULL,
+    "subject" text NOT NULL,
+    "failures" integer,
+    "last_failure_at" datetime,
+    "locked_until" datetime
+);
+CREATE INDEX IF NOT EXISTS "idx_login_lockouts_last_failure_at" ON "login_lockouts" ("last_failure_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_lockout_kind_subject" ON "login_lockouts" ("kind","subject");
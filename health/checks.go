@@ -0,0 +1,48 @@
+package health
+
+import (
+	"api-service/db"
+	"api-service/utils"
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// DatabaseCheck - Ping the database through a connection of the gorm pool
+func DatabaseCheck(conn *gorm.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		sqlDB, err := conn.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
+
+// MigrationsCheck - Fail while a migration is pending, modified or unknown, such as when a newer version of the
+// service migrated the database
+func MigrationsCheck(migrator *db.Migrator) CheckFunc {
+	return func(ctx context.Context) error {
+		m := *migrator
+		m.DB = migrator.DB.WithContext(ctx)
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.State != db.MigrationApplied {
+				return fmt.Errorf("migration %d_%s is %s", status.Version, status.Name, status.State)
+			}
+		}
+		return nil
+	}
+}
+
+// SigningKeyCheck - Fail while there is no key to sign tokens with
+func SigningKeyCheck(keys *utils.KeyManager) CheckFunc {
+	return func(context.Context) error {
+		_, err := keys.ActiveKey()
+		return err
+	}
+}
@@ -0,0 +1,137 @@
+package health
+
+/**
+The health package answers the liveness and readiness probes of orchestrators. A Checker runs the readiness checks registered with it, such as a database ping, concurrently and each with a timeout, and caches their results for a short while so that frequent probes do not load the database. checks.go holds the checks of the service's own dependencies.
+*/
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Statuses of a check and of a report
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusDraining = "draining" // The server is shutting down; only reports have it
+)
+
+var ErrCheckTimeout = errors.New("check timed out")
+
+// CheckFunc reports whether a dependency is usable. It should return once ctx is done.
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the outcome of every check. Status is ok only if every check is.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs the readiness checks. Register the checks before the first call to Check.
+type Checker struct {
+	Timeout  time.Duration // Longest a check may run; 2 seconds when 0
+	CacheTTL time.Duration // How long a result is reused; results are not cached when 0
+	Draining func() bool   // Reports whether the server is shutting down; may be nil
+
+	mu     sync.Mutex
+	checks []*check
+}
+
+// check is a registered check with its last result. mu is held while the check runs, so that concurrent probes
+// wait for one run instead of starting their own.
+type check struct {
+	name string
+	fn   CheckFunc
+
+	mu     sync.Mutex
+	result Result
+	ran    bool
+}
+
+// Register - Add a readiness check under the given name
+func (c *Checker) Register(name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, &check{name: name, fn: fn})
+}
+
+// Check - Run the checks whose cached results are too old, concurrently, and report every result. While the
+// server is draining the report is not ok, whatever the checks say.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := c.checks
+	c.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, chk := range checks {
+		wg.Add(1)
+		go func(i int, chk *check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, chk := range checks {
+		report.Checks[chk.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+	if c.Draining != nil && c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+// run - Return the cached result of a check, or run it with the timeout
+func (c *Checker) run(ctx context.Context, chk *check) Result {
+	chk.mu.Lock()
+	defer chk.mu.Unlock()
+	if chk.ran && time.Since(chk.result.CheckedAt) < c.CacheTTL {
+		return chk.result
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- chk.fn(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// The check ignores its context; leave it running and report the timeout
+		err = ErrCheckTimeout
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrCheckTimeout
+	}
+
+	result := Result{Status: StatusOK, Duration: time.Since(start).Round(time.Microsecond).String(), CheckedAt: start}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	// A probe that gave up early says nothing about the dependency, so its result is not cached
+	if ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		chk.result, chk.ran = result, true
+	}
+	return result
+}
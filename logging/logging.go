@@ -0,0 +1,164 @@
+package logging
+
+/**
+The logging package builds the structured logger of the service on log/slog. Records are written as JSON or text, every package logs through its own logger so that its level can be set apart from the others, attributes stored in the context (the request ID, the user) are added to every record logged with it, and passwords, tokens and Authorization values are redacted from all output (see redact.go).
+*/
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// LoggerKey is the attribute naming the package a record was logged by
+const LoggerKey = "logger"
+
+// Config selects the format and the levels of the log output.
+type Config struct {
+	Format string                // json or text
+	Level  slog.Level            // Lowest level logged
+	Levels map[string]slog.Level // Overrides Level for the packages it names
+}
+
+// output is the handler the loggers of every package write to, with the levels they filter by
+type output struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+func (o *output) levelOf(pkg string) slog.Level {
+	if level, ok := o.levels[pkg]; ok {
+		return level
+	}
+	return o.level
+}
+
+var current atomic.Pointer[output]
+
+// Until Setup is called, records are written as text to standard error
+func init() {
+	Setup(os.Stderr, Config{Format: FormatText, Level: slog.LevelInfo})
+}
+
+/*
+*
+Setup
+
+func Setup(w io.Writer, cfg Config) error
+Description: Writes the records of every logger to w in the format of cfg, from then on. Loggers returned by For before the call follow the change. The default slog logger and the log package are redirected too, so that nothing is written around the redaction.
+*/
+func Setup(w io.Writer, cfg Config) error {
+	options := &slog.HandlerOptions{
+		// The loggers filter by the level of their package, so the handler lets everything through
+		Level:       slog.Level(-1 << 10),
+		ReplaceAttr: redactAttr,
+	}
+	var handler slog.Handler
+	switch cfg.Format {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, expected json or text", cfg.Format)
+	}
+
+	current.Store(&output{handler: handler, level: cfg.Level, levels: cfg.Levels})
+	slog.SetDefault(For("default"))
+	return nil
+}
+
+// For - Return the logger of a package. Its records carry the package name under LoggerKey, and are filtered by the
+// level set for the package, or the general level.
+func For(pkg string) *slog.Logger {
+	return slog.New(&packageHandler{pkg: pkg})
+}
+
+// Fatal - Log msg at the error level and exit with status 1, like log.Fatal
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+/*
+*
+ParseLevels
+
+func ParseLevels(entries []string) (map[string]slog.Level, error)
+Description: Parses per-package levels given as "<package>=<level>", such as db=debug or access=warn. Levels are debug, info, warn or error, optionally with an offset such as warn+2.
+*/
+func ParseLevels(entries []string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level, len(entries))
+	for _, entry := range entries {
+		pkg, name, ok := strings.Cut(entry, "=")
+		if !ok || pkg == "" {
+			return nil, fmt.Errorf("%q is not <package>=<level>", entry)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		levels[pkg] = level
+	}
+	return levels, nil
+}
+
+type contextKey struct{}
+
+// ContextWith - Return a context whose records carry attrs, in addition to the attributes ctx already carries. Pass
+// the context to the Context variants of the logger methods, such as InfoContext.
+func ContextWith(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing := contextAttrs(ctx)
+	return context.WithValue(ctx, contextKey{}, append(existing[:len(existing):len(existing)], attrs...))
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return attrs
+}
+
+// packageHandler writes to the current output, filtered by the level of its package. The attributes and groups added
+// with With and WithGroup are kept as steps and replayed on the current output, since Setup may replace it.
+type packageHandler struct {
+	pkg   string
+	steps []func(slog.Handler) slog.Handler
+}
+
+func (h *packageHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().levelOf(h.pkg)
+}
+
+func (h *packageHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := append([]slog.Attr{slog.String(LoggerKey, h.pkg)}, contextAttrs(ctx)...)
+	handler := current.Load().handler.WithAttrs(attrs)
+	for _, step := range h.steps {
+		handler = step(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *packageHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *packageHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *packageHandler) with(step func(slog.Handler) slog.Handler) slog.Handler {
+	steps := append(h.steps[:len(h.steps):len(h.steps)], step)
+	return &packageHandler{pkg: h.pkg, steps: steps}
+}
@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the secrets removed from log output
+const Redacted = "[REDACTED]"
+
+// Attribute keys whose values are secrets. Keys are compared in lower case without underscores, dashes or dots, and
+// match when they contain a fragment or equal a name.
+var (
+	sensitiveFragments = []string{"password", "passwd", "secret", "token", "authorization", "cookie", "apikey", "privatekey", "recoverycode"}
+	sensitiveNames     = []string{"code", "otp", "totp", "pin"}
+)
+
+// Secrets inside strings such as messages, errors and URLs
+var (
+	authSchemePattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	jwtPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	assignmentPattern = regexp.MustCompile(`(?i)\b(\w*(?:password|passwd|secret|token)\w*|code)=[^&\s"',;]+`)
+	jsonFieldPattern  = regexp.MustCompile(`(?i)"(\w*(?:password|passwd|secret|token)\w*)"\s*:\s*"[^"]*"`)
+)
+
+// IsSensitiveKey - Report whether the values of an attribute, form field or header with this name are secrets
+func IsSensitiveKey(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "", ".", "").Replace(strings.ToLower(key))
+	for _, fragment := range sensitiveFragments {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	for _, name := range sensitiveNames {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrub - Replace the secrets found in a string: Bearer and Basic credentials, JWTs, password=… and token=…
+// assignments as in query strings, and "password": "…" fields of JSON documents
+func Scrub(s string) string {
+	s = authSchemePattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	s = assignmentPattern.ReplaceAllString(s, "$1="+Redacted)
+	s = jsonFieldPattern.ReplaceAllString(s, `"$1":"`+Redacted+`"`)
+	return s
+}
+
+// redactAttr - Redact an attribute before it is written. The values of sensitive keys are replaced as a whole;
+// strings, errors and other values are scrubbed. It is the ReplaceAttr function of the handlers.
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey || attr.Key == slog.SourceKey) {
+		return attr
+	}
+	if IsSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(Scrub(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			attr.Value = slog.StringValue(Scrub(value.Error()))
+		case fmt.Stringer:
+			attr.Value = slog.StringValue(Scrub(value.String()))
+		case []byte:
+			attr.Value = slog.StringValue(Scrub(string(value)))
+		default:
+			attr.Value = redactValue(value)
+		}
+	}
+	return attr
+}
+
+// redactValue - Redact a struct, map or slice through its JSON form, so that fields such as a password hash are not
+// written. Values that cannot be encoded as JSON are written as their scrubbed %v form.
+func redactValue(value any) slog.Value {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return slog.StringValue(Scrub(fmt.Sprintf("%+v", value)))
+	}
+	var generic any
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return slog.StringValue(Scrub(string(encoded)))
+	}
+	return slog.AnyValue(redactJSON(generic))
+}
+
+func redactJSON(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if IsSensitiveKey(key) {
+				value[key] = Redacted
+			} else {
+				value[key] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactJSON(item)
+		}
+	case string:
+		return Scrub(value)
+	}
+	return value
+}
@@ -1,53 +1,158 @@
 package main
 
 import (
-	"api-service/controllers"
+	"api-service/config"
 	"api-service/db"
+	"api-service/logging"
+	"api-service/metrics"
 	"api-service/middleware"
-	"api-service/services"
+	"api-service/server"
+	"api-service/tracing"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-
-	"github.com/gorilla/mux"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+var logger = logging.For("main")
+
 func main() {
-	// Initialize DB
-	dbConn := db.InitDB()
+	// Load the configuration
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stdout)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// Initialize Services
-	userService := &services.UserService{DB: dbConn}
-	adminService := &services.AdminService{DB: dbConn}
+	// Run a maintenance command such as "audit verify" or "config print" instead of the server
+	if len(args) > 0 {
+		os.Exit(runCommand(cfg, args))
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// Initialize Controllers
-	userController := &controllers.UserController{UserService: userService}
-	adminController := &controllers.AdminController{AdminService: adminService}
+	// Set up logging; the levels were checked by Validate
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Log.Level))
+	levels, _ := logging.ParseLevels(cfg.Log.Levels)
+	logging.Setup(os.Stderr, logging.Config{Format: cfg.Log.Format, Level: level, Levels: levels})
 
-	// Setup Router
-	router := mux.NewRouter()
+	// The server runs the shutdown hooks registered below, newest first, after draining the requests
+	srv := server.New(server.Config{
+		Addr:              cfg.Server.Addr,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ShutdownTimeout:   cfg.Server.ShutdownTimeout,
+		ShutdownDelay:     cfg.Server.ShutdownDelay,
+	})
 
-	// Public Routes
-	router.HandleFunc("/register", userController.RegisterAdmin).Methods("POST")
-	router.HandleFunc("/login", userController.Login).Methods("POST")
+	// Set up tracing. The provider is shut down after the other hooks, so that the spans they end are exported.
+	if exporter := newSpanExporter(cfg); exporter != nil {
+		provider := tracing.Setup(tracing.Config{Exporter: exporter, SampleRatio: cfg.Tracing.SampleRatio})
+		srv.OnShutdown("tracing", provider.Shutdown)
+	}
+
+	// Initialize DB
+	dbConn := db.InitDB(cfg.Database.Driver, cfg.Database.URL, cfg.Database.Migrate)
+	srv.OnShutdown("database", func(context.Context) error {
+		sqlDB, err := dbConn.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	})
+	if err := metrics.InstrumentDB(dbConn); err != nil {
+		logging.Fatal(logger, "Failed to instrument the database", "error", err)
+	}
+	if err := dbConn.Use(tracing.GormPlugin{}); err != nil {
+		logging.Fatal(logger, "Failed to trace the database", "error", err)
+	}
 
-	// Protected Routes
-	api := router.PathPrefix("/api").Subrouter()
-	api.Use(middleware.JWTMiddleware)
+	// Initialize the services and the routes
+	router := newRouter(cfg, srv, dbConn)
 
-	// User Routes (protected for logged-in users)
-	api.HandleFunc("/profile", userController.GetProfile).Methods("GET")
-	api.HandleFunc("/profile", userController.UpdateProfile).Methods("PUT")
+	// Start server. The first SIGTERM or SIGINT starts a graceful shutdown; a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	context.AfterFunc(ctx, stop)
 
-	// Admin Routes (protected for admin only)
-	adminApi := api.PathPrefix("/admin").Subrouter()
-	adminApi.Use(middleware.AdminRoleMiddleware)
+	// Metrics Route, on the admin listener unless metrics.addr is empty; on the main listener it takes the token
+	if cfg.Metrics.Enabled && cfg.Metrics.Addr == "" {
+		router.Handle("/metrics", metrics.RequireToken(cfg.Metrics.Token, metrics.Handler())).Methods("GET")
+	} else if cfg.Metrics.Enabled {
+		startMetricsServer(ctx, cfg, srv)
+	}
+
+	// Every request, routed or not, gets a request ID, a trace span and an access log entry
+	traced := tracing.InstrumentRouter(router)
+	handler := middleware.RequestIDMiddleware(traced(middleware.AccessLogMiddleware(metrics.InstrumentRouter(router))))
+	logger.Info("Server started", "addr", cfg.Server.Addr)
+	if err := srv.Run(ctx, handler); err != nil {
+		logger.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Server stopped")
+}
+
+// newSpanExporter - Return the exporter of tracing.exporter, or nil when tracing is disabled
+func newSpanExporter(cfg *config.Config) tracing.SpanExporter {
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		return &tracing.OTLPExporter{
+			Endpoint:    cfg.Tracing.Endpoint,
+			ServiceName: cfg.Tracing.ServiceName,
+			Client:      &http.Client{Timeout: 10 * time.Second},
+		}
+	case "stdout":
+		return &tracing.StdoutExporter{W: os.Stdout}
+	default:
+		return nil
+	}
+}
 
-	adminApi.HandleFunc("/users", adminController.GetAllUsers).Methods("GET")
-	adminApi.HandleFunc("/users", adminController.CreateUser).Methods("POST")
-	adminApi.HandleFunc("/users/{id}", adminController.DeleteUser).Methods("DELETE")
-	adminApi.HandleFunc("/users/{id}/revoke", adminController.RevokeToken).Methods("POST")
+// startMetricsServer serves /metrics on the admin listener of metrics.addr until ctx is cancelled. The main server
+// waits for it in a shutdown hook, which runs first.
+func startMetricsServer(ctx context.Context, cfg *config.Config, srv *server.Server) {
+	ln, err := net.Listen("tcp", cfg.Metrics.Addr)
+	if err != nil {
+		logging.Fatal(logger, "Failed to listen for metrics", "error", err)
+	}
+	handler := metrics.Handler()
+	if cfg.Metrics.Token != "" {
+		handler = metrics.RequireToken(cfg.Metrics.Token, handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handler)
+	metricsServer := server.New(server.Config{
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ShutdownTimeout:   cfg.Server.ShutdownTimeout,
+	})
 
-	// Start server
-	fmt.Println("Server started at :8080")
-	http.ListenAndServe(":8080", router)
+	done := make(chan error, 1)
+	go func() { done <- metricsServer.Serve(ctx, ln, mux) }()
+	srv.OnShutdown("metrics listener", func(ctx context.Context) error {
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	logger.Info("Metrics served", "addr", cfg.Metrics.Addr, "path", "/metrics")
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"api-service/config"
+	"api-service/db/dbtest"
+	"api-service/logging"
+	"api-service/server"
+	"api-service/tracing"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+const testSetupToken = "test-setup-token"
+
+func TestMain(m *testing.M) {
+	logging.Setup(io.Discard, logging.Config{Format: logging.FormatText})
+	os.Exit(m.Run())
+}
+
+// testServer serves the routes of newRouter on a fresh SQLite database
+type testServer struct {
+	*httptest.Server
+	t   *testing.T
+	cfg *config.Config
+	db  *gorm.DB
+}
+
+// newTestServer - Start a server with the default configuration, without rate limits and changed by configure, if
+// given. The first admin is created with setupAdmin.
+func newTestServer(t *testing.T, configure ...func(*config.Config)) *testServer {
+	t.Helper()
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	tokenFile := filepath.Join(t.TempDir(), "setup-token")
+	if err := os.WriteFile(tokenFile, []byte(testSetupToken), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Bootstrap.TokenFile = tokenFile
+	cfg.RateLimit.Auth, cfg.RateLimit.Profile, cfg.RateLimit.API = "off", "off", "off"
+	cfg.JWT.KeyRotation = 0
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	ts := &testServer{Server: httptest.NewUnstartedServer(nil), t: t, cfg: cfg, db: dbtest.SQLite(t)}
+	cfg.Server.Issuer = "http://" + ts.Listener.Addr().String()
+	// Traced like main does; spans are only recorded while a test has set up a tracing provider
+	if err := ts.db.Use(tracing.GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(cfg, server.New(server.Config{}), ts.db)
+	ts.Config.Handler = tracing.InstrumentRouter(router)(router)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// request - Send a request with a JSON body, if any, and the given access token, if any
+func (ts *testServer) request(method, path, token string, body interface{}) *http.Response {
+	ts.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// expect - Send a request and fail the test unless it gets the given status. The JSON response is decoded into out
+// if out is not nil.
+func (ts *testServer) expect(status int, method, path, token string, body, out interface{}) {
+	ts.t.Helper()
+	resp := ts.request(method, path, token, body)
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		ts.t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, status, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			ts.t.Fatalf("%s %s: decode %s: %v", method, path, data, err)
+		}
+	}
+}
+
+// setupAdmin - Create the first admin, a super admin of the default organization, and return their access token
+func (ts *testServer) setupAdmin() string {
+	ts.t.Helper()
+	ts.expect(http.StatusCreated, "POST", "/setup", "", map[string]string{
+		"token": testSetupToken, "username": "root", "password": "Root-Passw0rd!", "email": "root@example.com",
+	}, nil)
+	return ts.login("root", "Root-Passw0rd!")
+}
+
+// register - Register a user in the default organization and return their ID
+func (ts *testServer) register(username, password string) uint {
+	ts.t.Helper()
+	var user struct {
+		ID uint `json:"id"`
+	}
+	ts.expect(http.StatusCreated, "POST", "/register", "", map[string]string{
+		"username": username, "password": password, "email": username + "@example.com",
+	}, &user)
+	return user.ID
+}
+
+// login - Log in with a password and return the access token
+func (ts *testServer) login(username, password string) string {
+	ts.t.Helper()
+	var tokens struct {
+		Token string `json:"token"`
+	}
+	ts.expect(http.StatusOK, "POST", "/login", "", map[string]string{"username": username, "password": password}, &tokens)
+	if tokens.Token == "" {
+		ts.t.Fatalf("login %s: no token", username)
+	}
+	return tokens.Token
+}
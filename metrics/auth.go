@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"api-service/services"
+	"context"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Login outcomes besides the reasons of failures
+const (
+	LoginSucceeded   = "success"
+	LoginMFARequired = "mfa_required" // The first factor passed and an MFA challenge was issued
+)
+
+// Token validation results
+const (
+	TokenValid           = "valid"
+	TokenMissing         = "missing"
+	TokenInvalid         = "invalid" // Malformed, badly signed or meant for another purpose
+	TokenExpired         = "expired"
+	TokenRevoked         = "revoked"
+	TokenRevocationError = "revocation_error" // The revocation store could not be reached
+)
+
+var (
+	// Logins counts login attempts by method (password, mfa, passkey, oidc) and outcome (success or the reason of
+	// the failure, such as invalid_credentials or locked)
+	Logins = Default.NewCounterVec("auth_logins_total",
+		"Login attempts by method and outcome.", "method", "outcome")
+	// TokenValidations counts the access tokens checked by the authentication middleware by result
+	TokenValidations = Default.NewCounterVec("auth_token_validations_total",
+		"Access tokens checked on protected routes, by result (valid or the reason of the failure).", "result")
+	revocations = Default.NewCounterVec("auth_revocations_total",
+		"Token revocations by kind: a single token, every token of a subject, or every token.", "kind")
+)
+
+// LoginOutcome - Turn the reason of a failed login, such as "invalid credentials", into an outcome label such as
+// invalid_credentials
+func LoginOutcome(reason string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, reason)
+}
+
+// CountRevocations - Wrap a revocation store so that the revocations made through it are counted
+func CountRevocations(store services.RevocationStore) services.RevocationStore {
+	return revocationCounter{store}
+}
+
+type revocationCounter struct {
+	services.RevocationStore
+}
+
+func (c revocationCounter) RevokeToken(jti string, expiresAt time.Time) error {
+	err := c.RevocationStore.RevokeToken(jti, expiresAt)
+	if err == nil {
+		revocations.Inc("token")
+	}
+	return err
+}
+
+func (c revocationCounter) RevokeSubject(subject string, before time.Time) error {
+	err := c.RevocationStore.RevokeSubject(subject, before)
+	if err == nil {
+		revocations.Inc("subject")
+	}
+	return err
+}
+
+func (c revocationCounter) RevokeAll(before time.Time) error {
+	err := c.RevocationStore.RevokeAll(before)
+	if err == nil {
+		revocations.Inc("all")
+	}
+	return err
+}
+
+// WithContext - Bind the wrapped store, keeping the counting
+func (c revocationCounter) WithContext(ctx context.Context) services.RevocationStore {
+	return revocationCounter{c.RevocationStore.WithContext(ctx)}
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"api-service/services"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountRevocationsKeepsCountingWhenBound(t *testing.T) {
+	store := CountRevocations(services.NewMemoryRevocationStore())
+	if err := store.WithContext(context.Background()).RevokeAll(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	Default.Write(&out)
+	if want := `auth_revocations_total{kind="all"} 1`; !strings.Contains(out.String(), want) {
+		t.Fatalf("exposition lacks %s:\n%s", want, out.String())
+	}
+}
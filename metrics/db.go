@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var dbQueryDuration = Default.NewHistogramVec("db_query_duration_seconds",
+	"Time taken by database queries made through gorm, by operation and table.", DefaultBuckets, "operation", "table")
+
+const queryStartKey = "metrics:query_start"
+
+// InstrumentDB - Measure the queries made through conn and export the statistics of its connection pool. Call it
+// once, before the connection is used.
+func InstrumentDB(conn *gorm.DB) error {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+
+	callbacks := conn.Callback()
+	err = errors.Join(
+		callbacks.Create().Before("*").Register("metrics:before_create", startQuery),
+		callbacks.Create().After("*").Register("metrics:after_create", endQuery("create")),
+		callbacks.Query().Before("*").Register("metrics:before_query", startQuery),
+		callbacks.Query().After("*").Register("metrics:after_query", endQuery("query")),
+		callbacks.Update().Before("*").Register("metrics:before_update", startQuery),
+		callbacks.Update().After("*").Register("metrics:after_update", endQuery("update")),
+		callbacks.Delete().Before("*").Register("metrics:before_delete", startQuery),
+		callbacks.Delete().After("*").Register("metrics:after_delete", endQuery("delete")),
+		callbacks.Row().Before("*").Register("metrics:before_row", startQuery),
+		callbacks.Row().After("*").Register("metrics:after_row", endQuery("row")),
+		callbacks.Raw().Before("*").Register("metrics:before_raw", startQuery),
+		callbacks.Raw().After("*").Register("metrics:after_raw", endQuery("raw")),
+	)
+	if err != nil {
+		return err
+	}
+
+	registerPoolStats(sqlDB)
+	return nil
+}
+
+func startQuery(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func endQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		dbQueryDuration.Observe(time.Since(value.(time.Time)).Seconds(), operation, table)
+	}
+}
+
+// registerPoolStats - Export the statistics of the connection pool, read when scraped
+func registerPoolStats(sqlDB *sql.DB) {
+	stats := func(field func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return field(sqlDB.Stats()) }
+	}
+	Default.NewGaugeFunc("db_connections_max_open", "Maximum number of open connections to the database; 0 is unlimited.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	Default.NewGaugeFunc("db_connections_open", "Open connections to the database, in use or idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	Default.NewGaugeFunc("db_connections_in_use", "Connections to the database in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	Default.NewGaugeFunc("db_connections_idle", "Idle connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	Default.NewCounterFunc("db_connections_waited_total", "Times a query waited for a free connection.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	Default.NewCounterFunc("db_connections_wait_seconds_total", "Total time queries waited for a free connection.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	Default.NewCounterFunc("db_connections_closed_max_idle_total", "Connections closed because the pool had too many idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	Default.NewCounterFunc("db_connections_closed_max_idle_time_total", "Connections closed because they were idle too long.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	Default.NewCounterFunc("db_connections_closed_max_lifetime_total", "Connections closed because they reached their maximum lifetime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
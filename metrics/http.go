@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"api-service/utils"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = Default.NewCounterVec("http_requests_total",
+		"HTTP requests by route template, method and status code.", "route", "method", "status")
+	httpDuration = Default.NewHistogramVec("http_request_duration_seconds",
+		"Time from receiving an HTTP request to writing its response, by route template, method and status code.",
+		DefaultBuckets, "route", "method", "status")
+)
+
+// UnmatchedRoute labels the requests no route matched, so that scans of random paths do not create new series
+const UnmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+// InstrumentRouter - Count the requests the router handles and measure their latency. Requests are labelled with
+// the template of the route that matched, such as /api/users/{id}, rather than their path, which would create a
+// series per user. Register every route before serving the returned handler.
+func InstrumentRouter(router *mux.Router) http.Handler {
+	// mux only knows the matched route inside its own handler, so a middleware there passes it out
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+				if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+					*route = template
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := UnmatchedRoute
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		router.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+
+		status := strconv.Itoa(recorder.status)
+		method := methodLabel(r.Method)
+		httpRequests.Inc(route, method, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, method, status)
+	})
+}
+
+// OtherMethod labels the requests with a method outside the standard ones, which clients may choose freely
+const OtherMethod = "OTHER"
+
+var standardMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// methodLabel - Return the method of a request as a label value, so that made-up methods do not create new series
+func methodLabel(method string) string {
+	if standardMethods[method] {
+		return method
+	}
+	return OtherMethod
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// RequireToken - Serve next only to requests whose Authorization header carries the bearer token, as Prometheus
+// sends it with the authorization setting of a scrape job. Other requests get 401 Unauthorized, every request does
+// for an empty token.
+func RequireToken(token string, next http.Handler) http.Handler {
+	// Comparing hashes takes the same time whatever the length of the token sent
+	want := sha256.Sum256([]byte(token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := sha256.Sum256([]byte(utils.BearerToken(r)))
+		if token == "" || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestInstrumentRouterLabels(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := InstrumentRouter(router)
+
+	requests := []struct{ method, path string }{
+		{"GET", "/api/users/1"},
+		{"GET", "/api/users/2"},
+		{"DELETE", "/api/users/3"},
+		{"GET", "/random/scan"},
+		{"FOO", "/api/users/4"},
+		{"BAR-" + strings.Repeat("X", 100), "/api/users/5"},
+		{"get", "/api/users/6"},
+	}
+	for _, req := range requests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	var out strings.Builder
+	Default.Write(&out)
+	exposition := out.String()
+	for _, want := range []string{
+		`http_requests_total{route="/api/users/{id}",method="GET",status="204"} 2`,
+		`http_requests_total{route="/api/users/{id}",method="DELETE",status="204"} 1`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_requests_total{route="/api/users/{id}",method="OTHER",status="204"} 3`,
+	} {
+		if !strings.Contains(exposition, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, method := range []string{"FOO", "BAR-", `method="get"`} {
+		if strings.Contains(exposition, method) {
+			t.Errorf("the exposition has a series for the method %s", method)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	for _, test := range []struct {
+		name, token, header string
+		want                int
+	}{
+		{"bearer token", "scrape-token", "Bearer scrape-token", http.StatusOK},
+		{"without the prefix", "scrape-token", "scrape-token", http.StatusOK},
+		{"wrong token", "scrape-token", "Bearer other-token", http.StatusUnauthorized},
+		{"prefix of the token", "scrape-token", "Bearer scrape", http.StatusUnauthorized},
+		{"no header", "scrape-token", "", http.StatusUnauthorized},
+		{"empty token", "", "", http.StatusUnauthorized},
+		{"empty token, any header", "", "Bearer ", http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handler := RequireToken(test.token, Handler())
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Fatalf("status %d, want %d", rec.Code, test.want)
+			}
+			if test.want == http.StatusUnauthorized && (rec.Header().Get("WWW-Authenticate") == "" || strings.Contains(rec.Body.String(), "http_requests_total")) {
+				t.Fatalf("refused scrape answered %q with the metrics", rec.Header())
+			}
+		})
+	}
+}
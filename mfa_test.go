@@ -0,0 +1,131 @@
+package main
+
+import (
+	"api-service/oidcclient"
+	"api-service/utils"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// enrollMFA - Enable TOTP for the user of the token and return the secret
+func (ts *testServer) enrollMFA(token string) string {
+	ts.t.Helper()
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	ts.expect(http.StatusOK, "POST", "/api/profile/mfa", token, nil, &enrollment)
+	code, err := utils.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.expect(http.StatusOK, "POST", "/api/profile/mfa/confirm", token, map[string]string{"code": code}, nil)
+	return enrollment.Secret
+}
+
+// wrongCode - Return a code that the authenticator with the secret does not show around now
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+	now := time.Now()
+	for candidate := 0; ; candidate++ {
+		code := strconv.Itoa(100000 + candidate)
+		if _, ok := utils.ValidateTOTP(secret, code, now); !ok {
+			return code
+		}
+	}
+}
+
+// mfaChallenge - Log in with a password and return the mfa_pending challenge token
+func (ts *testServer) mfaChallenge(username, password string) string {
+	ts.t.Helper()
+	var challenge struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	ts.expect(http.StatusOK, "POST", "/login", "", map[string]string{"username": username, "password": password}, &challenge)
+	if challenge.MFAToken == "" {
+		ts.t.Fatalf("login %s: no MFA challenge", username)
+	}
+	return challenge.MFAToken
+}
+
+// oidcLogin - Submit the OpenID Connect login form with a second factor code and return the status
+func (ts *testServer) oidcLogin(rp *oidcclient.Client, username, password, otp string) int {
+	ts.t.Helper()
+	auth, err := rp.AuthCodeURL()
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	parsed, _ := url.Parse(auth.URL)
+	form := parsed.Query()
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("otp", otp)
+	browser := ts.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := browser.PostForm(ts.URL+"/oauth/authorize", form)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestMFAFailuresAreCountedPerUserAcrossChallenges(t *testing.T) {
+	ts := newTestServer(t)
+	ts.setupAdmin()
+	ts.register("alice", "Alice-Passw0rd!")
+	secret := ts.enrollMFA(ts.login("alice", "Alice-Passw0rd!"))
+	wrong := wrongCode(t, secret)
+
+	// Each wrong code is entered for a new challenge, so that no single challenge reaches its own limit
+	for i := 0; i < ts.cfg.Login.MFAMaxFailures; i++ {
+		challenge := ts.mfaChallenge("alice", "Alice-Passw0rd!")
+		ts.expect(http.StatusUnauthorized, "POST", "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": wrong}, nil)
+	}
+
+	challenge := ts.mfaChallenge("alice", "Alice-Passw0rd!")
+	code, _ := utils.TOTPCode(secret, time.Now().Add(30*time.Second))
+	resp := ts.request("POST", "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": code})
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("locked second factor: status %d, Retry-After %q, want 429 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestMFAFailuresAreCountedOnTheOIDCLoginPage(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.setupAdmin()
+	id := ts.register("bob", "Bob-Passw0rd!")
+	secret := ts.enrollMFA(ts.login("bob", "Bob-Passw0rd!"))
+	wrong := wrongCode(t, secret)
+	clientID, clientSecret := ts.registerClient(admin, "openid")
+	rp := &oidcclient.Client{
+		Issuer:       ts.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURI:  testRedirectURI,
+		Scopes:       []string{"openid"},
+		HTTPClient:   ts.Client(),
+	}
+	if err := rp.Discover(context.Background()); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	for i := 0; i < ts.cfg.Login.MFAMaxFailures; i++ {
+		if status := ts.oidcLogin(rp, "bob", "Bob-Passw0rd!", wrong); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status %d, want 401", i+1, status)
+		}
+	}
+	if status := ts.oidcLogin(rp, "bob", "Bob-Passw0rd!", wrong); status != http.StatusTooManyRequests {
+		t.Fatalf("locked second factor on the login page: status %d, want 429", status)
+	}
+	// The lock is shared with /login/mfa
+	challenge := ts.mfaChallenge("bob", "Bob-Passw0rd!")
+	ts.expect(http.StatusTooManyRequests, "POST", "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": wrong}, nil)
+
+	// Unlocking the user lifts the lock of their second factor
+	ts.expect(http.StatusOK, "POST", "/api/admin/users/"+strconv.Itoa(int(id))+"/unlock", admin, nil, nil)
+	ts.expect(http.StatusUnauthorized, "POST", "/login/mfa", "", map[string]string{"mfa_token": challenge, "code": wrong}, nil)
+}
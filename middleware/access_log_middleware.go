@@ -0,0 +1,127 @@
+package middleware
+
+/**
+The AccessLogMiddleware writes one log record per request, with its method, path, status, size, duration and the user or client that sent it. Secrets in the query string, such as reset and verification tokens, are redacted by the logging package.
+*/
+import (
+	"api-service/logging"
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// The access log records are logged by the "access" package, so that access=warn keeps only the failed requests
+var accessLogger = logging.For("access")
+
+// requestIdentity is filled in by JWTMiddleware, which runs inside the router, for the access log entry outside it
+type requestIdentity struct {
+	userID   string
+	username string
+	clientID string
+	tenantID uint
+}
+
+type requestIdentityKey struct{}
+
+/*
+*
+AccessLogMiddleware
+
+func AccessLogMiddleware(next http.Handler) http.Handler
+Description: This middleware logs every request once it has been handled. Requests answered with a server error are logged at the error level, those answered with a client error at the warn level and the others at the info level. It must wrap the router, inside RequestIDMiddleware, so that unmatched requests are logged too and every entry carries the request ID.
+
+	{"time":"2024-05-02T09:14:31Z","level":"INFO","msg":"request","logger":"access","request_id":"4bf92f35","method":"GET","path":"/api/users/7","status":200,"bytes":312,"duration_ms":4.1,"remote_ip":"203.0.113.7","user_agent":"curl/8.5.0","user_id":"3","username":"alice"}
+*/
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		identity := &requestIdentity{}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestIdentityKey{}, identity)))
+
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		} else if recorder.status >= 400 {
+			level = slog.LevelWarn
+		}
+		if !accessLogger.Enabled(r.Context(), level) {
+			return
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		}
+		if r.URL.RawQuery != "" {
+			attrs = append(attrs, slog.String("query", r.URL.RawQuery))
+		}
+		attrs = append(attrs,
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_ip", utils.ClientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+		attrs = append(attrs, identity.attrs()...)
+		accessLogger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// setRequestIdentity - Record who sent the request for the access log, and add it to the records logged with ctx
+func setRequestIdentity(ctx context.Context, claims *models.JWTClaims) context.Context {
+	identity := requestIdentity{tenantID: claims.TenantID, clientID: claims.ClientID}
+	if !claims.IsClientToken() {
+		identity.userID = claims.Subject
+		identity.username = claims.Username
+	}
+	if entry, ok := ctx.Value(requestIdentityKey{}).(*requestIdentity); ok {
+		*entry = identity
+	}
+	return logging.ContextWith(ctx, identity.attrs()...)
+}
+
+func (i *requestIdentity) attrs() []slog.Attr {
+	var attrs []slog.Attr
+	if i.userID != "" {
+		attrs = append(attrs, slog.String("user_id", i.userID), slog.String("username", i.username))
+	}
+	if i.clientID != "" {
+		attrs = append(attrs, slog.String("client_id", i.clientID))
+	}
+	if i.tenantID != 0 {
+		attrs = append(attrs, slog.Uint64("tenant_id", uint64(i.tenantID)))
+	}
+	return attrs
+}
+
+// responseRecorder remembers the status code and size of the response a handler wrote
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
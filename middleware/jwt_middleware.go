@@ -4,37 +4,117 @@ package middleware
 The JWTMiddleware is responsible for validating the JSON Web Token (JWT) provided by the user in the Authorization header. It ensures that only authenticated users can access protected routes by verifying the token and adding user information to the request context for downstream use in the application.
 */
 import (
+	"api-service/logging"
+	"api-service/metrics"
+	"api-service/models"
+	"api-service/services"
+	"api-service/tracing"
 	"api-service/utils"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 )
 
+var logger = logging.For("middleware")
+
+/*
+*
+AuthMiddleware carries the dependencies JWTMiddleware needs. Revocations is the token denylist that is checked on every request.
+*/
+type AuthMiddleware struct {
+	Revocations services.RevocationStore
+}
+
 /*
 *
 JWTMiddleware
 
-func JWTMiddleware(next http.Handler) http.Handler
-Description: This middleware intercepts incoming HTTP requests, checks if the request contains a valid JWT token in the Authorization header, and validates it. If the token is valid, it adds the user information to the request context and allows the request to proceed. If the token is missing or invalid, it returns an unauthorized error (401 Unauthorized).
+func (am *AuthMiddleware) JWTMiddleware(next http.Handler) http.Handler
+Description: This middleware intercepts incoming HTTP requests, checks if the request contains a valid JWT token in the Authorization header (with or without the "Bearer " prefix), and validates it. If the token is valid and has not been revoked, it adds the user information to the request context and allows the request to proceed. If the token is missing, invalid or revoked, it returns an unauthorized error (401 Unauthorized). Tokens a user delegated to an OAuth2 client through the authorization code flow are refused too, since they only carry the OpenID Connect scopes the user granted; see DelegatedJWTMiddleware.
+*/
+func (am *AuthMiddleware) JWTMiddleware(next http.Handler) http.Handler {
+	return am.authenticate(next, false)
+}
+
+/*
+*
+DelegatedJWTMiddleware
+
+func (am *AuthMiddleware) DelegatedJWTMiddleware(next http.Handler) http.Handler
+Description: Works like JWTMiddleware, but also accepts the tokens a user delegated to an OAuth2 client. Use it only on the routes the scopes of those tokens cover, such as /userinfo, and check the scopes in the handler.
 */
-func JWTMiddleware(next http.Handler) http.Handler {
+func (am *AuthMiddleware) DelegatedJWTMiddleware(next http.Handler) http.Handler {
+	return am.authenticate(next, true)
+}
+
+func (am *AuthMiddleware) authenticate(next http.Handler, allowDelegated bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The validation is traced with a span of its own, which ends before the request is handed on.
+		spanCtx, span := tracing.Start(r.Context(), "JWTMiddleware")
+		reject := func(result, message string) {
+			metrics.TokenValidations.Inc(result)
+			span.SetAttributes(tracing.String("auth.token.result", result))
+			span.SetStatus(tracing.StatusError, message)
+			span.End()
+			http.Error(w, message, http.StatusUnauthorized)
+		}
+
 		//The token is retrieved from the Authorization header. If no token is present, the middleware responds with an error.
-		tokenString := r.Header.Get("Authorization")
+		tokenString := utils.BearerToken(r)
 		if tokenString == "" {
 			//If the token is missing, it sends a 401 Unauthorized response:
-			http.Error(w, "Authorization token is required", http.StatusUnauthorized)
+			reject(metrics.TokenMissing, "Authorization token is required")
 			return
 		}
-		// print(tokenString)
-		//  The token is passed to the ValidateToken function in the utils package, where the JWT token is decrypted and validated. The ValidateToken function returns the user information if the token is valid.
-		user, err := utils.ValidateToken(tokenString)
+		//  The token is passed to the ParseToken function in the utils package, where the JWT token is decrypted and validated. The ParseToken function returns the token claims if the token is valid.
+		claims, err := utils.ParseToken(tokenString)
 		if err != nil {
 			// If the token is invalid or expired, a 401 Unauthorized error is returned:
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			if errors.Is(err, utils.ErrTokenExpired) {
+				reject(metrics.TokenExpired, "Invalid token")
+			} else {
+				reject(metrics.TokenInvalid, "Invalid token")
+			}
+			return
+		}
+
+		// Delegated tokens carry no roles, but they identify the user, so every other route would act on the user's behalf
+		if claims.IsDelegatedToken() && !allowDelegated {
+			reject(metrics.TokenInvalid, "Token is limited to the scopes granted to an OAuth2 client")
+			return
+		}
+
+		// The token ID (jti), subject and issue time are checked against the revocation store. If the store cannot be reached the request is rejected rather than letting a possibly revoked token through.
+		revoked, err := am.Revocations.WithContext(r.Context()).IsRevoked(claims.Id, claims.Subject, time.Unix(claims.IssuedAt, 0))
+		if err != nil {
+			logger.ErrorContext(spanCtx, "Failed to check token revocation", "error", err)
+			span.RecordError(err)
+			reject(metrics.TokenRevocationError, "Invalid token")
+			return
+		}
+		if revoked {
+			reject(metrics.TokenRevoked, "Token has been revoked")
 			return
 		}
 
 		// If the token is valid, the user information (extracted from the token) is stored in the request context using the ContextWithUser function. This allows downstream handlers to access the authenticated user's information via the context.
-		ctx := utils.ContextWithUser(r.Context(), user)
+		// Tokens issued to an OAuth2 client carry the client identity and its scopes, which are stored with ContextWithClient. Tokens from the client credentials grant have no user, so only the client is stored.
+		metrics.TokenValidations.Inc(metrics.TokenValid)
+		span.SetAttributes(tracing.String("auth.token.result", metrics.TokenValid), tracing.String("enduser.id", claims.Subject))
+		span.End()
+		// The identity is also recorded for the access log and added to the records logged with the context.
+		ctx := setRequestIdentity(r.Context(), claims)
+		ctx = utils.ContextWithClaims(ctx, claims)
+		if !claims.IsClientToken() {
+			ctx = utils.ContextWithUser(ctx, utils.UserFromClaims(claims))
+		}
+		if claims.ClientID != "" {
+			ctx = utils.ContextWithClient(ctx, &models.ClientIdentity{
+				ClientID: claims.ClientID,
+				Scopes:   strings.Fields(claims.Scope),
+			})
+		}
 		//The middleware calls the next handler in the chain, passing the modified request with the user information in the context. This ensures that only authenticated requests can proceed to the protected endpoint.
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
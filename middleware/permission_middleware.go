@@ -0,0 +1,58 @@
+package middleware
+
+/**
+The PermissionMiddleware guards routes with a permission such as "users:delete". It runs after JWTMiddleware and looks up the permissions of the roles carried by the token in the RBACService.
+*/
+import (
+	"api-service/services"
+	"api-service/utils"
+	"net/http"
+	"strings"
+)
+
+type PermissionMiddleware struct {
+	RBAC *services.RBACService
+}
+
+/*
+*
+RequirePermission
+
+func (pm *PermissionMiddleware) RequirePermission(permission string) func(http.Handler) http.Handler
+Description: This middleware lets a request through if one of the roles of the logged-in user grants the permission. Tokens from the client credentials grant carry no user; for them each scope counts as a role, and a scope equal to the permission grants it directly, so a client with the "admin" scope keeps full access. RegisterClient only lets an admin grant clients the permissions they hold themselves. Other requests get 403 Forbidden.
+*/
+func (pm *PermissionMiddleware) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := utils.GetClaimsFromContext(r.Context())
+			if err != nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			roles := claims.RoleNames()
+			if claims.IsClientToken() {
+				roles = strings.Fields(claims.Scope)
+				for _, scope := range roles {
+					if scope == permission {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			allowed, err := pm.RBAC.WithContext(r.Context()).HasPermission(roles, permission)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "Failed to check permission", "permission", permission, "error", err)
+				http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Forbidden - missing permission "+permission, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+/**
+The RateLimiter limits how many requests a client may send to a group of routes. Each limit is a RateLimitPolicy: a route group name, a limit such as 10 requests per minute and what the requests are counted by (the client IP address, the authenticated user, or the route group as a whole). The counts are kept in a services.RateLimitStore, in memory or on a Redis server shared by every instance.
+*/
+import (
+	"api-service/services"
+	"api-service/utils"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What a rate limit counts requests by
+const (
+	KeyByIP    = "ip"    // Each client IP address has its own limit
+	KeyByUser  = "user"  // Each authenticated user or client has its own limit; anonymous requests fall back to the IP address
+	KeyByRoute = "route" // All clients share the limit of the route group
+)
+
+// RateLimitPolicy is the limit of one route group. With a PathPrefix, the policy only applies to requests whose
+// path starts with it.
+type RateLimitPolicy struct {
+	Group      string
+	PathPrefix string
+	Limit      services.RateLimit
+	KeyBy      string
+}
+
+// ParseRateLimitPolicy - Build the policy of a route group from a spec such as "10/1m" or "10/1m:ip". Without a key
+// in the spec, requests are counted by defaultKey.
+func ParseRateLimitPolicy(group, spec, algorithm, defaultKey string) (RateLimitPolicy, error) {
+	policy := RateLimitPolicy{Group: group, KeyBy: defaultKey}
+	if limit, key, ok := strings.Cut(spec, ":"); ok {
+		spec, policy.KeyBy = limit, key
+	}
+	if policy.KeyBy != KeyByIP && policy.KeyBy != KeyByUser && policy.KeyBy != KeyByRoute {
+		return RateLimitPolicy{}, fmt.Errorf("rate limit of %s: unknown key %q, expected ip, user or route", group, policy.KeyBy)
+	}
+	limit, err := services.ParseRateLimit(spec, algorithm)
+	if err != nil {
+		return RateLimitPolicy{}, fmt.Errorf("rate limit of %s: %w", group, err)
+	}
+	policy.Limit = limit
+	return policy, nil
+}
+
+type RateLimiter struct {
+	Store services.RateLimitStore
+}
+
+/*
+*
+Limit
+
+func (rl *RateLimiter) Limit(policies ...RateLimitPolicy) func(http.Handler) http.Handler
+Description: This middleware counts each request against the first of the policies that applies to its path. Every limited response carries the IETF RateLimit headers:
+
+	RateLimit-Limit: 10
+	RateLimit-Remaining: 7
+	RateLimit-Reset: 18
+	RateLimit-Policy: 10;w=60
+
+Requests over the limit get 429 Too Many Requests with a Retry-After header in seconds. Policies counting by user must run after JWTMiddleware. If the store fails, the request is let through and the error is logged, so that an unreachable store does not take the service down.
+*/
+func (rl *RateLimiter) Limit(policies ...RateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy, ok := matchPolicy(policies, r.URL.Path)
+			if !ok || policy.Limit.Limit == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := rl.Store.Take(rateLimitKey(policy, r), policy.Limit, time.Now())
+			if err != nil {
+				logger.ErrorContext(r.Context(), "Failed to check rate limit", "group", policy.Group, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit.Limit, seconds(policy.Limit.Window)))
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(max(1, seconds(result.RetryAfter))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func matchPolicy(policies []RateLimitPolicy, path string) (RateLimitPolicy, bool) {
+	for _, policy := range policies {
+		if strings.HasPrefix(path, policy.PathPrefix) {
+			return policy, true
+		}
+	}
+	return RateLimitPolicy{}, false
+}
+
+// rateLimitKey returns the key a request is counted under, such as "auth:ip:203.0.113.5" or "api:user:42"
+func rateLimitKey(policy RateLimitPolicy, r *http.Request) string {
+	switch policy.KeyBy {
+	case KeyByRoute:
+		return policy.Group + ":route"
+	case KeyByUser:
+		if claims, err := utils.GetClaimsFromContext(r.Context()); err == nil {
+			return policy.Group + ":user:" + claims.Subject
+		}
+	}
+	return policy.Group + ":ip:" + utils.ClientIP(r)
+}
+
+// seconds rounds a duration up to whole seconds, as the headers expect
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,55 @@
+package middleware
+
+/**
+The RequestIDMiddleware gives every request an ID, so that a request can be followed from the client through the logs and the audit log.
+*/
+import (
+	"api-service/logging"
+	"api-service/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// maxRequestIDLength limits the length of request IDs accepted from clients
+const maxRequestIDLength = 128
+
+/*
+*
+RequestIDMiddleware
+
+func RequestIDMiddleware(next http.Handler) http.Handler
+Description: This middleware keeps the X-Request-ID header of the request if it holds a short printable ID, and otherwise generates a random one. The ID is stored in the request context, added to every record logged with it, and returned in the X-Request-ID response header.
+*/
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(utils.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(utils.RequestIDHeader, id)
+		}
+		w.Header().Set(utils.RequestIDHeader, id)
+		ctx := utils.ContextWithRequestID(r.Context(), id)
+		ctx = logging.ContextWith(ctx, slog.String("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+package models
+
+import "time"
+
+// Types of audit events recorded by the controllers
+const (
+	AuditLoginSucceeded       = "login.succeeded"
+	AuditLoginFailed          = "login.failed"
+	AuditUserRegistered       = "user.registered"
+	AuditProfileUpdated       = "profile.updated"
+	AuditEmailChangeRequested = "profile.email_change_requested"
+	AuditUserCreated          = "user.created"
+	AuditUserDeleted          = "user.deleted"
+	AuditUserUnlocked         = "user.unlocked"
+	AuditTokensRevoked        = "tokens.revoked"     // Every token of a user
+	AuditTokenRevoked         = "token.revoked"      // A single token, identified by its jti
+	AuditAllTokensRevoked     = "tokens.revoked_all" // Every token of an organization, or of all of them
+	AuditUserRolesChanged     = "user.roles_changed"
+	AuditRoleCreated          = "role.created"
+	AuditRoleUpdated          = "role.updated"
+	AuditRoleDeleted          = "role.deleted"
+	AuditGroupCreated         = "group.created"
+	AuditGroupUpdated         = "group.updated"
+	AuditGroupDeleted         = "group.deleted"
+	AuditGroupMembersAdded    = "group.members_added"
+	AuditGroupMemberRemoved   = "group.member_removed"
+)
+
+// Outcomes of audit events
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is an entry of the append-only audit log. The ID numbers the events without gaps, and every event
+// carries the hash of the one before it, so that changing, deleting or inserting an event breaks the chain.
+type AuditEvent struct {
+	ID             uint64            `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	CreatedAt      time.Time         `gorm:"index;not null" json:"created_at"`
+	Type           string            `gorm:"index;not null" json:"type"`
+	Outcome        string            `gorm:"not null" json:"outcome"`
+	ActorID        string            `gorm:"index" json:"actor_id,omitempty"` // User ID or client ID of the caller
+	ActorName      string            `json:"actor_name,omitempty"`
+	TargetType     string            `json:"target_type,omitempty"` // "user", "role", "group" or "token"
+	TargetID       string            `gorm:"index" json:"target_id,omitempty"`
+	TargetName     string            `json:"target_name,omitempty"`
+	OrganizationID uint              `gorm:"index" json:"organization_id"` // Organization whose admins see the event
+	IP             string            `json:"ip,omitempty"`
+	UserAgent      string            `json:"user_agent,omitempty"`
+	RequestID      string            `json:"request_id,omitempty"`
+	Details        map[string]string `gorm:"serializer:json" json:"details,omitempty"`
+	PrevHash       string            `json:"prev_hash"`
+	Hash           string            `gorm:"uniqueIndex;not null" json:"hash"`
+}
+
+// AuditChainHead is the single row naming the newest audit event. Appending an event locks it, so that events
+// are chained one after another, and it lets verification notice events removed from the end of the log.
+type AuditChainHead struct {
+	ID       uint   `gorm:"primaryKey"`
+	LastID   uint64 `gorm:"not null"`
+	LastHash string `gorm:"not null"`
+}
+
+// AuditFilter selects events for GET /api/admin/audit
+type AuditFilter struct {
+	Type           string
+	Outcome        string
+	ActorID        string
+	TargetType     string
+	TargetID       string
+	OrganizationID uint
+	Since          *time.Time
+	Until          *time.Time
+	Page           int
+	PageSize       int
+}
+
+// AuditPage is a page of audit events, newest first
+type AuditPage struct {
+	Events   []AuditEvent `json:"events"`
+	Total    int64        `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// AuditVerification reports the result of checking the audit chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Events   uint64 `json:"events"`
+	HeadHash string `json:"head_hash,omitempty"`
+	BrokenAt uint64 `json:"broken_at,omitempty"` // ID of the first event that does not match the chain
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Role grant sources reported by the effective roles of a user
+const (
+	RoleSourceDirect = "direct"
+	RoleSourceGroup  = "group"
+)
+
+// Group is a named set of users within an organization. Members inherit the roles of the group and of every group
+// above it: a group with a parent is nested in it, so its members are members of the parent as well.
+type Group struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"uniqueIndex:idx_groups_organization_name;not null" json:"organization_id"`
+	Name           string    `gorm:"uniqueIndex:idx_groups_organization_name;not null" json:"name"`
+	Description    string    `json:"description"`
+	ParentID       *uint     `gorm:"index" json:"parent_id,omitempty"`
+	Roles          []Role    `gorm:"many2many:group_roles" json:"roles"`
+	Members        []User    `gorm:"many2many:group_members" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// RoleNames returns the names of the roles given to the group
+func (g *Group) RoleNames() []string {
+	names := make([]string, len(g.Roles))
+	for i, r := range g.Roles {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// GroupRequest for POST /api/admin/groups and PUT /api/admin/groups/{id}
+type GroupRequest struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	ParentID       *uint    `json:"parent_id"`
+	Roles          []string `json:"roles"`
+	OrganizationID uint     `json:"organization_id,omitempty"` // Only super admins may create groups in another organization
+}
+
+// GroupMembersRequest for POST /api/admin/groups/{id}/members
+type GroupMembersRequest struct {
+	UserIDs []uint `json:"user_ids"`
+}
+
+// RoleGrant is one reason a user holds a role: a direct assignment, or a group the user is a member of
+type RoleGrant struct {
+	Source  string   `json:"source"`             // RoleSourceDirect or RoleSourceGroup
+	GroupID uint     `json:"group_id,omitempty"` // Group the role is given to
+	Group   string   `json:"group,omitempty"`
+	Path    []string `json:"path,omitempty"` // Groups from the one the user is a member of up to Group
+}
+
+// EffectiveRole is a role a user holds, with every grant it comes from
+type EffectiveRole struct {
+	Role   string      `json:"role"`
+	Grants []RoleGrant `json:"grants"`
+}
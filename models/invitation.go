@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// AdminInvitation is an invitation for a new admin, sent by an existing admin. The emailed link carries a signed
+// token naming the invitation; the row makes the invitation single-use and lets admins withdraw it.
+type AdminInvitation struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Email          string     `gorm:"index;not null" json:"email"`
+	InvitedBy      uint       `gorm:"not null" json:"invited_by"`   // ID of the admin who sent the invitation
+	OrganizationID uint       `gorm:"index" json:"organization_id"` // Organization the invitee joins
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// InvitationRequest for POST /api/admin/invitations
+type InvitationRequest struct {
+	Email          string `json:"email"`
+	OrganizationID uint   `json:"organization_id,omitempty"` // Only super admins may invite into another organization
+}
+
+// AcceptInvitationRequest for POST /invitations/accept
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SetupRequest for POST /setup, which creates the first admin with the bootstrap token
+type SetupRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
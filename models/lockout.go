@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Kinds of login lockouts
+const (
+	LockoutAccount = "account" // Keyed by the submitted username, whether or not an account has it
+	LockoutIP      = "ip"      // Keyed by the client IP address
+	LockoutMFA     = "mfa"     // Keyed by the user ID; counts wrong second factor codes
+)
+
+// LoginLockout counts the failed logins of a username, a client IP address or the second factor of a user. Once the failures reach the limit,
+// logins are refused until LockedUntil; every further failure doubles the lock. The count starts over when no
+// login has failed for the failure window.
+type LoginLockout struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	Kind          string    `gorm:"uniqueIndex:idx_lockout_kind_subject;not null" json:"kind"`
+	Subject       string    `gorm:"uniqueIndex:idx_lockout_kind_subject;not null" json:"subject"` // Username, IP address or user ID
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `gorm:"index" json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
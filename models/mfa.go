@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// MFAPending is the purpose of the challenge token /login returns when the user still has to enter a second factor.
+const MFAPending = "mfa_pending"
+
+// MFAEnrollment holds the TOTP secret of a user. MFA is enforced once ConfirmedAt is set.
+type MFAEnrollment struct {
+	ID                uint       `gorm:"primaryKey" json:"-"`
+	UserID            uint       `gorm:"uniqueIndex;not null" json:"-"`
+	Secret            string     `gorm:"not null" json:"-"`
+	ConfirmedAt       *time.Time `json:"confirmed_at,omitempty"`
+	LastUsedStep      int64      `json:"-"` // Time step of the last accepted code, rejects replays
+	ChallengeID       string     `json:"-"` // jti of the challenge token the failures below were counted for
+	ChallengeFailures int        `json:"-"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// MFAStatus is returned by GET /api/profile/mfa
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	Pending                bool       `json:"pending"` // Enrollment started but not yet confirmed
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesRemaining int64      `json:"recovery_codes_remaining"`
+}
+
+// MFAEnrollmentResponse is returned when enrollment starts
+type MFAEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// MFACode is the body of requests that carry a TOTP or recovery code
+type MFACode struct {
+	Code string `json:"code"`
+}
+
+// MFALoginRequest for /login/mfa
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// MFAChallenge is returned by /login instead of a token pair when MFA is enabled
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
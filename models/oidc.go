@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// OAuth2 grant types a registered client can be allowed to use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+// RegisteredClient is an application that may sign users in through the OpenID Connect provider, or a
+// machine identity (batch job, internal service) that obtains tokens with the client credentials grant.
+// Confidential clients authenticate with a secret, of which only the bcrypt hash is stored.
+type RegisteredClient struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	ClientID         string    `gorm:"uniqueIndex;not null" json:"client_id"`
+	ClientSecretHash string    `json:"-"`
+	Name             string    `json:"client_name"`
+	RedirectURIs     string    `json:"redirect_uris"`                                 // Space separated list
+	Scopes           string    `json:"scope"`                                         // Space separated list of scopes the client may request
+	GrantTypes       string    `gorm:"default:authorization_code" json:"grant_types"` // Space separated list
+	Public           bool      `json:"public"`                                        // Public clients have no secret and rely on PKCE alone
+	OrganizationID   uint      `gorm:"index" json:"organization_id"`                  // Tenant of the admin who registered the client; its service tokens act in it
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// AuthorizationRequest tracks an authorization code request from the login form to the token exchange.
+// The consent challenge identifies the request while the user decides on consent; the code is only
+// created once the request is approved. Both are stored hashed.
+type AuthorizationRequest struct {
+	ID                  uint    `gorm:"primaryKey"`
+	ChallengeHash       string  `gorm:"uniqueIndex;not null"`
+	CodeHash            *string `gorm:"uniqueIndex"`
+	ClientID            string  `gorm:"index;not null"`
+	UserID              uint    `gorm:"not null"`
+	RedirectURI         string  `gorm:"not null"`
+	Scope               string  `gorm:"not null"`
+	State               string
+	Nonce               string
+	CodeChallenge       string `gorm:"not null"`
+	CodeChallengeMethod string `gorm:"not null"`
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+	CreatedAt           time.Time
+}
+
+// Consent records the scopes a user has granted to a client, so the consent screen is shown only once.
+type Consent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_consent_user_client;not null" json:"user_id"`
+	ClientID  string    `gorm:"uniqueIndex:idx_consent_user_client;not null" json:"client_id"`
+	Scope     string    `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ClientRegistration is the body of a client registration request
+type ClientRegistration struct {
+	Name         string   `json:"client_name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scope        string   `json:"scope"`
+	GrantTypes   []string `json:"grant_types"` // Defaults to authorization_code
+	Public       bool     `json:"public"`
+}
+
+// ClientRegistrationResponse is returned once on registration; the secret cannot be retrieved again
+type ClientRegistrationResponse struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Name         string   `json:"client_name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scope        string   `json:"scope"`
+	GrantTypes   []string `json:"grant_types"`
+	Public       bool     `json:"public"`
+}
+
+// ClientIdentity is the OAuth2 client a request is made by. It is stored in the request context next to the user.
+type ClientIdentity struct {
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+// HasScope reports whether the client was granted the scope
+func (c *ClientIdentity) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthTokenResponse is returned by the /oauth/token endpoint
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// IDTokenClaims stores the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	jwt.StandardClaims
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// DefaultOrganizationSlug names the organization created at startup. Users that existed before organizations, the
+// first admin and self-registered users belong to it.
+const DefaultOrganizationSlug = "default"
+
+// Organization is a customer company, the tenant users belong to. Admins only see and manage the users of their
+// own organization; super admins work across all of them.
+type Organization struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	Slug      string    `gorm:"uniqueIndex;not null" json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrganizationRequest for POST /api/admin/organizations
+type OrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"api-service/webauthn"
+	"time"
+)
+
+// Kinds of WebAuthnSession
+const (
+	PasskeyRegistration = "registration"
+	PasskeyLogin        = "login"
+)
+
+// WebAuthnCredential is a passkey registered by a user. Only the public key is stored; the private key never leaves
+// the authenticator.
+type WebAuthnCredential struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"index;not null" json:"-"`
+	CredentialID   string     `gorm:"uniqueIndex;not null" json:"credential_id"` // base64url
+	PublicKey      []byte     `gorm:"not null" json:"-"`                         // COSE encoded
+	Algorithm      int        `json:"-"`
+	SignCount      uint32     `json:"sign_count"`
+	Transports     string     `json:"transports"` // Space separated, e.g. "internal hybrid"
+	AAGUID         string     `json:"aaguid"`     // Hex encoded authenticator model
+	Name           string     `json:"name"`
+	BackupEligible bool       `json:"backup_eligible"` // Synced passkey
+	BackupState    bool       `json:"backup_state"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// WebAuthnSession holds the challenge of a registration or login ceremony between its begin and finish requests.
+// Only the hash of the session ID handed to the client is stored, and a session can be finished once.
+type WebAuthnSession struct {
+	ID          uint   `gorm:"primaryKey"`
+	SessionHash string `gorm:"uniqueIndex;not null"`
+	Kind        string `gorm:"not null"`
+	UserID      uint   // Zero for a login without username (discoverable passkey)
+	Challenge   string `gorm:"not null"`
+	ExpiresAt   time.Time
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
+
+// PasskeyRegistrationOptions is returned by /api/profile/passkeys/register/begin. PublicKey is passed to
+// navigator.credentials.create().
+type PasskeyRegistrationOptions struct {
+	SessionID string                   `json:"session_id"`
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+// PasskeyRegistrationRequest for /api/profile/passkeys/register/finish
+type PasskeyRegistrationRequest struct {
+	SessionID  string                       `json:"session_id"`
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+// PasskeyLoginBegin for /login/passkey/begin. Without a username any discoverable passkey can be used.
+type PasskeyLoginBegin struct {
+	Username string `json:"username"`
+}
+
+// PasskeyLoginOptions is returned by /login/passkey/begin. PublicKey is passed to navigator.credentials.get().
+type PasskeyLoginOptions struct {
+	SessionID string                  `json:"session_id"`
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+// PasskeyLoginRequest for /login/passkey/finish
+type PasskeyLoginRequest struct {
+	SessionID  string                     `json:"session_id"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
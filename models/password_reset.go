@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// PasswordResetToken is the server-side record of a password reset token sent by email. Only the SHA-256 hash of
+// the token is stored, and a token can be used once.
+type PasswordResetToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time // Set when the password is reset, or when a newer token is requested
+	CreatedAt time.Time
+}
+
+// ForgotPasswordRequest for /password/forgot
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest for /password/reset
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Policy effects
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Policy is a declarative authorization rule. It applies to a request when the action is one of Actions (or
+// Actions contains "*") and every condition holds. Policies are loaded from POLICY_FILE or, when that is not set,
+// from the policies table.
+type Policy struct {
+	ID          uint              `gorm:"primaryKey" json:"-"`
+	Name        string            `gorm:"uniqueIndex;not null" json:"name"`
+	Description string            `json:"description,omitempty"`
+	Effect      string            `gorm:"not null" json:"effect"` // "allow" or "deny"
+	Priority    int               `json:"priority"`               // Lower values are evaluated first
+	Actions     []string          `gorm:"serializer:json" json:"actions"`
+	Conditions  []PolicyCondition `gorm:"serializer:json" json:"conditions,omitempty"`
+	Redact      []string          `gorm:"serializer:json" json:"redact,omitempty"` // Fields of the resource the subject must not see
+	CreatedAt   time.Time         `json:"-"`
+	UpdatedAt   time.Time         `json:"-"`
+}
+
+// PolicyCondition compares an attribute such as "subject.region" with a literal Value or with another attribute
+// named by Ref, e.g. {"attribute": "resource.region", "operator": "equals", "ref": "subject.region"}.
+// Operators are "equals", "not_equals", "in", "contains" and "present".
+type PolicyCondition struct {
+	Attribute string      `json:"attribute"`
+	Operator  string      `json:"operator"`
+	Value     interface{} `json:"value,omitempty"`
+	Ref       string      `json:"ref,omitempty"`
+}
+
+// PolicyDecision is the result of evaluating the policies for one request
+type PolicyDecision struct {
+	Allowed bool     `json:"allowed"`
+	Policy  string   `json:"policy,omitempty"` // The policy that decided; empty when no policy applied
+	Redact  []string `json:"redact,omitempty"` // Obligation: fields to remove from the resource before returning it
+}
+
+// PolicyEvaluationRequest for POST /api/admin/policies/evaluate. Subject and resource attributes are given
+// directly or taken from the users with the given IDs; without either, the caller is the subject.
+type PolicyEvaluationRequest struct {
+	Action         string                 `json:"action"`
+	Subject        map[string]interface{} `json:"subject,omitempty"`
+	SubjectUserID  uint                   `json:"subject_user_id,omitempty"`
+	Resource       map[string]interface{} `json:"resource,omitempty"`
+	ResourceUserID uint                   `json:"resource_user_id,omitempty"`
+}
+
+// PolicyEvaluationResponse is the decision of a dry run together with the attributes it was based on
+type PolicyEvaluationResponse struct {
+	PolicyDecision
+	Subject  map[string]interface{} `json:"subject"`
+	Resource map[string]interface{} `json:"resource"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// Permissions checked by the routes in main.go. They are seeded into the permissions table at startup.
+const (
+	PermUsersRead           = "users:read"
+	PermUsersCreate         = "users:create"
+	PermUsersDelete         = "users:delete"
+	PermUsersUnlock         = "users:unlock"
+	PermTokensRevoke        = "tokens:revoke"
+	PermMFAReset            = "mfa:reset"
+	PermClientsRead         = "clients:read"
+	PermClientsManage       = "clients:manage"
+	PermInvitationsManage   = "invitations:manage"
+	PermRolesRead           = "roles:read"
+	PermRolesManage         = "roles:manage"
+	PermPoliciesRead        = "policies:read"
+	PermPoliciesManage      = "policies:manage"
+	PermGroupsRead          = "groups:read"
+	PermGroupsManage        = "groups:manage"
+	PermOrganizationsManage = "organizations:manage"
+	PermAuditRead           = "audit:read"
+)
+
+// Roles seeded at startup. RoleAdmin holds every permission within its organization and cannot be changed;
+// RoleSuperAdmin additionally manages organizations and works across all of them; RoleUser is given to every
+// registered account.
+const (
+	RoleAdmin      = "admin"
+	RoleSuperAdmin = "super_admin"
+	RoleUser       = "user"
+)
+
+// Permission is a single action on the API, named "<resource>:<action>".
+type Permission struct {
+	ID          uint   `gorm:"primaryKey" json:"-"`
+	Name        string `gorm:"uniqueIndex;not null" json:"name"`
+	Description string `json:"description"`
+}
+
+// Role is a named set of permissions. Users hold roles through the user_roles table.
+type Role struct {
+	ID          uint         `gorm:"primaryKey" json:"-"`
+	Name        string       `gorm:"uniqueIndex;not null" json:"name"`
+	Description string       `json:"description"`
+	Builtin     bool         `json:"builtin"` // Seeded roles cannot be deleted
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// PermissionNames returns the names of the role's permissions
+func (r *Role) PermissionNames() []string {
+	names := make([]string, len(r.Permissions))
+	for i, p := range r.Permissions {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// RoleRequest for POST /api/admin/roles and PUT /api/admin/roles/{name}
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserRolesRequest for PUT /api/admin/users/{id}/roles
+type UserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// RefreshToken is the server-side record of an opaque refresh token. Only the SHA-256 hash of the
+// token is stored. Every token issued from the same login shares a FamilyID so that the whole chain
+// can be revoked when reuse of an already rotated token is detected.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	FamilyID  string     `gorm:"index;not null" json:"family_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`    // Set when the token is rotated
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // Set when the family is revoked
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RefreshRequest for /token/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenPair is returned by /login and /token/refresh
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Lifetime of the access token in seconds
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Kinds of token revocation
+const (
+	RevocationToken  = "token"  // A single token, identified by its jti
+	RevocationUser   = "user"   // Every token of a subject issued before RevokedBefore
+	RevocationGlobal = "global" // Every token issued before RevokedBefore
+)
+
+// TokenRevocation is a denylist entry checked by JWTMiddleware. Entries are pruned once ExpiresAt has
+// passed, at which point every token they cover has expired on its own.
+type TokenRevocation struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Kind          string    `gorm:"uniqueIndex:idx_revocation_kind_subject;not null" json:"kind"`
+	Subject       string    `gorm:"uniqueIndex:idx_revocation_kind_subject;not null" json:"subject"` // jti, user ID or "*"
+	RevokedBefore time.Time `json:"revoked_before"`                                                  // Issued-at cutoff for user and global revocations
+	ExpiresAt     time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -1,19 +1,38 @@
 package models
 
 import (
+	"time"
+
 	"github.com/golang-jwt/jwt"
 )
 
 type User struct {
-	ID       uint   `gorm:"primaryKey" json:"id"`
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email" gorm:"unique"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"` // Nil until the user opens the verification link
+	PendingEmail    string     `json:"pending_email,omitempty"`     // New address awaiting confirmation, Email stays active until then
+	Username        string     `gorm:"unique" json:"username"`
+	Password        string     `json:"-"` // bcrypt hash, never serialized
+	Mobile          string     `json:"mobile"`
+	Address         string     `json:"address"`
+	Region          string     `json:"region,omitempty"`             // Region the user belongs to, used by authorization policies
+	OrganizationID  uint       `gorm:"index" json:"organization_id"` // Tenant the user belongs to
+	Role            string     `json:"role"`                         // Role the account was created with; the roles it holds are in Roles
+	Roles           []Role     `gorm:"many2many:user_roles" json:"roles,omitempty"`
+	EffectiveRoles  []string   `gorm:"-" json:"effective_roles,omitempty"` // Roles held directly or through groups, when computed
+	Token           string     `json:"-"`                                  // Optional, stores JWT token for revocation; never serialized
+}
+
+// RegistrationRequest is the body of /register. Everything else about the new account, such as its role and
+// organization, is decided by the service.
+type RegistrationRequest struct {
 	Name     string `json:"name"`
-	Email    string `json:"email" gorm:"unique"`
-	Username string `gorm:"unique" json:"username"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
 	Password string `json:"password"`
 	Mobile   string `json:"mobile"`
 	Address  string `json:"address"`
-	Role     string `json:"role"`            // Admin or User
-	Token    string `json:"token,omitempty"` // Optional, stores JWT token for revocation
 }
 
 // LoginCredentials for login
@@ -24,8 +43,67 @@ type LoginCredentials struct {
 
 // JWTClaims stores the claims for JWT
 type JWTClaims struct {
-	Email    string `json:"email"`
-	Role     string `json:"role"`
-	Username string `json:"username"`
+	Email    string   `json:"email"`
+	Role     string   `json:"role"`
+	Roles    []string `json:"roles,omitempty"` // Every role the user holds, directly or through groups
+	Username string   `json:"username"`
+	Region   string   `json:"region,omitempty"`
+	TenantID uint     `json:"tid,omitempty"`       // Organization of the user or client the token was issued to
+	ClientID string   `json:"client_id,omitempty"` // Set on tokens issued to an OAuth2 client
+	Scope    string   `json:"scope,omitempty"`     // Space separated scopes granted to the OAuth2 client
+	Purpose  string   `json:"purpose,omitempty"`   // Set on single-purpose tokens such as "mfa_pending"; these are not access tokens
 	jwt.StandardClaims
 }
+
+// IsClientToken reports whether the token was issued to an OAuth2 client acting on its own behalf
+// (client credentials grant) rather than to a user. Such tokens use the client ID as subject.
+func (c *JWTClaims) IsClientToken() bool {
+	return c.ClientID != "" && c.Subject == c.ClientID
+}
+
+// IsDelegatedToken reports whether the token was issued to an OAuth2 client on behalf of a user (authorization code
+// grant). Such tokens carry no roles and are only accepted where their scopes apply, such as /userinfo.
+func (c *JWTClaims) IsDelegatedToken() bool {
+	return c.ClientID != "" && c.Subject != c.ClientID
+}
+
+// RoleNames returns the names of the roles the user holds
+func (u *User) RoleNames() []string {
+	names := make([]string, len(u.Roles))
+	for i, r := range u.Roles {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// EffectiveRoleNames returns the roles the user holds directly or through groups. Until they are computed, only
+// the roles held directly are returned.
+func (u *User) EffectiveRoleNames() []string {
+	if u.EffectiveRoles != nil {
+		return u.EffectiveRoles
+	}
+	return u.RoleNames()
+}
+
+// RoleNames returns the roles carried by the token. Tokens issued before roles were added only carry Role.
+func (c *JWTClaims) RoleNames() []string {
+	if len(c.Roles) == 0 && c.Role != "" {
+		return []string{c.Role}
+	}
+	return c.Roles
+}
+
+// EmailVerified reports whether the user confirmed their current email address
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// EmailRequest is the body of requests that carry a single email address
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyEmailRequest for POST /email/verify
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"api-service/config"
+	"api-service/oidcclient"
+	"api-service/utils"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testRedirectURI = "https://rp.example.com/callback"
+
+// registerClient - Register a confidential authorization code client and return its ID and secret
+func (ts *testServer) registerClient(adminToken, scope string) (string, string) {
+	ts.t.Helper()
+	var client struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	ts.expect(http.StatusCreated, "POST", "/api/admin/clients", adminToken, map[string]interface{}{
+		"client_name": "Relying party", "redirect_uris": []string{testRedirectURI}, "scope": scope,
+	}, &client)
+	return client.ClientID, client.ClientSecret
+}
+
+var consentChallenge = regexp.MustCompile(`name="consent_challenge" value="([^"]+)"`)
+
+// authorize - Sign in at the authorization endpoint as the user would in a browser, approve the consent screen and
+// return the parameters the user is redirected back to the client with
+func (ts *testServer) authorize(authURL, username, password string) url.Values {
+	ts.t.Helper()
+	browser := ts.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := browser.Get(authURL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		ts.t.Fatalf("login page: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	parsed, _ := url.Parse(authURL)
+	form := parsed.Query()
+	form.Set("username", username)
+	form.Set("password", password)
+	resp, err = browser.PostForm(ts.URL+"/oauth/authorize", form)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	page := readBody(ts.t, resp)
+	match := consentChallenge.FindStringSubmatch(page)
+	if resp.StatusCode != http.StatusOK || match == nil {
+		ts.t.Fatalf("login: status %d, no consent screen: %s", resp.StatusCode, page)
+	}
+
+	resp, err = browser.PostForm(ts.URL+"/oauth/consent", url.Values{"consent_challenge": {match[1]}, "decision": {"approve"}})
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if resp.StatusCode != http.StatusFound || err != nil || !strings.HasPrefix(location.String(), testRedirectURI) {
+		ts.t.Fatalf("consent: status %d, redirect %v", resp.StatusCode, location)
+	}
+	return location.Query()
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.setupAdmin()
+	ts.register("alice", "Alice-Passw0rd!")
+	clientID, secret := ts.registerClient(admin, "openid profile email")
+
+	ctx := context.Background()
+	rp := &oidcclient.Client{
+		Issuer:       ts.URL,
+		ClientID:     clientID,
+		ClientSecret: secret,
+		RedirectURI:  testRedirectURI,
+		Scopes:       []string{"openid", "profile", "email"},
+		HTTPClient:   ts.Client(),
+	}
+	if err := rp.Discover(ctx); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	auth, err := rp.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := ts.authorize(auth.URL, "alice", "Alice-Passw0rd!")
+	if callback.Get("state") != auth.State {
+		t.Fatalf("state %q, want %q", callback.Get("state"), auth.State)
+	}
+	code := callback.Get("code")
+
+	if _, err := rp.Exchange(ctx, code, "wrong-verifier"); err == nil {
+		t.Fatal("exchange with a wrong code_verifier succeeded")
+	}
+	// The failed exchange used the code up, so the user signs in again (without the consent screen this time)
+	auth, _ = rp.AuthCodeURL()
+	code = ts.authorizeConsented(auth.URL, "alice", "Alice-Passw0rd!")
+
+	tokens, err := rp.Exchange(ctx, code, auth.CodeVerifier)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if _, err := rp.Exchange(ctx, code, auth.CodeVerifier); err == nil {
+		t.Fatal("an authorization code was redeemed twice")
+	}
+
+	idToken, err := rp.VerifyIDToken(ctx, tokens.IDToken, auth.Nonce)
+	if err != nil {
+		t.Fatalf("verify ID token: %v", err)
+	}
+	if idToken.PreferredUsername != "alice" || idToken.Email != "alice@example.com" {
+		t.Errorf("ID token claims = %+v", idToken)
+	}
+
+	info, err := rp.UserInfo(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("userinfo: %v", err)
+	}
+	if info["sub"] != idToken.Subject || info["preferred_username"] != "alice" || info["email"] != "alice@example.com" {
+		t.Errorf("userinfo = %v", info)
+	}
+	if _, err := rp.UserInfo(ctx, tokens.IDToken); err == nil {
+		t.Error("userinfo accepted an ID token")
+	}
+
+	// Neither token the client got is an API access token of the user
+	for name, token := range map[string]string{"ID token": tokens.IDToken, "access token": tokens.AccessToken} {
+		if resp := ts.request("GET", "/api/profile", token, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: GET /api/profile status %d, want 401", name, resp.StatusCode)
+		}
+	}
+}
+
+// A delegated access token carries none of the user's roles, so a client never gets the admin rights of an admin
+// who signed in to it
+func TestOIDCAccessTokenCarriesNoRoles(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.setupAdmin()
+	clientID, secret := ts.registerClient(admin, "openid profile")
+
+	ctx := context.Background()
+	rp := &oidcclient.Client{Issuer: ts.URL, ClientID: clientID, ClientSecret: secret, RedirectURI: testRedirectURI,
+		Scopes: []string{"openid", "profile"}, HTTPClient: ts.Client()}
+	if err := rp.Discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := rp.AuthCodeURL()
+	tokens, err := rp.Exchange(ctx, ts.authorize(auth.URL, "root", "Root-Passw0rd!").Get("code"), auth.CodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := ts.request("GET", "/api/admin/users", tokens.AccessToken, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /api/admin/users with a delegated token: status %d, want 401", resp.StatusCode)
+	}
+	if _, err := rp.UserInfo(ctx, tokens.AccessToken); err != nil {
+		t.Errorf("userinfo: %v", err)
+	}
+}
+
+// authorizeConsented - Sign in for a client the user already consented to and return the authorization code
+func (ts *testServer) authorizeConsented(authURL, username, password string) string {
+	ts.t.Helper()
+	browser := ts.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	parsed, _ := url.Parse(authURL)
+	form := parsed.Query()
+	form.Set("username", username)
+	form.Set("password", password)
+	resp, err := browser.PostForm(ts.URL+"/oauth/authorize", form)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if resp.StatusCode != http.StatusFound || err != nil {
+		ts.t.Fatalf("authorize: status %d, redirect %v", resp.StatusCode, location)
+	}
+	return location.Query().Get("code")
+}
+
+func TestMachineClientScopesAreLimitedToTheCaller(t *testing.T) {
+	ts := newTestServer(t)
+	root := ts.setupAdmin()
+
+	// A client manager who may only read users besides managing clients
+	ts.expect(http.StatusCreated, "POST", "/api/admin/roles", root, map[string]interface{}{
+		"name": "client_manager", "permissions": []string{"clients:manage", "users:read"},
+	}, nil)
+	id := ts.register("manager", "Manager-Passw0rd!")
+	ts.expect(http.StatusOK, "PUT", fmt.Sprintf("/api/admin/users/%d/roles", id), root, map[string]interface{}{
+		"roles": []string{"user", "client_manager"},
+	}, nil)
+	manager := ts.login("manager", "Manager-Passw0rd!")
+
+	register := func(token, scope string) *http.Response {
+		return ts.request("POST", "/api/admin/clients", token, map[string]interface{}{
+			"client_name": "Worker", "grant_types": []string{"client_credentials"}, "scope": scope,
+		})
+	}
+	for _, scope := range []string{"admin", "super_admin", "users:read users:delete", "root", "users:read bogus"} {
+		if resp := register(manager, scope); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("manager registering scope %q: status %d, want 400", scope, resp.StatusCode)
+		}
+	}
+	for _, scope := range []string{"users:read", "user users:read"} {
+		if resp := register(manager, scope); resp.StatusCode != http.StatusCreated {
+			t.Errorf("manager registering scope %q: status %d, want 201: %s", scope, resp.StatusCode, readBody(t, resp))
+		}
+	}
+	// The super admin holds every permission
+	for _, scope := range []string{"admin", "super_admin"} {
+		if resp := register(root, scope); resp.StatusCode != http.StatusCreated {
+			t.Errorf("super admin registering scope %q: status %d, want 201: %s", scope, resp.StatusCode, readBody(t, resp))
+		}
+	}
+}
+
+func TestKeysLoadedFromFilesSetTheAdvertisedAlgorithm(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// jwt.signing_alg keeps its RS256 default, which only applies to generated keys
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.JWT.KeyFiles = []string{path} })
+
+	var discovery struct {
+		Algorithms []string `json:"id_token_signing_alg_values_supported"`
+	}
+	ts.expect(http.StatusOK, "GET", "/.well-known/openid-configuration", "", nil, &discovery)
+	if len(discovery.Algorithms) != 1 || discovery.Algorithms[0] != utils.AlgEdDSA {
+		t.Fatalf("advertised %v, want the algorithm of the key file", discovery.Algorithms)
+	}
+	var jwks utils.JWKSet
+	ts.expect(http.StatusOK, "GET", "/.well-known/jwks.json", "", nil, &jwks)
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != utils.AlgEdDSA || jwks.Keys[0].Kty != "OKP" {
+		t.Fatalf("published %+v", jwks.Keys)
+	}
+
+	token := ts.setupAdmin()
+	header, _, _ := strings.Cut(token, ".")
+	data, _ := base64.RawURLEncoding.DecodeString(header)
+	if !strings.Contains(string(data), `"alg":"EdDSA"`) || !strings.Contains(string(data), jwks.Keys[0].Kid) {
+		t.Fatalf("token header %s, want EdDSA and the published key", data)
+	}
+	ts.expect(http.StatusOK, "GET", "/api/profile/mfa", token, nil, nil)
+}
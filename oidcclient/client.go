@@ -0,0 +1,265 @@
+package oidcclient
+
+/**
+The oidcclient package is a minimal OpenID Connect relying party for this service. Internal applications can use it to sign users in, and because it only needs an HTTP client it can also drive the whole authorization code flow in-process against an httptest server.
+*/
+import (
+	"api-service/models"
+	"api-service/tracing"
+	"api-service/utils"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ProviderConfig is the part of the discovery document the client needs.
+type ProviderConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// AuthRequest holds the values the application must remember between redirecting the user and handling the callback.
+type AuthRequest struct {
+	URL          string
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+type Client struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients
+	RedirectURI  string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	provider *ProviderConfig
+}
+
+// Discover fetches the discovery document of the issuer.
+func (c *Client) Discover(ctx context.Context) error {
+	var provider ProviderConfig
+	if err := c.getJSON(ctx, c.Issuer+"/.well-known/openid-configuration", "", &provider); err != nil {
+		return err
+	}
+	if provider.Issuer != c.Issuer {
+		return fmt.Errorf("issuer mismatch: got %q, want %q", provider.Issuer, c.Issuer)
+	}
+	c.provider = &provider
+	return nil
+}
+
+// AuthCodeURL builds the authorization URL together with a fresh state, nonce and PKCE code verifier.
+func (c *Client) AuthCodeURL() (AuthRequest, error) {
+	if c.provider == nil {
+		return AuthRequest{}, errors.New("provider not discovered")
+	}
+	state, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return AuthRequest{}, err
+	}
+	nonce, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return AuthRequest{}, err
+	}
+	verifier, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return AuthRequest{}, err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {c.RedirectURI},
+		"scope":                 {strings.Join(c.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	return AuthRequest{
+		URL:          c.provider.AuthorizationEndpoint + "?" + q.Encode(),
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, nil
+}
+
+// Exchange redeems an authorization code at the token endpoint.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier string) (*models.OAuthTokenResponse, error) {
+	if c.provider == nil {
+		return nil, errors.New("provider not discovered")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tracing.Inject(ctx, req.Header)
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var oerr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.NewDecoder(resp.Body).Decode(&oerr)
+		return nil, fmt.Errorf("token endpoint: %s: %s", oerr.Error, oerr.Description)
+	}
+
+	var tokens models.OAuthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken checks the signature of an ID token against the provider's JWKS as well as its issuer, audience and nonce.
+func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*models.IDTokenClaims, error) {
+	if c.provider == nil {
+		return nil, errors.New("provider not discovered")
+	}
+	var set utils.JWKSet
+	if err := c.getJSON(ctx, c.provider.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+
+	claims := &models.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range set.Keys {
+			if key.Kid == kid {
+				if key.Alg != token.Method.Alg() {
+					return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+				}
+				return publicKey(key)
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != c.provider.Issuer {
+		return nil, errors.New("ID token issuer mismatch")
+	}
+	if !claims.VerifyAudience(c.ClientID, true) {
+		return nil, errors.New("ID token audience mismatch")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// UserInfo calls the userinfo endpoint with an access token.
+func (c *Client) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	if c.provider == nil {
+		return nil, errors.New("provider not discovered")
+	}
+	var info map[string]interface{}
+	if err := c.getJSON(ctx, c.provider.UserinfoEndpoint, accessToken, &info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint, bearer string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	tracing.Inject(ctx, req.Header)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// publicKey converts a JWK into the public key type expected by the jwt package.
+func publicKey(key utils.JWK) (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch key.Kty {
+	case "RSA":
+		n, err := decode(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decode(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"api-service/webauthn"
+	"net/http"
+	"testing"
+)
+
+// registerPasskey - Register a passkey of the authenticator for the user of the token
+func (ts *testServer) registerPasskey(token string, authenticator *webauthn.SoftAuthenticator) {
+	ts.t.Helper()
+	var begin struct {
+		SessionID string                   `json:"session_id"`
+		PublicKey webauthn.CreationOptions `json:"publicKey"`
+	}
+	ts.expect(http.StatusOK, "POST", "/api/profile/passkeys/register/begin", token, nil, &begin)
+	credential, err := authenticator.Create(begin.PublicKey)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.expect(http.StatusCreated, "POST", "/api/profile/passkeys/register/finish", token, map[string]interface{}{
+		"session_id": begin.SessionID, "name": "Laptop", "credential": credential,
+	}, nil)
+}
+
+// passkeyLogin - Run a passkey login ceremony with the authenticator and return the status of the finish request
+func (ts *testServer) passkeyLogin(username string, authenticator *webauthn.SoftAuthenticator) int {
+	ts.t.Helper()
+	var begin struct {
+		SessionID string                  `json:"session_id"`
+		PublicKey webauthn.RequestOptions `json:"publicKey"`
+	}
+	ts.expect(http.StatusOK, "POST", "/login/passkey/begin", "", map[string]string{"username": username}, &begin)
+	assertion, err := authenticator.Get(begin.PublicKey)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	resp := ts.request("POST", "/login/passkey/finish", "", map[string]interface{}{
+		"session_id": begin.SessionID, "credential": assertion,
+	})
+	return resp.StatusCode
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.setupAdmin()
+	ts.register("alice", "Alice-Passw0rd!")
+	token := ts.login("alice", "Alice-Passw0rd!")
+	authenticator := webauthn.NewSoftAuthenticator(ts.cfg.WebAuthn.Origins[0])
+	ts.registerPasskey(token, authenticator)
+
+	for i := 1; i <= 2; i++ {
+		if status := ts.passkeyLogin("alice", authenticator); status != http.StatusOK {
+			t.Fatalf("passkey login %d: status %d, want 200", i, status)
+		}
+	}
+	var passkeys []struct {
+		SignCount uint32 `json:"sign_count"`
+	}
+	ts.expect(http.StatusOK, "GET", "/api/profile/passkeys", token, nil, &passkeys)
+	if len(passkeys) != 1 || passkeys[0].SignCount != 2 {
+		t.Fatalf("passkeys %+v, want one with sign count 2", passkeys)
+	}
+
+	// The same passkey answering for another origin, as on a phishing site, does not log in
+	phishing := *authenticator
+	phishing.Origin = "https://evil.example.net"
+	if status := ts.passkeyLogin("alice", &phishing); status != http.StatusUnauthorized {
+		t.Fatalf("passkey login from another origin: status %d, want 401", status)
+	}
+}
@@ -0,0 +1,242 @@
+package policy
+
+/**
+The policy package evaluates declarative attribute-based policies (models.Policy). A request is described by an action, such as "users:read", and two attribute sets: the subject making the request, built from the claims of its token, and the resource it acts on, built from a models.User. Deny policies override allow policies; among the applicable allow policies the one with the lowest priority decides and brings its obligations, the fields to redact from the resource. Without an applicable policy the request is denied.
+*/
+import (
+	"api-service/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attributes of a subject or resource, keyed by name ("id", "roles", "region", ...). Values are strings, numbers
+// or string lists.
+type Attributes map[string]interface{}
+
+// Operators a condition can use
+const (
+	OpEquals    = "equals"
+	OpNotEquals = "not_equals"
+	OpIn        = "in"
+	OpContains  = "contains"
+	OpPresent   = "present"
+)
+
+// Fields of a user that policies can redact, by their JSON name
+var RedactableFields = []string{"name", "email", "pending_email", "mobile", "address", "region"}
+
+// Evaluate returns the decision of the policies for an action of the subject on the resource
+func Evaluate(policies []models.Policy, action string, subject, resource Attributes) models.PolicyDecision {
+	ordered := append([]models.Policy(nil), policies...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority < ordered[j].Priority })
+
+	var allow *models.Policy
+	for i := range ordered {
+		p := &ordered[i]
+		if !applies(p, action, subject, resource) {
+			continue
+		}
+		if p.Effect == models.PolicyDeny {
+			return models.PolicyDecision{Allowed: false, Policy: p.Name}
+		}
+		if allow == nil {
+			allow = p
+		}
+	}
+	if allow == nil {
+		return models.PolicyDecision{Allowed: false}
+	}
+	return models.PolicyDecision{Allowed: true, Policy: allow.Name, Redact: append([]string(nil), allow.Redact...)}
+}
+
+// Validate checks that a policy is well formed
+func Validate(p models.Policy) error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("policy name is required")
+	}
+	if p.Effect != models.PolicyAllow && p.Effect != models.PolicyDeny {
+		return fmt.Errorf("policy %s: effect must be %q or %q", p.Name, models.PolicyAllow, models.PolicyDeny)
+	}
+	if len(p.Actions) == 0 {
+		return fmt.Errorf("policy %s: at least one action is required", p.Name)
+	}
+	for _, c := range p.Conditions {
+		if !strings.HasPrefix(c.Attribute, "subject.") && !strings.HasPrefix(c.Attribute, "resource.") && c.Attribute != "action" {
+			return fmt.Errorf("policy %s: attribute %q must start with subject. or resource.", p.Name, c.Attribute)
+		}
+		switch c.Operator {
+		case OpEquals, OpNotEquals, OpIn, OpContains:
+			if c.Value == nil && c.Ref == "" {
+				return fmt.Errorf("policy %s: condition on %s needs a value or a ref", p.Name, c.Attribute)
+			}
+		case OpPresent:
+		default:
+			return fmt.Errorf("policy %s: unknown operator %q", p.Name, c.Operator)
+		}
+	}
+	for _, field := range p.Redact {
+		if !contains(RedactableFields, field) {
+			return fmt.Errorf("policy %s: field %q cannot be redacted", p.Name, field)
+		}
+	}
+	return nil
+}
+
+// SubjectAttributes builds the subject of a request from the claims of its token and the permissions of its roles
+func SubjectAttributes(claims *models.JWTClaims, permissions []string) Attributes {
+	attrs := Attributes{
+		"permissions":     permissions,
+		"organization_id": strconv.FormatUint(uint64(claims.TenantID), 10),
+	}
+	if claims.IsClientToken() {
+		attrs["client_id"] = claims.ClientID
+		attrs["scopes"] = strings.Fields(claims.Scope)
+		return attrs
+	}
+	attrs["id"] = claims.Subject
+	attrs["username"] = claims.Username
+	attrs["email"] = claims.Email
+	attrs["roles"] = claims.RoleNames()
+	attrs["region"] = claims.Region
+	if claims.ClientID != "" {
+		attrs["client_id"] = claims.ClientID
+		attrs["scopes"] = strings.Fields(claims.Scope)
+	}
+	return attrs
+}
+
+// UserAttributes builds the attributes of a user acting as the resource of a request
+func UserAttributes(user models.User) Attributes {
+	return Attributes{
+		"id":              strconv.FormatUint(uint64(user.ID), 10),
+		"username":        user.Username,
+		"email":           user.Email,
+		"roles":           user.EffectiveRoleNames(),
+		"region":          user.Region,
+		"organization_id": strconv.FormatUint(uint64(user.OrganizationID), 10),
+	}
+}
+
+// Redact removes the fields named by a decision's obligations from a user
+func Redact(user *models.User, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "name":
+			user.Name = ""
+		case "email":
+			user.Email = ""
+		case "pending_email":
+			user.PendingEmail = ""
+		case "mobile":
+			user.Mobile = ""
+		case "address":
+			user.Address = ""
+		case "region":
+			user.Region = ""
+		}
+	}
+}
+
+func applies(p *models.Policy, action string, subject, resource Attributes) bool {
+	if !contains(p.Actions, action) && !contains(p.Actions, "*") {
+		return false
+	}
+	for _, c := range p.Conditions {
+		if !holds(c, action, subject, resource) {
+			return false
+		}
+	}
+	return true
+}
+
+// holds evaluates one condition. A comparison with a missing or empty attribute is false, so a policy never
+// applies because two attributes are both unset.
+func holds(c models.PolicyCondition, action string, subject, resource Attributes) bool {
+	left := values(lookup(c.Attribute, action, subject, resource))
+	if c.Operator == OpPresent {
+		return len(left) > 0
+	}
+	var right []string
+	if c.Ref != "" {
+		right = values(lookup(c.Ref, action, subject, resource))
+	} else {
+		right = values(c.Value)
+	}
+	if len(left) == 0 || len(right) == 0 {
+		return false
+	}
+
+	switch c.Operator {
+	case OpEquals:
+		return equal(left, right)
+	case OpNotEquals:
+		return !equal(left, right)
+	case OpIn:
+		return containsAll(right, left)
+	case OpContains:
+		return containsAll(left, right)
+	}
+	return false
+}
+
+func lookup(name, action string, subject, resource Attributes) interface{} {
+	if name == "action" {
+		return action
+	}
+	if key, ok := strings.CutPrefix(name, "subject."); ok {
+		return subject[key]
+	}
+	if key, ok := strings.CutPrefix(name, "resource."); ok {
+		return resource[key]
+	}
+	return nil
+}
+
+// values normalizes an attribute to a list of strings. Empty strings count as missing.
+func values(v interface{}) []string {
+	var out []string
+	add := func(x interface{}) {
+		if s := fmt.Sprint(x); x != nil && s != "" {
+			out = append(out, s)
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+	case []string:
+		for _, x := range v {
+			add(x)
+		}
+	case []interface{}:
+		for _, x := range v {
+			add(x)
+		}
+	default:
+		add(v)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	return len(a) == len(b) && containsAll(a, b)
+}
+
+func containsAll(list, wanted []string) bool {
+	for _, w := range wanted {
+		if !contains(list, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package redisclient
+
+/**
+The redisclient package is a minimal client for servers speaking the Redis protocol (RESP). It only sends commands and reads their replies, which is all the shared rate limit store needs, so it works against Redis, Valkey, KeyDB or the in-process stand-in of the redistest package.
+*/
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error is an error reply of the server, such as "NOSCRIPT No matching script".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client sends commands to one server over a small pool of connections. It is safe for concurrent use.
+type Client struct {
+	Addr     string        // host:port of the server
+	Password string        // Sent with AUTH on new connections when set
+	DB       int           // Selected with SELECT on new connections when not 0
+	Timeout  time.Duration // Dial, read and write timeout of a command; 5 seconds when 0
+	MaxIdle  int           // Idle connections kept open; 4 when 0
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Do sends a command and returns its reply: a string for simple and bulk strings, an int64 for integers, a
+// []interface{} for arrays and nil for null replies. Error replies are returned as Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(c.timeout(), args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection may be in an unknown state
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes the idle connections. Connections in use are closed when they are returned.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	c.MaxIdle = -1
+	return nil
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return c.Timeout
+}
+
+func (c *Client) get() (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.Addr, c.timeout())
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.Password != "" {
+		if _, err := cn.do(c.timeout(), []string{"AUTH", c.Password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if _, err := cn.do(c.timeout(), []string{"SELECT", strconv.Itoa(c.DB)}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	maxIdle := c.MaxIdle
+	if maxIdle == 0 {
+		maxIdle = 4
+	}
+	if len(c.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// do writes a command as an array of bulk strings and reads the reply
+func (cn *conn) do(timeout time.Duration, args []string) (interface{}, error) {
+	cn.SetDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+// readReply reads one RESP2 reply. Error replies inside arrays are returned as Error values.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redisclient: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redisclient: malformed bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redisclient: malformed array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readReply(r)
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				item, err = replyErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redisclient: unknown reply type %q", kind)
+	}
+}
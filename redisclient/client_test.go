@@ -0,0 +1,74 @@
+package redisclient_test
+
+import (
+	"api-service/redisclient"
+	"api-service/redisclient/redistest"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClientReplies(t *testing.T) {
+	srv := redistest.NewServer(t)
+	client := &redisclient.Client{Addr: srv.Addr()}
+	defer client.Close()
+
+	steps := []struct {
+		args []string
+		want interface{}
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"SET", "greeting", "hello\r\nworld"}, "OK"},
+		{[]string{"GET", "greeting"}, "hello\r\nworld"},
+		{[]string{"INCR", "counter"}, int64(1)},
+		{[]string{"HMSET", "hash", "a", "1", "b", ""}, "OK"},
+		{[]string{"HMGET", "hash", "a", "b", "c"}, []interface{}{"1", "", nil}},
+	}
+	for _, step := range steps {
+		got, err := client.Do(step.args...)
+		if err != nil || !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%v: %#v, %v, want %#v", step.args, got, err, step.want)
+		}
+	}
+}
+
+func TestClientKeepsTheConnectionAfterAnErrorReply(t *testing.T) {
+	srv := redistest.NewServer(t)
+	client := &redisclient.Client{Addr: srv.Addr()}
+	defer client.Close()
+
+	client.Do("SET", "name", "alice")
+	_, err := client.Do("INCR", "name")
+	var replyErr redisclient.Error
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "ERR value is not an integer") {
+		t.Fatalf("INCR of a string: error %v, want the error reply", err)
+	}
+	if got, err := client.Do("GET", "name"); err != nil || got != "alice" {
+		t.Fatalf("GET after an error reply: %v, %v", got, err)
+	}
+}
+
+func TestClientAuthenticatesAndSelectsNewConnections(t *testing.T) {
+	srv := redistest.NewServer(t)
+	srv.Password = "secret"
+
+	client := &redisclient.Client{Addr: srv.Addr(), Password: "secret", DB: 2}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := client.Do("PING"); err != nil {
+			t.Fatalf("PING %d: %v", i+1, err)
+		}
+	}
+	// The idle connection is reused, so AUTH and SELECT are only sent once
+	if got, want := strings.Join(srv.Commands(), " "), "AUTH SELECT PING PING PING"; got != want {
+		t.Fatalf("commands %q, want %q", got, want)
+	}
+
+	wrong := &redisclient.Client{Addr: srv.Addr(), Password: "guess"}
+	defer wrong.Close()
+	if _, err := wrong.Do("PING"); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Fatalf("wrong password: error %v, want WRONGPASS", err)
+	}
+}
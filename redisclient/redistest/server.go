@@ -0,0 +1,439 @@
+package redistest
+
+/**
+The redistest package runs an in-process stand-in for a Redis server in tests. It speaks RESP2 over TCP and keeps strings and hashes in memory, with the few commands the service sends: PING, AUTH, SELECT, GET, SET, INCR, DEL, PEXPIRE, PTTL, HMGET, HMSET, EVAL and EVALSHA.
+
+It cannot run Lua. Instead a test registers a Go function for each script it expects, which makes the same redis.call calls through Call; EVAL and EVALSHA then behave as on Redis, including the NOSCRIPT error for a script the server has not seen yet:
+
+	srv := redistest.NewServer(t)
+	srv.Script(source, func(srv *redistest.Server, keys, args []string) (interface{}, error) { ... })
+	client := &redisclient.Client{Addr: srv.Addr()}
+
+Tests that also run against a real server take its address from TEST_REDIS_ADDR with RealAddr, and skip without it.
+*/
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RealAddrEnv names the variable holding the address of a Redis server the tests may write to
+const RealAddrEnv = "TEST_REDIS_ADDR"
+
+// RealAddr - Return the address of the Redis server of TEST_REDIS_ADDR, or skip the test when it is not set
+func RealAddr(t testing.TB) string {
+	t.Helper()
+	addr := os.Getenv(RealAddrEnv)
+	if addr == "" {
+		t.Skipf("%s is not set", RealAddrEnv)
+	}
+	return addr
+}
+
+// Error is an error reply, such as "NOSCRIPT No matching script"
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ScriptFunc runs a registered script with the keys and arguments of EVAL or EVALSHA. Its reply is encoded as
+// Redis encodes the reply of a Lua script: strings, int64 values and nested []interface{} values.
+type ScriptFunc func(srv *Server, keys, args []string) (interface{}, error)
+
+type entry struct {
+	str     *string
+	hash    map[string]string
+	expires time.Time // Zero when the key does not expire
+}
+
+// Server is a stand-in for a Redis server, listening on a local port until the test ends
+type Server struct {
+	Password string // Required with AUTH before other commands when set
+
+	ln       net.Listener
+	mu       sync.Mutex
+	data     map[string]*entry
+	scripts  map[string]ScriptFunc // By the source of the script
+	loaded   map[string]string     // Source of the scripts EVAL has run, by SHA-1
+	commands []string              // Names of the commands received, in order
+	conns    map[net.Conn]bool
+}
+
+// NewServer - Start a server on a random local port, closed when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("redistest: listen: %v", err)
+	}
+	srv := &Server{
+		ln:      ln,
+		data:    make(map[string]*entry),
+		scripts: make(map[string]ScriptFunc),
+		loaded:  make(map[string]string),
+		conns:   make(map[net.Conn]bool),
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns[nc] = true
+			srv.mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				srv.serve(nc)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		// Clients keep idle connections open, so they are closed too
+		ln.Close()
+		srv.mu.Lock()
+		for nc := range srv.conns {
+			nc.Close()
+		}
+		srv.mu.Unlock()
+		wg.Wait()
+	})
+	return srv
+}
+
+// Addr - Return the address the server listens on
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Script - Register the Go implementation of a Lua script
+func (s *Server) Script(source string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[source] = fn
+}
+
+// Commands - Return the names of the commands received so far, such as "EVALSHA"
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Keys - Return the keys that exist, in no particular order
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.data {
+		if s.live(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Call - Run a data command, as redis.call does in a script. It must only be called from a ScriptFunc.
+func (s *Server) Call(args ...string) (interface{}, error) {
+	return s.command(args)
+}
+
+func (s *Server) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	authenticated := s.Password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				writeReply(nc, Error("ERR "+err.Error()))
+			}
+			return
+		}
+		name := strings.ToUpper(args[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, name)
+		s.mu.Unlock()
+
+		var reply interface{}
+		switch {
+		case name == "AUTH":
+			if len(args) == 2 && args[1] == s.Password {
+				authenticated, reply = true, "OK"
+			} else {
+				reply = Error("WRONGPASS invalid username-password pair")
+			}
+		case !authenticated:
+			reply = Error("NOAUTH Authentication required.")
+		case name == "EVAL" || name == "EVALSHA":
+			reply = s.eval(name, args[1:])
+		default:
+			s.mu.Lock()
+			reply, err = s.command(args)
+			s.mu.Unlock()
+			if err != nil {
+				reply = err
+			}
+		}
+		if err := writeReply(nc, reply); err != nil {
+			return
+		}
+	}
+}
+
+// eval - Run a registered script. Scripts run one at a time, as on Redis.
+func (s *Server) eval(name string, args []string) interface{} {
+	if len(args) < 2 {
+		return Error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return Error("ERR Number of keys can't be greater than number of args")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	source := args[0]
+	if name == "EVALSHA" {
+		var ok bool
+		if source, ok = s.loaded[strings.ToLower(args[0])]; !ok {
+			return Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	}
+	fn, ok := s.scripts[source]
+	if !ok {
+		return Error("ERR redistest: no Go implementation registered for the script")
+	}
+	sum := sha1.Sum([]byte(source))
+	s.loaded[hex.EncodeToString(sum[:])] = source
+
+	reply, err := fn(s, args[2:2+numKeys], args[2+numKeys:])
+	if err != nil {
+		return err
+	}
+	return reply
+}
+
+// command - Run a data command. The caller holds s.mu.
+func (s *Server) command(args []string) (interface{}, error) {
+	name := strings.ToUpper(args[0])
+	arity := map[string]int{"PING": 1, "SELECT": 2, "GET": 2, "SET": 3, "INCR": 2, "PEXPIRE": 3, "PTTL": 2}
+	if n, ok := arity[name]; ok && len(args) != n {
+		return nil, Error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+	}
+
+	switch name {
+	case "PING":
+		return "PONG", nil
+	case "SELECT":
+		return "OK", nil
+	case "GET":
+		e := s.live(args[1])
+		if e == nil {
+			return nil, nil
+		}
+		if e.str == nil {
+			return nil, wrongType
+		}
+		return *e.str, nil
+	case "SET":
+		value := args[2]
+		s.data[args[1]] = &entry{str: &value}
+		return "OK", nil
+	case "INCR":
+		e := s.live(args[1])
+		if e == nil {
+			zero := "0"
+			e = &entry{str: &zero}
+			s.data[args[1]] = e
+		}
+		if e.str == nil {
+			return nil, wrongType
+		}
+		n, err := strconv.ParseInt(*e.str, 10, 64)
+		if err != nil {
+			return nil, Error("ERR value is not an integer or out of range")
+		}
+		n++
+		value := strconv.FormatInt(n, 10)
+		e.str = &value
+		return n, nil
+	case "DEL":
+		var deleted int64
+		for _, key := range args[1:] {
+			if s.live(key) != nil {
+				delete(s.data, key)
+				deleted++
+			}
+		}
+		return deleted, nil
+	case "PEXPIRE":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return nil, Error("ERR value is not an integer or out of range")
+		}
+		e := s.live(args[1])
+		if e == nil {
+			return int64(0), nil
+		}
+		e.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return int64(1), nil
+	case "PTTL":
+		e := s.live(args[1])
+		switch {
+		case e == nil:
+			return int64(-2), nil
+		case e.expires.IsZero():
+			return int64(-1), nil
+		default:
+			return time.Until(e.expires).Milliseconds(), nil
+		}
+	case "HMGET":
+		if len(args) < 3 {
+			return nil, Error("ERR wrong number of arguments for 'hmget' command")
+		}
+		e := s.live(args[1])
+		if e != nil && e.hash == nil {
+			return nil, wrongType
+		}
+		values := make([]interface{}, len(args)-2)
+		for i, field := range args[2:] {
+			if e == nil {
+				continue
+			}
+			if value, ok := e.hash[field]; ok {
+				values[i] = value
+			}
+		}
+		return values, nil
+	case "HMSET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return nil, Error("ERR wrong number of arguments for 'hmset' command")
+		}
+		e := s.live(args[1])
+		if e == nil {
+			e = &entry{hash: make(map[string]string)}
+			s.data[args[1]] = e
+		}
+		if e.hash == nil {
+			return nil, wrongType
+		}
+		for i := 2; i < len(args); i += 2 {
+			e.hash[args[i]] = args[i+1]
+		}
+		return "OK", nil
+	default:
+		return nil, Error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+var wrongType = Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// live - Return the entry of a key, dropping it if it has expired. The caller holds s.mu.
+func (s *Server) live(key string) *entry {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(s.data, key)
+		return nil
+	}
+	return e
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writeReply writes a reply. Strings are sent as bulk strings, except "OK" and "PONG" which are status replies as
+// on Redis.
+func writeReply(w io.Writer, reply interface{}) error {
+	var buf []byte
+	buf = appendReply(buf, reply)
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendReply(buf []byte, reply interface{}) []byte {
+	switch v := reply.(type) {
+	case nil:
+		return append(buf, "$-1\r\n"...)
+	case Error:
+		return append(append(append(buf, '-'), v...), "\r\n"...)
+	case error:
+		return append(append(append(buf, "-ERR "...), v.Error()...), "\r\n"...)
+	case string:
+		if v == "OK" || v == "PONG" {
+			return append(append(append(buf, '+'), v...), "\r\n"...)
+		}
+		buf = append(strconv.AppendInt(append(buf, '$'), int64(len(v)), 10), "\r\n"...)
+		return append(append(buf, v...), "\r\n"...)
+	case int64:
+		return append(strconv.AppendInt(append(buf, ':'), v, 10), "\r\n"...)
+	case int:
+		return append(strconv.AppendInt(append(buf, ':'), int64(v), 10), "\r\n"...)
+	case []interface{}:
+		buf = append(strconv.AppendInt(append(buf, '*'), int64(len(v)), 10), "\r\n"...)
+		for _, item := range v {
+			buf = appendReply(buf, item)
+		}
+		return buf
+	default:
+		return append(buf, fmt.Sprintf("-ERR redistest: cannot encode %T\r\n", reply)...)
+	}
+}
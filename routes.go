@@ -0,0 +1,296 @@
+package main
+
+import (
+	"api-service/config"
+	"api-service/controllers"
+	"api-service/db"
+	"api-service/health"
+	"api-service/logging"
+	"api-service/metrics"
+	"api-service/middleware"
+	"api-service/models"
+	"api-service/redisclient"
+	"api-service/server"
+	"api-service/services"
+	"api-service/storage"
+	"api-service/utils"
+	"api-service/webauthn"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// newRouter builds the services of the server on the migrated database and returns the router serving its routes.
+// The background jobs it starts are stopped by shutdown hooks registered on srv.
+func newRouter(cfg *config.Config, srv *server.Server, dbConn *gorm.DB) *mux.Router {
+	// Not configurable: the services taking dbConn share its tables with the repositories, see the storage package
+	store := &storage.SQLStore{DB: dbConn}
+
+	// Initialize the signing keys
+	keys, err := utils.NewKeyManager(cfg.JWT.SigningAlg, cfg.JWT.KeyOverlap)
+	if err != nil {
+		logging.Fatal(logger, "Invalid signing key configuration", "error", err)
+	}
+	if len(cfg.JWT.KeyFiles) > 0 {
+		for _, path := range cfg.JWT.KeyFiles {
+			if err := keys.LoadPEMFile(path); err != nil {
+				logging.Fatal(logger, "Failed to load signing key", "file", path, "error", err)
+			}
+		}
+		// The algorithm is the one of the first key, whatever jwt.signing_alg says
+		active, _ := keys.ActiveKey()
+		if cfg.Source("jwt.signing_alg") != config.SourceDefault && active.Algorithm != cfg.JWT.SigningAlg {
+			logger.Warn("jwt.signing_alg is ignored, tokens are signed with the algorithm of the first key file", "algorithm", active.Algorithm, "file", cfg.JWT.KeyFiles[0])
+		}
+		logger.Info("Signing keys loaded from files, they are not rotated", "files", len(cfg.JWT.KeyFiles), "algorithm", active.Algorithm)
+	} else {
+		if err := keys.Rotate(); err != nil {
+			logging.Fatal(logger, "Failed to generate signing key", "error", err)
+		}
+		if cfg.JWT.KeyRotation > 0 {
+			srv.OnShutdown("key rotation", server.StopFunc(keys.StartRotation(cfg.JWT.KeyRotation)))
+		}
+	}
+	utils.Keys = keys
+
+	// Initialize the readiness checks
+	migrator, err := db.NewMigrator(dbConn, cfg.Database.Driver)
+	if err != nil {
+		logging.Fatal(logger, "Invalid migrations", "error", err)
+	}
+	healthChecker := &health.Checker{Timeout: cfg.Health.CheckTimeout, CacheTTL: cfg.Health.CacheTTL, Draining: srv.Draining}
+	healthChecker.Register("database", health.DatabaseCheck(dbConn))
+	healthChecker.Register("migrations", health.MigrationsCheck(migrator))
+	healthChecker.Register("signing_key", health.SigningKeyCheck(keys))
+
+	// Initialize the token revocation store
+	var revocations services.RevocationStore
+	if cfg.Revocation.Store == "memory" {
+		revocations = services.NewMemoryRevocationStore()
+	} else {
+		revocations = &services.PostgresRevocationStore{DB: dbConn}
+	}
+	revocations = metrics.CountRevocations(revocations)
+	srv.OnShutdown("revocation pruner", server.StopFunc(services.StartRevocationPruner(revocations, 10*time.Minute)))
+
+	// Initialize the mail delivery
+	var mailer services.Mailer
+	if cfg.SMTP.Host != "" {
+		mailer = &services.SMTPMailer{Host: cfg.SMTP.Host, Port: cfg.SMTP.Port, Username: cfg.SMTP.Username, Password: cfg.SMTP.Password, From: cfg.SMTP.From}
+	} else {
+		logger.Warn("smtp.host is not set, emails are not delivered")
+		mailer = services.NewMemoryMailer()
+	}
+
+	// Initialize the key that signs email verification links and admin invitations
+	linkKey := []byte(cfg.Email.VerificationKey)
+	if len(linkKey) == 0 {
+		logger.Warn("email.verification_key is not set, verification and invitation links stop working on restart")
+		linkKey = make([]byte, 32)
+		if _, err := rand.Read(linkKey); err != nil {
+			logging.Fatal(logger, "Failed to generate email verification key", "error", err)
+		}
+	}
+
+	// Initialize the rate limits
+	var rateLimitStore services.RateLimitStore
+	if cfg.RateLimit.Store == "redis" {
+		redis := &redisclient.Client{Addr: cfg.RateLimit.RedisAddr, Password: cfg.RateLimit.RedisPassword}
+		srv.OnShutdown("redis", func(context.Context) error { return redis.Close() })
+		rateLimitStore = &services.RedisRateLimitStore{Client: redis}
+	} else {
+		rateLimitStore = services.NewMemoryRateLimitStore()
+	}
+	authPolicy, err := middleware.ParseRateLimitPolicy("auth", cfg.RateLimit.Auth, cfg.RateLimit.Algorithm, middleware.KeyByIP)
+	if err != nil {
+		logging.Fatal(logger, "Invalid rate limit configuration", "error", err)
+	}
+	profilePolicy, err := middleware.ParseRateLimitPolicy("profile", cfg.RateLimit.Profile, cfg.RateLimit.Algorithm, middleware.KeyByUser)
+	if err != nil {
+		logging.Fatal(logger, "Invalid rate limit configuration", "error", err)
+	}
+	profilePolicy.PathPrefix = "/api/profile"
+	apiPolicy, err := middleware.ParseRateLimitPolicy("api", cfg.RateLimit.API, cfg.RateLimit.Algorithm, middleware.KeyByUser)
+	if err != nil {
+		logging.Fatal(logger, "Invalid rate limit configuration", "error", err)
+	}
+	rateLimiter := &middleware.RateLimiter{Store: rateLimitStore}
+	authLimit := rateLimiter.Limit(authPolicy)
+
+	// Initialize Services
+	auditService := &services.AuditService{DB: dbConn}
+	if err := auditService.Init(); err != nil {
+		logging.Fatal(logger, "Failed to initialize the audit log", "error", err)
+	}
+	organizationService := &services.OrganizationService{DB: dbConn}
+	if err := organizationService.Seed(); err != nil {
+		logging.Fatal(logger, "Failed to seed organizations", "error", err)
+	}
+	rbacService := &services.RBACService{DB: dbConn, Revocations: revocations}
+	if err := rbacService.Seed(); err != nil {
+		logging.Fatal(logger, "Failed to seed roles and permissions", "error", err)
+	}
+	groupService := &services.GroupService{DB: dbConn, RBAC: rbacService}
+	policyService := &services.PolicyService{DB: dbConn, RBAC: rbacService, File: cfg.Policy.File}
+	if err := policyService.Load(); err != nil {
+		logging.Fatal(logger, "Failed to load policies", "error", err)
+	}
+	lockoutService := &services.LockoutService{
+		DB:                 dbConn,
+		AccountMaxFailures: cfg.Login.MaxFailures,
+		IPMaxFailures:      cfg.Login.IPMaxFailures,
+		MFAMaxFailures:     cfg.Login.MFAMaxFailures,
+		BaseLockout:        cfg.Login.Lockout,
+		MaxLockout:         cfg.Login.LockoutMax,
+		FailureWindow:      cfg.Login.FailureWindow,
+	}
+	srv.OnShutdown("lockout pruner", server.StopFunc(lockoutService.StartPruner(10*time.Minute)))
+	userService := &services.UserService{Store: store, Lockout: lockoutService}
+	emailVerificationService := &services.EmailVerificationService{DB: dbConn, Mailer: mailer, Key: linkKey, VerifyURL: cfg.Email.VerificationURL, Required: cfg.Email.VerificationRequired}
+	adminService := &services.AdminService{Store: store, Revocations: revocations, EmailVerification: emailVerificationService, Lockout: lockoutService}
+	invitationService := &services.InvitationService{DB: dbConn, Mailer: mailer, Key: linkKey, AcceptURL: cfg.Invitations.URL}
+	bootstrapService := &services.BootstrapService{DB: dbConn, TokenFile: cfg.Bootstrap.TokenFile}
+	if err := bootstrapService.Init(); err != nil {
+		logging.Fatal(logger, "Failed to initialize admin setup", "error", err)
+	}
+	tokenService := &services.TokenService{DB: dbConn, Revocations: revocations}
+	mfaService := &services.MFAService{DB: dbConn, Issuer: cfg.MFA.Issuer, Revocations: revocations, Lockout: lockoutService}
+	oidcService := &services.OIDCService{DB: dbConn, Issuer: cfg.Server.Issuer, Revocations: revocations, RBAC: rbacService}
+	passwordService := &services.PasswordService{DB: dbConn, Mailer: mailer, Tokens: tokenService, Revocations: revocations, ResetURL: cfg.PasswordReset.URL}
+	srv.OnShutdown("password reset mailer", passwordService.Start())
+	passkeyService := &services.PasskeyService{DB: dbConn, WebAuthn: &webauthn.Config{
+		RPID:    cfg.WebAuthn.RPID,
+		RPName:  cfg.WebAuthn.RPName,
+		Origins: cfg.WebAuthn.Origins,
+		Timeout: services.PasskeySessionTTL,
+	}}
+
+	// Initialize Controllers
+	userController := &controllers.UserController{UserService: userService, TokenService: tokenService, MFAService: mfaService, EmailVerificationService: emailVerificationService, PolicyService: policyService, AuditService: auditService}
+	adminController := &controllers.AdminController{AdminService: adminService, PolicyService: policyService, AuditService: auditService}
+	jwksController := &controllers.JWKSController{Keys: keys}
+	oidcController := &controllers.OIDCController{OIDCService: oidcService, UserService: userService, MFAService: mfaService, EmailVerificationService: emailVerificationService, Keys: keys, AuditService: auditService}
+	mfaController := &controllers.MFAController{MFAService: mfaService}
+	passwordController := &controllers.PasswordController{PasswordService: passwordService}
+	passkeyController := &controllers.PasskeyController{PasskeyService: passkeyService, UserService: userService, TokenService: tokenService, MFAService: mfaService, EmailVerificationService: emailVerificationService, AuditService: auditService}
+	emailController := &controllers.EmailController{EmailVerificationService: emailVerificationService, AuditService: auditService}
+	invitationController := &controllers.InvitationController{InvitationService: invitationService}
+	setupController := &controllers.SetupController{BootstrapService: bootstrapService}
+	roleController := &controllers.RoleController{RBACService: rbacService, AuditService: auditService}
+	policyController := &controllers.PolicyController{PolicyService: policyService, UserService: userService}
+	organizationController := &controllers.OrganizationController{OrganizationService: organizationService}
+	groupController := &controllers.GroupController{GroupService: groupService, AuditService: auditService}
+	auditController := &controllers.AuditController{AuditService: auditService}
+	healthController := &controllers.HealthController{Checker: healthChecker}
+	authMiddleware := &middleware.AuthMiddleware{Revocations: revocations}
+	permissionMiddleware := &middleware.PermissionMiddleware{RBAC: rbacService}
+
+	// Setup Router
+	router := mux.NewRouter()
+
+	// Public Routes (the login, registration and recovery endpoints share the "auth" rate limit)
+	router.Handle("/register", authLimit(http.HandlerFunc(userController.Register))).Methods("POST")
+	router.Handle("/setup", authLimit(http.HandlerFunc(setupController.Setup))).Methods("POST")
+	router.HandleFunc("/invitations/accept", invitationController.AcceptInvitationPage).Methods("GET")
+	router.Handle("/invitations/accept", authLimit(http.HandlerFunc(invitationController.AcceptInvitation))).Methods("POST")
+	router.Handle("/login", authLimit(http.HandlerFunc(userController.Login))).Methods("POST")
+	router.Handle("/login/mfa", authLimit(http.HandlerFunc(userController.LoginMFA))).Methods("POST")
+	router.Handle("/login/passkey/begin", authLimit(http.HandlerFunc(passkeyController.BeginLogin))).Methods("POST")
+	router.Handle("/login/passkey/finish", authLimit(http.HandlerFunc(passkeyController.FinishLogin))).Methods("POST")
+	router.Handle("/token/refresh", authLimit(http.HandlerFunc(userController.RefreshToken))).Methods("POST")
+	router.Handle("/password/forgot", authLimit(http.HandlerFunc(passwordController.ForgotPassword))).Methods("POST")
+	router.HandleFunc("/password/reset", passwordController.ResetPasswordPage).Methods("GET")
+	router.Handle("/password/reset", authLimit(http.HandlerFunc(passwordController.ResetPassword))).Methods("POST")
+	router.HandleFunc("/email/verify", emailController.VerifyEmailPage).Methods("GET")
+	router.Handle("/email/verify", authLimit(http.HandlerFunc(emailController.VerifyEmail))).Methods("POST")
+	router.Handle("/email/verify/resend", authLimit(http.HandlerFunc(emailController.ResendVerification))).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", jwksController.JWKS).Methods("GET")
+
+	// Health Routes (probes of orchestrators, without rate limits)
+	router.HandleFunc("/healthz", healthController.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", healthController.Readiness).Methods("GET")
+
+	// OpenID Connect Provider Routes
+	router.HandleFunc("/.well-known/openid-configuration", oidcController.Discovery).Methods("GET")
+	router.HandleFunc("/oauth/authorize", oidcController.Authorize).Methods("GET")
+	router.Handle("/oauth/authorize", authLimit(http.HandlerFunc(oidcController.AuthorizeSubmit))).Methods("POST")
+	router.HandleFunc("/oauth/consent", oidcController.Consent).Methods("POST")
+	router.HandleFunc("/oauth/token", oidcController.Token).Methods("POST")
+	router.Handle("/userinfo", authMiddleware.DelegatedJWTMiddleware(http.HandlerFunc(oidcController.UserInfo))).Methods("GET", "POST")
+
+	// Protected Routes
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(authMiddleware.JWTMiddleware)
+	api.Use(rateLimiter.Limit(profilePolicy, apiPolicy))
+
+	// User Routes (protected for logged-in users)
+	api.HandleFunc("/profile", userController.GetProfile).Methods("GET")
+	api.HandleFunc("/profile", userController.UpdateProfile).Methods("PUT")
+	api.HandleFunc("/profile/email", emailController.ChangeEmail).Methods("PUT")
+	api.HandleFunc("/users", userController.ListUsers).Methods("GET")
+	api.HandleFunc("/users/{id}", userController.GetUser).Methods("GET")
+	api.HandleFunc("/profile/mfa", mfaController.GetStatus).Methods("GET")
+	api.HandleFunc("/profile/mfa", mfaController.StartEnrollment).Methods("POST")
+	api.HandleFunc("/profile/mfa", mfaController.Disable).Methods("DELETE")
+	api.HandleFunc("/profile/mfa/confirm", mfaController.ConfirmEnrollment).Methods("POST")
+	api.HandleFunc("/profile/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes).Methods("POST")
+	api.HandleFunc("/profile/passkeys", passkeyController.ListPasskeys).Methods("GET")
+	api.HandleFunc("/profile/passkeys/register/begin", passkeyController.BeginRegistration).Methods("POST")
+	api.HandleFunc("/profile/passkeys/register/finish", passkeyController.FinishRegistration).Methods("POST")
+	api.HandleFunc("/profile/passkeys/{id}", passkeyController.DeletePasskey).Methods("DELETE")
+	api.HandleFunc("/logout", userController.Logout).Methods("POST")
+	api.HandleFunc("/consents", oidcController.ListConsents).Methods("GET")
+	api.HandleFunc("/consents/{client_id}", oidcController.RevokeConsent).Methods("DELETE")
+
+	// Admin Routes (each protected by a permission)
+	adminApi := api.PathPrefix("/admin").Subrouter()
+	guard := func(permission string, handler http.HandlerFunc) http.Handler {
+		return permissionMiddleware.RequirePermission(permission)(handler)
+	}
+
+	adminApi.Handle("/users", guard(models.PermUsersRead, adminController.GetAllUsers)).Methods("GET")
+	adminApi.Handle("/users", guard(models.PermUsersCreate, adminController.CreateUser)).Methods("POST")
+	adminApi.Handle("/users/{id}", guard(models.PermUsersDelete, adminController.DeleteUser)).Methods("DELETE")
+	adminApi.Handle("/users/{id}/unlock", guard(models.PermUsersUnlock, adminController.UnlockUser)).Methods("POST")
+	adminApi.Handle("/users/{id}/revoke", guard(models.PermTokensRevoke, adminController.RevokeToken)).Methods("POST")
+	adminApi.Handle("/users/{id}/mfa", guard(models.PermMFAReset, mfaController.AdminReset)).Methods("DELETE")
+	adminApi.Handle("/users/{id}/roles", guard(models.PermRolesRead, roleController.GetUserRoles)).Methods("GET")
+	adminApi.Handle("/users/{id}/roles", guard(models.PermRolesManage, roleController.SetUserRoles)).Methods("PUT")
+	adminApi.Handle("/users/{id}/effective-roles", guard(models.PermGroupsRead, roleController.GetEffectiveRoles)).Methods("GET")
+	adminApi.Handle("/groups", guard(models.PermGroupsRead, groupController.ListGroups)).Methods("GET")
+	adminApi.Handle("/groups", guard(models.PermGroupsManage, groupController.CreateGroup)).Methods("POST")
+	adminApi.Handle("/groups/{id}", guard(models.PermGroupsRead, groupController.GetGroup)).Methods("GET")
+	adminApi.Handle("/groups/{id}", guard(models.PermGroupsManage, groupController.UpdateGroup)).Methods("PUT")
+	adminApi.Handle("/groups/{id}", guard(models.PermGroupsManage, groupController.DeleteGroup)).Methods("DELETE")
+	adminApi.Handle("/groups/{id}/members", guard(models.PermGroupsRead, groupController.ListMembers)).Methods("GET")
+	adminApi.Handle("/groups/{id}/members", guard(models.PermGroupsManage, groupController.AddMembers)).Methods("POST")
+	adminApi.Handle("/groups/{id}/members/{user_id}", guard(models.PermGroupsManage, groupController.RemoveMember)).Methods("DELETE")
+	adminApi.Handle("/roles", guard(models.PermRolesRead, roleController.ListRoles)).Methods("GET")
+	adminApi.Handle("/roles", guard(models.PermRolesManage, roleController.CreateRole)).Methods("POST")
+	adminApi.Handle("/roles/{name}", guard(models.PermRolesManage, roleController.UpdateRole)).Methods("PUT")
+	adminApi.Handle("/roles/{name}", guard(models.PermRolesManage, roleController.DeleteRole)).Methods("DELETE")
+	adminApi.Handle("/permissions", guard(models.PermRolesRead, roleController.ListPermissions)).Methods("GET")
+	adminApi.Handle("/policies", guard(models.PermPoliciesRead, policyController.ListPolicies)).Methods("GET")
+	adminApi.Handle("/policies/evaluate", guard(models.PermPoliciesRead, policyController.Evaluate)).Methods("POST")
+	adminApi.Handle("/policies/{name}", guard(models.PermPoliciesManage, policyController.SavePolicy)).Methods("PUT")
+	adminApi.Handle("/policies/{name}", guard(models.PermPoliciesManage, policyController.DeletePolicy)).Methods("DELETE")
+	adminApi.Handle("/invitations", guard(models.PermInvitationsManage, invitationController.ListInvitations)).Methods("GET")
+	adminApi.Handle("/invitations", guard(models.PermInvitationsManage, invitationController.Invite)).Methods("POST")
+	adminApi.Handle("/invitations/{id}", guard(models.PermInvitationsManage, invitationController.RevokeInvitation)).Methods("DELETE")
+	adminApi.Handle("/tokens/revoke-all", guard(models.PermTokensRevoke, adminController.RevokeAllTokens)).Methods("POST")
+	adminApi.Handle("/tokens/{jti}/revoke", guard(models.PermTokensRevoke, adminController.RevokeTokenByID)).Methods("POST")
+	adminApi.Handle("/clients", guard(models.PermClientsRead, oidcController.ListClients)).Methods("GET")
+	adminApi.Handle("/clients", guard(models.PermClientsManage, oidcController.RegisterClient)).Methods("POST")
+	adminApi.Handle("/clients/{client_id}", guard(models.PermClientsManage, oidcController.DeleteClient)).Methods("DELETE")
+	adminApi.Handle("/audit", guard(models.PermAuditRead, auditController.ListEvents)).Methods("GET")
+	adminApi.Handle("/audit/verify", guard(models.PermAuditRead, auditController.VerifyChain)).Methods("GET")
+	adminApi.Handle("/organizations", guard(models.PermOrganizationsManage, organizationController.ListOrganizations)).Methods("GET")
+	adminApi.Handle("/organizations", guard(models.PermOrganizationsManage, organizationController.CreateOrganization)).Methods("POST")
+	adminApi.Handle("/organizations/{id}", guard(models.PermOrganizationsManage, organizationController.DeleteOrganization)).Methods("DELETE")
+
+	return router
+}
@@ -0,0 +1,151 @@
+package server
+
+/**
+The server package runs the HTTP server for the lifetime of the process. It applies the read, write, header and idle timeouts of the configuration, and when the context given to Run is cancelled (main cancels it on SIGTERM or SIGINT) it stops accepting connections, lets in-flight requests finish within the shutdown timeout, and then runs the shutdown hooks that close the database and stop background workers, within a shutdown timeout of their own.
+*/
+import (
+	"api-service/logging"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.For("server")
+
+var ErrShutdownTimeout = errors.New("in-flight requests did not finish before the shutdown timeout")
+
+// Config holds the timeouts of the server. Zero disables a timeout, except ShutdownTimeout, which is 30 seconds
+// when zero.
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration // Reading a whole request, body included
+	ReadHeaderTimeout time.Duration // Reading the request headers
+	WriteTimeout      time.Duration // From the end of the request headers to the end of the response
+	IdleTimeout       time.Duration // Keeping an idle keep-alive connection open
+	ShutdownTimeout   time.Duration // Draining in-flight requests, and then again running the shutdown hooks
+	ShutdownDelay     time.Duration // Serving on while reporting not ready, so that load balancers stop sending requests first
+}
+
+// Server is an HTTP server with a graceful shutdown. Register hooks with OnShutdown, then call Run.
+type Server struct {
+	Config Config
+
+	mu       sync.Mutex
+	hooks    []hook
+	draining atomic.Bool
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func New(cfg Config) *Server {
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+	return &Server{Config: cfg}
+}
+
+// OnShutdown - Register a function to run after the requests have drained. Hooks run in the reverse order of
+// registration, like deferred calls, so a worker registered after the database is stopped before the database is
+// closed. Every hook runs even if an earlier one fails.
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook{name: name, fn: fn})
+}
+
+// Draining - Report whether the server is shutting down. Readiness checks fail while it is.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Run - Listen on the configured address and serve handler until ctx is cancelled, then shut down. It returns nil
+// after a clean shutdown, and an error if the server could not listen, the requests did not drain in time or a
+// hook failed.
+func (s *Server) Run(ctx context.Context, handler http.Handler) error {
+	ln, err := net.Listen("tcp", s.Config.Addr)
+	if err != nil {
+		return errors.Join(err, s.runHooks())
+	}
+	return s.Serve(ctx, ln, handler)
+}
+
+// Serve - Like Run, on a listener that is already open. Serve closes it.
+func (s *Server) Serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       s.Config.ReadTimeout,
+		ReadHeaderTimeout: s.Config.ReadHeaderTimeout,
+		WriteTimeout:      s.Config.WriteTimeout,
+		IdleTimeout:       s.Config.IdleTimeout,
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	select {
+	case err := <-served:
+		// The listener failed before a shutdown was asked for
+		return errors.Join(fmt.Errorf("server stopped: %w", err), s.runHooks())
+	case <-ctx.Done():
+	}
+
+	s.draining.Store(true)
+	if s.Config.ShutdownDelay > 0 {
+		logger.Info("Shutting down after the shutdown delay", "addr", ln.Addr().String(), "delay", s.Config.ShutdownDelay.String())
+		time.Sleep(s.Config.ShutdownDelay)
+	}
+	logger.Info("Shutting down, waiting for in-flight requests", "addr", ln.Addr().String(), "timeout", s.Config.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+	var errs []error
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Cut the requests that are still running
+		srv.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrShutdownTimeout
+		}
+		errs = append(errs, err)
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errs = append(errs, err)
+	}
+	errs = append(errs, s.runHooks())
+	return errors.Join(errs...)
+}
+
+// runHooks - Run the shutdown hooks, newest first, and return their errors. They get a deadline of their own, so that
+// requests using up the shutdown timeout do not leave the hooks without time to close the database cleanly.
+func (s *Server) runHooks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	hooks := s.hooks
+	s.hooks = nil
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %s: %w", hooks[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StopFunc - Adapt the stop function of a background worker, which cannot fail, to a shutdown hook
+func StopFunc(stop func()) func(ctx context.Context) error {
+	return func(context.Context) error {
+		stop()
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// start - Serve handler on a local port until the returned context is cancelled. Serve's error is sent on the
+// returned channel.
+func start(t *testing.T, s *Server, handler http.Handler) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln, handler) }()
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+// wait - Return Serve's error, failing the test if it does not return in time
+func wait(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve did not return")
+		return nil
+	}
+}
+
+// hookLog records the shutdown hooks that ran, in order
+type hookLog struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (l *hookLog) hook(name string, check func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		l.mu.Lock()
+		l.names = append(l.names, name)
+		l.mu.Unlock()
+		if check != nil {
+			return check(ctx)
+		}
+		return nil
+	}
+}
+
+func (l *hookLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.names, " ")
+}
+
+func TestShutdownLetsInFlightRequestsFinish(t *testing.T) {
+	s := New(Config{ShutdownTimeout: 5 * time.Second})
+	var hooks hookLog
+	s.OnShutdown("database", hooks.hook("database", nil))
+	s.OnShutdown("worker", hooks.hook("worker", nil))
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "finished")
+	})
+	url, cancel, done := start(t, s, handler)
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{string(body), err}
+	}()
+	<-started
+	cancel()
+
+	if err := wait(t, done); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if r := <-response; r.err != nil || r.body != "finished" {
+		t.Fatalf("in-flight request: %q, %v, want the whole response", r.body, r.err)
+	}
+	if !s.Draining() {
+		t.Fatal("the server does not report draining")
+	}
+	if got := hooks.String(); got != "worker database" {
+		t.Fatalf("hooks ran as %q, want newest first", got)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Fatal("the server accepts requests after the shutdown")
+	}
+}
+
+func TestShutdownHooksGetTheirOwnDeadline(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	s := New(Config{ShutdownTimeout: timeout})
+	var hooks hookLog
+	s.OnShutdown("database", hooks.hook("database", func(ctx context.Context) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < timeout/2 {
+			return errors.New("no time left to close the database")
+		}
+		return nil
+	}))
+
+	// A request outlives the shutdown timeout and is cut off
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	url, cancel, done := start(t, s, handler)
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+
+	err := wait(t, done)
+	if !errors.Is(err, ErrShutdownTimeout) {
+		t.Fatalf("Serve: %v, want %v", err, ErrShutdownTimeout)
+	}
+	if strings.Contains(err.Error(), "shutdown hook") {
+		t.Fatalf("Serve: %v, want the hook to have the whole shutdown timeout", err)
+	}
+	if got := hooks.String(); got != "database" {
+		t.Fatalf("hooks ran as %q", got)
+	}
+}
+
+func TestShutdownHooksAreBounded(t *testing.T) {
+	s := New(Config{ShutdownTimeout: 100 * time.Millisecond})
+	s.OnShutdown("stuck worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	_, cancel, done := start(t, s, http.NotFoundHandler())
+	cancel()
+
+	err := wait(t, done)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "shutdown hook stuck worker") {
+		t.Fatalf("Serve: %v, want the stuck worker to report its deadline", err)
+	}
+}
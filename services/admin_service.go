@@ -2,56 +2,245 @@ package services
 
 import (
 	"api-service/models"
+	"api-service/storage"
+	"api-service/tracing"
+	"api-service/utils"
+	"context"
+	"errors"
+	"strconv"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
+// AdminService manages users on behalf of an admin. Every query is scoped to Tenant, the organization of the
+// calling admin; use ForTenant to get a service for the caller of a request. Super admins act across tenants.
 type AdminService struct {
-	DB *gorm.DB
+	Store             storage.Store
+	Revocations       RevocationStore
+	EmailVerification *EmailVerificationService
+	Lockout           *LockoutService
+	Tenant            Tenant
+
+	ctx context.Context // Parent of the spans of the methods, see WithContext
+}
+
+// ForTenant - Return a copy of the service scoped to the tenant
+func (s *AdminService) ForTenant(tenant Tenant) *AdminService {
+	scoped := *s
+	scoped.Tenant = tenant
+	return &scoped
 }
 
-func (s *AdminService) CreateUser(username, password, role string, email string) (models.User, error) {
+// WithContext - Return a copy of the service whose methods are traced as part of ctx, such as the request being
+// handled
+func (s *AdminService) WithContext(ctx context.Context) *AdminService {
+	bound := *s
+	bound.ctx = ctx
+	return &bound
+}
+
+// CreateUser - Create a user account in the tenant's organization, or in organizationID when a super admin names
+// one. Admin accounts cannot be created here; admins are invited, so that every admin chooses their own password.
+func (s *AdminService) CreateUser(username, password, role, email, region string, organizationID uint) (_ models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "AdminService.CreateUser")
+	defer span.EndErr(&err)
+
+	if role == "" {
+		role = models.RoleUser
+	}
+	if role == models.RoleAdmin || role == models.RoleSuperAdmin {
+		return models.User{}, ErrAdminRoleByInvite
+	}
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	user := models.User{
 		Username: username,
 		Password: string(hashedPassword),
 		Role:     role,
 		Email:    email,
+		Region:   region,
 	}
 
-	if err := s.DB.Create(&user).Error; err != nil {
+	err = store.Transaction(func(tx storage.Store) error {
+		orgID, err := s.organization(tx, organizationID)
+		if err != nil {
+			return err
+		}
+		user.OrganizationID = orgID
+		return tx.Users().Create(&user, role)
+	})
+	if err != nil {
 		return models.User{}, err
 	}
 
+	// The account is created even when the email cannot be sent; the user can request a new link
+	if user.Email != "" {
+		if err := s.EmailVerification.SendVerification(user); err != nil {
+			logger.Error("Failed to send verification email", "user_id", user.ID, "error", err)
+		}
+	}
+
 	return user, nil
 }
 
-func (s *AdminService) GetAllUsers() ([]models.User, error) {
-	var users []models.User
-	if err := s.DB.Find(&users).Error; err != nil {
-		return nil, err
+// organization - Return the organization a user is created in, like checkOrganization does for services that use
+// the database directly
+func (s *AdminService) organization(tx storage.Store, id uint) (uint, error) {
+	if id == 0 || id == s.Tenant.OrganizationID {
+		return s.Tenant.OrganizationID, nil
+	}
+	if !s.Tenant.CrossTenant {
+		return 0, ErrCrossTenant
+	}
+	org, err := tx.Organizations().Get(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, ErrOrganizationNotFound
+		}
+		return 0, err
 	}
-	return users, nil
+	return org.ID, nil
 }
 
-func (s *AdminService) DeleteUser(userID uint) error {
-	if err := s.DB.Delete(&models.User{}, userID).Error; err != nil {
+// GetAllUsers - Return the users of the tenant. A super admin may narrow the list to one organization; zero
+// returns the users of every organization.
+func (s *AdminService) GetAllUsers(organizationID uint) (_ []models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "AdminService.GetAllUsers")
+	defer span.EndErr(&err)
+	return listTenantUsers(store, s.Tenant, organizationID)
+}
+
+// GetUser - Return a user of the tenant by ID
+func (s *AdminService) GetUser(userID uint) (_ models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "AdminService.GetUser", tracing.Int("user.id", int(userID)))
+	defer span.EndErr(&err)
+	return getTenantUser(store, s.Tenant, userID)
+}
+
+// DeleteUser - Delete a user of the tenant together with their role assignments and group memberships, and revoke
+// their tokens. The guards of SetUserRoles apply as if the user lost every role: an organization keeps its last
+// admin, and only a super admin deletes a super admin, provided another one is left.
+func (s *AdminService) DeleteUser(userID uint) (err error) {
+	ctx, store, span := startSpan(s.ctx, s.Store, "AdminService.DeleteUser", tracing.Int("user.id", int(userID)))
+	defer span.EndErr(&err)
+
+	err = store.Transaction(func(tx storage.Store) error {
+		user, err := getTenantUser(tx, s.Tenant, userID)
+		if err != nil {
+			return err
+		}
+		roles, err := tx.Roles().ListByUser(user.ID)
+		if err != nil {
+			return err
+		}
+		had := make([]string, 0, len(roles))
+		for _, role := range roles {
+			had = append(had, role.Name)
+		}
+		if err := checkRoleChange(s.Tenant, user.OrganizationID, had, nil, tx.Roles().CountHolders); err != nil {
+			return err
+		}
+		return tx.Users().Delete(user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Tokens issued to the user so far must not outlive the account
+	if err := bindRevocations(s.Revocations, ctx).RevokeSubject(strconv.FormatUint(uint64(userID), 10), time.Now()); err != nil {
 		return err
 	}
-	return nil
+	return store.RefreshTokens().RevokeByUsers([]uint{userID}, time.Now())
 }
 
-func (s *AdminService) RevokeToken(userID uint) error {
-	var user models.User
-	if err := s.DB.First(&user, userID).Error; err != nil {
+// UnlockUser - Lift the login and second factor lockouts of a user of the tenant and forget their failed logins.
+// Locks of client IP addresses are not affected.
+func (s *AdminService) UnlockUser(userID uint) (_ models.User, err error) {
+	ctx, store, span := startSpan(s.ctx, s.Store, "AdminService.UnlockUser", tracing.Int("user.id", int(userID)))
+	defer span.EndErr(&err)
+	lockout := s.Lockout.WithContext(ctx)
+
+	user, err := getTenantUser(store, s.Tenant, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := lockout.Unlock(user.Username); err != nil {
+		return models.User{}, err
+	}
+	return user, lockout.UnlockMFA(user.ID)
+}
+
+// RevokeToken - Revoke every access and refresh token of a user of the tenant
+func (s *AdminService) RevokeToken(userID uint) (err error) {
+	ctx, store, span := startSpan(s.ctx, s.Store, "AdminService.RevokeToken", tracing.Int("user.id", int(userID)))
+	defer span.EndErr(&err)
+
+	user, err := getTenantUser(store, s.Tenant, userID)
+	if err != nil {
 		return err
 	}
 
 	user.Token = "" // Revoke token by clearing it
-	if err := s.DB.Save(&user).Error; err != nil {
+	if err := store.Users().Update(&user); err != nil {
+		return err
+	}
+
+	// Deny every access token issued to the user so far
+	if err := bindRevocations(s.Revocations, ctx).RevokeSubject(strconv.FormatUint(uint64(userID), 10), time.Now()); err != nil {
 		return err
 	}
 
-	return nil
+	// Refresh tokens must not outlive the revocation
+	return store.RefreshTokens().RevokeByUsers([]uint{userID}, time.Now())
+}
+
+// RevokeTokenByID - Revoke a single access token by its jti. A jti does not tell which tenant the token belongs
+// to, so only super admins may do this.
+func (s *AdminService) RevokeTokenByID(jti string) (err error) {
+	ctx, _, span := startSpan(s.ctx, s.Store, "AdminService.RevokeTokenByID")
+	defer span.EndErr(&err)
+
+	if !s.Tenant.CrossTenant {
+		return ErrCrossTenant
+	}
+	return bindRevocations(s.Revocations, ctx).RevokeToken(jti, time.Now().Add(utils.AccessTokenTTL))
+}
+
+// RevokeAllTokens - Revoke every access and refresh token issued so far in the tenant, forcing its users to log in
+// again. For super admins this revokes the tokens of every organization.
+func (s *AdminService) RevokeAllTokens() (err error) {
+	ctx, store, span := startSpan(s.ctx, s.Store, "AdminService.RevokeAllTokens", tracing.Bool("tenant.cross_tenant", s.Tenant.CrossTenant))
+	defer span.EndErr(&err)
+	revocations := bindRevocations(s.Revocations, ctx)
+
+	now := time.Now()
+	if s.Tenant.CrossTenant {
+		if err := revocations.RevokeAll(now); err != nil {
+			return err
+		}
+		return store.RefreshTokens().RevokeAll(now)
+	}
+	if s.Tenant.OrganizationID == 0 {
+		return nil
+	}
+
+	userIDs, err := store.Users().IDs(s.Tenant.OrganizationID)
+	if err != nil {
+		return err
+	}
+	clientIDs, err := store.Clients().IDs(s.Tenant.OrganizationID)
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		if err := revocations.RevokeSubject(strconv.FormatUint(uint64(id), 10), now); err != nil {
+			return err
+		}
+	}
+	for _, clientID := range clientIDs {
+		if err := revocations.RevokeSubject(clientID, now); err != nil {
+			return err
+		}
+	}
+	return store.RefreshTokens().RevokeByUsers(userIDs, now)
 }
@@ -0,0 +1,114 @@
+package services_test
+
+import (
+	"api-service/models"
+	"api-service/services"
+	"api-service/storage"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// adminService - Return an admin service over the fixture's database, scoped to the tenant
+func (f *rbacFixture) adminService(tenant services.Tenant) *services.AdminService {
+	return (&services.AdminService{Store: &storage.SQLStore{DB: f.conn}, Revocations: f.rbac.Revocations}).ForTenant(tenant)
+}
+
+func TestDeleteUserGuards(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		tenant func(f *rbacFixture) services.Tenant
+		user   func(t *testing.T, f *rbacFixture) models.User
+		want   error
+	}{
+		{
+			// The default organization has an admin, but acme would be left without one
+			name:   "last admin of the organization",
+			tenant: func(f *rbacFixture) services.Tenant { return f.acme },
+			user:   func(t *testing.T, f *rbacFixture) models.User { return f.acmeAdmin },
+			want:   services.ErrLastAdmin,
+		},
+		{
+			name:   "last admin, by a super admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.superAdmin },
+			user:   func(t *testing.T, f *rbacFixture) models.User { return f.acmeAdmin },
+			want:   services.ErrLastAdmin,
+		},
+		{
+			name:   "super admin deleted by an admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.defaultOrg },
+			user: func(t *testing.T, f *rbacFixture) models.User {
+				// Another admin keeps the organization from losing its last one
+				f.createUser(t, "admin-2", f.root.OrganizationID, models.RoleAdmin)
+				return f.root
+			},
+			want: services.ErrSuperAdminRole,
+		},
+		{
+			name:   "last super admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.superAdmin },
+			user: func(t *testing.T, f *rbacFixture) models.User {
+				f.createUser(t, "admin-2", f.root.OrganizationID, models.RoleAdmin)
+				return f.root
+			},
+			want: services.ErrLastSuperAdmin,
+		},
+		{
+			name:   "user of another organization",
+			tenant: func(f *rbacFixture) services.Tenant { return f.defaultOrg },
+			user:   func(t *testing.T, f *rbacFixture) models.User { return f.acmeUser },
+			want:   storage.ErrNotFound,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRBACFixture(t)
+			user := test.user(t, f)
+
+			if err := f.adminService(test.tenant(f)).DeleteUser(user.ID); !errors.Is(err, test.want) {
+				t.Fatalf("error %v, want %v", err, test.want)
+			}
+			if roles := roleNames(t, f.conn, reloadUser(t, f.conn, user.ID)); len(roles) == 0 {
+				t.Fatal("roles of the user removed")
+			}
+		})
+	}
+}
+
+func TestDeleteUserRevokesTokens(t *testing.T) {
+	f := newRBACFixture(t)
+	refresh := models.RefreshToken{UserID: f.acmeUser.ID, FamilyID: "f", TokenHash: "h", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := f.conn.Create(&refresh).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.adminService(f.acme).DeleteUser(f.acmeUser.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err := f.conn.Model(&models.User{}).Where("id = ?", f.acmeUser.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("user not deleted")
+	}
+	revoked, err := f.rbac.Revocations.IsRevoked("", strconv.FormatUint(uint64(f.acmeUser.ID), 10), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("access tokens of the deleted user not revoked")
+	}
+	if err := f.conn.First(&refresh, refresh.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if refresh.RevokedAt == nil {
+		t.Fatal("refresh token of the deleted user not revoked")
+	}
+
+	// With a second admin, acme can delete one of them
+	f.createUser(t, "acme-admin-2", f.acme.OrganizationID, models.RoleAdmin)
+	if err := f.adminService(f.acme).DeleteUser(f.acmeAdmin.ID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"api-service/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Page sizes of GET /api/admin/audit
+const (
+	AuditDefaultPageSize = 50
+	AuditMaxPageSize     = 500
+)
+
+// auditHeadID is the primary key of the single AuditChainHead row
+const auditHeadID = 1
+
+// maxAuditField limits the length of free-text fields taken from the request, such as the user agent
+const maxAuditField = 512
+
+// AuditService keeps the audit log of security events. Events are appended one at a time: each one takes the next
+// ID and the hash of the previous event while the chain head is locked, so that the chain stays linear even with
+// several instances writing to the same database. Verify recomputes the chain to detect tampering.
+type AuditService struct {
+	DB *gorm.DB
+
+	once    sync.Once
+	appends *sync.Mutex // Serializes appends of this instance and its bound copies, so they do not queue on the row lock
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled. A nil service stays nil.
+func (s *AuditService) WithContext(ctx context.Context) *AuditService {
+	if s == nil {
+		return nil
+	}
+	return &AuditService{DB: s.DB.WithContext(ctx), appends: s.appendLock()}
+}
+
+// appendLock - Return the lock serializing appends, creating it on first use
+func (s *AuditService) appendLock() *sync.Mutex {
+	s.once.Do(func() {
+		if s.appends == nil {
+			s.appends = &sync.Mutex{}
+		}
+	})
+	return s.appends
+}
+
+// Init - Create the chain head if the audit log is new
+func (s *AuditService) Init() error {
+	head := models.AuditChainHead{ID: auditHeadID}
+	return s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&head).Error
+}
+
+// Record - Append an event to the audit log, chained to the previous event by its hash
+func (s *AuditService) Record(event models.AuditEvent) error {
+	appends := s.appendLock()
+	appends.Lock()
+	defer appends.Unlock()
+
+	event.ActorName = cleanAuditField(event.ActorName)
+	event.TargetName = cleanAuditField(event.TargetName)
+	event.UserAgent = cleanAuditField(event.UserAgent)
+	event.RequestID = cleanAuditField(event.RequestID)
+	// The details are cleaned in a copy, the caller's map may be shared with other events
+	if event.Details != nil {
+		details := make(map[string]string, len(event.Details))
+		for key, value := range event.Details {
+			details[key] = cleanAuditField(value)
+		}
+		event.Details = details
+	}
+	if event.Outcome == "" {
+		event.Outcome = models.AuditSuccess
+	}
+	// Timestamps are stored with microsecond precision; truncating first keeps the hash stable after a round trip
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var head models.AuditChainHead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, auditHeadID).Error; err != nil {
+			return err
+		}
+
+		event.ID = head.LastID + 1
+		event.PrevHash = head.LastHash
+		event.Hash = auditHash(event)
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		return tx.Model(&head).Updates(map[string]interface{}{"last_id": event.ID, "last_hash": event.Hash}).Error
+	})
+}
+
+// LoginFailed - Record a failed login for a username. If the account exists the event names it and belongs to its
+// organization, so that its admins see it; attempts on unknown usernames, and failed second factors whose challenge
+// names no user, are only visible to super admins.
+func (s *AuditService) LoginFailed(event models.AuditEvent, username string) error {
+	event.Type = models.AuditLoginFailed
+	event.Outcome = models.AuditFailure
+	if username == "" {
+		return s.Record(event)
+	}
+	event.TargetType = "user"
+	event.TargetName = username
+
+	var user models.User
+	if err := s.DB.Select("id", "organization_id").Where("username = ?", username).Take(&user).Error; err == nil {
+		event.TargetID = strconv.FormatUint(uint64(user.ID), 10)
+		event.OrganizationID = user.OrganizationID
+	}
+	return s.Record(event)
+}
+
+// ListEvents - Return a page of the tenant's audit events matching the filter, newest first
+func (s *AuditService) ListEvents(tenant Tenant, filter models.AuditFilter) (models.AuditPage, error) {
+	query := s.DB.Model(&models.AuditEvent{}).Scopes(tenant.Scope)
+	if filter.OrganizationID != 0 {
+		if !tenant.Owns(filter.OrganizationID) {
+			return models.AuditPage{}, ErrCrossTenant
+		}
+		query = query.Where("organization_id = ?", filter.OrganizationID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+
+	page := models.AuditPage{Page: filter.Page, PageSize: filter.PageSize}
+	if page.Page < 1 {
+		page.Page = 1
+	}
+	if page.PageSize < 1 {
+		page.PageSize = AuditDefaultPageSize
+	}
+	if page.PageSize > AuditMaxPageSize {
+		page.PageSize = AuditMaxPageSize
+	}
+
+	if err := query.Count(&page.Total).Error; err != nil {
+		return models.AuditPage{}, err
+	}
+	page.Events = []models.AuditEvent{}
+	err := query.Order("id DESC").Offset((page.Page - 1) * page.PageSize).Limit(page.PageSize).Find(&page.Events).Error
+	return page, err
+}
+
+// Verify - Recompute the hash chain over the whole audit log. The result names the first event that was changed,
+// removed or inserted; the error is only set if the log could not be read. The chain spans every organization, so
+// only a tenant acting across tenants may verify it.
+func (s *AuditService) Verify(tenant Tenant) (models.AuditVerification, error) {
+	if !tenant.CrossTenant {
+		return models.AuditVerification{}, ErrCrossTenant
+	}
+
+	// Without a chain head the log must be empty
+	var head models.AuditChainHead
+	if err := s.DB.First(&head, auditHeadID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.AuditVerification{}, err
+	}
+
+	result := models.AuditVerification{HeadHash: head.LastHash}
+	prevHash := ""
+	var events []models.AuditEvent
+	for {
+		if err := s.DB.Where("id > ?", result.Events).Order("id").Limit(1000).Find(&events).Error; err != nil {
+			return models.AuditVerification{}, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, event := range events {
+			expected := result.Events + 1
+			switch {
+			case event.ID != expected:
+				return brokenChain(result, expected, "event is missing"), nil
+			case event.PrevHash != prevHash:
+				return brokenChain(result, event.ID, "event does not follow the previous event"), nil
+			case auditHash(event) != event.Hash:
+				return brokenChain(result, event.ID, "event has been modified"), nil
+			}
+			prevHash = event.Hash
+			result.Events = event.ID
+		}
+	}
+
+	if head.LastID != result.Events || head.LastHash != prevHash {
+		return brokenChain(result, result.Events+1, fmt.Sprintf("chain head names event %d but the log ends at event %d", head.LastID, result.Events)), nil
+	}
+	result.Valid = true
+	return result, nil
+}
+
+func brokenChain(result models.AuditVerification, id uint64, reason string) models.AuditVerification {
+	result.Valid = false
+	result.BrokenAt = id
+	result.Error = fmt.Sprintf("audit event %d: %s", id, reason)
+	return result
+}
+
+// auditHash - Return the hex encoded SHA-256 hash of an event. It covers every field except the hash itself,
+// including the hash of the previous event.
+func auditHash(event models.AuditEvent) string {
+	details := event.Details
+	if len(details) == 0 {
+		details = nil
+	}
+	payload, _ := json.Marshal(struct {
+		ID             uint64            `json:"id"`
+		Time           string            `json:"time"`
+		Type           string            `json:"type"`
+		Outcome        string            `json:"outcome"`
+		ActorID        string            `json:"actor_id"`
+		ActorName      string            `json:"actor_name"`
+		TargetType     string            `json:"target_type"`
+		TargetID       string            `json:"target_id"`
+		TargetName     string            `json:"target_name"`
+		OrganizationID uint              `json:"organization_id"`
+		IP             string            `json:"ip"`
+		UserAgent      string            `json:"user_agent"`
+		RequestID      string            `json:"request_id"`
+		Details        map[string]string `json:"details"`
+		PrevHash       string            `json:"prev_hash"`
+	}{
+		event.ID, event.CreatedAt.UTC().Format(time.RFC3339Nano), event.Type, event.Outcome, event.ActorID, event.ActorName,
+		event.TargetType, event.TargetID, event.TargetName, event.OrganizationID, event.IP, event.UserAgent,
+		event.RequestID, details, event.PrevHash,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// cleanAuditField - Make a value taken from the request safe to store: valid UTF-8 without NUL bytes, of bounded length
+func cleanAuditField(value string) string {
+	value = strings.ReplaceAll(strings.ToValidUTF8(value, "�"), "\x00", "")
+	if len(value) > maxAuditField {
+		value = strings.ToValidUTF8(value[:maxAuditField], "")
+	}
+	return value
+}
@@ -0,0 +1,133 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/models"
+	"api-service/services"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+var crossTenant = services.Tenant{CrossTenant: true}
+
+// newAuditLog - Return an audit service holding three events, with the database it writes to
+func newAuditLog(t *testing.T) (*services.AuditService, *gorm.DB) {
+	t.Helper()
+	conn := dbtest.SQLite(t)
+	s := &services.AuditService{DB: conn}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []models.AuditEvent{
+		{Type: models.AuditLoginSucceeded, ActorID: "1", ActorName: "alice"},
+		{Type: models.AuditUserDeleted, ActorID: "1", TargetType: "user", TargetID: "2", Details: map[string]string{"reason": "left"}},
+		{Type: models.AuditLoginSucceeded, ActorID: "3", ActorName: "carol"},
+	} {
+		if err := s.Record(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, conn
+}
+
+func TestRecordKeepsTheCallersDetails(t *testing.T) {
+	s, conn := newAuditLog(t)
+	details := map[string]string{"roles": "user", "note": "bad\x00byte " + strings.Repeat("x", 600)}
+	want := map[string]string{"roles": details["roles"], "note": details["note"]}
+
+	if err := s.Record(models.AuditEvent{Type: models.AuditUserRolesChanged, Details: details}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(details, want) {
+		t.Fatal("details of the caller changed by Record")
+	}
+	var stored models.AuditEvent
+	if err := conn.Order("id DESC").First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if note := stored.Details["note"]; strings.Contains(note, "\x00") || len(note) > 512 {
+		t.Fatalf("stored note of %d bytes not cleaned", len(note))
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		tamper   func(t *testing.T, conn *gorm.DB)
+		brokenAt uint64
+	}{
+		{
+			name: "edited event",
+			tamper: func(t *testing.T, conn *gorm.DB) {
+				if err := conn.Exec("UPDATE audit_events SET actor_name = ? WHERE id = 1", "mallory").Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			brokenAt: 1,
+		},
+		{
+			name: "edited details",
+			tamper: func(t *testing.T, conn *gorm.DB) {
+				if err := conn.Exec("UPDATE audit_events SET details = ? WHERE id = 2", `{"reason":"fired"}`).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			brokenAt: 2,
+		},
+		{
+			name: "deleted event",
+			tamper: func(t *testing.T, conn *gorm.DB) {
+				if err := conn.Exec("DELETE FROM audit_events WHERE id = 2").Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			brokenAt: 2,
+		},
+		{
+			name: "deleted newest event",
+			tamper: func(t *testing.T, conn *gorm.DB) {
+				if err := conn.Exec("DELETE FROM audit_events WHERE id = 3").Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			brokenAt: 3,
+		},
+		{
+			// The hash of the edited event is recomputed, but the next event still names the old one
+			name: "rehashed event",
+			tamper: func(t *testing.T, conn *gorm.DB) {
+				if err := conn.Exec("UPDATE audit_events SET hash = ? WHERE id = 2", strings.Repeat("0", 64)).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			brokenAt: 2,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, conn := newAuditLog(t)
+			if result, err := s.Verify(crossTenant); err != nil || !result.Valid || result.Events != 3 {
+				t.Fatalf("untouched log: %+v, %v", result, err)
+			}
+
+			test.tamper(t, conn)
+			result, err := s.Verify(crossTenant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid || result.BrokenAt != test.brokenAt {
+				t.Fatalf("verification %+v, want broken at event %d", result, test.brokenAt)
+			}
+		})
+	}
+}
+
+func TestVerifyIsReservedToCrossTenantCallers(t *testing.T) {
+	s, _ := newAuditLog(t)
+	if _, err := s.Verify(services.Tenant{OrganizationID: 1}); !errors.Is(err, services.ErrCrossTenant) {
+		t.Fatalf("error %v, want %v", err, services.ErrCrossTenant)
+	}
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSetupClosed       = errors.New("setup is closed, an admin already exists")
+	ErrInvalidSetupToken = errors.New("invalid setup token")
+	ErrUsernameRequired  = errors.New("username is required")
+	ErrUsernameTaken     = errors.New("username is already in use")
+)
+
+// BootstrapService creates the first admin. While no admin exists, a one-time setup token is read from TokenFile
+// or generated and printed at startup, and only that token can create the first admin at /setup. Later admins
+// are invited by existing admins through the InvitationService.
+type BootstrapService struct {
+	DB        *gorm.DB
+	TokenFile string // File holding the setup token; when empty, a token is generated and printed to the log
+
+	once  sync.Once
+	setup *setupToken
+}
+
+// setupToken holds the setup token, shared with the copies WithContext returns
+type setupToken struct {
+	mu   sync.Mutex
+	hash string // Hash of the setup token, empty once setup is closed
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *BootstrapService) WithContext(ctx context.Context) *BootstrapService {
+	return &BootstrapService{DB: s.DB.WithContext(ctx), TokenFile: s.TokenFile, setup: s.setupToken()}
+}
+
+// setupToken - Return the setup token state, creating it on first use
+func (s *BootstrapService) setupToken() *setupToken {
+	s.once.Do(func() {
+		if s.setup == nil {
+			s.setup = &setupToken{}
+		}
+	})
+	return s.setup
+}
+
+// Init - Open setup with a setup token if no admin exists yet
+func (s *BootstrapService) Init() error {
+	setup := s.setupToken()
+	setup.mu.Lock()
+	defer setup.mu.Unlock()
+
+	admins, err := countAdmins(s.DB)
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	var token string
+	if s.TokenFile != "" {
+		data, err := os.ReadFile(s.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			return fmt.Errorf("setup token file %s is empty", s.TokenFile)
+		}
+		logger.Warn("No admin account exists. Create the first admin at POST /setup with the token from the setup token file", "file", s.TokenFile)
+	} else {
+		if token, err = utils.GenerateOpaqueToken(); err != nil {
+			return err
+		}
+		// The generated token is part of the message rather than an attribute, which would be redacted: the log is the
+		// only place the operator can read it from
+		logger.Warn("No admin account exists. Create the first admin at POST /setup with the setup token " + token)
+	}
+	setup.hash = utils.HashToken(token)
+	return nil
+}
+
+// SetupOpen reports whether the first admin can still be created
+func (s *BootstrapService) SetupOpen() bool {
+	setup := s.setupToken()
+	setup.mu.Lock()
+	defer setup.mu.Unlock()
+	return setup.hash != ""
+}
+
+// CreateAdmin - Create the first admin with the setup token. The token works once; setup closes as soon as an
+// admin exists. The email address is trusted, since the token proves the caller runs the deployment. The first
+// admin belongs to the default organization and is also a super admin.
+func (s *BootstrapService) CreateAdmin(req models.SetupRequest) (models.User, error) {
+	setup := s.setupToken()
+	setup.mu.Lock()
+	defer setup.mu.Unlock()
+
+	if setup.hash == "" {
+		return models.User{}, ErrSetupClosed
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(req.Token)), []byte(setup.hash)) != 1 {
+		return models.User{}, ErrInvalidSetupToken
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		return models.User{}, ErrUsernameRequired
+	}
+	if len(req.Password) < MinPasswordLength {
+		return models.User{}, ErrWeakPassword
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	now := time.Now()
+	admin := models.User{
+		Username: req.Username,
+		Password: string(hashedPassword),
+		Email:    strings.TrimSpace(req.Email),
+		Role:     models.RoleAdmin,
+	}
+	if admin.Email != "" {
+		admin.EmailVerifiedAt = &now
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// Another instance may have completed setup with a shared token file
+		admins, err := countAdmins(tx)
+		if err != nil {
+			return err
+		}
+		if admins > 0 {
+			return ErrSetupClosed
+		}
+		// The first admin runs the deployment, so they also administer every organization
+		org, err := defaultOrganization(tx)
+		if err != nil {
+			return err
+		}
+		admin.OrganizationID = org.ID
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
+		}
+		if err := assignRole(tx, &admin, models.RoleAdmin); err != nil {
+			return err
+		}
+		return assignRole(tx, &admin, models.RoleSuperAdmin)
+	})
+	if errors.Is(err, ErrSetupClosed) {
+		setup.hash = ""
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	setup.hash = ""
+	return admin, nil
+}
@@ -0,0 +1,132 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/models"
+	"api-service/services"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+const setupToken = "setup-token-from-the-file"
+
+// seededSQLite - Open a migrated SQLite database with the default organization and the built-in roles, as the
+// server seeds them at startup
+func seededSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn := dbtest.SQLite(t)
+	if err := (&services.OrganizationService{DB: conn}).Seed(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&services.RBACService{DB: conn}).Seed(); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// newBootstrapService - Return a bootstrap service reading the setup token from a file, opened with Init
+func newBootstrapService(t *testing.T, conn *gorm.DB) *services.BootstrapService {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "setup-token")
+	if err := os.WriteFile(tokenFile, []byte(setupToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &services.BootstrapService{DB: conn, TokenFile: tokenFile}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func setupRequest(username string) models.SetupRequest {
+	return models.SetupRequest{Token: setupToken, Username: username, Password: "Root-Passw0rd!", Email: username + "@example.com"}
+}
+
+// roleNames - Return the names of the roles assigned to the user
+func roleNames(t *testing.T, conn *gorm.DB, user models.User) map[string]bool {
+	t.Helper()
+	var roles []models.Role
+	if err := conn.Model(&user).Association("Roles").Find(&roles); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, role := range roles {
+		names[role.Name] = true
+	}
+	return names
+}
+
+func TestSetupTokenIsSingleUse(t *testing.T) {
+	conn := seededSQLite(t)
+	s := newBootstrapService(t, conn)
+	if !s.SetupOpen() {
+		t.Fatal("setup closed without an admin")
+	}
+
+	wrong := setupRequest("root")
+	wrong.Token = "guessed"
+	if _, err := s.CreateAdmin(wrong); !errors.Is(err, services.ErrInvalidSetupToken) {
+		t.Fatalf("wrong token: error %v, want %v", err, services.ErrInvalidSetupToken)
+	}
+	weak := setupRequest("root")
+	weak.Password = "short"
+	if _, err := s.CreateAdmin(weak); !errors.Is(err, services.ErrWeakPassword) {
+		t.Fatalf("weak password: error %v, want %v", err, services.ErrWeakPassword)
+	}
+
+	// Rejected requests leave the token usable, the first accepted one uses it up
+	admin, err := s.WithContext(context.Background()).CreateAdmin(setupRequest("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := roleNames(t, conn, admin); !roles[models.RoleAdmin] || !roles[models.RoleSuperAdmin] {
+		t.Fatalf("first admin has roles %v, want admin and super_admin", roles)
+	}
+	if !admin.EmailVerified() {
+		t.Fatal("address of the first admin not trusted")
+	}
+	if s.SetupOpen() {
+		t.Fatal("setup still open after the first admin")
+	}
+	if _, err := s.CreateAdmin(setupRequest("second")); !errors.Is(err, services.ErrSetupClosed) {
+		t.Fatalf("second use of the token: error %v, want %v", err, services.ErrSetupClosed)
+	}
+}
+
+func TestSetupRefusesOnceAnAdminExists(t *testing.T) {
+	conn := seededSQLite(t)
+	// Two instances share the token file and the database
+	first := newBootstrapService(t, conn)
+	second := newBootstrapService(t, conn)
+
+	if _, err := first.CreateAdmin(setupRequest("root")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.CreateAdmin(setupRequest("intruder")); !errors.Is(err, services.ErrSetupClosed) {
+		t.Fatalf("setup on another instance: error %v, want %v", err, services.ErrSetupClosed)
+	}
+	if second.SetupOpen() {
+		t.Fatal("setup still open on the instance that found an admin")
+	}
+
+	// An instance started after the first admin never opens setup
+	restarted := newBootstrapService(t, conn)
+	if restarted.SetupOpen() {
+		t.Fatal("setup opened although an admin exists")
+	}
+	if _, err := restarted.CreateAdmin(setupRequest("intruder")); !errors.Is(err, services.ErrSetupClosed) {
+		t.Fatalf("setup after a restart: error %v, want %v", err, services.ErrSetupClosed)
+	}
+	var count int64
+	if err := conn.Model(&models.User{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d users, want only the first admin", count)
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EmailVerificationTTL is how long an email verification link stays valid.
+const EmailVerificationTTL = 24 * time.Hour
+
+const emailVerificationPurpose = "email_verification"
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrEmailTaken               = errors.New("email address is already in use")
+	ErrEmailUnchanged           = errors.New("email address is unchanged")
+	ErrEmailNotVerified         = errors.New("email address not verified")
+)
+
+// emailVerification is the payload of a verification link. It binds the link to one user and one address, so a
+// link sent to an address that is no longer the user's current or pending address stops working.
+type emailVerification struct {
+	UserID uint   `json:"uid"`
+	Email  string `json:"email"`
+}
+
+type EmailVerificationService struct {
+	DB        *gorm.DB
+	Mailer    Mailer
+	Key       []byte // HMAC key that signs verification links
+	VerifyURL string // Page the emailed link points to; the token is appended as the "token" query parameter
+	Required  bool   // Block logins until the email address is verified
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *EmailVerificationService) WithContext(ctx context.Context) *EmailVerificationService {
+	bound := *s
+	bound.DB = s.DB.WithContext(ctx)
+	return &bound
+}
+
+// SendVerification - Email a verification link for the user's current address
+func (s *EmailVerificationService) SendVerification(user models.User) error {
+	link, err := s.link(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"please confirm that this is your email address by opening the link below:\n\n"+
+			"%s\n\n"+
+			"The link expires in %d hours.\n",
+			user.Username, link, int(EmailVerificationTTL.Hours())),
+	})
+}
+
+// Resend - Email a new verification link to an unverified address. Unknown and already verified addresses are
+// ignored without an error, so callers cannot use the endpoint to find out which addresses have an account.
+func (s *EmailVerificationService) Resend(email string) error {
+	var user models.User
+	if err := s.DB.Where("email = ? AND email_verified_at IS NULL", strings.TrimSpace(email)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := s.SendVerification(user); err != nil {
+		logger.Error("Failed to send verification email", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+// RequestEmailChange - Store the new address as pending and email a confirmation link to it. The current address
+// stays in use until the link is opened, and it is notified of the request.
+func (s *EmailVerificationService) RequestEmailChange(userID uint, newEmail string) (models.User, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(newEmail))
+	if err != nil || address.Name != "" {
+		return models.User{}, ErrInvalidEmail
+	}
+	newEmail = address.Address
+
+	var user models.User
+	if err := s.DB.First(&user, userID).Error; err != nil {
+		return models.User{}, err
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return models.User{}, ErrEmailUnchanged
+	}
+	var count int64
+	if err := s.DB.Model(&models.User{}).Where("email = ? AND id <> ?", newEmail, userID).Count(&count).Error; err != nil {
+		return models.User{}, err
+	}
+	if count > 0 {
+		return models.User{}, ErrEmailTaken
+	}
+
+	user.PendingEmail = newEmail
+	if err := s.DB.Model(&user).Update("pending_email", newEmail).Error; err != nil {
+		return models.User{}, err
+	}
+
+	link, err := s.link(user.ID, newEmail)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := s.Mailer.Send(Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"open the link below to use this address for your account:\n\n"+
+			"%s\n\n"+
+			"The link expires in %d hours. Until then your account keeps using its current address.\n",
+			user.Username, link, int(EmailVerificationTTL.Hours())),
+	}); err != nil {
+		return models.User{}, err
+	}
+	if user.Email != "" {
+		if err := s.Mailer.Send(Message{
+			To:      user.Email,
+			Subject: "Email address change requested",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"a change of your account's email address to %s was requested. The change takes effect once the new "+
+				"address is confirmed. If you did not request it, change your password.\n",
+				user.Username, newEmail),
+		}); err != nil {
+			logger.Error("Failed to notify the user of an email change", "user_id", user.ID, "error", err)
+		}
+	}
+	return user, nil
+}
+
+// Verify - Confirm an address with a verification link. A link for the current address marks it verified; a link
+// for the pending address replaces the current address with it.
+func (s *EmailVerificationService) Verify(token string) (models.User, error) {
+	var claim emailVerification
+	if err := utils.VerifySignedToken(s.Key, emailVerificationPurpose, token, &claim); err != nil {
+		return models.User{}, ErrInvalidVerificationToken
+	}
+
+	var user models.User
+	if err := s.DB.First(&user, claim.UserID).Error; err != nil {
+		return models.User{}, ErrInvalidVerificationToken
+	}
+	now := time.Now()
+	switch {
+	case claim.Email == user.Email:
+		if user.EmailVerifiedAt != nil {
+			return user, nil
+		}
+		user.EmailVerifiedAt = &now
+		if err := s.DB.Model(&user).Update("email_verified_at", now).Error; err != nil {
+			return models.User{}, err
+		}
+	case claim.Email == user.PendingEmail:
+		// The address may have been taken by another account since the change was requested
+		var count int64
+		if err := s.DB.Model(&models.User{}).Where("email = ? AND id <> ?", claim.Email, user.ID).Count(&count).Error; err != nil {
+			return models.User{}, err
+		}
+		if count > 0 {
+			return models.User{}, ErrEmailTaken
+		}
+		// The pending_email condition keeps a concurrent change request from being overwritten
+		res := s.DB.Model(&models.User{}).
+			Where("id = ? AND pending_email = ?", user.ID, claim.Email).
+			Updates(map[string]interface{}{"email": claim.Email, "pending_email": "", "email_verified_at": now})
+		if res.Error != nil {
+			return models.User{}, res.Error
+		}
+		if res.RowsAffected != 1 {
+			return models.User{}, ErrInvalidVerificationToken
+		}
+		user.Email = claim.Email
+		user.PendingEmail = ""
+		user.EmailVerifiedAt = &now
+	default:
+		return models.User{}, ErrInvalidVerificationToken
+	}
+	return user, nil
+}
+
+// CheckLogin - Reject the login of a user with an unverified address when verification is required
+func (s *EmailVerificationService) CheckLogin(user *models.User) error {
+	if s.Required && !user.EmailVerified() {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+func (s *EmailVerificationService) link(userID uint, email string) (string, error) {
+	token, err := utils.SignToken(s.Key, emailVerificationPurpose, emailVerification{UserID: userID, Email: email}, EmailVerificationTTL)
+	if err != nil {
+		return "", err
+	}
+	separator := "?"
+	if strings.Contains(s.VerifyURL, "?") {
+		separator = "&"
+	}
+	return s.VerifyURL + separator + "token=" + url.QueryEscape(token), nil
+}
@@ -0,0 +1,201 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var verificationLink = regexp.MustCompile(`https://app\.example\.com/verify\?token=(\S+)`)
+
+func newEmailVerificationService(t *testing.T) (*services.EmailVerificationService, *services.MemoryMailer, *gorm.DB) {
+	t.Helper()
+	mailer := services.NewMemoryMailer()
+	conn := dbtest.SQLite(t)
+	return &services.EmailVerificationService{
+		DB:        conn,
+		Mailer:    mailer,
+		Key:       []byte("0123456789abcdef0123456789abcdef"),
+		VerifyURL: "https://app.example.com/verify",
+		Required:  true,
+	}, mailer, conn
+}
+
+// verificationToken - Return the token of the latest verification link emailed to the address
+func verificationToken(t *testing.T, mailer *services.MemoryMailer, to string) string {
+	t.Helper()
+	msg, ok := mailer.Last(to)
+	if !ok {
+		t.Fatalf("no email to %s", to)
+	}
+	match := verificationLink.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no verification link in %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func reloadUser(t *testing.T, conn *gorm.DB, id uint) models.User {
+	t.Helper()
+	var user models.User
+	if err := conn.First(&user, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestVerifyEmailAddress(t *testing.T) {
+	s, mailer, conn := newEmailVerificationService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+	if err := s.CheckLogin(&user); !errors.Is(err, services.ErrEmailNotVerified) {
+		t.Fatalf("unverified login: error %v, want %v", err, services.ErrEmailNotVerified)
+	}
+
+	if err := s.SendVerification(user); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := s.Verify(verificationToken(t, mailer, user.Email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.EmailVerified() {
+		t.Fatal("address not marked verified")
+	}
+	user = reloadUser(t, conn, user.ID)
+	if err := s.CheckLogin(&user); err != nil {
+		t.Fatalf("verified login: %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperedAndExpiredLinks(t *testing.T) {
+	s, mailer, conn := newEmailVerificationService(t)
+	alice := createUser(t, conn, "alice", "Old-Passw0rd!")
+	mallory := createUser(t, conn, "mallory", "Old-Passw0rd!")
+	if err := s.SendVerification(mallory); err != nil {
+		t.Fatal(err)
+	}
+	token := verificationToken(t, mailer, mallory.Email)
+
+	// Mallory's own link, edited to verify Alice's address
+	body, sig, _ := strings.Cut(token, ".")
+	envelope, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope = bytes.Replace(envelope, []byte(`"email":"mallory@example.com"`), []byte(`"email":"alice@example.com"`), 1)
+	envelope = bytes.Replace(envelope, []byte(fmt.Sprintf(`"uid":%d`, mallory.ID)), []byte(fmt.Sprintf(`"uid":%d`, alice.ID)), 1)
+	forged := base64.RawURLEncoding.EncodeToString(envelope) + "." + sig
+	expired, err := utils.SignToken(s.Key, "email_verification", map[string]interface{}{"uid": alice.ID, "email": alice.Email}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := utils.SignToken([]byte("another key of thirty-two bytes!"), "email_verification", map[string]interface{}{"uid": alice.ID, "email": alice.Email}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPurpose, err := utils.SignToken(s.Key, "invitation", map[string]interface{}{"uid": alice.ID, "email": alice.Email}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, link := range map[string]string{
+		"tampered":      forged,
+		"expired":       expired,
+		"other key":     otherKey,
+		"other purpose": otherPurpose,
+		"truncated":     token[:len(token)-2],
+	} {
+		if _, err := s.Verify(link); !errors.Is(err, services.ErrInvalidVerificationToken) {
+			t.Errorf("%s link: error %v, want %v", name, err, services.ErrInvalidVerificationToken)
+		}
+	}
+	if alice = reloadUser(t, conn, alice.ID); alice.EmailVerified() {
+		t.Fatal("address verified by a forged link")
+	}
+}
+
+func TestConfirmPendingEmailChange(t *testing.T) {
+	s, mailer, conn := newEmailVerificationService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+	if err := s.SendVerification(user); err != nil {
+		t.Fatal(err)
+	}
+	oldLink := verificationToken(t, mailer, user.Email)
+
+	if _, err := s.RequestEmailChange(user.ID, "Alice@Example.com"); !errors.Is(err, services.ErrEmailUnchanged) {
+		t.Fatalf("same address: error %v, want %v", err, services.ErrEmailUnchanged)
+	}
+	if _, err := s.RequestEmailChange(user.ID, "Alice <alice@new.example.com>"); !errors.Is(err, services.ErrInvalidEmail) {
+		t.Fatalf("address with a name: error %v, want %v", err, services.ErrInvalidEmail)
+	}
+	if _, err := s.RequestEmailChange(user.ID, " alice@new.example.com "); err != nil {
+		t.Fatal(err)
+	}
+	if notice, ok := mailer.Last(user.Email); !ok || !strings.Contains(notice.Body, "alice@new.example.com") {
+		t.Fatalf("current address not notified: %+v", notice)
+	}
+	pending := reloadUser(t, conn, user.ID)
+	if pending.Email != user.Email || pending.PendingEmail != "alice@new.example.com" {
+		t.Fatalf("email %q, pending %q: the address changed before it was confirmed", pending.Email, pending.PendingEmail)
+	}
+
+	changed, err := s.Verify(verificationToken(t, mailer, "alice@new.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := reloadUser(t, conn, user.ID)
+	for _, got := range []models.User{changed, stored} {
+		if got.Email != "alice@new.example.com" || got.PendingEmail != "" || !got.EmailVerified() {
+			t.Fatalf("email %q, pending %q, verified %v after the confirmation", got.Email, got.PendingEmail, got.EmailVerified())
+		}
+	}
+
+	// The link sent to the previous address no longer matches an address of the user
+	if _, err := s.Verify(oldLink); !errors.Is(err, services.ErrInvalidVerificationToken) {
+		t.Fatalf("link of the previous address: error %v, want %v", err, services.ErrInvalidVerificationToken)
+	}
+}
+
+func TestEmailChangeLinksAreBoundToTheLatestRequest(t *testing.T) {
+	s, mailer, conn := newEmailVerificationService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+
+	if _, err := s.RequestEmailChange(user.ID, "first@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	first := verificationToken(t, mailer, "first@example.com")
+	if _, err := s.RequestEmailChange(user.ID, "second@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(first); !errors.Is(err, services.ErrInvalidVerificationToken) {
+		t.Fatalf("superseded link: error %v, want %v", err, services.ErrInvalidVerificationToken)
+	}
+
+	// Another account took the address before the link was opened
+	createUser(t, conn, "second", "Old-Passw0rd!")
+	if _, err := s.RequestEmailChange(user.ID, "second@example.com"); !errors.Is(err, services.ErrEmailTaken) {
+		t.Fatalf("taken address: error %v, want %v", err, services.ErrEmailTaken)
+	}
+	if _, err := s.Verify(verificationToken(t, mailer, "second@example.com")); !errors.Is(err, services.ErrEmailTaken) {
+		t.Fatalf("address taken since the request: error %v, want %v", err, services.ErrEmailTaken)
+	}
+	if stored := reloadUser(t, conn, user.ID); stored.Email != user.Email {
+		t.Fatalf("email changed to %q", stored.Email)
+	}
+}
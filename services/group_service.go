@@ -0,0 +1,429 @@
+package services
+
+import (
+	"api-service/models"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrGroupNotFound       = errors.New("group not found")
+	ErrGroupExists         = errors.New("a group with this name already exists")
+	ErrGroupNameRequired   = errors.New("group name is required")
+	ErrParentGroupNotFound = errors.New("parent group not found")
+	ErrGroupCycle          = errors.New("a group cannot be nested in itself or in one of its subgroups")
+	ErrGroupHasSubgroups   = errors.New("groups with subgroups cannot be deleted")
+	ErrPrivilegedGroupRole = errors.New("the admin and super_admin roles cannot be given to groups")
+	ErrUnknownGroupMember  = errors.New("unknown user")
+)
+
+// GroupService manages groups, their nesting, their members and the roles members inherit. Groups belong to an
+// organization; every method is scoped to the tenant of the caller.
+type GroupService struct {
+	DB   *gorm.DB
+	RBAC *RBACService
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *GroupService) WithContext(ctx context.Context) *GroupService {
+	return &GroupService{DB: s.DB.WithContext(ctx), RBAC: s.RBAC.WithContext(ctx)}
+}
+
+// ListGroups - Return the groups of the tenant with their roles
+func (s *GroupService) ListGroups(tenant Tenant) ([]models.Group, error) {
+	var groups []models.Group
+	if err := s.DB.Scopes(tenant.Scope).Preload("Roles").Order("name").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// GetGroup - Return a group of the tenant with its roles
+func (s *GroupService) GetGroup(tenant Tenant, id uint) (models.Group, error) {
+	return s.findGroup(s.DB, tenant, id)
+}
+
+// CreateGroup - Create a group in the tenant's organization, or in the organization a super admin names
+func (s *GroupService) CreateGroup(tenant Tenant, req models.GroupRequest) (models.Group, error) {
+	group := models.Group{Name: strings.TrimSpace(req.Name), Description: req.Description}
+	if group.Name == "" {
+		return models.Group{}, ErrGroupNameRequired
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		orgID, err := checkOrganization(tx, tenant, req.OrganizationID)
+		if err != nil {
+			return err
+		}
+		group.OrganizationID = orgID
+		if err := s.checkName(tx, group); err != nil {
+			return err
+		}
+		if req.ParentID != nil {
+			if err := s.checkParent(tx, orgID, *req.ParentID); err != nil {
+				return err
+			}
+			group.ParentID = req.ParentID
+		}
+		if group.Roles, err = s.lookupRoles(tx, req.Roles); err != nil {
+			return err
+		}
+		return tx.Create(&group).Error
+	})
+	if err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+// UpdateGroup - Replace the name, description, parent and roles of a group. The members of the group and of its
+// subgroups get their access tokens revoked, so that their inherited roles change at once.
+func (s *GroupService) UpdateGroup(tenant Tenant, id uint, req models.GroupRequest) (models.Group, error) {
+	var group models.Group
+	var affected []uint
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if group, err = s.findGroup(tx, tenant, id); err != nil {
+			return err
+		}
+		group.Name = strings.TrimSpace(req.Name)
+		group.Description = req.Description
+		if group.Name == "" {
+			return ErrGroupNameRequired
+		}
+		if err := s.checkName(tx, group); err != nil {
+			return err
+		}
+
+		group.ParentID = nil
+		if req.ParentID != nil {
+			if err := s.checkParent(tx, group.OrganizationID, *req.ParentID); err != nil {
+				return err
+			}
+			groups, err := organizationGroups(tx, group.OrganizationID)
+			if err != nil {
+				return err
+			}
+			// Walk up from the new parent; reaching the group means it would be nested in itself
+			for parent := req.ParentID; parent != nil; parent = groups[*parent].ParentID {
+				if *parent == group.ID {
+					return ErrGroupCycle
+				}
+			}
+			group.ParentID = req.ParentID
+		}
+
+		roles, err := s.lookupRoles(tx, req.Roles)
+		if err != nil {
+			return err
+		}
+		if affected, err = subtreeMembers(tx, group); err != nil {
+			return err
+		}
+		if err := tx.Model(&group).Updates(map[string]interface{}{
+			"name":        group.Name,
+			"description": group.Description,
+			"parent_id":   group.ParentID,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&group).Association("Roles").Replace(roles); err != nil {
+			return err
+		}
+		group.Roles = roles
+		return nil
+	})
+	if err != nil {
+		return models.Group{}, err
+	}
+	if err := s.RBAC.revokeAccessTokens(affected...); err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+// DeleteGroup - Delete a group without subgroups. Its members lose the roles they inherited from it.
+func (s *GroupService) DeleteGroup(tenant Tenant, id uint) error {
+	var members []uint
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		group, err := s.findGroup(tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		var subgroups int64
+		if err := tx.Model(&models.Group{}).Where("parent_id = ?", group.ID).Count(&subgroups).Error; err != nil {
+			return err
+		}
+		if subgroups > 0 {
+			return ErrGroupHasSubgroups
+		}
+		if err := tx.Table("group_members").Where("group_id = ?", group.ID).Pluck("user_id", &members).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&group).Association("Roles").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM group_members WHERE group_id = ?", group.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&group).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.RBAC.revokeAccessTokens(members...)
+}
+
+// ListMembers - Return the users who are direct members of a group of the tenant
+func (s *GroupService) ListMembers(tenant Tenant, id uint) ([]models.User, error) {
+	group, err := s.findGroup(s.DB, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	var members []models.User
+	if err := s.DB.Model(&group).Order("username").Association("Members").Find(&members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// AddMembers - Add users of the group's organization to a group. Their access tokens are revoked, so that the next
+// refresh issues a token with the inherited roles.
+func (s *GroupService) AddMembers(tenant Tenant, id uint, userIDs []uint) error {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		group, err := s.findGroup(tx, tenant, id)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+		var users []models.User
+		if err := tx.Where("id IN ? AND organization_id = ?", userIDs, group.OrganizationID).Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) != len(uniqueIDs(userIDs)) {
+			return ErrUnknownGroupMember
+		}
+		return tx.Model(&group).Association("Members").Append(&users)
+	})
+	if err != nil {
+		return err
+	}
+	return s.RBAC.revokeAccessTokens(userIDs...)
+}
+
+// RemoveMember - Remove a user from a group. The user's access tokens are revoked, so that the inherited roles stop
+// working at once.
+func (s *GroupService) RemoveMember(tenant Tenant, id, userID uint) error {
+	group, err := s.findGroup(s.DB, tenant, id)
+	if err != nil {
+		return err
+	}
+	res := s.DB.Exec("DELETE FROM group_members WHERE group_id = ? AND user_id = ?", group.ID, userID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUnknownGroupMember
+	}
+	return s.RBAC.revokeAccessTokens(userID)
+}
+
+func (s *GroupService) findGroup(tx *gorm.DB, tenant Tenant, id uint) (models.Group, error) {
+	var group models.Group
+	if err := tx.Scopes(tenant.Scope).Preload("Roles").First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Group{}, ErrGroupNotFound
+		}
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+// checkParent - Check that a parent group exists in the organization. Groups are only nested within an
+// organization, even by super admins.
+func (s *GroupService) checkParent(tx *gorm.DB, organizationID, parentID uint) error {
+	_, err := s.findGroup(tx, Tenant{OrganizationID: organizationID}, parentID)
+	if errors.Is(err, ErrGroupNotFound) {
+		return ErrParentGroupNotFound
+	}
+	return err
+}
+
+func (s *GroupService) checkName(tx *gorm.DB, group models.Group) error {
+	var count int64
+	if err := tx.Model(&models.Group{}).
+		Where("organization_id = ? AND name = ? AND id <> ?", group.OrganizationID, group.Name, group.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrGroupExists
+	}
+	return nil
+}
+
+// lookupRoles - Resolve role names for a group. Admin roles are only held directly, so that every admin is
+// visible in the roles of their account.
+func (s *GroupService) lookupRoles(tx *gorm.DB, names []string) ([]models.Role, error) {
+	roles := []models.Role{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == models.RoleAdmin || name == models.RoleSuperAdmin {
+			return nil, ErrPrivilegedGroupRole
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		role, err := s.RBAC.findRole(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// organizationGroups - Load the groups of an organization with their roles, by ID
+func organizationGroups(tx *gorm.DB, organizationIDs ...uint) (map[uint]models.Group, error) {
+	var groups []models.Group
+	if err := tx.Preload("Roles").Where("organization_id IN ?", organizationIDs).Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Group, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+	return byID, nil
+}
+
+// subtreeMembers - Return the members of a group and of all groups nested in it
+func subtreeMembers(tx *gorm.DB, group models.Group) ([]uint, error) {
+	groups, err := organizationGroups(tx, group.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	subtree := []uint{group.ID}
+	for id := range groups {
+		for parent := groups[id].ParentID; parent != nil; parent = groups[*parent].ParentID {
+			if *parent == group.ID {
+				subtree = append(subtree, id)
+				break
+			}
+		}
+	}
+	var members []uint
+	if err := tx.Table("group_members").Where("group_id IN ?", subtree).Distinct().Pluck("user_id", &members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// loadEffectiveRoles - Compute the roles the users hold directly or through groups into EffectiveRoles. The
+// roles the users hold directly must be loaded.
+func loadEffectiveRoles(tx *gorm.DB, users []models.User) error {
+	sources, err := roleSources(tx, users)
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		roles := sources[users[i].ID]
+		names := make([]string, len(roles))
+		for j, r := range roles {
+			names[j] = r.Role
+		}
+		users[i].EffectiveRoles = names
+	}
+	return nil
+}
+
+// roleSources - Compute, for each user, every role they hold and the grants it comes from: the roles held directly
+// first, then those of the groups the user is a member of and of the groups those are nested in. The roles the
+// users hold directly must be loaded.
+func roleSources(tx *gorm.DB, users []models.User) (map[uint][]models.EffectiveRole, error) {
+	result := make(map[uint][]models.EffectiveRole, len(users))
+	if len(users) == 0 {
+		return result, nil
+	}
+
+	userIDs := make([]uint, len(users))
+	orgIDs := make([]uint, 0, 1)
+	seenOrg := make(map[uint]bool)
+	for i, u := range users {
+		userIDs[i] = u.ID
+		if !seenOrg[u.OrganizationID] {
+			seenOrg[u.OrganizationID] = true
+			orgIDs = append(orgIDs, u.OrganizationID)
+		}
+	}
+	var memberships []struct {
+		GroupID uint
+		UserID  uint
+	}
+	if err := tx.Table("group_members").Select("group_id, user_id").Where("user_id IN ?", userIDs).Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	groupsOf := make(map[uint][]uint)
+	for _, m := range memberships {
+		groupsOf[m.UserID] = append(groupsOf[m.UserID], m.GroupID)
+	}
+	groups := map[uint]models.Group{}
+	if len(memberships) > 0 {
+		var err error
+		if groups, err = organizationGroups(tx, orgIDs...); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, u := range users {
+		roles := []models.EffectiveRole{}
+		index := make(map[string]int)
+		grant := func(role string, g models.RoleGrant) {
+			i, ok := index[role]
+			if !ok {
+				i = len(roles)
+				index[role] = i
+				roles = append(roles, models.EffectiveRole{Role: role})
+			}
+			roles[i].Grants = append(roles[i].Grants, g)
+		}
+
+		for _, r := range u.Roles {
+			grant(r.Name, models.RoleGrant{Source: models.RoleSourceDirect})
+		}
+		memberOf := groupsOf[u.ID]
+		sort.Slice(memberOf, func(i, j int) bool { return memberOf[i] < memberOf[j] })
+		for _, id := range memberOf {
+			var path []string
+			visited := make(map[uint]bool)
+			for g, ok := groups[id]; ok && !visited[g.ID]; {
+				visited[g.ID] = true
+				path = append(path, g.Name)
+				for _, r := range g.Roles {
+					grant(r.Name, models.RoleGrant{Source: models.RoleSourceGroup, GroupID: g.ID, Group: g.Name, Path: append([]string(nil), path...)})
+				}
+				if g.ParentID == nil {
+					break
+				}
+				g, ok = groups[*g.ParentID]
+			}
+		}
+		result[u.ID] = roles
+	}
+	return result, nil
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// AdminInvitationTTL is how long an admin invitation stays valid.
+const AdminInvitationTTL = 72 * time.Hour
+
+const adminInvitationPurpose = "admin_invitation"
+
+var (
+	ErrInvalidInvitation  = errors.New("invalid or expired invitation")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrAdminRoleByInvite  = errors.New("admins can only be added by invitation")
+)
+
+// adminInvitation is the payload of an invitation link
+type adminInvitation struct {
+	InvitationID uint   `json:"iid"`
+	Email        string `json:"email"`
+}
+
+type InvitationService struct {
+	DB        *gorm.DB
+	Mailer    Mailer
+	Key       []byte // HMAC key that signs invitation links
+	AcceptURL string // Page the emailed link points to; the token is appended as the "token" query parameter
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *InvitationService) WithContext(ctx context.Context) *InvitationService {
+	bound := *s
+	bound.DB = s.DB.WithContext(ctx)
+	return &bound
+}
+
+// Invite - Email a signed, single-use invitation to become an admin of the inviter's organization, or of
+// organizationID when a super admin names one
+func (s *InvitationService) Invite(inviter models.User, tenant Tenant, email string, organizationID uint) (models.AdminInvitation, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" {
+		return models.AdminInvitation{}, ErrInvalidEmail
+	}
+	var count int64
+	if err := s.DB.Model(&models.User{}).Where("email = ?", address.Address).Count(&count).Error; err != nil {
+		return models.AdminInvitation{}, err
+	}
+	if count > 0 {
+		return models.AdminInvitation{}, ErrEmailTaken
+	}
+	orgID, err := checkOrganization(s.DB, tenant, organizationID)
+	if err != nil {
+		return models.AdminInvitation{}, err
+	}
+
+	invitation := models.AdminInvitation{
+		Email:          address.Address,
+		InvitedBy:      inviter.ID,
+		OrganizationID: orgID,
+		ExpiresAt:      time.Now().Add(AdminInvitationTTL),
+	}
+	if err := s.DB.Create(&invitation).Error; err != nil {
+		return models.AdminInvitation{}, err
+	}
+
+	token, err := utils.SignToken(s.Key, adminInvitationPurpose, adminInvitation{InvitationID: invitation.ID, Email: invitation.Email}, AdminInvitationTTL)
+	if err != nil {
+		return models.AdminInvitation{}, err
+	}
+	if err := s.Mailer.Send(Message{
+		To:      invitation.Email,
+		Subject: "You have been invited as an administrator",
+		Body: fmt.Sprintf("Hello,\n\n"+
+			"%s invited you to become an administrator. Open the link below to choose a username and password:\n\n"+
+			"%s\n\n"+
+			"The invitation expires in %d hours and can be used once.\n",
+			inviter.Username, s.acceptLink(token), int(AdminInvitationTTL.Hours())),
+	}); err != nil {
+		return models.AdminInvitation{}, err
+	}
+	return invitation, nil
+}
+
+// ListInvitations - Return every invitation of the tenant, newest first
+func (s *InvitationService) ListInvitations(tenant Tenant) ([]models.AdminInvitation, error) {
+	var invitations []models.AdminInvitation
+	if err := s.DB.Scopes(tenant.Scope).Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation - Withdraw an invitation of the tenant that has not been accepted yet
+func (s *InvitationService) RevokeInvitation(tenant Tenant, id uint) error {
+	res := s.DB.Model(&models.AdminInvitation{}).Scopes(tenant.Scope).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// Accept - Create the invited admin in the organization of the invitation, with their own username and password.
+// The invitation is consumed, and the address is verified since the invitee received the link there.
+func (s *InvitationService) Accept(token, username, password string) (models.User, error) {
+	var claim adminInvitation
+	if err := utils.VerifySignedToken(s.Key, adminInvitationPurpose, token, &claim); err != nil {
+		return models.User{}, ErrInvalidInvitation
+	}
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return models.User{}, ErrUsernameRequired
+	}
+	if len(password) < MinPasswordLength {
+		return models.User{}, ErrWeakPassword
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	now := time.Now()
+	admin := models.User{
+		Username:        username,
+		Password:        string(hashedPassword),
+		Email:           claim.Email,
+		EmailVerifiedAt: &now,
+		Role:            models.RoleAdmin,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsernameTaken
+		}
+		if err := tx.Model(&models.User{}).Where("email = ?", claim.Email).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		// The accepted_at condition makes concurrent accepts race on the row, so only one of them can succeed
+		res := tx.Model(&models.AdminInvitation{}).
+			Where("id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", claim.InvitationID, claim.Email, now).
+			Update("accepted_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return ErrInvalidInvitation
+		}
+		var invitation models.AdminInvitation
+		if err := tx.First(&invitation, claim.InvitationID).Error; err != nil {
+			return err
+		}
+		admin.OrganizationID = invitation.OrganizationID
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
+		}
+		return assignRole(tx, &admin, models.RoleAdmin)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return admin, nil
+}
+
+func (s *InvitationService) acceptLink(token string) string {
+	separator := "?"
+	if strings.Contains(s.AcceptURL, "?") {
+		separator = "&"
+	}
+	return s.AcceptURL + separator + "token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,130 @@
+package services_test
+
+import (
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var invitationLink = regexp.MustCompile(`https://app\.example\.com/invitations/accept\?token=(\S+)`)
+
+// newInvitationService - Return an invitation service and the first admin, who invites
+func newInvitationService(t *testing.T) (*services.InvitationService, *services.MemoryMailer, *gorm.DB, models.User) {
+	t.Helper()
+	conn := seededSQLite(t)
+	root, err := newBootstrapService(t, conn).CreateAdmin(setupRequest("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := services.NewMemoryMailer()
+	return &services.InvitationService{
+		DB:        conn,
+		Mailer:    mailer,
+		Key:       []byte("0123456789abcdef0123456789abcdef"),
+		AcceptURL: "https://app.example.com/invitations/accept",
+	}, mailer, conn, root
+}
+
+// invite - Invite an address into the inviter's organization and return the token of the emailed link
+func invite(t *testing.T, s *services.InvitationService, mailer *services.MemoryMailer, inviter models.User, email string) (models.AdminInvitation, string) {
+	t.Helper()
+	invitation, err := s.Invite(inviter, services.Tenant{OrganizationID: inviter.OrganizationID}, email, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := mailer.Last(email)
+	if !ok {
+		t.Fatalf("no invitation emailed to %s", email)
+	}
+	match := invitationLink.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no invitation link in %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invitation, token
+}
+
+func TestInvitationIsSingleUse(t *testing.T) {
+	s, mailer, conn, root := newInvitationService(t)
+	_, token := invite(t, s, mailer, root, "bob@example.com")
+
+	admin, err := s.Accept(token, "bob", "Bob-Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := roleNames(t, conn, admin); !roles[models.RoleAdmin] || roles[models.RoleSuperAdmin] {
+		t.Fatalf("invited admin has roles %v, want admin only", roles)
+	}
+	if admin.OrganizationID != root.OrganizationID || admin.Email != "bob@example.com" || !admin.EmailVerified() {
+		t.Fatalf("invited admin %+v", admin)
+	}
+	if _, err := s.Accept(token, "bob2", "Bob-Passw0rd!"); !errors.Is(err, services.ErrEmailTaken) {
+		t.Fatalf("second use: error %v, want %v", err, services.ErrEmailTaken)
+	}
+	// The invitation is used up rather than only blocked by the account it created
+	if err := conn.Select("Roles").Delete(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Accept(token, "bob2", "Bob-Passw0rd!"); !errors.Is(err, services.ErrInvalidInvitation) {
+		t.Fatalf("second use after the account was deleted: error %v, want %v", err, services.ErrInvalidInvitation)
+	}
+}
+
+func TestInvitationsExpire(t *testing.T) {
+	s, mailer, conn, root := newInvitationService(t)
+
+	// The invitation row expires, even though its link is still validly signed
+	invitation, token := invite(t, s, mailer, root, "bob@example.com")
+	if time.Until(invitation.ExpiresAt) > services.AdminInvitationTTL || time.Until(invitation.ExpiresAt) < services.AdminInvitationTTL-time.Minute {
+		t.Fatalf("invitation expires at %v, want in %v", invitation.ExpiresAt, services.AdminInvitationTTL)
+	}
+	if err := conn.Model(&invitation).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Accept(token, "bob", "Bob-Passw0rd!"); !errors.Is(err, services.ErrInvalidInvitation) {
+		t.Fatalf("expired invitation: error %v, want %v", err, services.ErrInvalidInvitation)
+	}
+
+	// An expired link is refused although its invitation is still open
+	open, _ := invite(t, s, mailer, root, "carol@example.com")
+	expired, err := utils.SignToken(s.Key, "admin_invitation", map[string]interface{}{"iid": open.ID, "email": open.Email}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Accept(expired, "carol", "Carol-Passw0rd!"); !errors.Is(err, services.ErrInvalidInvitation) {
+		t.Fatalf("expired link: error %v, want %v", err, services.ErrInvalidInvitation)
+	}
+
+	var admins int64
+	if err := conn.Model(&models.User{}).Where("username IN ?", []string{"bob", "carol"}).Count(&admins).Error; err != nil {
+		t.Fatal(err)
+	}
+	if admins != 0 {
+		t.Fatalf("%d admins created from expired invitations", admins)
+	}
+}
+
+func TestRevokedInvitationsCannotBeAccepted(t *testing.T) {
+	s, mailer, _, root := newInvitationService(t)
+	invitation, token := invite(t, s, mailer, root, "bob@example.com")
+
+	if err := s.RevokeInvitation(services.Tenant{OrganizationID: root.OrganizationID + 1}, invitation.ID); !errors.Is(err, services.ErrInvitationNotFound) {
+		t.Fatalf("revoked by another organization: error %v, want %v", err, services.ErrInvitationNotFound)
+	}
+	if err := s.RevokeInvitation(services.Tenant{OrganizationID: root.OrganizationID}, invitation.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Accept(token, "bob", "Bob-Passw0rd!"); !errors.Is(err, services.ErrInvalidInvitation) {
+		t.Fatalf("revoked invitation: error %v, want %v", err, services.ErrInvalidInvitation)
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"api-service/models"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLoginLocked = errors.New("too many failed login attempts, try again later")
+
+// LockedError is returned for logins refused by a lockout. It matches ErrLoginLocked with errors.Is.
+type LockedError struct {
+	RetryAfter time.Duration // Time until the lock is lifted
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", ErrLoginLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLoginLocked
+}
+
+// LockoutService counts failed logins per username and per client IP address and locks them out with exponential
+// back-off. Usernames are counted whether or not an account has them, so that a lock reveals nothing about which
+// accounts exist. Wrong second factor codes are counted per user, so that starting a new login does not give a
+// fresh set of guesses. The counts live in the database, so every instance enforces the same locks.
+type LockoutService struct {
+	DB                 *gorm.DB
+	AccountMaxFailures int           // Failures of a username before it is locked; 0 disables the account lockout
+	IPMaxFailures      int           // Failures from an IP address before it is locked; 0 disables the IP lockout
+	MFAMaxFailures     int           // Wrong second factor codes of a user before it is locked; 0 disables the MFA lockout
+	BaseLockout        time.Duration // Length of the first lock
+	MaxLockout         time.Duration // Upper bound of the doubled locks
+	FailureWindow      time.Duration // Failures are forgotten once none happened for this long after the last lock
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled. A nil service stays nil.
+func (s *LockoutService) WithContext(ctx context.Context) *LockoutService {
+	if s == nil {
+		return nil
+	}
+	bound := *s
+	bound.DB = s.DB.WithContext(ctx)
+	return &bound
+}
+
+// Check - Return a *LockedError if the username or the IP address is locked. A nil service never locks.
+func (s *LockoutService) Check(username, ip string) error {
+	if s == nil {
+		return nil
+	}
+
+	var lockouts []models.LoginLockout
+	err := s.DB.Where("(kind = ? AND subject = ?) OR (kind = ? AND subject = ?)",
+		models.LockoutAccount, username, models.LockoutIP, ip).Find(&lockouts).Error
+	if err != nil {
+		return err
+	}
+	return lockedError(lockouts)
+}
+
+// CheckMFA - Return a *LockedError if the second factor of the user is locked. A nil service never locks.
+func (s *LockoutService) CheckMFA(userID uint) error {
+	if s == nil {
+		return nil
+	}
+
+	var lockouts []models.LoginLockout
+	if err := s.DB.Where("kind = ? AND subject = ?", models.LockoutMFA, mfaSubject(userID)).Find(&lockouts).Error; err != nil {
+		return err
+	}
+	return lockedError(lockouts)
+}
+
+// lockedError - Return a *LockedError for the longest of the locks that has not expired yet, or nil
+func lockedError(lockouts []models.LoginLockout) error {
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, lockout := range lockouts {
+		if wait := lockout.LockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordFailure - Count a failed login for the username and the IP address, locking them once they reach their limit
+func (s *LockoutService) RecordFailure(username, ip string) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.fail(models.LockoutAccount, username, s.AccountMaxFailures); err != nil {
+		return err
+	}
+	return s.fail(models.LockoutIP, ip, s.IPMaxFailures)
+}
+
+// RecordMFAFailure - Count a wrong second factor code of the user, locking their second factor once it reaches its
+// limit
+func (s *LockoutService) RecordMFAFailure(userID uint) error {
+	if s == nil {
+		return nil
+	}
+	return s.fail(models.LockoutMFA, mfaSubject(userID), s.MFAMaxFailures)
+}
+
+// RecordMFASuccess - Forget the wrong second factor codes of the user after a successful login
+func (s *LockoutService) RecordMFASuccess(userID uint) error {
+	return s.UnlockMFA(userID)
+}
+
+// RecordSuccess - Forget the failed logins of a username after a successful login. The count of the IP address is
+// kept, so that logging in to an account of one's own does not reset it.
+func (s *LockoutService) RecordSuccess(username string) error {
+	return s.Unlock(username)
+}
+
+// Unlock - Lift the lock of a username and forget its failed logins
+func (s *LockoutService) Unlock(username string) error {
+	if s == nil {
+		return nil
+	}
+	return s.DB.Where("kind = ? AND subject = ?", models.LockoutAccount, username).Delete(&models.LoginLockout{}).Error
+}
+
+// UnlockMFA - Lift the lock of the second factor of a user and forget its wrong codes
+func (s *LockoutService) UnlockMFA(userID uint) error {
+	if s == nil {
+		return nil
+	}
+	return s.DB.Where("kind = ? AND subject = ?", models.LockoutMFA, mfaSubject(userID)).Delete(&models.LoginLockout{}).Error
+}
+
+// mfaSubject - Return the subject the second factor of a user is counted under
+func mfaSubject(userID uint) string {
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// Prune - Delete the counts that are no longer locked and whose failures have been forgotten
+func (s *LockoutService) Prune(now time.Time) error {
+	cutoff := now.Add(-s.FailureWindow)
+	return s.DB.Where("locked_until < ? AND last_failure_at < ?", cutoff, cutoff).Delete(&models.LoginLockout{}).Error
+}
+
+// StartPruner - Prune the counts in the background at the given interval. Call the returned function to stop.
+func (s *LockoutService) StartPruner(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Prune(time.Now()); err != nil {
+					logger.Error("Failed to prune login lockouts", "error", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// fail - Count a failure for one username, IP address or second factor. The first lock lasts BaseLockout and every failure after it
+// doubles the lock, up to MaxLockout.
+func (s *LockoutService) fail(kind, subject string, maxFailures int) error {
+	if maxFailures <= 0 || subject == "" {
+		return nil
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		lockout := models.LoginLockout{Kind: kind, Subject: subject}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&lockout).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND subject = ?", kind, subject).First(&lockout).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		lastActivity := lockout.LastFailureAt
+		if lockout.LockedUntil.After(lastActivity) {
+			lastActivity = lockout.LockedUntil
+		}
+		if now.Sub(lastActivity) > s.FailureWindow {
+			lockout.Failures = 0
+		}
+		lockout.Failures++
+		lockout.LastFailureAt = now
+		if lockout.Failures >= maxFailures {
+			lockout.LockedUntil = now.Add(s.lockDuration(lockout.Failures - maxFailures))
+		}
+		return tx.Save(&lockout).Error
+	})
+}
+
+// lockDuration - Return BaseLockout doubled the given number of times, capped at MaxLockout
+func (s *LockoutService) lockDuration(doublings int) time.Duration {
+	duration := s.BaseLockout
+	for i := 0; i < doublings && duration < s.MaxLockout; i++ {
+		duration *= 2
+	}
+	if duration > s.MaxLockout {
+		duration = s.MaxLockout
+	}
+	return duration
+}
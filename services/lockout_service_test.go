@@ -0,0 +1,193 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/models"
+	"api-service/services"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newLockoutService(t *testing.T) *services.LockoutService {
+	t.Helper()
+	return &services.LockoutService{
+		DB:                 dbtest.SQLite(t),
+		AccountMaxFailures: 3,
+		IPMaxFailures:      5,
+		MFAMaxFailures:     3,
+		BaseLockout:        time.Minute,
+		MaxLockout:         10 * time.Minute,
+		FailureWindow:      time.Hour,
+	}
+}
+
+// lockout - Return the stored count of a username, IP address or second factor
+func lockout(t *testing.T, s *services.LockoutService, kind, subject string) models.LoginLockout {
+	t.Helper()
+	var row models.LoginLockout
+	if err := s.DB.Where("kind = ? AND subject = ?", kind, subject).First(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+// lockDuration - Return the length of the lock set by the latest failure of a count, zero if it is not locked
+func lockDuration(row models.LoginLockout) time.Duration {
+	if !row.LockedUntil.After(row.LastFailureAt) {
+		return 0
+	}
+	return row.LockedUntil.Sub(row.LastFailureAt).Round(time.Second)
+}
+
+func TestLockDurationDoublesUpToTheLimit(t *testing.T) {
+	s := newLockoutService(t)
+	failures := 0
+	for _, test := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute}, // The threshold locks for BaseLockout
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute}, // Capped at MaxLockout
+		{8, 10 * time.Minute},
+		{40, 10 * time.Minute},
+	} {
+		for ; failures < test.failures; failures++ {
+			if err := s.RecordMFAFailure(7); err != nil {
+				t.Fatal(err)
+			}
+		}
+		row := lockout(t, s, models.LockoutMFA, "7")
+		if got := lockDuration(row); got != test.want {
+			t.Errorf("after %d failures: locked for %v, want %v", row.Failures, got, test.want)
+		}
+		err := s.CheckMFA(7)
+		if locked := errors.Is(err, services.ErrLoginLocked); locked != (test.want > 0) {
+			t.Errorf("after %d failures: CheckMFA returned %v", row.Failures, err)
+		}
+	}
+}
+
+func TestFailuresAreForgottenAfterTheWindow(t *testing.T) {
+	for _, test := range []struct {
+		name                   string
+		lastFailure, lockEnded time.Duration // Time since the last failure and since the end of the lock
+		want                   int           // Count after one more failure
+		locked                 bool
+	}{
+		{"within the window", 31 * time.Minute, 30 * time.Minute, 4, true},
+		{"after the window", 62 * time.Minute, 61 * time.Minute, 1, false},
+		// The window starts when the lock ends, so a long lock does not outlast the count
+		{"lock ended within the window", 3 * time.Hour, 30 * time.Minute, 4, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := newLockoutService(t)
+			for i := 0; i < s.AccountMaxFailures; i++ {
+				if err := s.RecordFailure("alice", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Move the failures and the lock into the past
+			now := time.Now()
+			if err := s.DB.Model(&models.LoginLockout{}).Where("kind = ?", models.LockoutAccount).Updates(map[string]interface{}{
+				"last_failure_at": now.Add(-test.lastFailure), "locked_until": now.Add(-test.lockEnded),
+			}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.RecordFailure("alice", ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := lockout(t, s, models.LockoutAccount, "alice").Failures; got != test.want {
+				t.Errorf("failures %d, want %d", got, test.want)
+			}
+			if err := s.Check("alice", ""); errors.Is(err, services.ErrLoginLocked) != test.locked {
+				t.Errorf("Check returned %v, want locked %v", err, test.locked)
+			}
+		})
+	}
+}
+
+func TestAccountsAndIPAddressesAreCountedApart(t *testing.T) {
+	s := newLockoutService(t)
+	record := func(username, ip string) {
+		t.Helper()
+		if err := s.RecordFailure(username, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One username guessed from several addresses locks the account, but none of the addresses
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		record("alice", ip)
+	}
+	// One address guessing several usernames locks the address, but none of the usernames
+	for _, username := range []string{"u1", "u2", "u3", "u4", "u5"} {
+		record(username, "192.0.2.1")
+	}
+
+	for _, test := range []struct {
+		username, ip string
+		locked       bool
+	}{
+		{"alice", "10.0.0.9", true},
+		{"bob", "10.0.0.1", false},
+		{"u1", "10.0.0.9", false},
+		{"bob", "192.0.2.1", true},
+	} {
+		err := s.Check(test.username, test.ip)
+		if locked := errors.Is(err, services.ErrLoginLocked); locked != test.locked {
+			t.Errorf("Check(%q, %q) returned %v, want locked %v", test.username, test.ip, err, test.locked)
+		}
+		var lockedErr *services.LockedError
+		if test.locked && (!errors.As(err, &lockedErr) || lockedErr.RetryAfter <= 0 || lockedErr.RetryAfter > time.Minute) {
+			t.Errorf("Check(%q, %q) returned %v, want a retry within a minute", test.username, test.ip, err)
+		}
+	}
+
+	// A successful login forgets the failures of the username, not those of the address
+	if err := s.RecordSuccess("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Check("alice", "10.0.0.9"); err != nil {
+		t.Errorf("Check after a successful login returned %v", err)
+	}
+	if err := s.RecordSuccess("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Check("u1", "192.0.2.1"); !errors.Is(err, services.ErrLoginLocked) {
+		t.Errorf("address unlocked by a successful login: %v", err)
+	}
+}
+
+func TestDisabledLockoutsNeverLock(t *testing.T) {
+	s := newLockoutService(t)
+	s.AccountMaxFailures, s.IPMaxFailures = 0, 0
+	for i := 0; i < 10; i++ {
+		if err := s.RecordFailure("alice", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Check("alice", "192.0.2.1"); err != nil {
+		t.Fatalf("Check returned %v", err)
+	}
+	var nilService *services.LockoutService
+	if err := nilService.RecordFailure("alice", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nilService.Check("alice", "192.0.2.1"); err != nil {
+		t.Fatalf("nil service: Check returned %v", err)
+	}
+	var rows int64
+	if err := s.DB.Model(&models.LoginLockout{}).Count(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Fatalf("%d counts stored with the lockouts disabled", rows)
+	}
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MFAChallengeTTL is the lifetime of the mfa_pending token returned by /login.
+	MFAChallengeTTL = 5 * time.Minute
+	// MaxMFAChallengeFailures is how many wrong codes a single challenge token accepts before it is revoked.
+	MaxMFAChallengeFailures = 5
+	recoveryCodeCount       = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+	ErrMFANotEnrolled    = errors.New("MFA enrollment not started")
+	ErrInvalidMFACode    = errors.New("invalid MFA code")
+	ErrInvalidMFAToken   = errors.New("invalid MFA token")
+)
+
+type MFAService struct {
+	DB          *gorm.DB
+	Issuer      string // Shown as the account issuer in authenticator apps
+	Revocations RevocationStore
+	Lockout     *LockoutService // Counts wrong codes per user across logins; nil disables the lockout
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *MFAService) WithContext(ctx context.Context) *MFAService {
+	bound := *s
+	bound.DB = s.DB.WithContext(ctx)
+	bound.Revocations = bindRevocations(s.Revocations, ctx)
+	bound.Lockout = s.Lockout.WithContext(ctx)
+	return &bound
+}
+
+// Status - Report whether the user has MFA enabled
+func (s *MFAService) Status(userID uint) (models.MFAStatus, error) {
+	var enrollment models.MFAEnrollment
+	if err := s.DB.Where("user_id = ?", userID).First(&enrollment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.MFAStatus{}, nil
+		}
+		return models.MFAStatus{}, err
+	}
+
+	var remaining int64
+	if err := s.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&remaining).Error; err != nil {
+		return models.MFAStatus{}, err
+	}
+	return models.MFAStatus{
+		Enabled:                enrollment.ConfirmedAt != nil,
+		Pending:                enrollment.ConfirmedAt == nil,
+		ConfirmedAt:            enrollment.ConfirmedAt,
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// Enabled - Report whether logins of the user require a second factor
+func (s *MFAService) Enabled(userID uint) (bool, error) {
+	status, err := s.Status(userID)
+	return status.Enabled, err
+}
+
+// StartEnrollment - Generate a new TOTP secret. Any unconfirmed enrollment is replaced.
+func (s *MFAService) StartEnrollment(user models.User) (models.MFAEnrollmentResponse, error) {
+	var enrollment models.MFAEnrollment
+	err := s.DB.Where("user_id = ?", user.ID).First(&enrollment).Error
+	if err == nil && enrollment.ConfirmedAt != nil {
+		return models.MFAEnrollmentResponse{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return models.MFAEnrollmentResponse{}, err
+	}
+	enrollment.UserID = user.ID
+	enrollment.Secret = secret
+	enrollment.LastUsedStep = 0
+	if err := s.DB.Save(&enrollment).Error; err != nil {
+		return models.MFAEnrollmentResponse{}, err
+	}
+
+	return models.MFAEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPURI(s.Issuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmEnrollment - Enable MFA once the user proves the authenticator works, and hand out recovery codes
+func (s *MFAService) ConfirmEnrollment(userID uint, code string) ([]string, error) {
+	var enrollment models.MFAEnrollment
+	if err := s.DB.Where("user_id = ?", userID).First(&enrollment).Error; err != nil {
+		return nil, ErrMFANotEnrolled
+	}
+	if enrollment.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := utils.ValidateTOTP(enrollment.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	var codes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&enrollment).Updates(map[string]interface{}{"confirmed_at": &now, "last_used_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes - Invalidate the remaining recovery codes and issue new ones. Requires a valid code,
+// checked with VerifyAttempt.
+func (s *MFAService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	if err := s.VerifyAttempt(userID, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Disable - Turn MFA off for the user. Requires a valid code, checked with VerifyAttempt.
+func (s *MFAService) Disable(userID uint, code string) error {
+	if err := s.VerifyAttempt(userID, code); err != nil {
+		return err
+	}
+	return s.Reset(userID)
+}
+
+// AdminReset - Reset the MFA of a user of the tenant. Used by admins when a user lost their authenticator.
+func (s *MFAService) AdminReset(tenant Tenant, userID uint) error {
+	if _, err := findTenantUser(s.DB, tenant, userID); err != nil {
+		return err
+	}
+	return s.Reset(userID)
+}
+
+// Reset - Remove the user's MFA enrollment and recovery codes without a code
+func (s *MFAService) Reset(userID uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.MFAEnrollment{}).Error
+	})
+}
+
+// Verify - Check a TOTP code or an unused recovery code of a user with MFA enabled
+func (s *MFAService) Verify(userID uint, code string) error {
+	var enrollment models.MFAEnrollment
+	if err := s.DB.Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&enrollment).Error; err != nil {
+		return ErrMFANotEnrolled
+	}
+
+	if step, ok := utils.ValidateTOTP(enrollment.Secret, code, time.Now()); ok {
+		// Only a code from a later time step than the last accepted one is valid, so a code cannot be replayed
+		res := s.DB.Model(&models.MFAEnrollment{}).
+			Where("id = ? AND last_used_step < ?", enrollment.ID, step).
+			Update("last_used_step", step)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	// Recovery codes are accepted with or without the dash and in any case
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	res := s.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashToken(normalized)).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// IssueChallenge - Create the mfa_pending token that /login returns instead of an access token
+func (s *MFAService) IssueChallenge(user models.User) (models.MFAChallenge, error) {
+	if err := awaitRevocationCutoff(s.Revocations, strconv.FormatUint(uint64(user.ID), 10)); err != nil {
+		return models.MFAChallenge{}, err
+	}
+	token, err := utils.GeneratePurposeJWT(user, models.MFAPending, MFAChallengeTTL)
+	if err != nil {
+		return models.MFAChallenge{}, err
+	}
+	return models.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// VerifyAttempt - Verify a code the user entered to log in or to change their second factor. Wrong codes are
+// counted per user by the lockout, so that codes cannot be brute forced by starting new logins or from a stolen
+// session; while the second factor is locked a *LockedError is returned without the code being checked.
+func (s *MFAService) VerifyAttempt(userID uint, code string) error {
+	if err := s.Lockout.CheckMFA(userID); err != nil {
+		return err
+	}
+	if err := s.Verify(userID, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if lockErr := s.Lockout.RecordMFAFailure(userID); lockErr != nil {
+				return lockErr
+			}
+		}
+		return err
+	}
+	return s.Lockout.RecordMFASuccess(userID)
+}
+
+// CompleteChallenge - Verify the mfa_pending token and the code with VerifyAttempt. The challenge token is single use,
+// and it is also revoked after MaxMFAChallengeFailures wrong codes.
+func (s *MFAService) CompleteChallenge(mfaToken, code string) (*models.User, error) {
+	claims, err := utils.ParsePurposeToken(mfaToken, models.MFAPending)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	revoked, err := s.Revocations.IsRevoked(claims.Id, claims.Subject, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidMFAToken
+	}
+
+	id, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	userID := uint(id)
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+
+	if err := s.VerifyAttempt(userID, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.recordChallengeFailure(userID, claims.Id, expiresAt)
+		}
+		return nil, err
+	}
+
+	if err := s.Revocations.RevokeToken(claims.Id, expiresAt); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.DB.First(&user, userID).Error; err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	return &user, nil
+}
+
+func (s *MFAService) recordChallengeFailure(userID uint, jti string, expiresAt time.Time) {
+	var enrollment models.MFAEnrollment
+	if err := s.DB.Where("user_id = ?", userID).First(&enrollment).Error; err != nil {
+		return
+	}
+	if enrollment.ChallengeID != jti {
+		enrollment.ChallengeID = jti
+		enrollment.ChallengeFailures = 0
+	}
+	enrollment.ChallengeFailures++
+	s.DB.Model(&enrollment).Updates(map[string]interface{}{
+		"challenge_id":       enrollment.ChallengeID,
+		"challenge_failures": enrollment.ChallengeFailures,
+	})
+	if enrollment.ChallengeFailures >= MaxMFAChallengeFailures {
+		s.Revocations.RevokeToken(jti, expiresAt)
+	}
+}
+
+func (s *MFAService) replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := base32.StdEncoding.EncodeToString(b) // 16 characters
+		codes = append(codes, raw[:8]+"-"+raw[8:])
+		records = append(records, models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(raw)})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,114 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/models"
+	"api-service/services"
+	"api-service/utils"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newMFAService(t *testing.T) (*services.MFAService, *gorm.DB) {
+	t.Helper()
+	conn := dbtest.SQLite(t)
+	return &services.MFAService{
+		DB:          conn,
+		Issuer:      "api-service",
+		Revocations: services.NewMemoryRevocationStore(),
+		Lockout: &services.LockoutService{
+			DB:             conn,
+			MFAMaxFailures: 3,
+			BaseLockout:    time.Minute,
+			MaxLockout:     time.Hour,
+			FailureWindow:  time.Hour,
+		},
+	}, conn
+}
+
+// enableMFA - Enroll the user and return a recovery code
+func enableMFA(t *testing.T, s *services.MFAService, user models.User) string {
+	t.Helper()
+	enrollment, err := s.StartEnrollment(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := utils.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := s.ConfirmEnrollment(user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recoveryCodes[0]
+}
+
+// Changing the second factor from a stolen session must not give an unlimited number of guesses
+func TestChangingMFACountsWrongCodes(t *testing.T) {
+	for name, change := range map[string]func(s *services.MFAService, userID uint, code string) error{
+		"regenerate recovery codes": func(s *services.MFAService, userID uint, code string) error {
+			_, err := s.RegenerateRecoveryCodes(userID, code)
+			return err
+		},
+		"disable": func(s *services.MFAService, userID uint, code string) error {
+			return s.Disable(userID, code)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, conn := newMFAService(t)
+			user := createUser(t, conn, "alice", "Old-Passw0rd!")
+			recoveryCode := enableMFA(t, s, user)
+
+			for i := 0; i < s.Lockout.MFAMaxFailures; i++ {
+				if err := change(s, user.ID, "000000"); !errors.Is(err, services.ErrInvalidMFACode) {
+					t.Fatalf("wrong code %d: %v, want %v", i+1, err, services.ErrInvalidMFACode)
+				}
+			}
+			// Once locked even a valid code is refused without being checked, so it is not used up
+			var locked *services.LockedError
+			if err := change(s, user.ID, recoveryCode); !errors.As(err, &locked) || locked.RetryAfter <= 0 {
+				t.Fatalf("valid code while locked: %v, want a *LockedError", err)
+			}
+			if err := s.VerifyAttempt(user.ID, recoveryCode); !errors.As(err, &locked) {
+				t.Fatalf("login while locked: %v, want a *LockedError", err)
+			}
+			if enabled, _ := s.Enabled(user.ID); !enabled {
+				t.Fatal("MFA changed while locked")
+			}
+
+			if err := s.Lockout.UnlockMFA(user.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := change(s, user.ID, recoveryCode); err != nil {
+				t.Fatalf("valid code after the lock: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidCodeForgetsWrongCodes(t *testing.T) {
+	s, conn := newMFAService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+	recoveryCode := enableMFA(t, s, user)
+
+	for i := 0; i < s.Lockout.MFAMaxFailures-1; i++ {
+		if _, err := s.RegenerateRecoveryCodes(user.ID, "000000"); !errors.Is(err, services.ErrInvalidMFACode) {
+			t.Fatal(err)
+		}
+	}
+	codes, err := s.RegenerateRecoveryCodes(user.ID, recoveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The count started over, so another wrong code does not lock
+	if err := s.Disable(user.ID, "000000"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("wrong code after a valid one: %v", err)
+	}
+	if err := s.Disable(user.ID, codes[0]); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,628 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// AuthorizationCodeTTL is how long an authorization code can be exchanged at the token endpoint.
+	AuthorizationCodeTTL = time.Minute
+	// ConsentTTL is how long the user has to answer the consent screen.
+	ConsentTTL = 10 * time.Minute
+)
+
+// scopePattern is the syntax of a single scope, e.g. "users:read"
+var scopePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// SupportedScopes are the scopes clients may request. Every scope other than openid unlocks a group of user claims.
+var SupportedScopes = []string{"openid", "profile", "email", "phone", "address"}
+
+// OAuthError is an OAuth2 error response (RFC 6749 section 5.2).
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// AuthorizeParams are the parameters of an authorization request.
+type AuthorizeParams struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationResult is either a code to return to the client or a consent challenge to show to the user.
+type AuthorizationResult struct {
+	Code             string
+	ConsentChallenge string
+}
+
+type OIDCService struct {
+	DB          *gorm.DB
+	Issuer      string
+	Revocations RevocationStore
+	RBAC        *RBACService // Resolves the roles and permissions the scopes of machine clients grant
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *OIDCService) WithContext(ctx context.Context) *OIDCService {
+	bound := *s
+	bound.DB = s.DB.WithContext(ctx)
+	bound.Revocations = bindRevocations(s.Revocations, ctx)
+	bound.RBAC = s.RBAC.WithContext(ctx)
+	return &bound
+}
+
+// RegisterClient - Register a new client in the tenant's organization. The returned secret is shown only once. The
+// scopes of a machine client must name roles or permissions, and may not grant a permission the caller registering
+// it does not hold.
+func (s *OIDCService) RegisterClient(tenant Tenant, caller *models.JWTClaims, reg models.ClientRegistration) (models.ClientRegistrationResponse, error) {
+	if strings.TrimSpace(reg.Name) == "" {
+		return models.ClientRegistrationResponse{}, oauthError("invalid_client_metadata", "client_name is required")
+	}
+	grantTypes := reg.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{models.GrantAuthorizationCode}
+	}
+	authCode := false
+	for _, grant := range grantTypes {
+		switch grant {
+		case models.GrantAuthorizationCode:
+			authCode = true
+		case models.GrantClientCredentials:
+			if reg.Public {
+				return models.ClientRegistrationResponse{}, oauthError("invalid_client_metadata", "public clients cannot use the client_credentials grant")
+			}
+		default:
+			return models.ClientRegistrationResponse{}, oauthError("invalid_client_metadata", "unsupported grant type "+grant)
+		}
+	}
+
+	if authCode && len(reg.RedirectURIs) == 0 {
+		return models.ClientRegistrationResponse{}, oauthError("invalid_redirect_uri", "at least one redirect URI is required")
+	}
+	for _, uri := range reg.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " ") {
+			return models.ClientRegistrationResponse{}, oauthError("invalid_redirect_uri", "redirect URIs must be absolute URLs without fragment")
+		}
+	}
+
+	scope := reg.Scope
+	if scope == "" && authCode {
+		scope = "openid profile email"
+	}
+	for _, sc := range strings.Fields(scope) {
+		// Sign-in clients are limited to the OpenID Connect scopes; machine clients may hold any API scope
+		if authCode && !containsScope(strings.Join(SupportedScopes, " "), sc) {
+			return models.ClientRegistrationResponse{}, oauthError("invalid_client_metadata", "unsupported scope "+sc)
+		}
+		if !scopePattern.MatchString(sc) {
+			return models.ClientRegistrationResponse{}, oauthError("invalid_client_metadata", "invalid scope "+sc)
+		}
+	}
+	if !authCode {
+		if err := s.checkGrantable(caller, strings.Fields(scope)); err != nil {
+			return models.ClientRegistrationResponse{}, err
+		}
+	}
+
+	clientID, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return models.ClientRegistrationResponse{}, err
+	}
+	client := models.RegisteredClient{
+		ClientID:       clientID,
+		Name:           reg.Name,
+		RedirectURIs:   strings.Join(reg.RedirectURIs, " "),
+		Scopes:         normalizeScope(scope),
+		GrantTypes:     strings.Join(grantTypes, " "),
+		Public:         reg.Public,
+		OrganizationID: tenant.OrganizationID,
+	}
+
+	var secret string
+	if !reg.Public {
+		if secret, err = utils.GenerateOpaqueToken(); err != nil {
+			return models.ClientRegistrationResponse{}, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return models.ClientRegistrationResponse{}, err
+		}
+		client.ClientSecretHash = string(hash)
+	}
+
+	if err := s.DB.Create(&client).Error; err != nil {
+		return models.ClientRegistrationResponse{}, err
+	}
+
+	return models.ClientRegistrationResponse{
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		Name:         client.Name,
+		RedirectURIs: reg.RedirectURIs,
+		Scope:        client.Scopes,
+		GrantTypes:   grantTypes,
+		Public:       client.Public,
+	}, nil
+}
+
+// ListClients - List the clients registered in the tenant
+func (s *OIDCService) ListClients(tenant Tenant) ([]models.RegisteredClient, error) {
+	var clients []models.RegisteredClient
+	if err := s.DB.Scopes(tenant.Scope).Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteClient - Remove a client of the tenant together with its consents and revoke the tokens it obtained with the client credentials grant
+func (s *OIDCService) DeleteClient(tenant Tenant, clientID string) error {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var client models.RegisteredClient
+		if err := tx.Scopes(tenant.Scope).Where("client_id = ?", clientID).First(&client).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", clientID).Delete(&models.Consent{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("client_id = ?", clientID).Delete(&models.RegisteredClient{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.Revocations.RevokeSubject(clientID, time.Now())
+}
+
+// GetClient - Look up a registered client by its client ID
+func (s *OIDCService) GetClient(clientID string) (*models.RegisteredClient, error) {
+	var client models.RegisteredClient
+	if err := s.DB.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// AuthenticateClient - Verify the credentials of a client. Public clients have no secret.
+func (s *OIDCService) AuthenticateClient(clientID, clientSecret string) (*models.RegisteredClient, error) {
+	client, err := s.GetClient(clientID)
+	if err != nil {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	if client.Public {
+		return client, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(client.ClientSecretHash), []byte(clientSecret)) != nil {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+/*
+ValidateAuthorizeRequest checks an authorization request. The client is returned as soon as the client ID and redirect URI
+are known to be valid: from then on errors can be reported to the client by redirecting to its redirect URI.
+*/
+func (s *OIDCService) ValidateAuthorizeRequest(p AuthorizeParams) (*models.RegisteredClient, error) {
+	client, err := s.GetClient(p.ClientID)
+	if err != nil {
+		return nil, oauthError("invalid_request", "unknown client_id")
+	}
+	if !containsScope(client.RedirectURIs, p.RedirectURI) {
+		return nil, oauthError("invalid_request", "redirect_uri is not registered for this client")
+	}
+	if !containsScope(client.GrantTypes, models.GrantAuthorizationCode) {
+		return client, oauthError("unauthorized_client", "the client may not use the authorization code flow")
+	}
+
+	if p.ResponseType != "code" {
+		return client, oauthError("unsupported_response_type", "only the code response type is supported")
+	}
+	if !containsScope(p.Scope, "openid") {
+		return client, oauthError("invalid_scope", "the openid scope is required")
+	}
+	for _, sc := range strings.Fields(p.Scope) {
+		if !containsScope(client.Scopes, sc) {
+			return client, oauthError("invalid_scope", "scope "+sc+" is not allowed for this client")
+		}
+	}
+	if p.CodeChallengeMethod != "S256" || len(p.CodeChallenge) < 43 {
+		return client, oauthError("invalid_request", "PKCE with code_challenge_method S256 is required")
+	}
+	return client, nil
+}
+
+// Authorize - Start an authorization for an authenticated user. If the user already consented to the requested
+// scopes a code is issued right away, otherwise a consent challenge is returned.
+func (s *OIDCService) Authorize(p AuthorizeParams, user *models.User) (AuthorizationResult, error) {
+	challenge, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return AuthorizationResult{}, err
+	}
+	req := models.AuthorizationRequest{
+		ChallengeHash:       utils.HashToken(challenge),
+		ClientID:            p.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         p.RedirectURI,
+		Scope:               normalizeScope(p.Scope),
+		State:               p.State,
+		Nonce:               p.Nonce,
+		CodeChallenge:       p.CodeChallenge,
+		CodeChallengeMethod: p.CodeChallengeMethod,
+		AuthTime:            time.Now(),
+		ExpiresAt:           time.Now().Add(ConsentTTL),
+	}
+
+	var consent models.Consent
+	err = s.DB.Where("user_id = ? AND client_id = ?", user.ID, p.ClientID).First(&consent).Error
+	consented := err == nil && coversScope(consent.Scope, req.Scope)
+
+	if !consented {
+		if err := s.DB.Create(&req).Error; err != nil {
+			return AuthorizationResult{}, err
+		}
+		return AuthorizationResult{ConsentChallenge: challenge}, nil
+	}
+
+	code, err := s.attachCode(&req)
+	if err != nil {
+		return AuthorizationResult{}, err
+	}
+	if err := s.DB.Create(&req).Error; err != nil {
+		return AuthorizationResult{}, err
+	}
+	return AuthorizationResult{Code: code}, nil
+}
+
+// PendingConsent - Look up the authorization request behind a consent challenge
+func (s *OIDCService) PendingConsent(challenge string) (*models.AuthorizationRequest, *models.RegisteredClient, error) {
+	var req models.AuthorizationRequest
+	err := s.DB.Where("challenge_hash = ? AND code_hash IS NULL AND used_at IS NULL", utils.HashToken(challenge)).First(&req).Error
+	if err != nil || time.Now().After(req.ExpiresAt) {
+		return nil, nil, oauthError("invalid_request", "unknown or expired consent challenge")
+	}
+	client, err := s.GetClient(req.ClientID)
+	if err != nil {
+		return nil, nil, oauthError("invalid_request", "unknown client")
+	}
+	return &req, client, nil
+}
+
+// DecideConsent - Record the user's consent decision. On approval the consent is stored and a code is issued.
+func (s *OIDCService) DecideConsent(challenge string, approve bool) (*models.AuthorizationRequest, string, error) {
+	req, _, err := s.PendingConsent(challenge)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !approve {
+		now := time.Now()
+		s.DB.Model(req).Update("used_at", &now)
+		return req, "", oauthError("access_denied", "the user denied the request")
+	}
+
+	code, err := s.attachCode(req)
+	if err != nil {
+		return nil, "", err
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.AuthorizationRequest{}).
+			Where("id = ? AND code_hash IS NULL", req.ID).
+			Updates(map[string]interface{}{"code_hash": req.CodeHash, "expires_at": req.ExpiresAt})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return oauthError("invalid_request", "consent was already given")
+		}
+
+		var consent models.Consent
+		if err := tx.Where("user_id = ? AND client_id = ?", req.UserID, req.ClientID).First(&consent).Error; err == nil {
+			req.Scope = normalizeScope(consent.Scope + " " + req.Scope)
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+		}).Create(&models.Consent{UserID: req.UserID, ClientID: req.ClientID, Scope: req.Scope}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return req, code, nil
+}
+
+// ExchangeCode - Redeem an authorization code for an access token and an ID token
+func (s *OIDCService) ExchangeCode(client *models.RegisteredClient, code, redirectURI, codeVerifier string) (models.OAuthTokenResponse, error) {
+	if !containsScope(client.GrantTypes, models.GrantAuthorizationCode) {
+		return models.OAuthTokenResponse{}, oauthError("unauthorized_client", "the client may not use the authorization_code grant")
+	}
+	var req models.AuthorizationRequest
+	if err := s.DB.Where("code_hash = ?", utils.HashToken(code)).First(&req).Error; err != nil {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "unknown authorization code")
+	}
+
+	// Codes are single use. The used_at condition makes concurrent exchanges race on the row.
+	res := s.DB.Model(&models.AuthorizationRequest{}).
+		Where("id = ? AND used_at IS NULL", req.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return models.OAuthTokenResponse{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "authorization code already used")
+	}
+
+	if time.Now().After(req.ExpiresAt) {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "authorization code expired")
+	}
+	if req.ClientID != client.ClientID {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "authorization code was issued to another client")
+	}
+	if req.RedirectURI != redirectURI {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+	if !verifyPKCE(req.CodeChallenge, codeVerifier) {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "code_verifier does not match the code_challenge")
+	}
+
+	var user models.User
+	if err := s.DB.First(&user, req.UserID).Error; err != nil {
+		return models.OAuthTokenResponse{}, oauthError("invalid_grant", "user no longer exists")
+	}
+	if err := loadRoles(s.DB, &user); err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+
+	if err := awaitRevocationCutoff(s.Revocations, strconv.FormatUint(uint64(user.ID), 10)); err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	accessToken, err := utils.GenerateClientJWT(user, client.ClientID, req.Scope)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	idToken, err := s.idToken(user, &req)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+
+	return models.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(utils.AccessTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       req.Scope,
+	}, nil
+}
+
+// ClientCredentials - Issue an access token to a client acting on its own behalf. Without a requested scope the
+// token carries every scope the client is allowed.
+func (s *OIDCService) ClientCredentials(client *models.RegisteredClient, scope string) (models.OAuthTokenResponse, error) {
+	if !containsScope(client.GrantTypes, models.GrantClientCredentials) || client.Public {
+		return models.OAuthTokenResponse{}, oauthError("unauthorized_client", "the client may not use the client_credentials grant")
+	}
+	if scope == "" {
+		scope = client.Scopes
+	}
+	scope = normalizeScope(scope)
+	if !coversScope(client.Scopes, scope) {
+		return models.OAuthTokenResponse{}, oauthError("invalid_scope", "the requested scope exceeds the scopes allowed for this client")
+	}
+
+	if err := awaitRevocationCutoff(s.Revocations, client.ClientID); err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	accessToken, err := utils.GenerateServiceJWT(client.ClientID, scope, client.OrganizationID)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+
+	return models.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(utils.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// UserInfo - Return the claims about the user that the access token's scopes allow
+func (s *OIDCService) UserInfo(claims *models.JWTClaims) (map[string]interface{}, error) {
+	if claims.IsClientToken() || !containsScope(claims.Scope, "openid") {
+		return nil, oauthError("insufficient_scope", "the access token does not carry the openid scope")
+	}
+	id, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	var user models.User
+	if err := s.DB.First(&user, uint(id)).Error; err != nil {
+		return nil, oauthError("invalid_token", "unknown user")
+	}
+	return userClaims(user, claims.Scope), nil
+}
+
+// ListConsents - List the clients a user has granted access to
+func (s *OIDCService) ListConsents(userID uint) ([]models.Consent, error) {
+	var consents []models.Consent
+	if err := s.DB.Where("user_id = ?", userID).Find(&consents).Error; err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// RevokeConsent - Withdraw a consent; the consent screen is shown again on the next sign in
+func (s *OIDCService) RevokeConsent(userID uint, clientID string) error {
+	return s.DB.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&models.Consent{}).Error
+}
+
+// Discovery - Build the OpenID Provider metadata document
+func (s *OIDCService) Discovery(signingAlg string) map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/oauth/authorize",
+		"token_endpoint":                        s.Issuer + "/oauth/token",
+		"userinfo_endpoint":                     s.Issuer + "/userinfo",
+		"jwks_uri":                              s.Issuer + "/.well-known/jwks.json",
+		"registration_endpoint":                 s.Issuer + "/api/admin/clients",
+		"scopes_supported":                      SupportedScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{models.GrantAuthorizationCode, models.GrantClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{signingAlg},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "azp",
+			"name", "preferred_username", "email", "phone_number", "address",
+		},
+	}
+}
+
+// checkGrantable - Check that the caller may give a machine client the scopes: each must name a role or a
+// permission, and together they may only grant permissions the caller holds
+func (s *OIDCService) checkGrantable(caller *models.JWTClaims, scopes []string) error {
+	granted, unknown, err := s.RBAC.ScopePermissions(scopes)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return oauthError("invalid_client_metadata", "unknown scope "+unknown[0]+", expected a role or a permission")
+	}
+	held, err := s.RBAC.CallerPermissions(caller)
+	if err != nil {
+		return err
+	}
+	for _, perm := range granted {
+		if !containsRole(held, perm) {
+			return oauthError("invalid_client_metadata", "the scope grants the permission "+perm+", which the caller does not hold")
+		}
+	}
+	return nil
+}
+
+func (s *OIDCService) attachCode(req *models.AuthorizationRequest) (string, error) {
+	code, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	hash := utils.HashToken(code)
+	req.CodeHash = &hash
+	req.ExpiresAt = time.Now().Add(AuthorizationCodeTTL)
+	return code, nil
+}
+
+func (s *OIDCService) idToken(user models.User, req *models.AuthorizationRequest) (string, error) {
+	now := time.Now()
+	claims := &models.IDTokenClaims{
+		Nonce:           req.Nonce,
+		AuthTime:        req.AuthTime.Unix(),
+		AuthorizedParty: req.ClientID,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    s.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  req.ClientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(utils.AccessTokenTTL).Unix(),
+		},
+	}
+	if containsScope(req.Scope, "profile") {
+		claims.Name = user.Name
+		claims.PreferredUsername = user.Username
+	}
+	if containsScope(req.Scope, "email") {
+		claims.Email = user.Email
+	}
+	return utils.SignClaims(claims)
+}
+
+func userClaims(user models.User, scope string) map[string]interface{} {
+	info := map[string]interface{}{
+		"sub": strconv.FormatUint(uint64(user.ID), 10),
+	}
+	if containsScope(scope, "profile") {
+		info["name"] = user.Name
+		info["preferred_username"] = user.Username
+	}
+	if containsScope(scope, "email") {
+		info["email"] = user.Email
+	}
+	if containsScope(scope, "phone") && user.Mobile != "" {
+		info["phone_number"] = user.Mobile
+	}
+	if containsScope(scope, "address") && user.Address != "" {
+		info["address"] = map[string]string{"formatted": user.Address}
+	}
+	return info
+}
+
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// containsScope reports whether a space separated list contains the value.
+func containsScope(list, value string) bool {
+	for _, item := range strings.Fields(list) {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// coversScope reports whether every scope in requested is part of granted.
+func coversScope(granted, requested string) bool {
+	for _, sc := range strings.Fields(requested) {
+		if !containsScope(granted, sc) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeScope removes duplicate scopes while keeping their order.
+func normalizeScope(scope string) string {
+	var out []string
+	for _, sc := range strings.Fields(scope) {
+		if !containsScope(strings.Join(out, " "), sc) {
+			out = append(out, sc)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// IsOAuthError reports whether err is an OAuth2 protocol error and returns it.
+func IsOAuthError(err error) (*OAuthError, bool) {
+	var oerr *OAuthError
+	ok := errors.As(err, &oerr)
+	return oerr, ok
+}
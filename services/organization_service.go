@@ -0,0 +1,154 @@
+package services
+
+import (
+	"api-service/models"
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOrganizationNotFound    = errors.New("organization not found")
+	ErrOrganizationExists      = errors.New("an organization with this slug already exists")
+	ErrInvalidOrganizationSlug = errors.New("organization slugs may only contain lowercase letters, digits and '-'")
+	ErrOrganizationNameMissing = errors.New("organization name is required")
+	ErrOrganizationNotEmpty    = errors.New("organizations with users cannot be deleted")
+	ErrDefaultOrganization     = errors.New("the default organization cannot be deleted")
+	ErrCrossTenant             = errors.New("only super admins can act across organizations")
+)
+
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9-]{1,64}$`)
+
+// OrganizationService manages the organizations users belong to. Only super admins use it.
+type OrganizationService struct {
+	DB *gorm.DB
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *OrganizationService) WithContext(ctx context.Context) *OrganizationService {
+	return &OrganizationService{DB: s.DB.WithContext(ctx)}
+}
+
+// Seed - Create the default organization and move users, clients and invitations from before organizations into it
+func (s *OrganizationService) Seed() error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		org, err := defaultOrganization(tx)
+		if err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.User{}, &models.RegisteredClient{}, &models.AdminInvitation{}} {
+			if err := tx.Model(model).
+				Where("organization_id = 0 OR organization_id IS NULL").
+				Update("organization_id", org.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListOrganizations - Return every organization
+func (s *OrganizationService) ListOrganizations() ([]models.Organization, error) {
+	var orgs []models.Organization
+	if err := s.DB.Order("slug").Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// CreateOrganization - Create an organization. Its first admin is added by invitation.
+func (s *OrganizationService) CreateOrganization(req models.OrganizationRequest) (models.Organization, error) {
+	org := models.Organization{Name: strings.TrimSpace(req.Name), Slug: strings.TrimSpace(req.Slug)}
+	if org.Name == "" {
+		return models.Organization{}, ErrOrganizationNameMissing
+	}
+	if !organizationSlugPattern.MatchString(org.Slug) {
+		return models.Organization{}, ErrInvalidOrganizationSlug
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Organization{}).Where("slug = ?", org.Slug).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrOrganizationExists
+		}
+		return tx.Create(&org).Error
+	})
+	if err != nil {
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+// DeleteOrganization - Delete an organization without users, together with its pending invitations and clients
+func (s *OrganizationService) DeleteOrganization(id uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var org models.Organization
+		if err := tx.First(&org, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrganizationNotFound
+			}
+			return err
+		}
+		if org.Slug == models.DefaultOrganizationSlug {
+			return ErrDefaultOrganization
+		}
+		var users int64
+		if err := tx.Model(&models.User{}).Where("organization_id = ?", id).Count(&users).Error; err != nil {
+			return err
+		}
+		if users > 0 {
+			return ErrOrganizationNotEmpty
+		}
+		var clients []string
+		if err := tx.Model(&models.RegisteredClient{}).Where("organization_id = ?", id).Pluck("client_id", &clients).Error; err != nil {
+			return err
+		}
+		if len(clients) > 0 {
+			if err := tx.Where("client_id IN ?", clients).Delete(&models.Consent{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("client_id IN ?", clients).Delete(&models.RegisteredClient{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.AdminInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&org).Error
+	})
+}
+
+// checkOrganization - Resolve the organization a tenant asks to act in. Zero means the tenant's own organization;
+// only super admins may name another one.
+func checkOrganization(tx *gorm.DB, tenant Tenant, id uint) (uint, error) {
+	if id == 0 || id == tenant.OrganizationID {
+		return tenant.OrganizationID, nil
+	}
+	if !tenant.CrossTenant {
+		return 0, ErrCrossTenant
+	}
+	var org models.Organization
+	if err := tx.First(&org, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrOrganizationNotFound
+		}
+		return 0, err
+	}
+	return org.ID, nil
+}
+
+// defaultOrganization - Return the default organization, creating it if needed
+func defaultOrganization(tx *gorm.DB) (models.Organization, error) {
+	org := models.Organization{Name: "Default", Slug: models.DefaultOrganizationSlug}
+	if err := tx.Where(models.Organization{Slug: org.Slug}).Attrs(org).FirstOrCreate(&org).Error; err != nil {
+		return models.Organization{}, err
+	}
+	return org, nil
+}
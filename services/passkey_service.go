@@ -0,0 +1,280 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"api-service/webauthn"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasskeySessionTTL is how long a registration or login ceremony may take between its begin and finish requests.
+const PasskeySessionTTL = 5 * time.Minute
+
+var (
+	ErrInvalidPasskeySession = errors.New("invalid or expired passkey session")
+	ErrInvalidPasskey        = errors.New("passkey verification failed")
+	ErrPasskeyExists         = errors.New("passkey is already registered")
+	ErrPasskeyNotFound       = errors.New("passkey not found")
+)
+
+type PasskeyService struct {
+	DB       *gorm.DB
+	WebAuthn *webauthn.Config
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *PasskeyService) WithContext(ctx context.Context) *PasskeyService {
+	return &PasskeyService{DB: s.DB.WithContext(ctx), WebAuthn: s.WebAuthn}
+}
+
+// BeginRegistration - Create the options for registering a new passkey of the user
+func (s *PasskeyService) BeginRegistration(user models.User) (models.PasskeyRegistrationOptions, error) {
+	existing, err := s.List(user.ID)
+	if err != nil {
+		return models.PasskeyRegistrationOptions{}, err
+	}
+
+	displayName := user.Name
+	if displayName == "" {
+		displayName = user.Username
+	}
+	options, err := s.WebAuthn.NewCreationOptions(webauthn.UserEntity{
+		ID:          userHandle(user.ID),
+		Name:        user.Username,
+		DisplayName: displayName,
+	}, descriptors(existing))
+	if err != nil {
+		return models.PasskeyRegistrationOptions{}, err
+	}
+
+	sessionID, err := s.startSession(models.PasskeyRegistration, user.ID, options.Challenge)
+	if err != nil {
+		return models.PasskeyRegistrationOptions{}, err
+	}
+	return models.PasskeyRegistrationOptions{SessionID: sessionID, PublicKey: options}, nil
+}
+
+// FinishRegistration - Verify the authenticator's response and store the new passkey
+func (s *PasskeyService) FinishRegistration(userID uint, req models.PasskeyRegistrationRequest) (*models.WebAuthnCredential, error) {
+	session, err := s.consumeSession(req.SessionID, models.PasskeyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, ErrInvalidPasskeySession
+	}
+
+	verified, err := s.WebAuthn.VerifyRegistration(req.Credential, session.Challenge)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	credentialID := webauthn.EncodeID(verified.ID)
+	var count int64
+	if err := s.DB.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", credentialID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrPasskeyExists
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	credential := models.WebAuthnCredential{
+		UserID:         userID,
+		CredentialID:   credentialID,
+		PublicKey:      verified.PublicKey,
+		Algorithm:      verified.Algorithm,
+		SignCount:      verified.SignCount,
+		Transports:     strings.Join(verified.Transports, " "),
+		AAGUID:         hex.EncodeToString(verified.AAGUID),
+		Name:           name,
+		BackupEligible: verified.BackupEligible,
+		BackupState:    verified.BackupState,
+	}
+	if err := s.DB.Create(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// BeginLogin - Create the options for logging in with a passkey. With a username, the user's passkeys are listed as
+// allowed credentials; without one the browser offers any discoverable passkey. Unknown usernames get the same
+// response as a login without username, so the endpoint does not reveal which accounts exist.
+func (s *PasskeyService) BeginLogin(username string) (models.PasskeyLoginOptions, error) {
+	var userID uint
+	var allowed []webauthn.CredentialDescriptor
+	if username != "" {
+		var user models.User
+		if err := s.DB.Where("username = ?", username).First(&user).Error; err == nil {
+			existing, err := s.List(user.ID)
+			if err != nil {
+				return models.PasskeyLoginOptions{}, err
+			}
+			if len(existing) > 0 {
+				userID = user.ID
+				allowed = descriptors(existing)
+			}
+		}
+	}
+
+	options, err := s.WebAuthn.NewRequestOptions(allowed)
+	if err != nil {
+		return models.PasskeyLoginOptions{}, err
+	}
+	sessionID, err := s.startSession(models.PasskeyLogin, userID, options.Challenge)
+	if err != nil {
+		return models.PasskeyLoginOptions{}, err
+	}
+	return models.PasskeyLoginOptions{SessionID: sessionID, PublicKey: options}, nil
+}
+
+// FinishLogin - Verify an assertion and return the user it belongs to, and whether the authenticator verified the
+// user (PIN or biometrics) in addition to user presence
+func (s *PasskeyService) FinishLogin(req models.PasskeyLoginRequest) (*models.User, bool, error) {
+	session, err := s.consumeSession(req.SessionID, models.PasskeyLogin)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rawID, err := webauthn.CredentialID(req.Credential.RawID)
+	if err != nil {
+		return nil, false, ErrInvalidPasskey
+	}
+	var credential models.WebAuthnCredential
+	if err := s.DB.Where("credential_id = ?", webauthn.EncodeID(rawID)).First(&credential).Error; err != nil {
+		return nil, false, ErrInvalidPasskey
+	}
+	// The credential must belong to the user the ceremony was started for, or to the user the authenticator names
+	if session.UserID != 0 && credential.UserID != session.UserID {
+		return nil, false, ErrInvalidPasskey
+	}
+	if req.Credential.Response.UserHandle != "" && req.Credential.Response.UserHandle != userHandle(credential.UserID) {
+		return nil, false, ErrInvalidPasskey
+	}
+
+	verified, err := s.WebAuthn.VerifyAssertion(req.Credential, session.Challenge, webauthn.Credential{
+		ID:        rawID,
+		PublicKey: credential.PublicKey,
+		Algorithm: credential.Algorithm,
+		SignCount: credential.SignCount,
+	})
+	if err != nil {
+		return nil, false, ErrInvalidPasskey
+	}
+
+	// The sign_count condition makes concurrent logins with a replayed counter race on the row
+	now := time.Now()
+	res := s.DB.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ?", credential.ID, credential.SignCount).
+		Updates(map[string]interface{}{
+			"sign_count":   verified.SignCount,
+			"backup_state": verified.BackupState,
+			"last_used_at": now,
+		})
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, false, ErrInvalidPasskey
+	}
+
+	var user models.User
+	if err := s.DB.First(&user, credential.UserID).Error; err != nil {
+		return nil, false, ErrInvalidPasskey
+	}
+	return &user, verified.UserVerified, nil
+}
+
+// List - List the passkeys of a user
+func (s *PasskeyService) List(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := s.DB.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+// Delete - Remove a passkey of a user
+func (s *PasskeyService) Delete(userID, id uint) error {
+	res := s.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (s *PasskeyService) startSession(kind string, userID uint, challenge string) (string, error) {
+	// Drop sessions of abandoned ceremonies
+	if err := s.DB.Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnSession{}).Error; err != nil {
+		return "", err
+	}
+
+	sessionID, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	session := models.WebAuthnSession{
+		SessionHash: utils.HashToken(sessionID),
+		Kind:        kind,
+		UserID:      userID,
+		Challenge:   challenge,
+		ExpiresAt:   time.Now().Add(PasskeySessionTTL),
+	}
+	if err := s.DB.Create(&session).Error; err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// consumeSession marks a session as used. The used_at condition makes concurrent finish requests race on the row,
+// so a challenge can only be answered once.
+func (s *PasskeyService) consumeSession(sessionID, kind string) (*models.WebAuthnSession, error) {
+	now := time.Now()
+	hash := utils.HashToken(sessionID)
+	res := s.DB.Model(&models.WebAuthnSession{}).
+		Where("session_hash = ? AND kind = ? AND used_at IS NULL AND expires_at > ?", hash, kind, now).
+		Update("used_at", now)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, ErrInvalidPasskeySession
+	}
+
+	var session models.WebAuthnSession
+	if err := s.DB.Where("session_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// userHandle is the WebAuthn user.id of a user: the user ID as 8 big endian bytes, which carries no personal data.
+func userHandle(userID uint) string {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return webauthn.EncodeID(handle)
+}
+
+func descriptors(credentials []models.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	list := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, c := range credentials {
+		list = append(list, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         c.CredentialID,
+			Transports: strings.Fields(c.Transports),
+		})
+	}
+	return list
+}
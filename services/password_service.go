@@ -0,0 +1,208 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// PasswordResetTTL is how long a password reset link stays valid.
+	PasswordResetTTL = 30 * time.Minute
+	// MinPasswordLength is the minimum length of a password set through a reset.
+	MinPasswordLength = 8
+	// resetQueueSize is how many reset requests can wait for the background worker before new ones are dropped.
+	resetQueueSize = 256
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrWeakPassword      = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+)
+
+type PasswordService struct {
+	DB          *gorm.DB
+	Mailer      Mailer
+	Tokens      *TokenService
+	Revocations RevocationStore
+	ResetURL    string // Page the emailed link points to; the token is appended as the "token" query parameter
+
+	queue chan string // Addresses waiting for the worker started by Start; nil while it is not running
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled. The copy queues reset requests for the worker of the service, which runs outside any request.
+func (s *PasswordService) WithContext(ctx context.Context) *PasswordService {
+	bound := *s
+	bound.DB = s.DB.WithContext(ctx)
+	bound.Tokens = s.Tokens.WithContext(ctx)
+	bound.Revocations = bindRevocations(s.Revocations, ctx)
+	return &bound
+}
+
+// Start - Handle reset requests in a background worker, so that RequestReset returns as fast for an unknown
+// address as for the address of an account, whose link has to be stored and emailed. Call the returned function to
+// stop the worker; it handles the queued requests until ctx is done.
+func (s *PasswordService) Start() (stop func(ctx context.Context) error) {
+	queue := make(chan string, resetQueueSize)
+	done := make(chan struct{})
+	s.queue = queue
+	go func() {
+		defer close(done)
+		for email := range queue {
+			if err := s.sendReset(email); err != nil {
+				logger.Error("Failed to handle a password reset request", "error", err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func(ctx context.Context) error {
+		once.Do(func() { close(queue) })
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RequestReset - Email a password reset link to the user with the given address. Unknown addresses are ignored
+// without an error, so callers cannot use the endpoint to find out which addresses have an account. Once Start has
+// been called the request is only queued, so that neither the outcome nor the timing of the call depends on the
+// address; without it the link is stored and sent before returning.
+func (s *PasswordService) RequestReset(email string) error {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil
+	}
+	if s.queue == nil {
+		return s.sendReset(email)
+	}
+	select {
+	case s.queue <- email:
+	default:
+		logger.Warn("Dropped a password reset request, the queue is full")
+	}
+	return nil
+}
+
+// sendReset - Store a reset token for the user with the given address and email them the link. Unknown addresses
+// are ignored.
+func (s *PasswordService) sendReset(email string) error {
+	var user models.User
+	if err := s.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the latest link works
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(PasswordResetTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"a password reset was requested for your account. Open the link below to choose a new password:\n\n"+
+			"%s\n\n"+
+			"The link expires in %d minutes and can be used once. If you did not request a reset, you can ignore this email.\n",
+			user.Username, s.resetLink(token), int(PasswordResetTTL.Minutes())),
+	}
+	// Delivery failures are logged rather than returned, so that the response does not depend on whether the
+	// address belongs to an account
+	if err := s.Mailer.Send(msg); err != nil {
+		logger.Error("Failed to send password reset email", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+// ResetPassword - Set a new password with a reset token. The token is consumed, and every access and refresh
+// token of the user is revoked so that sessions started with the old password end.
+func (s *PasswordService) ResetPassword(token, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	var userID uint
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var reset models.PasswordResetToken
+		if err := tx.Where("token_hash = ?", utils.HashToken(token)).First(&reset).Error; err != nil {
+			return ErrInvalidResetToken
+		}
+
+		// The used_at condition makes concurrent resets with the same token race on the row, so only one of
+		// them can succeed
+		now := time.Now()
+		res := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", reset.ID, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return ErrInvalidResetToken
+		}
+
+		res = tx.Model(&models.User{}).Where("id = ?", reset.UserID).
+			Updates(map[string]interface{}{"password": string(hashedPassword), "token": ""})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return ErrInvalidResetToken
+		}
+		userID = reset.UserID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.Revocations.RevokeSubject(strconv.FormatUint(uint64(userID), 10), time.Now()); err != nil {
+		return err
+	}
+	return s.Tokens.RevokeUserTokens(userID)
+}
+
+func (s *PasswordService) resetLink(token string) string {
+	separator := "?"
+	if strings.Contains(s.ResetURL, "?") {
+		separator = "&"
+	}
+	return s.ResetURL + separator + "token=" + url.QueryEscape(token)
+}
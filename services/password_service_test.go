@@ -0,0 +1,170 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/models"
+	"api-service/services"
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var resetLink = regexp.MustCompile(`https://app\.example\.com/reset\?token=(\S+)`)
+
+func newPasswordService(t *testing.T, mailer services.Mailer) (*services.PasswordService, *gorm.DB) {
+	t.Helper()
+	conn := dbtest.SQLite(t)
+	revocations := services.NewMemoryRevocationStore()
+	return &services.PasswordService{
+		DB:          conn,
+		Mailer:      mailer,
+		Tokens:      &services.TokenService{DB: conn, Revocations: revocations},
+		Revocations: revocations,
+		ResetURL:    "https://app.example.com/reset",
+	}, conn
+}
+
+func createUser(t *testing.T, conn *gorm.DB, username, password string) models.User {
+	t.Helper()
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Username: username, Email: username + "@example.com", Password: string(hashed)}
+	if err := conn.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// resetToken - Return the token of the latest reset link emailed to the address
+func resetToken(t *testing.T, mailer *services.MemoryMailer, to string) string {
+	t.Helper()
+	msg, ok := mailer.Last(to)
+	if !ok {
+		t.Fatalf("no email to %s", to)
+	}
+	match := resetLink.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no reset link in %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPasswordReset(t *testing.T) {
+	mailer := services.NewMemoryMailer()
+	s, conn := newPasswordService(t, mailer)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+
+	if err := s.RequestReset(" alice@example.com "); err != nil {
+		t.Fatal(err)
+	}
+	first := resetToken(t, mailer, user.Email)
+	if err := s.RequestReset(user.Email); err != nil {
+		t.Fatal(err)
+	}
+	token := resetToken(t, mailer, user.Email)
+
+	if err := s.ResetPassword(first, "New-Passw0rd!"); !errors.Is(err, services.ErrInvalidResetToken) {
+		t.Fatalf("superseded link: error %v, want %v", err, services.ErrInvalidResetToken)
+	}
+	if err := s.ResetPassword(token, "short"); !errors.Is(err, services.ErrWeakPassword) {
+		t.Fatalf("weak password: error %v, want %v", err, services.ErrWeakPassword)
+	}
+	if err := s.ResetPassword(token, "New-Passw0rd!"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if err := s.ResetPassword(token, "Other-Passw0rd!"); !errors.Is(err, services.ErrInvalidResetToken) {
+		t.Fatalf("reused link: error %v, want %v", err, services.ErrInvalidResetToken)
+	}
+
+	var stored models.User
+	if err := conn.First(&stored, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("New-Passw0rd!")) != nil {
+		t.Fatal("the new password was not stored")
+	}
+	revoked, err := s.Revocations.IsRevoked("jti", strconv.FormatUint(uint64(user.ID), 10), time.Now().Add(-time.Minute))
+	if err != nil || !revoked {
+		t.Fatalf("tokens issued before the reset: revoked %v, %v", revoked, err)
+	}
+}
+
+func TestPasswordResetIgnoresUnknownAddresses(t *testing.T) {
+	mailer := services.NewMemoryMailer()
+	s, conn := newPasswordService(t, mailer)
+	createUser(t, conn, "alice", "Old-Passw0rd!")
+
+	for _, email := range []string{"", "bob@example.com"} {
+		if err := s.RequestReset(email); err != nil {
+			t.Fatalf("RequestReset(%q): %v", email, err)
+		}
+	}
+	if msgs := mailer.Messages(); len(msgs) != 0 {
+		t.Fatalf("sent %d emails for unknown addresses", len(msgs))
+	}
+	var count int64
+	conn.Model(&models.PasswordResetToken{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("stored %d reset tokens for unknown addresses", count)
+	}
+}
+
+// blockingMailer holds every message until release is closed
+type blockingMailer struct {
+	*services.MemoryMailer
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(msg services.Message) error {
+	<-m.release
+	return m.MemoryMailer.Send(msg)
+}
+
+func TestPasswordResetIsSentInTheBackground(t *testing.T) {
+	mailer := &blockingMailer{MemoryMailer: services.NewMemoryMailer(), release: make(chan struct{})}
+	s, conn := newPasswordService(t, mailer)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+	stop := s.Start()
+
+	// The request returns while the email is still being delivered, as fast as for an unknown address
+	returned := make(chan error, 1)
+	go func() { returned <- s.RequestReset(user.Email) }()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RequestReset waited for the email to be delivered")
+	}
+	if err := s.RequestReset("bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stopping delivers the queued requests
+	close(mailer.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stop(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if msgs := mailer.Messages(); len(msgs) != 1 || msgs[0].To != user.Email {
+		t.Fatalf("sent %+v, want one email to %s", msgs, user.Email)
+	}
+	if err := s.ResetPassword(resetToken(t, mailer.MemoryMailer, user.Email), "New-Passw0rd!"); err != nil {
+		t.Fatalf("reset with the emailed link: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/policy"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Actions the controllers ask the policies about
+const (
+	ActionProfileRead   = "profile:read"
+	ActionProfileUpdate = "profile:update"
+	ActionUsersRead     = "users:read"
+	ActionUsersDelete   = "users:delete"
+)
+
+var (
+	ErrPolicyNotFound    = errors.New("policy not found")
+	ErrPoliciesReadOnly  = errors.New("policies are loaded from POLICY_FILE and cannot be changed over the API")
+	ErrPolicyDenied      = errors.New("denied by policy")
+	ErrInvalidPolicyName = errors.New("policy name in the body does not match the URL")
+	ErrInvalidPolicy     = errors.New("invalid policy")
+)
+
+// defaultPolicies are stored in an empty policies table. They keep the behavior of the routes from before
+// policies: users manage their own profile, and holders of a permission may use it on any user.
+var defaultPolicies = []models.Policy{
+	{
+		Name:        "own-profile",
+		Description: "Users read and update their own profile",
+		Effect:      models.PolicyAllow,
+		Priority:    10,
+		Actions:     []string{ActionProfileRead, ActionProfileUpdate},
+		Conditions:  []models.PolicyCondition{{Attribute: "resource.id", Operator: policy.OpEquals, Ref: "subject.id"}},
+	},
+	{
+		Name:        "permission-holders",
+		Description: "Holders of a permission may use it on every user",
+		Effect:      models.PolicyAllow,
+		Priority:    100,
+		Actions:     []string{ActionUsersRead, ActionUsersDelete},
+		Conditions:  []models.PolicyCondition{{Attribute: "subject.permissions", Operator: policy.OpContains, Ref: "action"}},
+	},
+}
+
+// PolicyService loads the attribute-based policies and evaluates them for the controllers. Policies come from
+// File when it is set, otherwise from the policies table.
+type PolicyService struct {
+	DB   *gorm.DB
+	RBAC *RBACService
+	File string // JSON file with an array of policies; when set, the policies table is not used
+
+	once   sync.Once
+	loaded *loadedPolicies
+}
+
+// loadedPolicies holds the policies in force, shared with the copies WithContext returns
+type loadedPolicies struct {
+	mu       sync.RWMutex
+	policies []models.Policy
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *PolicyService) WithContext(ctx context.Context) *PolicyService {
+	return &PolicyService{DB: s.DB.WithContext(ctx), RBAC: s.RBAC.WithContext(ctx), File: s.File, loaded: s.inForce()}
+}
+
+// inForce - Return the policies in force, creating the empty set on first use
+func (s *PolicyService) inForce() *loadedPolicies {
+	s.once.Do(func() {
+		if s.loaded == nil {
+			s.loaded = &loadedPolicies{}
+		}
+	})
+	return s.loaded
+}
+
+// Load - Read the policies from the file or the database. An empty policies table is filled with the defaults.
+func (s *PolicyService) Load() error {
+	var policies []models.Policy
+	if s.File != "" {
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &policies); err != nil {
+			return fmt.Errorf("invalid policy file %s: %w", s.File, err)
+		}
+	} else {
+		var count int64
+		if err := s.DB.Model(&models.Policy{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			seed := append([]models.Policy(nil), defaultPolicies...)
+			if err := s.DB.Create(&seed).Error; err != nil {
+				return err
+			}
+		}
+		if err := s.DB.Order("priority, name").Find(&policies).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, p := range policies {
+		if err := policy.Validate(p); err != nil {
+			return err
+		}
+	}
+	loaded := s.inForce()
+	loaded.mu.Lock()
+	loaded.policies = policies
+	loaded.mu.Unlock()
+	return nil
+}
+
+// ListPolicies - Return the policies in evaluation order
+func (s *PolicyService) ListPolicies() []models.Policy {
+	loaded := s.inForce()
+	loaded.mu.RLock()
+	defer loaded.mu.RUnlock()
+	return append([]models.Policy(nil), loaded.policies...)
+}
+
+// SavePolicy - Create or replace a policy in the policies table
+func (s *PolicyService) SavePolicy(p models.Policy) (models.Policy, error) {
+	if s.File != "" {
+		return models.Policy{}, ErrPoliciesReadOnly
+	}
+	if err := policy.Validate(p); err != nil {
+		return models.Policy{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	var existing models.Policy
+	err := s.DB.Where("name = ?", p.Name).First(&existing).Error
+	switch {
+	case err == nil:
+		p.ID = existing.ID
+		p.CreatedAt = existing.CreatedAt
+		err = s.DB.Save(&p).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = s.DB.Create(&p).Error
+	}
+	if err != nil {
+		return models.Policy{}, err
+	}
+	return p, s.Load()
+}
+
+// DeletePolicy - Remove a policy from the policies table
+func (s *PolicyService) DeletePolicy(name string) error {
+	if s.File != "" {
+		return ErrPoliciesReadOnly
+	}
+	res := s.DB.Where("name = ?", name).Delete(&models.Policy{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPolicyNotFound
+	}
+	return s.Load()
+}
+
+// Subject - Build the subject attributes of the caller of a request
+func (s *PolicyService) Subject(claims *models.JWTClaims) (policy.Attributes, error) {
+	perms, err := s.RBAC.CallerPermissions(claims)
+	if err != nil {
+		return nil, err
+	}
+	return policy.SubjectAttributes(claims, perms), nil
+}
+
+// SubjectForUser - Build the subject attributes a user would have with a token issued now
+func (s *PolicyService) SubjectForUser(user models.User) (policy.Attributes, error) {
+	if err := loadRoles(s.DB, &user); err != nil {
+		return nil, err
+	}
+	claims := &models.JWTClaims{
+		Email:    user.Email,
+		Role:     user.Role,
+		Roles:    user.EffectiveRoleNames(),
+		Username: user.Username,
+		Region:   user.Region,
+		TenantID: user.OrganizationID,
+	}
+	claims.Subject = strconv.FormatUint(uint64(user.ID), 10)
+	return s.Subject(claims)
+}
+
+// ResourceForUser - Build the attributes of a user acting as the resource of a request
+func (s *PolicyService) ResourceForUser(user models.User) (policy.Attributes, error) {
+	if err := loadRoles(s.DB, &user); err != nil {
+		return nil, err
+	}
+	return policy.UserAttributes(user), nil
+}
+
+// Evaluate - Evaluate the policies for an action of the subject on the resource
+func (s *PolicyService) Evaluate(action string, subject, resource policy.Attributes) models.PolicyDecision {
+	loaded := s.inForce()
+	loaded.mu.RLock()
+	defer loaded.mu.RUnlock()
+	return policy.Evaluate(loaded.policies, action, subject, resource)
+}
+
+// Authorize - Decide whether the caller may perform the action on a user. On success the user is returned with
+// the fields the policy redacts removed; otherwise ErrPolicyDenied is returned.
+func (s *PolicyService) Authorize(claims *models.JWTClaims, action string, user models.User) (models.User, error) {
+	subject, err := s.Subject(claims)
+	if err != nil {
+		return models.User{}, err
+	}
+	resource, err := s.ResourceForUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+	decision := s.Evaluate(action, subject, resource)
+	if !decision.Allowed {
+		return models.User{}, ErrPolicyDenied
+	}
+	policy.Redact(&user, decision.Redact)
+	return user, nil
+}
+
+// Filter - Return the users the caller may perform the action on, with redactions applied. The roles of the users
+// must be loaded; the roles they inherit from groups are added.
+func (s *PolicyService) Filter(claims *models.JWTClaims, action string, users []models.User) ([]models.User, error) {
+	subject, err := s.Subject(claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadEffectiveRoles(s.DB, users); err != nil {
+		return nil, err
+	}
+	allowed := []models.User{}
+	for _, user := range users {
+		decision := s.Evaluate(action, subject, policy.UserAttributes(user))
+		if decision.Allowed {
+			policy.Redact(&user, decision.Redact)
+			allowed = append(allowed, user)
+		}
+	}
+	return allowed, nil
+}
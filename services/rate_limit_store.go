@@ -0,0 +1,302 @@
+package services
+
+import (
+	"api-service/redisclient"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting algorithms
+const (
+	TokenBucket   = "token_bucket"   // Allows bursts of Limit requests, refilled evenly over Window
+	SlidingWindow = "sliding_window" // Allows Limit requests in any Window, estimated from the current and previous window
+)
+
+var ErrInvalidRateLimit = errors.New(`invalid rate limit, expected "<requests>/<window>" such as "10/1m"`)
+
+// RateLimit allows Limit requests per Window. A Limit of 0 means unlimited.
+type RateLimit struct {
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+}
+
+// ParseRateLimit - Parse a limit such as "10/1m" or "300/1h". "off" and "0" disable limiting.
+func ParseRateLimit(spec, algorithm string) (RateLimit, error) {
+	if algorithm != TokenBucket && algorithm != SlidingWindow {
+		return RateLimit{}, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	if spec == "off" || spec == "0" {
+		return RateLimit{Algorithm: algorithm}, nil
+	}
+	count, window, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit < 0 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration < time.Millisecond {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+	return RateLimit{Algorithm: algorithm, Limit: limit, Window: duration}, nil
+}
+
+// RateLimitResult is the outcome of taking one request from a limit
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int           // Requests left right now
+	Reset      time.Duration // Time until the full limit is available again
+	RetryAfter time.Duration // Time until the next request is allowed, set when the request was refused
+}
+
+// RateLimitStore keeps the state of rate limits. Take counts one request for a key against the limit.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// tokenBucketResult - Describe a bucket holding the given tokens after the request was allowed or refused
+func tokenBucketResult(limit RateLimit, tokens float64, allowed bool) RateLimitResult {
+	perToken := float64(limit.Window) / float64(limit.Limit)
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Limit) - tokens) * perToken),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	return result
+}
+
+// slidingWindowResult - Describe a sliding window whose previous and current fixed windows hold the given counts,
+// elapsed into the current window, after the request was allowed or refused
+func slidingWindowResult(limit RateLimit, previous, current int, elapsed time.Duration, allowed bool) RateLimitResult {
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	used := float64(previous)*weight + float64(current)
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit.Limit,
+		Remaining: int(math.Max(0, math.Floor(float64(limit.Limit)-used))),
+		// The previous window stops counting at the end of the current one, and the current one a window later
+		Reset: limit.Window - elapsed,
+	}
+	if current > 0 {
+		result.Reset += limit.Window
+	}
+	if !allowed {
+		// The request fits once the weight of the previous window has dropped far enough. If the current window
+		// alone is full, that happens in the next window, where the current window becomes the previous one.
+		if current < limit.Limit && previous > 0 {
+			needed := 1 - float64(limit.Limit-1-current)/float64(previous)
+			result.RetryAfter = time.Duration(needed*float64(limit.Window)) - elapsed
+		} else {
+			needed := math.Max(0, 1-float64(limit.Limit-1)/float64(current))
+			result.RetryAfter = limit.Window - elapsed + time.Duration(needed*float64(limit.Window))
+		}
+	}
+	return result
+}
+
+// windowStart - Return the index of the fixed window holding now and how far into it now is
+func windowStart(limit RateLimit, now time.Time) (int64, time.Duration) {
+	ms := now.UnixMilli()
+	window := limit.Window.Milliseconds()
+	return ms / window, time.Duration(ms%window) * time.Millisecond
+}
+
+// MemoryRateLimitStore keeps rate limits in process memory. It is suitable for a single instance and for tests.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens   float64   // Token bucket: tokens left at updated
+	window   int64     // Sliding window: index of the current fixed window
+	previous int       // Sliding window: requests in the previous fixed window
+	current  int       // Sliding window: requests in the current fixed window
+	updated  time.Time // Time of the last request
+	expires  time.Time // The bucket is back to its initial state after this time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok || now.After(bucket.expires) {
+		bucket = &memoryBucket{tokens: float64(limit.Limit), updated: now}
+		s.buckets[key] = bucket
+	}
+
+	if limit.Algorithm == SlidingWindow {
+		window, elapsed := windowStart(limit, now)
+		switch {
+		case window == bucket.window+1:
+			bucket.previous, bucket.current = bucket.current, 0
+		case window != bucket.window:
+			bucket.previous, bucket.current = 0, 0
+		}
+		bucket.window = window
+		weight := 1 - float64(elapsed)/float64(limit.Window)
+		allowed := float64(bucket.previous)*weight+float64(bucket.current)+1 <= float64(limit.Limit)
+		if allowed {
+			bucket.current++
+		}
+		bucket.updated = now
+		bucket.expires = now.Add(2 * limit.Window)
+		return slidingWindowResult(limit, bucket.previous, bucket.current, elapsed, allowed), nil
+	}
+
+	refill := float64(now.Sub(bucket.updated)) / float64(limit.Window) * float64(limit.Limit)
+	bucket.tokens = math.Min(float64(limit.Limit), bucket.tokens+math.Max(0, refill))
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.updated = now
+	bucket.expires = now.Add(limit.Window)
+	return tokenBucketResult(limit, bucket.tokens, allowed), nil
+}
+
+// sweep - Drop the buckets that are back to their initial state, at most once a minute
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.After(bucket.expires) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// tokenBucketScript refills and takes from a bucket stored as a hash of its tokens and the time of the last request.
+// Times are in milliseconds; the tokens are returned as a string because Lua numbers are truncated to integers.
+const tokenBucketScript = `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+  tokens = limit
+  updated = now
+end
+tokens = math.min(limit, tokens + math.max(0, now - updated) * limit / window)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, tostring(tokens)}
+`
+
+// slidingWindowScript counts a request in the current fixed window (KEYS[1]) if the weighted count of the previous
+// (KEYS[2]) and current windows leaves room for it.
+const slidingWindowScript = `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local allowed = 0
+if previous * (1 - elapsed / window) + current + 1 <= limit then
+  current = redis.call('INCR', KEYS[1])
+  redis.call('PEXPIRE', KEYS[1], 2 * window)
+  allowed = 1
+end
+return {allowed, previous, current}
+`
+
+// RedisRateLimitStore keeps rate limits on a server speaking the Redis protocol, shared by every instance. Each
+// request runs one Lua script, so the check and the update are atomic. The instances' clocks must be in sync.
+type RedisRateLimitStore struct {
+	Client *redisclient.Client
+	Prefix string // Prepended to every key, "ratelimit:" when empty
+}
+
+func (s *RedisRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "ratelimit:"
+	}
+	window := strconv.FormatInt(limit.Window.Milliseconds(), 10)
+	limitArg := strconv.Itoa(limit.Limit)
+
+	if limit.Algorithm == SlidingWindow {
+		index, elapsed := windowStart(limit, now)
+		keys := []string{
+			prefix + key + ":" + strconv.FormatInt(index, 10),
+			prefix + key + ":" + strconv.FormatInt(index-1, 10),
+		}
+		reply, err := s.eval(slidingWindowScript, keys, limitArg, window, strconv.FormatInt(elapsed.Milliseconds(), 10))
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		if len(reply) != 3 {
+			return RateLimitResult{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+		}
+		allowed, _ := reply[0].(int64)
+		previous, _ := reply[1].(int64)
+		current, _ := reply[2].(int64)
+		return slidingWindowResult(limit, int(previous), int(current), elapsed, allowed == 1), nil
+	}
+
+	reply, err := s.eval(tokenBucketScript, []string{prefix + key}, limitArg, window, strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(reply) != 2 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	tokensReply, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(tokensReply, 64)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	return tokenBucketResult(limit, tokens, allowed == 1), nil
+}
+
+// eval - Run a script by its SHA-1, loading it with EVAL if the server does not know it yet
+func (s *RedisRateLimitStore) eval(script string, keys []string, args ...string) ([]interface{}, error) {
+	sum := sha1.Sum([]byte(script))
+	command := append([]string{"EVALSHA", hex.EncodeToString(sum[:]), strconv.Itoa(len(keys))}, keys...)
+	reply, err := s.Client.Do(append(command, args...)...)
+	var replyErr redisclient.Error
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		command[0], command[1] = "EVAL", script
+		reply, err = s.Client.Do(append(command, args...)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	return items, nil
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"api-service/redisclient"
+	"api-service/redisclient/redistest"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redisStandIn - Start a RESP stand-in running Go equivalents of the rate limit scripts, which make the same
+// redis.call calls as the Lua source
+func redisStandIn(t *testing.T) *redistest.Server {
+	srv := redistest.NewServer(t)
+	srv.Script(tokenBucketScript, func(srv *redistest.Server, keys, args []string) (interface{}, error) {
+		limit, _ := strconv.ParseFloat(args[0], 64)
+		window, _ := strconv.ParseFloat(args[1], 64)
+		now, _ := strconv.ParseFloat(args[2], 64)
+		reply, err := srv.Call("HMGET", keys[0], "tokens", "updated")
+		if err != nil {
+			return nil, err
+		}
+		state := reply.([]interface{})
+		tokensState, _ := state[0].(string)
+		updatedState, _ := state[1].(string)
+		tokens, tokensErr := strconv.ParseFloat(tokensState, 64)
+		updated, updatedErr := strconv.ParseFloat(updatedState, 64)
+		if tokensErr != nil || updatedErr != nil {
+			tokens, updated = limit, now
+		}
+		tokens = math.Min(limit, tokens+math.Max(0, now-updated)*limit/window)
+		allowed := int64(0)
+		if tokens >= 1 {
+			tokens--
+			allowed = 1
+		}
+		luaTokens := strconv.FormatFloat(tokens, 'g', 14, 64) // tostring
+		if _, err := srv.Call("HMSET", keys[0], "tokens", luaTokens, "updated", args[2]); err != nil {
+			return nil, err
+		}
+		if _, err := srv.Call("PEXPIRE", keys[0], args[1]); err != nil {
+			return nil, err
+		}
+		return []interface{}{allowed, luaTokens}, nil
+	})
+	srv.Script(slidingWindowScript, func(srv *redistest.Server, keys, args []string) (interface{}, error) {
+		limit, _ := strconv.ParseFloat(args[0], 64)
+		window, _ := strconv.ParseFloat(args[1], 64)
+		elapsed, _ := strconv.ParseFloat(args[2], 64)
+		get := func(key string) (int64, error) {
+			reply, err := srv.Call("GET", key)
+			if reply == nil || err != nil {
+				return 0, err
+			}
+			return strconv.ParseInt(reply.(string), 10, 64)
+		}
+		current, err := get(keys[0])
+		if err != nil {
+			return nil, err
+		}
+		previous, err := get(keys[1])
+		if err != nil {
+			return nil, err
+		}
+		allowed := int64(0)
+		if float64(previous)*(1-elapsed/window)+float64(current)+1 <= limit {
+			reply, err := srv.Call("INCR", keys[0])
+			if err != nil {
+				return nil, err
+			}
+			current = reply.(int64)
+			if _, err := srv.Call("PEXPIRE", keys[0], strconv.FormatFloat(2*window, 'f', 0, 64)); err != nil {
+				return nil, err
+			}
+			allowed = 1
+		}
+		return []interface{}{allowed, previous, current}, nil
+	})
+	return srv
+}
+
+func TestRateLimitStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) RateLimitStore{
+		"Memory": func(t *testing.T) RateLimitStore { return NewMemoryRateLimitStore() },
+		"Redis": func(t *testing.T) RateLimitStore {
+			return &RedisRateLimitStore{Client: &redisclient.Client{Addr: redisStandIn(t).Addr()}}
+		},
+		"RealRedis": func(t *testing.T) RateLimitStore {
+			client := &redisclient.Client{Addr: redistest.RealAddr(t)}
+			t.Cleanup(func() { client.Close() })
+			// Keys of earlier runs must not count
+			return &RedisRateLimitStore{Client: client, Prefix: "ratelimit-test:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":"}
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("TokenBucket", func(t *testing.T) { testTokenBucket(t, newStore(t)) })
+			t.Run("SlidingWindow", func(t *testing.T) { testSlidingWindow(t, newStore(t)) })
+		})
+	}
+}
+
+func take(t *testing.T, store RateLimitStore, key string, limit RateLimit, now time.Time) RateLimitResult {
+	t.Helper()
+	result, err := store.Take(key, limit, now)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	return result
+}
+
+func testTokenBucket(t *testing.T, store RateLimitStore) {
+	limit := RateLimit{Algorithm: TokenBucket, Limit: 3, Window: time.Minute}
+	start := time.Now().Truncate(time.Millisecond)
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result := take(t, store, "ip:1", limit, start)
+		if !result.Allowed || result.Remaining != remaining || result.Limit != 3 {
+			t.Fatalf("request with %d left: %+v", remaining+1, result)
+		}
+	}
+	refused := take(t, store, "ip:1", limit, start)
+	if refused.Allowed || refused.RetryAfter != 20*time.Second || refused.Reset != time.Minute {
+		t.Fatalf("request over the limit: %+v, want refused with RetryAfter 20s and Reset 1m", refused)
+	}
+	if other := take(t, store, "ip:2", limit, start); !other.Allowed || other.Remaining != 2 {
+		t.Fatalf("another key: %+v, want its own bucket", other)
+	}
+
+	// One token is back after a third of the window
+	if result := take(t, store, "ip:1", limit, start.Add(20*time.Second)); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("after the refill of one token: %+v", result)
+	}
+	if result := take(t, store, "ip:1", limit, start.Add(25*time.Second)); result.Allowed {
+		t.Fatalf("before the next token: %+v, want refused", result)
+	}
+}
+
+func testSlidingWindow(t *testing.T, store RateLimitStore) {
+	limit := RateLimit{Algorithm: SlidingWindow, Limit: 4, Window: time.Minute}
+	start := time.Now().Truncate(time.Minute).Add(time.Minute)
+
+	for i := 0; i < 4; i++ {
+		if result := take(t, store, "user:1", limit, start); !result.Allowed || result.Remaining != 3-i {
+			t.Fatalf("request %d: %+v", i+1, result)
+		}
+	}
+	refused := take(t, store, "user:1", limit, start.Add(10*time.Second))
+	if refused.Allowed || refused.RetryAfter != 50*time.Second+15*time.Second {
+		t.Fatalf("request over the limit: %+v, want refused with RetryAfter 1m5s", refused)
+	}
+
+	// Halfway through the next window the previous one counts for half of its 4 requests
+	halfway := start.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if result := take(t, store, "user:1", limit, halfway); !result.Allowed {
+			t.Fatalf("request %d halfway through the next window: %+v", i+1, result)
+		}
+	}
+	if result := take(t, store, "user:1", limit, halfway); result.Allowed {
+		t.Fatalf("third request halfway through the next window: %+v, want refused", result)
+	}
+
+	// Two windows later nothing counts any more
+	if result := take(t, store, "user:1", limit, start.Add(3*time.Minute)); !result.Allowed || result.Remaining != 3 {
+		t.Fatalf("two windows later: %+v", result)
+	}
+}
+
+func TestRedisRateLimitStoreLoadsScriptsOnce(t *testing.T) {
+	srv := redisStandIn(t)
+	store := &RedisRateLimitStore{Client: &redisclient.Client{Addr: srv.Addr()}}
+	bucket := RateLimit{Algorithm: TokenBucket, Limit: 10, Window: time.Minute}
+	window := RateLimit{Algorithm: SlidingWindow, Limit: 10, Window: time.Minute}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		take(t, store, "auth:ip:203.0.113.5", bucket, now)
+		take(t, store, "api:user:42", window, now)
+	}
+	got := strings.Join(srv.Commands(), " ")
+	want := "EVALSHA EVAL EVALSHA EVAL EVALSHA EVALSHA EVALSHA EVALSHA"
+	if got != want {
+		t.Fatalf("commands %q, want %q: each script is sent once after NOSCRIPT, then run by its SHA-1", got, want)
+	}
+
+	keys := srv.Keys()
+	if len(keys) != 2 {
+		t.Fatalf("keys %v, want the bucket and the current window", keys)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "ratelimit:") {
+			t.Errorf("key %q does not have the default prefix", key)
+		}
+		// Every key expires, so that idle clients do not fill the server
+		client := &redisclient.Client{Addr: srv.Addr()}
+		ttl, err := client.Do("PTTL", key)
+		if err != nil || ttl.(int64) <= 0 {
+			t.Errorf("PTTL %s = %v, %v, want a positive TTL", key, ttl, err)
+		}
+	}
+}
+
+func TestRedisRateLimitStoreReportsServerErrors(t *testing.T) {
+	srv := redistest.NewServer(t)
+	srv.Password = "secret"
+	limit := RateLimit{Algorithm: TokenBucket, Limit: 10, Window: time.Minute}
+
+	unauthenticated := &RedisRateLimitStore{Client: &redisclient.Client{Addr: srv.Addr()}}
+	if _, err := unauthenticated.Take("ip:1", limit, time.Now()); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Fatalf("without the password: error %v, want NOAUTH", err)
+	}
+	unreachable := &RedisRateLimitStore{Client: &redisclient.Client{Addr: "127.0.0.1:1", Timeout: time.Second}}
+	if _, err := unreachable.Take("ip:1", limit, time.Now()); err == nil {
+		t.Fatal("unreachable server: no error")
+	}
+}
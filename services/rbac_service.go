@@ -0,0 +1,604 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/storage"
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rolePermissionCacheTTL bounds how long a change made on another instance takes to reach this one.
+const rolePermissionCacheTTL = time.Minute
+
+var (
+	ErrRoleNotFound      = storage.ErrRoleNotFound
+	ErrRoleExists        = errors.New("role already exists")
+	ErrInvalidRoleName   = errors.New("role names may only contain lowercase letters, digits, '-' and '_'")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrBuiltinRole       = errors.New("built-in roles cannot be changed or deleted")
+	ErrLastAdmin         = errors.New("the last admin of an organization cannot lose the admin role")
+	ErrSuperAdminRole    = errors.New("only super admins can give or take the super_admin role")
+	ErrLastSuperAdmin    = errors.New("the last super admin cannot lose the super_admin role")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// builtinPermissions is the catalog of permissions the routes check. Roles can only be bound to these.
+var builtinPermissions = []models.Permission{
+	{Name: models.PermUsersRead, Description: "List users"},
+	{Name: models.PermUsersCreate, Description: "Create users"},
+	{Name: models.PermUsersDelete, Description: "Delete users"},
+	{Name: models.PermUsersUnlock, Description: "Lift the login lockout of users"},
+	{Name: models.PermTokensRevoke, Description: "Revoke access and refresh tokens"},
+	{Name: models.PermMFAReset, Description: "Reset the MFA enrollment of a user"},
+	{Name: models.PermClientsRead, Description: "List OAuth2 clients"},
+	{Name: models.PermClientsManage, Description: "Register and delete OAuth2 clients"},
+	{Name: models.PermInvitationsManage, Description: "Invite admins and withdraw invitations"},
+	{Name: models.PermRolesRead, Description: "List roles, permissions and the roles of users"},
+	{Name: models.PermRolesManage, Description: "Create, change and delete roles, and assign them to users"},
+	{Name: models.PermPoliciesRead, Description: "List authorization policies and evaluate them"},
+	{Name: models.PermPoliciesManage, Description: "Create, change and delete authorization policies"},
+	{Name: models.PermGroupsRead, Description: "List groups, their members and the effective roles of users"},
+	{Name: models.PermGroupsManage, Description: "Create, change and delete groups and manage their members"},
+	{Name: models.PermOrganizationsManage, Description: "Create and delete organizations"},
+	{Name: models.PermAuditRead, Description: "Read the audit log of the organization"},
+}
+
+// superAdminPermissions are only granted to the super_admin role; the admin role holds every other permission.
+var superAdminPermissions = map[string]bool{
+	models.PermOrganizationsManage: true,
+}
+
+// RBACService stores roles, permissions and their bindings, and answers permission checks for the middleware.
+// The role to permission bindings are cached in memory, and the cache is shared with the copies WithContext returns.
+type RBACService struct {
+	DB          *gorm.DB
+	Revocations RevocationStore
+
+	once  sync.Once
+	cache *rolePermissionCache
+}
+
+type rolePermissionCache struct {
+	mu       sync.Mutex
+	roles    map[string]map[string]bool // Role name to its permissions
+	loadedAt time.Time
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (s *RBACService) WithContext(ctx context.Context) *RBACService {
+	if s == nil {
+		return nil
+	}
+	return &RBACService{DB: s.DB.WithContext(ctx), Revocations: bindRevocations(s.Revocations, ctx), cache: s.rolePermissions()}
+}
+
+// rolePermissions - Return the cache of the role to permission bindings, creating it on first use
+func (s *RBACService) rolePermissions() *rolePermissionCache {
+	s.once.Do(func() {
+		if s.cache == nil {
+			s.cache = &rolePermissionCache{}
+		}
+	})
+	return s.cache
+}
+
+// Seed - Create the built-in permissions and roles, and give users without roles the role named by their Role
+// column, so that existing admins keep their access. While nobody holds the super_admin role, the admins of the
+// default organization get it, since they managed every user before organizations existed.
+func (s *RBACService) Seed() error {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		for _, p := range builtinPermissions {
+			perm := p
+			if err := tx.Where(models.Permission{Name: perm.Name}).Attrs(perm).FirstOrCreate(&perm).Error; err != nil {
+				return err
+			}
+		}
+		var all []models.Permission
+		if err := tx.Find(&all).Error; err != nil {
+			return err
+		}
+
+		tenantPerms := make([]models.Permission, 0, len(all))
+		for _, p := range all {
+			if !superAdminPermissions[p.Name] {
+				tenantPerms = append(tenantPerms, p)
+			}
+		}
+		admin := models.Role{Name: models.RoleAdmin, Description: "Full access to the admin API within the organization", Builtin: true}
+		if err := tx.Where(models.Role{Name: admin.Name}).Attrs(admin).FirstOrCreate(&admin).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&admin).Association("Permissions").Replace(tenantPerms); err != nil {
+			return err
+		}
+		superAdmin := models.Role{Name: models.RoleSuperAdmin, Description: "Full access to the admin API across all organizations", Builtin: true}
+		if err := tx.Where(models.Role{Name: superAdmin.Name}).Attrs(superAdmin).FirstOrCreate(&superAdmin).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&superAdmin).Association("Permissions").Replace(all); err != nil {
+			return err
+		}
+		user := models.Role{Name: models.RoleUser, Description: "Registered users", Builtin: true}
+		if err := tx.Where(models.Role{Name: user.Name}).Attrs(user).FirstOrCreate(&user).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(`INSERT INTO user_roles (user_id, role_id)
+			SELECT u.id, r.id FROM users u JOIN roles r ON r.name = COALESCE(NULLIF(u.role, ''), ?)
+			WHERE NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id)`, models.RoleUser).Error; err != nil {
+			return err
+		}
+
+		superAdmins, err := countRoleHolders(tx, models.RoleSuperAdmin, 0)
+		if err != nil || superAdmins > 0 {
+			return err
+		}
+		return tx.Exec(`INSERT INTO user_roles (user_id, role_id)
+			SELECT ur.user_id, ? FROM user_roles ur
+			JOIN users u ON u.id = ur.user_id
+			JOIN organizations o ON o.id = u.organization_id
+			WHERE ur.role_id = ? AND o.slug = ?`, superAdmin.ID, admin.ID, models.DefaultOrganizationSlug).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	return nil
+}
+
+// HasPermission - Report whether any of the roles grants the permission
+func (s *RBACService) HasPermission(roles []string, permission string) (bool, error) {
+	cache, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if cache[role][permission] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PermissionsOf - Return every permission granted by the roles
+func (s *RBACService) PermissionsOf(roles []string) ([]string, error) {
+	cache, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	perms := []string{}
+	for _, role := range roles {
+		for perm := range cache[role] {
+			if !seen[perm] {
+				seen[perm] = true
+				perms = append(perms, perm)
+			}
+		}
+	}
+	sort.Strings(perms)
+	return perms, nil
+}
+
+// CallerPermissions - Return the permissions of the caller of a request, as RequirePermission grants them: the
+// permissions of the roles of a user, or for a client credentials token those of the roles its scopes name, plus
+// the scopes naming a permission
+func (s *RBACService) CallerPermissions(claims *models.JWTClaims) ([]string, error) {
+	if !claims.IsClientToken() {
+		return s.PermissionsOf(claims.RoleNames())
+	}
+	perms, _, err := s.ScopePermissions(strings.Fields(claims.Scope))
+	return perms, err
+}
+
+// ScopePermissions - Return the permissions a client credentials token with the scopes is granted: those of the
+// scopes naming a role, and the scopes naming a permission. Scopes naming neither grant nothing and are returned in
+// unknown.
+func (s *RBACService) ScopePermissions(scopes []string) (perms, unknown []string, err error) {
+	var catalog []string
+	if err := s.DB.Model(&models.Permission{}).Pluck("name", &catalog).Error; err != nil {
+		return nil, nil, err
+	}
+	roles, err := s.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool)
+	grant := func(perm string) {
+		if !seen[perm] {
+			seen[perm] = true
+			perms = append(perms, perm)
+		}
+	}
+	for _, scope := range scopes {
+		rolePerms, isRole := roles[scope]
+		isPerm := containsRole(catalog, scope)
+		for perm := range rolePerms {
+			grant(perm)
+		}
+		if isPerm {
+			grant(scope)
+		}
+		if !isRole && !isPerm {
+			unknown = append(unknown, scope)
+		}
+	}
+	sort.Strings(perms)
+	return perms, unknown, nil
+}
+
+// load - Return the cached role to permission bindings, refreshing them when they are stale. The returned map is
+// never modified.
+func (s *RBACService) load() (map[string]map[string]bool, error) {
+	cache := s.rolePermissions()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.roles != nil && time.Since(cache.loadedAt) <= rolePermissionCacheTTL {
+		return cache.roles, nil
+	}
+	var all []models.Role
+	if err := s.DB.Preload("Permissions").Find(&all).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[string]map[string]bool, len(all))
+	for _, role := range all {
+		perms := make(map[string]bool, len(role.Permissions))
+		for _, p := range role.Permissions {
+			perms[p.Name] = true
+		}
+		roles[role.Name] = perms
+	}
+	cache.roles, cache.loadedAt = roles, time.Now()
+	return roles, nil
+}
+
+// ListPermissions - Return the permission catalog
+func (s *RBACService) ListPermissions() ([]models.Permission, error) {
+	var perms []models.Permission
+	if err := s.DB.Order("name").Find(&perms).Error; err != nil {
+		return nil, err
+	}
+	return perms, nil
+}
+
+// ListRoles - Return every role with its permissions
+func (s *RBACService) ListRoles() ([]models.Role, error) {
+	var roles []models.Role
+	if err := s.DB.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// CreateRole - Create a role bound to the given permissions
+func (s *RBACService) CreateRole(req models.RoleRequest) (models.Role, error) {
+	if !roleNamePattern.MatchString(req.Name) {
+		return models.Role{}, ErrInvalidRoleName
+	}
+	perms, err := s.lookupPermissions(req.Permissions)
+	if err != nil {
+		return models.Role{}, err
+	}
+
+	role := models.Role{Name: req.Name, Description: req.Description, Permissions: perms}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Role{}).Where("name = ?", req.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRoleExists
+		}
+		return tx.Create(&role).Error
+	})
+	if err != nil {
+		return models.Role{}, err
+	}
+	s.invalidate()
+	return role, nil
+}
+
+// UpdateRole - Replace the description and permissions of a role. The admin and super_admin roles always hold
+// their permissions and cannot be changed.
+func (s *RBACService) UpdateRole(name string, req models.RoleRequest) (models.Role, error) {
+	role, err := s.findRole(s.DB, name)
+	if err != nil {
+		return models.Role{}, err
+	}
+	if role.Name == models.RoleAdmin || role.Name == models.RoleSuperAdmin {
+		return models.Role{}, ErrBuiltinRole
+	}
+	perms, err := s.lookupPermissions(req.Permissions)
+	if err != nil {
+		return models.Role{}, err
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Update("description", req.Description).Error; err != nil {
+			return err
+		}
+		return tx.Model(&role).Association("Permissions").Replace(perms)
+	})
+	if err != nil {
+		return models.Role{}, err
+	}
+	s.invalidate()
+	role.Description = req.Description
+	role.Permissions = perms
+	return role, nil
+}
+
+// DeleteRole - Delete a role that is not built in. Users and groups holding it lose it.
+func (s *RBACService) DeleteRole(name string) error {
+	role, err := s.findRole(s.DB, name)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+
+	var holders []uint
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("user_roles").Where("role_id = ?", role.ID).Pluck("user_id", &holders).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM user_roles WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		// Members of groups holding the role inherit it, as do the members of their subgroups
+		var groups []models.Group
+		if err := tx.Joins("JOIN group_roles ON group_roles.group_id = groups.id").
+			Where("group_roles.role_id = ?", role.ID).Find(&groups).Error; err != nil {
+			return err
+		}
+		for _, group := range groups {
+			members, err := subtreeMembers(tx, group)
+			if err != nil {
+				return err
+			}
+			holders = append(holders, members...)
+		}
+		if err := tx.Exec("DELETE FROM group_roles WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	return s.revokeAccessTokens(holders...)
+}
+
+// EffectiveRoles - Return every role a user of the tenant holds, directly or through groups, with the grants each
+// role comes from
+func (s *RBACService) EffectiveRoles(tenant Tenant, userID uint) ([]models.EffectiveRole, error) {
+	user, err := findTenantUser(s.DB, tenant, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.Model(&user).Association("Roles").Find(&user.Roles); err != nil {
+		return nil, err
+	}
+	sources, err := roleSources(s.DB, []models.User{user})
+	if err != nil {
+		return nil, err
+	}
+	return sources[user.ID], nil
+}
+
+// UserRoles - Return the roles a user of the tenant holds
+func (s *RBACService) UserRoles(tenant Tenant, userID uint) ([]models.Role, error) {
+	user, err := findTenantUser(s.DB, tenant, userID)
+	if err != nil {
+		return nil, err
+	}
+	var roles []models.Role
+	if err := s.DB.Model(&user).Preload("Permissions").Association("Roles").Find(&roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetUserRoles - Replace the roles of a user of the tenant, as far as checkRoleChange allows: the admin role cannot
+// be given here, since admins join by invitation, and the last admin of an organization cannot lose it. Only super
+// admins give or take the super_admin role, and the last super admin keeps it. The user's access tokens are revoked
+// so that removed roles stop working at once; the next refresh issues a token with the new roles.
+func (s *RBACService) SetUserRoles(tenant Tenant, userID uint, names []string) ([]models.Role, error) {
+	var roles []models.Role
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Scopes(tenant.Scope).Preload("Roles").First(&user, userID).Error; err != nil {
+			return err
+		}
+		holders := func(role string, organizationID uint) (int64, error) {
+			return countRoleHolders(tx, role, organizationID)
+		}
+		if err := checkRoleChange(tenant, user.OrganizationID, user.RoleNames(), names, holders); err != nil {
+			return err
+		}
+
+		roles = make([]models.Role, 0, len(names))
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			role, err := s.findRole(tx, name)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, role)
+		}
+		if err := tx.Model(&user).Association("Roles").Replace(roles); err != nil {
+			return err
+		}
+		// Role is the role tokens issued before roles were added fall back to
+		primary := ""
+		if len(roles) > 0 {
+			primary = roles[0].Name
+		}
+		return tx.Model(&user).Update("role", primary).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.revokeAccessTokens(userID); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *RBACService) findRole(tx *gorm.DB, name string) (models.Role, error) {
+	var role models.Role
+	if err := tx.Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Role{}, ErrRoleNotFound
+		}
+		return models.Role{}, err
+	}
+	return role, nil
+}
+
+func (s *RBACService) lookupPermissions(names []string) ([]models.Permission, error) {
+	perms := []models.Permission{}
+	if len(names) == 0 {
+		return perms, nil
+	}
+	if err := s.DB.Where("name IN ?", names).Find(&perms).Error; err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		found := false
+		for _, p := range perms {
+			if p.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrUnknownPermission
+		}
+	}
+	return perms, nil
+}
+
+func (s *RBACService) revokeAccessTokens(userIDs ...uint) error {
+	for _, id := range userIDs {
+		if err := s.Revocations.RevokeSubject(strconv.FormatUint(uint64(id), 10), time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RBACService) invalidate() {
+	cache := s.rolePermissions()
+	cache.mu.Lock()
+	cache.roles = nil
+	cache.mu.Unlock()
+}
+
+// assignRole - Give a newly created user a role
+func assignRole(tx *gorm.DB, user *models.User, name string) error {
+	var role models.Role
+	if err := tx.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+	if err := tx.Model(user).Association("Roles").Append(&role); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadRoles - Load the roles a user holds directly, and compute the effective roles including those inherited
+// from groups, which GenerateJWT embeds in the token
+func loadRoles(tx *gorm.DB, user *models.User) error {
+	user.Roles = nil
+	if err := tx.Model(user).Association("Roles").Find(&user.Roles); err != nil {
+		return err
+	}
+	users := []models.User{*user}
+	if err := loadEffectiveRoles(tx, users); err != nil {
+		return err
+	}
+	user.EffectiveRoles = users[0].EffectiveRoles
+	return nil
+}
+
+// checkRoleChange - Check that a user of organizationID holding the roles named by had may be left holding the
+// roles named by names, or none when the user is deleted. Admins join by invitation only and an organization keeps
+// its last admin; only a tenant acting across tenants gives or takes the super_admin role, and its last holder
+// keeps it. holders counts the users holding a role in an organization, or in every organization for 0.
+func checkRoleChange(tenant Tenant, organizationID uint, had, names []string, holders func(role string, organizationID uint) (int64, error)) error {
+	wasAdmin := containsRole(had, models.RoleAdmin)
+	isAdmin := containsRole(names, models.RoleAdmin)
+	if isAdmin && !wasAdmin {
+		return ErrAdminRoleByInvite
+	}
+	if wasAdmin && !isAdmin {
+		admins, err := holders(models.RoleAdmin, organizationID)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return ErrLastAdmin
+		}
+	}
+	wasSuperAdmin := containsRole(had, models.RoleSuperAdmin)
+	isSuperAdmin := containsRole(names, models.RoleSuperAdmin)
+	if wasSuperAdmin != isSuperAdmin {
+		if !tenant.CrossTenant {
+			return ErrSuperAdminRole
+		}
+		if wasSuperAdmin {
+			superAdmins, err := holders(models.RoleSuperAdmin, 0)
+			if err != nil {
+				return err
+			}
+			if superAdmins <= 1 {
+				return ErrLastSuperAdmin
+			}
+		}
+	}
+	return nil
+}
+
+// countAdmins - Count the users holding the admin role
+func countAdmins(tx *gorm.DB) (int64, error) {
+	return countRoleHolders(tx, models.RoleAdmin, 0)
+}
+
+// countRoleHolders - Count the users of an organization, or of every organization for 0, holding a role
+func countRoleHolders(tx *gorm.DB, name string, organizationID uint) (int64, error) {
+	return (&storage.SQLStore{DB: tx}).Roles().CountHolders(name, organizationID)
+}
+
+func containsRole(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package services_test
+
+import (
+	"api-service/models"
+	"api-service/services"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rbacFixture is a deployment with two organizations: the first admin, also the only super admin, in the default
+// organization, and acme with an admin of its own and a plain user
+type rbacFixture struct {
+	conn                      *gorm.DB
+	rbac                      *services.RBACService
+	root, acmeAdmin, acmeUser models.User
+	defaultOrg, acme          services.Tenant
+	superAdmin                services.Tenant
+}
+
+func newRBACFixture(t *testing.T) *rbacFixture {
+	t.Helper()
+	conn := seededSQLite(t)
+	root, err := newBootstrapService(t, conn).CreateAdmin(setupRequest("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := models.Organization{Name: "Acme", Slug: "acme"}
+	if err := conn.Create(&acme).Error; err != nil {
+		t.Fatal(err)
+	}
+	f := &rbacFixture{
+		conn:       conn,
+		rbac:       &services.RBACService{DB: conn, Revocations: services.NewMemoryRevocationStore()},
+		root:       root,
+		defaultOrg: services.Tenant{OrganizationID: root.OrganizationID},
+		acme:       services.Tenant{OrganizationID: acme.ID},
+		superAdmin: services.Tenant{OrganizationID: root.OrganizationID, CrossTenant: true},
+	}
+	f.acmeAdmin = f.createUser(t, "acme-admin", acme.ID, models.RoleAdmin)
+	f.acmeUser = f.createUser(t, "acme-user", acme.ID, models.RoleUser)
+	return f
+}
+
+// createUser - Create a user of an organization holding the named roles, bypassing the guards as invitations do
+func (f *rbacFixture) createUser(t *testing.T, username string, organizationID uint, roles ...string) models.User {
+	t.Helper()
+	user := createUser(t, f.conn, username, "Passw0rd-"+username)
+	if err := f.conn.Model(&user).Update("organization_id", organizationID).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range roles {
+		var role models.Role
+		if err := f.conn.Where("name = ?", name).First(&role).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := f.conn.Model(&user).Association("Roles").Append(&role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func TestSetUserRolesGuards(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		tenant func(f *rbacFixture) services.Tenant
+		user   func(f *rbacFixture) models.User
+		roles  []string
+		want   error
+	}{
+		{
+			name:   "admin role by assignment",
+			tenant: func(f *rbacFixture) services.Tenant { return f.acme },
+			user:   func(f *rbacFixture) models.User { return f.acmeUser },
+			roles:  []string{models.RoleUser, models.RoleAdmin},
+			want:   services.ErrAdminRoleByInvite,
+		},
+		{
+			// The default organization has an admin, but acme would be left without one
+			name:   "last admin of the organization",
+			tenant: func(f *rbacFixture) services.Tenant { return f.acme },
+			user:   func(f *rbacFixture) models.User { return f.acmeAdmin },
+			roles:  []string{models.RoleUser},
+			want:   services.ErrLastAdmin,
+		},
+		{
+			name:   "last admin, by a super admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.superAdmin },
+			user:   func(f *rbacFixture) models.User { return f.acmeAdmin },
+			roles:  nil,
+			want:   services.ErrLastAdmin,
+		},
+		{
+			name:   "super_admin role given by an admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.acme },
+			user:   func(f *rbacFixture) models.User { return f.acmeAdmin },
+			roles:  []string{models.RoleAdmin, models.RoleSuperAdmin},
+			want:   services.ErrSuperAdminRole,
+		},
+		{
+			name:   "super_admin role taken by an admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.defaultOrg },
+			user:   func(f *rbacFixture) models.User { return f.root },
+			roles:  []string{models.RoleAdmin},
+			want:   services.ErrSuperAdminRole,
+		},
+		{
+			name:   "last super admin",
+			tenant: func(f *rbacFixture) services.Tenant { return f.superAdmin },
+			user:   func(f *rbacFixture) models.User { return f.root },
+			roles:  []string{models.RoleAdmin},
+			want:   services.ErrLastSuperAdmin,
+		},
+		{
+			name:   "roles of a user of another organization",
+			tenant: func(f *rbacFixture) services.Tenant { return f.defaultOrg },
+			user:   func(f *rbacFixture) models.User { return f.acmeUser },
+			roles:  []string{models.RoleUser},
+			want:   gorm.ErrRecordNotFound,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRBACFixture(t)
+			user := test.user(f)
+			before := roleNames(t, f.conn, user)
+
+			if _, err := f.rbac.SetUserRoles(test.tenant(f), user.ID, test.roles); !errors.Is(err, test.want) {
+				t.Fatalf("error %v, want %v", err, test.want)
+			}
+			if after := roleNames(t, f.conn, user); !reflect.DeepEqual(after, before) {
+				t.Fatalf("roles changed from %v to %v", before, after)
+			}
+		})
+	}
+}
+
+func TestSetUserRolesAllowsChangesTheGuardsDoNotCover(t *testing.T) {
+	f := newRBACFixture(t)
+
+	// With a second admin, acme can let one of them go
+	second := f.createUser(t, "acme-admin-2", f.acme.OrganizationID, models.RoleAdmin)
+	if _, err := f.rbac.SetUserRoles(f.acme, f.acmeAdmin.ID, []string{models.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.rbac.SetUserRoles(f.acme, second.ID, []string{models.RoleUser}); !errors.Is(err, services.ErrLastAdmin) {
+		t.Fatalf("new last admin: error %v, want %v", err, services.ErrLastAdmin)
+	}
+
+	// A super admin hands the super_admin role on, after which the first one can give it up
+	if _, err := f.rbac.SetUserRoles(f.superAdmin, second.ID, []string{models.RoleAdmin, models.RoleSuperAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.rbac.SetUserRoles(f.superAdmin, f.root.ID, []string{models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if roles := roleNames(t, f.conn, f.root); roles[models.RoleSuperAdmin] || !roles[models.RoleAdmin] {
+		t.Fatalf("roles of the first admin %v, want admin only", roles)
+	}
+
+	// The change revokes the access tokens issued so far
+	revoked, err := f.rbac.Revocations.IsRevoked("", strconv.FormatUint(uint64(f.root.ID), 10), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("access tokens of the user not revoked")
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevocationStore is the token denylist checked by JWTMiddleware on every request.
+type RevocationStore interface {
+	// RevokeToken revokes a single token until it expires.
+	RevokeToken(jti string, expiresAt time.Time) error
+	// RevokeSubject revokes every token of a subject issued before the given time.
+	RevokeSubject(subject string, before time.Time) error
+	// RevokeAll revokes every token issued before the given time.
+	RevokeAll(before time.Time) error
+	// IsRevoked reports whether a token with the given jti, subject and issued-at time has been revoked.
+	IsRevoked(jti, subject string, issuedAt time.Time) (bool, error)
+	// Prune removes entries that only cover tokens which have expired anyway.
+	Prune(now time.Time) error
+	// WithContext returns the store with its queries traced as part of ctx, such as the request being handled.
+	WithContext(ctx context.Context) RevocationStore
+}
+
+// bindRevocations - Bind a store that may be unset to ctx
+func bindRevocations(store RevocationStore, ctx context.Context) RevocationStore {
+	if store == nil {
+		return nil
+	}
+	return store.WithContext(ctx)
+}
+
+// revocationCutoff - Round an issued-before cutoff up to the next second. Tokens carry their issue time in whole
+// seconds, so a token issued earlier in the same second as the revocation has the same issue time as one issued
+// later in that second; both are revoked, since letting the earlier one through would defeat the revocation.
+func revocationCutoff(before time.Time) time.Time {
+	return before.UTC().Truncate(time.Second).Add(time.Second)
+}
+
+// awaitRevocationCutoff - Wait for the next second when the subject was revoked during the current one, so that a
+// token issued to it now is not revoked along with the tokens issued before the revocation. A nil store never
+// waits.
+func awaitRevocationCutoff(store RevocationStore, subject string) error {
+	if store == nil {
+		return nil
+	}
+	now := time.Now()
+	revoked, err := store.IsRevoked("", subject, now.Truncate(time.Second))
+	if err != nil || !revoked {
+		return err
+	}
+	time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+	return nil
+}
+
+// cutoffExpiry returns when an issued-before revocation stops mattering: every token issued before the
+// cutoff has expired by then.
+func cutoffExpiry(before time.Time) time.Time {
+	return before.Add(utils.AccessTokenTTL)
+}
+
+// MemoryRevocationStore keeps the denylist in process memory. It is suitable for a single instance and for tests.
+type MemoryRevocationStore struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // jti -> expiry
+	subjects map[string]time.Time // subject -> revoked before
+	global   time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeSubject(subject string, before time.Time) error {
+	before = revocationCutoff(before)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if before.After(s.subjects[subject]) {
+		s.subjects[subject] = before
+	}
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeAll(before time.Time) error {
+	before = revocationCutoff(before)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if before.After(s.global) {
+		s.global = before
+	}
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(jti, subject string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.tokens[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if before, ok := s.subjects[subject]; ok && issuedAt.Before(before) {
+		return true, nil
+	}
+	return issuedAt.Before(s.global), nil
+}
+
+func (s *MemoryRevocationStore) Prune(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, expiresAt := range s.tokens {
+		if now.After(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for subject, before := range s.subjects {
+		if now.After(cutoffExpiry(before)) {
+			delete(s.subjects, subject)
+		}
+	}
+	if !s.global.IsZero() && now.After(cutoffExpiry(s.global)) {
+		s.global = time.Time{}
+	}
+	return nil
+}
+
+// WithContext - Return the store itself, since it makes no queries
+func (s *MemoryRevocationStore) WithContext(context.Context) RevocationStore {
+	return s
+}
+
+// PostgresRevocationStore keeps the denylist in the token_revocations table so that it is shared by every instance.
+type PostgresRevocationStore struct {
+	DB *gorm.DB
+}
+
+func (s *PostgresRevocationStore) WithContext(ctx context.Context) RevocationStore {
+	return &PostgresRevocationStore{DB: s.DB.WithContext(ctx)}
+}
+
+func (s *PostgresRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	return s.upsert(models.TokenRevocation{
+		Kind:      models.RevocationToken,
+		Subject:   jti,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (s *PostgresRevocationStore) RevokeSubject(subject string, before time.Time) error {
+	before = revocationCutoff(before)
+	return s.upsert(models.TokenRevocation{
+		Kind:          models.RevocationUser,
+		Subject:       subject,
+		RevokedBefore: before,
+		ExpiresAt:     cutoffExpiry(before),
+	})
+}
+
+func (s *PostgresRevocationStore) RevokeAll(before time.Time) error {
+	before = revocationCutoff(before)
+	return s.upsert(models.TokenRevocation{
+		Kind:          models.RevocationGlobal,
+		Subject:       "*",
+		RevokedBefore: before,
+		ExpiresAt:     cutoffExpiry(before),
+	})
+}
+
+// upsert - Add an entry, or extend the existing entry of the same kind and subject. The later cutoff and expiry are
+// kept, so that a revocation that arrives late never shortens a newer one.
+func (s *PostgresRevocationStore) upsert(entry models.TokenRevocation) error {
+	entry.RevokedBefore = entry.RevokedBefore.UTC()
+	entry.ExpiresAt = entry.ExpiresAt.UTC()
+	greatest := "GREATEST"
+	if s.DB.Dialector.Name() == "sqlite" {
+		greatest = "MAX"
+	}
+	return s.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "subject"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "revoked_before"}, Value: gorm.Expr(greatest + "(token_revocations.revoked_before, excluded.revoked_before)")},
+			{Column: clause.Column{Name: "expires_at"}, Value: gorm.Expr(greatest + "(token_revocations.expires_at, excluded.expires_at)")},
+		},
+	}).Create(&entry).Error
+}
+
+func (s *PostgresRevocationStore) IsRevoked(jti, subject string, issuedAt time.Time) (bool, error) {
+	issuedAt = issuedAt.UTC()
+	var count int64
+	err := s.DB.Model(&models.TokenRevocation{}).
+		Where("expires_at > ?", time.Now().UTC()).
+		Where(s.DB.Where("kind = ? AND subject = ? AND subject <> ''", models.RevocationToken, jti).
+			Or("kind = ? AND subject = ? AND revoked_before > ?", models.RevocationUser, subject, issuedAt).
+			Or("kind = ? AND revoked_before > ?", models.RevocationGlobal, issuedAt)).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *PostgresRevocationStore) Prune(now time.Time) error {
+	return s.DB.Where("expires_at <= ?", now.UTC()).Delete(&models.TokenRevocation{}).Error
+}
+
+// StartRevocationPruner prunes expired denylist entries every interval until the returned stop function is called.
+func StartRevocationPruner(store RevocationStore, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := store.Prune(time.Now()); err != nil {
+					logger.Error("Failed to prune token revocations", "error", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
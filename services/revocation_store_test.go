@@ -0,0 +1,108 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/services"
+	"testing"
+	"time"
+)
+
+func TestRevocationStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) services.RevocationStore{
+		"Memory": func(t *testing.T) services.RevocationStore { return services.NewMemoryRevocationStore() },
+		"SQLite": func(t *testing.T) services.RevocationStore {
+			return &services.PostgresRevocationStore{DB: dbtest.SQLite(t)}
+		},
+		"Postgres": func(t *testing.T) services.RevocationStore {
+			return &services.PostgresRevocationStore{DB: dbtest.Postgres(t)}
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("Token", func(t *testing.T) { testRevokeToken(t, newStore(t)) })
+			t.Run("SubjectSameSecond", func(t *testing.T) { testRevokeSubjectSameSecond(t, newStore(t)) })
+			t.Run("SubjectKeepsLatest", func(t *testing.T) { testRevokeSubjectKeepsLatest(t, newStore(t)) })
+			t.Run("All", func(t *testing.T) { testRevokeAll(t, newStore(t)) })
+			t.Run("Prune", func(t *testing.T) { testPrune(t, newStore(t)) })
+		})
+	}
+}
+
+func assertRevoked(t *testing.T, store services.RevocationStore, jti, subject string, issuedAt time.Time, want bool) {
+	t.Helper()
+	revoked, err := store.IsRevoked(jti, subject, issuedAt)
+	if err != nil {
+		t.Fatalf("IsRevoked: %v", err)
+	}
+	if revoked != want {
+		t.Errorf("IsRevoked(%q, %q, %s) = %v, want %v", jti, subject, issuedAt.Format(time.RFC3339Nano), revoked, want)
+	}
+}
+
+func testRevokeToken(t *testing.T, store services.RevocationStore) {
+	issued := time.Now().Truncate(time.Second)
+	if err := store.RevokeToken("jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, store, "jti-1", "1", issued, true)
+	assertRevoked(t, store, "jti-2", "1", issued, false)
+	assertRevoked(t, store, "", "1", issued, false)
+}
+
+// A token issued in the same second as a revocation of its subject carries the same issue time whether it was issued
+// before or after the revocation, so it is revoked; tokens of the next second are valid
+func testRevokeSubjectSameSecond(t *testing.T, store services.RevocationStore) {
+	second := time.Now().Truncate(time.Second)
+	if err := store.RevokeSubject("1", second.Add(700*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, store, "a", "1", second.Add(-time.Second), true)
+	assertRevoked(t, store, "b", "1", second, true)
+	assertRevoked(t, store, "c", "1", second.Add(time.Second), false)
+	assertRevoked(t, store, "d", "2", second.Add(-time.Second), false)
+}
+
+// An older revocation recorded after a newer one does not move the cutoff back
+func testRevokeSubjectKeepsLatest(t *testing.T, store services.RevocationStore) {
+	now := time.Now().Truncate(time.Second)
+	if err := store.RevokeSubject("1", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubject("1", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, store, "a", "1", now.Add(-time.Second), true)
+
+	if err := store.RevokeSubject("1", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, store, "a", "1", now.Add(30*time.Second), true)
+}
+
+func testRevokeAll(t *testing.T, store services.RevocationStore) {
+	now := time.Now().Truncate(time.Second)
+	if err := store.RevokeAll(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeAll(now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, store, "a", "1", now.Add(-time.Second), true)
+	assertRevoked(t, store, "b", "2", now, true)
+	assertRevoked(t, store, "c", "2", now.Add(time.Second), false)
+}
+
+func testPrune(t *testing.T, store services.RevocationStore) {
+	now := time.Now().Truncate(time.Second)
+	if err := store.RevokeToken("jti-1", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubject("1", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Prune(now.Add(24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, store, "jti-1", "2", now, false)
+	assertRevoked(t, store, "a", "1", now.Add(-time.Second), false)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/storage"
+
+	"gorm.io/gorm"
+)
+
+// Tenant is the organization a request acts in, taken from the tenant claim of its token. Super admins act across
+// every organization.
+type Tenant struct {
+	OrganizationID uint
+	CrossTenant    bool
+}
+
+// TenantFromClaims - Return the tenant of the caller of a request. Only users holding the super_admin role work
+// across tenants; OAuth2 clients always act in the organization they were registered in.
+func TenantFromClaims(claims *models.JWTClaims) Tenant {
+	tenant := Tenant{OrganizationID: claims.TenantID}
+	if !claims.IsClientToken() {
+		tenant.CrossTenant = containsRole(claims.RoleNames(), models.RoleSuperAdmin)
+	}
+	return tenant
+}
+
+// Scope - Restrict a query to the rows of the tenant. The table of the query must have an organization_id column.
+func (t Tenant) Scope(db *gorm.DB) *gorm.DB {
+	if t.CrossTenant {
+		return db
+	}
+	return db.Where("organization_id = ?", t.OrganizationID)
+}
+
+// Owns - Report whether a row of the given organization belongs to the tenant
+func (t Tenant) Owns(organizationID uint) bool {
+	return t.CrossTenant || t.OrganizationID == organizationID
+}
+
+// findTenantUser - Look up a user of the tenant. Users of other tenants are reported as not found, so that their
+// existence is not revealed.
+func findTenantUser(tx *gorm.DB, tenant Tenant, userID uint) (models.User, error) {
+	var user models.User
+	if err := tx.Scopes(tenant.Scope).First(&user, userID).Error; err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// getTenantUser - Look up a user of the tenant through the store. Users of other tenants are reported as
+// storage.ErrNotFound, so that their existence is not revealed.
+func getTenantUser(store storage.Store, tenant Tenant, userID uint) (models.User, error) {
+	user, err := store.Users().Get(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if !tenant.Owns(user.OrganizationID) {
+		return models.User{}, storage.ErrNotFound
+	}
+	return user, nil
+}
+
+// listTenantUsers - Return the users of the tenant with their roles. A cross-tenant caller may narrow the list to one
+// organization; zero returns the users of every organization.
+func listTenantUsers(store storage.Store, tenant Tenant, organizationID uint) ([]models.User, error) {
+	if !tenant.CrossTenant {
+		if tenant.OrganizationID == 0 || (organizationID != 0 && organizationID != tenant.OrganizationID) {
+			return []models.User{}, nil
+		}
+		organizationID = tenant.OrganizationID
+	}
+	return store.Users().List(organizationID)
+}
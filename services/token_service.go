@@ -0,0 +1,174 @@
+package services
+
+import (
+	"api-service/models"
+	"api-service/utils"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshTokenTTL is the lifetime of a refresh token. Every rotation issues a new token with a fresh lifetime.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReuse   = errors.New("refresh token reuse detected")
+)
+
+type TokenService struct {
+	DB          *gorm.DB
+	Revocations RevocationStore
+}
+
+// WithContext - Return a copy of the service whose queries are traced as part of ctx, such as the request being
+// handled
+func (ts *TokenService) WithContext(ctx context.Context) *TokenService {
+	bound := *ts
+	bound.DB = ts.DB.WithContext(ctx)
+	bound.Revocations = bindRevocations(ts.Revocations, ctx)
+	return &bound
+}
+
+// IssueTokens - Issue an access JWT and a refresh token starting a new token family
+func (ts *TokenService) IssueTokens(user models.User) (models.TokenPair, error) {
+	familyID, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	return ts.issue(ts.DB, user, familyID)
+}
+
+// RotateRefreshToken - Exchange a refresh token for a new token pair. The presented token is consumed;
+// presenting it again revokes every refresh token of its family and every access token of its user, since the
+// access tokens issued from a stolen family cannot be told apart from the others.
+func (ts *TokenService) RotateRefreshToken(refreshToken string) (models.TokenPair, error) {
+	var pair models.TokenPair
+	reused := false
+
+	err := ts.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		if err := tx.Where("token_hash = ?", utils.HashToken(refreshToken)).First(&current).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if current.UsedAt != nil || current.RevokedAt != nil {
+			reused = true
+			return nil
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		// Mark the token as used. The used_at condition makes concurrent rotations of the same token
+		// race on the row, so only one of them can succeed.
+		now := time.Now()
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", current.ID).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		var user models.User
+		if err := tx.First(&user, current.UserID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		var err error
+		pair, err = ts.issue(tx, user, current.FamilyID)
+		return err
+	})
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	if reused {
+		// Revoke outside the transaction above so the revocation is committed even though the request fails.
+		var current models.RefreshToken
+		if err := ts.DB.Where("token_hash = ?", utils.HashToken(refreshToken)).First(&current).Error; err != nil {
+			return models.TokenPair{}, err
+		}
+		subject := strconv.FormatUint(uint64(current.UserID), 10)
+		if err := errors.Join(ts.RevokeFamily(current.FamilyID), ts.Revocations.RevokeSubject(subject, time.Now())); err != nil {
+			return models.TokenPair{}, err
+		}
+		return models.TokenPair{}, ErrRefreshTokenReuse
+	}
+
+	return pair, nil
+}
+
+// Logout - Revoke the presented access token and, when given, the refresh token family it belongs to
+func (ts *TokenService) Logout(claims *models.JWTClaims, refreshToken string) error {
+	if err := ts.Revocations.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return err
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	var current models.RefreshToken
+	if err := ts.DB.Where("token_hash = ?", utils.HashToken(refreshToken)).First(&current).Error; err != nil {
+		return nil
+	}
+	if strconv.FormatUint(uint64(current.UserID), 10) != claims.Subject {
+		return nil
+	}
+	return ts.RevokeFamily(current.FamilyID)
+}
+
+// RevokeFamily - Revoke every refresh token that descends from the same login
+func (ts *TokenService) RevokeFamily(familyID string) error {
+	return ts.DB.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserTokens - Revoke every refresh token held by a user
+func (ts *TokenService) RevokeUserTokens(userID uint) error {
+	return ts.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (ts *TokenService) issue(tx *gorm.DB, user models.User, familyID string) (models.TokenPair, error) {
+	if err := loadRoles(tx, &user); err != nil {
+		return models.TokenPair{}, err
+	}
+	if err := awaitRevocationCutoff(ts.Revocations, strconv.FormatUint(uint64(user.ID), 10)); err != nil {
+		return models.TokenPair{}, err
+	}
+	accessToken, err := utils.GenerateJWT(user)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return models.TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
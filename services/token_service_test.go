@@ -0,0 +1,137 @@
+package services_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/services"
+	"api-service/utils"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// useTestKeys - Sign tokens with a fresh key until the test ends
+func useTestKeys(t *testing.T) {
+	t.Helper()
+	keys, err := utils.NewKeyManager(utils.AlgES256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	previous := utils.Keys
+	utils.Keys = keys
+	t.Cleanup(func() { utils.Keys = previous })
+}
+
+func newTokenService(t *testing.T) (*services.TokenService, *gorm.DB) {
+	t.Helper()
+	useTestKeys(t)
+	conn := dbtest.SQLite(t)
+	return &services.TokenService{DB: conn, Revocations: services.NewMemoryRevocationStore()}, conn
+}
+
+// assertAccessRevoked - Check whether an access token has been revoked
+func assertAccessRevoked(t *testing.T, store services.RevocationStore, token string, want bool) {
+	t.Helper()
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := store.IsRevoked(claims.Id, claims.Subject, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != want {
+		t.Fatalf("access token issued at %d: revoked %v, want %v", claims.IssuedAt, revoked, want)
+	}
+}
+
+func TestTokensIssuedAfterARevocationAreValid(t *testing.T) {
+	ts, conn := newTokenService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+
+	before, err := ts.IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Revocations.RevokeSubject(strconv.FormatUint(uint64(user.ID), 10), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	after, err := ts.IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAccessRevoked(t, ts.Revocations, before.Token, true)
+	assertAccessRevoked(t, ts.Revocations, after.Token, false)
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	ts, conn := newTokenService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+
+	first, err := ts.IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ts.RotateRefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.Token == "" {
+		t.Fatal("rotation did not issue a new token pair")
+	}
+	assertAccessRevoked(t, ts.Revocations, first.Token, false)
+	assertAccessRevoked(t, ts.Revocations, second.Token, false)
+
+	if _, err := ts.RotateRefreshToken("unknown"); !errors.Is(err, services.ErrInvalidRefreshToken) {
+		t.Fatalf("unknown token: %v, want %v", err, services.ErrInvalidRefreshToken)
+	}
+}
+
+// Replaying a rotated refresh token, as a thief or the victim of a theft would, kills the whole family and every
+// access token issued from it
+func TestRotateRefreshTokenDetectsReuse(t *testing.T) {
+	ts, conn := newTokenService(t)
+	user := createUser(t, conn, "alice", "Old-Passw0rd!")
+	other := createUser(t, conn, "bob", "Old-Passw0rd!")
+
+	first, err := ts.IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ts.RotateRefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bystander, err := ts.IssueTokens(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.RotateRefreshToken(first.RefreshToken); !errors.Is(err, services.ErrRefreshTokenReuse) {
+		t.Fatalf("replayed token: %v, want %v", err, services.ErrRefreshTokenReuse)
+	}
+	if _, err := ts.RotateRefreshToken(second.RefreshToken); !errors.Is(err, services.ErrRefreshTokenReuse) {
+		t.Fatalf("latest token of the family: %v, want %v", err, services.ErrRefreshTokenReuse)
+	}
+	assertAccessRevoked(t, ts.Revocations, first.Token, true)
+	assertAccessRevoked(t, ts.Revocations, second.Token, true)
+
+	// Other users keep their tokens, and the victim can log in again
+	assertAccessRevoked(t, ts.Revocations, bystander.Token, false)
+	if _, err := ts.RotateRefreshToken(bystander.RefreshToken); err != nil {
+		t.Fatalf("bystander: %v", err)
+	}
+	again, err := ts.IssueTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAccessRevoked(t, ts.Revocations, again.Token, false)
+	if _, err := ts.RotateRefreshToken(again.RefreshToken); err != nil {
+		t.Fatalf("new login: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"api-service/storage"
+	"api-service/tracing"
+	"context"
+)
+
+// startSpan - Start the span of a service method as a child of ctx, the context the service was bound to with
+// WithContext, and return the store bound to the span so that the queries of the method are traced in it
+func startSpan(ctx context.Context, store storage.Store, name string, attrs ...tracing.Attribute) (context.Context, storage.Store, *tracing.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracing.Start(ctx, name, attrs...)
+	return ctx, store.WithContext(ctx), span
+}
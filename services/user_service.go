@@ -1,87 +1,154 @@
 package services
 
 import (
+	"api-service/logging"
 	"api-service/models"
-	"api-service/utils"
+	"api-service/storage"
+	"api-service/tracing"
+	"context"
 	"errors"
 
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
+var logger = logging.For("services")
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyPasswordHash is compared against when the username does not exist, so that a failed login takes as long for
+// an unknown username as for a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
 type UserService struct {
-	DB *gorm.DB
+	Store   storage.Store
+	Lockout *LockoutService // Counts failed logins; nil disables the lockout
+
+	ctx context.Context // Parent of the spans of the methods, see WithContext
+}
+
+// WithContext - Return a copy of the service whose methods are traced as part of ctx, such as the request being
+// handled
+func (us *UserService) WithContext(ctx context.Context) *UserService {
+	bound := *us
+	bound.ctx = ctx
+	return &bound
 }
 
 // CreateUser - Create a new user in the DB
-func (us *UserService) CreateUser(user *models.User) error {
+func (us *UserService) CreateUser(user *models.User) (err error) {
+	_, store, span := startSpan(us.ctx, us.Store, "UserService.CreateUser")
+	defer span.EndErr(&err)
+
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 	user.Password = string(hashedPassword)
+	// New addresses start unverified, whatever the request body says
+	user.EmailVerifiedAt = nil
+	user.PendingEmail = ""
 
-	// Save the user
-	return us.DB.Create(user).Error
+	// Save the user together with the role it was created with. Users without an organization join the default one.
+	return store.Transaction(func(tx storage.Store) error {
+		if user.OrganizationID == 0 {
+			org, err := tx.Organizations().Default()
+			if err != nil {
+				return err
+			}
+			user.OrganizationID = org.ID
+		}
+		// Roles are only given through Role, never taken from the request body
+		return tx.Users().Create(user, user.Role)
+	})
 }
 
-// Authenticate - Authenticate user credentials
-func (us *UserService) Authenticate(username, password string) (*models.User, error) {
-	var user models.User
-	err := us.DB.Where("username = ?", username).First(&user).Error
-	if err != nil {
-		return nil, errors.New("user not found")
+// Authenticate - Authenticate user credentials from the given client IP address. Locked usernames and addresses
+// get a *LockedError without the password being checked; unknown usernames and wrong passwords both get
+// ErrInvalidCredentials.
+func (us *UserService) Authenticate(username, password, ip string) (_ *models.User, err error) {
+	ctx, store, span := startSpan(us.ctx, us.Store, "UserService.Authenticate")
+	defer span.EndErr(&err)
+	lockout := us.Lockout.WithContext(ctx)
+
+	if err := lockout.Check(username, ip); err != nil {
+		return nil, err
+	}
+
+	user, err := store.Users().GetByUsername(username)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	hash := dummyPasswordHash
+	if err == nil {
+		hash = []byte(user.Password)
 	}
-	print(password)
 	// Compare password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
-		return nil, errors.New("invalid password")
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || err != nil {
+		if err := lockout.RecordFailure(username, ip); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
 	}
 
+	if err := lockout.RecordSuccess(username); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(tracing.Int("user.id", int(user.ID)))
 	return &user, nil
 }
-func (s *UserService) Login(username, password string) (string, error) {
-	var user models.User
-	if err := s.DB.Where("username = ?", username).First(&user).Error; err != nil {
-		return "", errors.New("invalid credentials")
-	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
-	}
+func (s *UserService) GetProfile(username string) (_ models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "UserService.GetProfile")
+	defer span.EndErr(&err)
+	return store.Users().GetByUsername(username)
+}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user)
-	if err != nil {
-		return "", err
-	}
+// GetUser - Return a user by ID in any organization, such as the account of the caller of a request
+func (s *UserService) GetUser(userID uint) (_ models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "UserService.GetUser", tracing.Int("user.id", int(userID)))
+	defer span.EndErr(&err)
+	return store.Users().Get(userID)
+}
 
-	return token, nil
+// GetUserByID - Return a user of the tenant by ID. Users of other tenants are reported as not found.
+func (s *UserService) GetUserByID(tenant Tenant, userID uint) (_ models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "UserService.GetUserByID", tracing.Int("user.id", int(userID)))
+	defer span.EndErr(&err)
+	return getTenantUser(store, tenant, userID)
 }
 
-func (s *UserService) GetProfile(username string) (models.User, error) {
-	var user models.User
-	print(username)
-	if err := s.DB.Where("username = ?", username).First(&user).Error; err != nil {
-		return models.User{}, err
-	}
-	return user, nil
+// ListUsers - Return every user of the tenant with their roles
+func (s *UserService) ListUsers(tenant Tenant) (_ []models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "UserService.ListUsers")
+	defer span.EndErr(&err)
+	return listTenantUsers(store, tenant, 0)
 }
 
-func (s *UserService) UpdateProfile(username string, mobile, address string) (models.User, error) {
-	var user models.User
-	if err := s.DB.Where("username = ?", username).First(&user).Error; err != nil {
+func (s *UserService) UpdateProfile(username string, mobile, address string) (_ models.User, err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "UserService.UpdateProfile")
+	defer span.EndErr(&err)
+
+	user, err := store.Users().GetByUsername(username)
+	if err != nil {
 		return models.User{}, err
 	}
 
 	user.Mobile = mobile
 	user.Address = address
 
-	if err := s.DB.Save(&user).Error; err != nil {
+	if err := store.Users().Update(&user); err != nil {
 		return models.User{}, err
 	}
 
 	return user, nil
 }
+
+// SaveToken - Store the access token last issued to a user
+func (s *UserService) SaveToken(user *models.User, token string) (err error) {
+	_, store, span := startSpan(s.ctx, s.Store, "UserService.SaveToken", tracing.Int("user.id", int(user.ID)))
+	defer span.EndErr(&err)
+
+	user.Token = token
+	return store.Users().Update(user)
+}
@@ -0,0 +1,446 @@
+package storage
+
+import (
+	"api-service/models"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the repositories in process memory. It is meant for tests of services; the server always runs
+// on SQLStore, since the services that do not use repositories yet need a database. Transactions are serialized and
+// roll back by restoring a copy of the data.
+type MemoryStore struct {
+	txMu sync.Mutex // Held for the whole of an outermost transaction
+	mu   sync.Mutex // Held for each operation
+	data memoryData
+}
+
+// memoryData holds the rows of every repository. IDs come from one counter shared by all of them.
+type memoryData struct {
+	lastID        uint
+	users         map[uint]models.User
+	userRoles     map[uint][]uint // User ID -> IDs of the roles they hold
+	organizations map[uint]models.Organization
+	roles         map[uint]models.Role
+	refreshTokens map[uint]models.RefreshToken
+	clients       map[uint]models.RegisteredClient
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: memoryData{
+		users:         make(map[uint]models.User),
+		userRoles:     make(map[uint][]uint),
+		organizations: make(map[uint]models.Organization),
+		roles:         make(map[uint]models.Role),
+		refreshTokens: make(map[uint]models.RefreshToken),
+		clients:       make(map[uint]models.RegisteredClient),
+	}}
+}
+
+func (s *MemoryStore) Users() UserRepository                 { return memoryUsers{s} }
+func (s *MemoryStore) Organizations() OrganizationRepository { return memoryOrganizations{s} }
+func (s *MemoryStore) Roles() RoleRepository                 { return memoryRoles{s} }
+func (s *MemoryStore) RefreshTokens() RefreshTokenRepository { return memoryRefreshTokens{s} }
+func (s *MemoryStore) Clients() ClientRepository             { return memoryClients{s} }
+
+func (s *MemoryStore) Transaction(fn func(tx Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	return memoryTx{s}.Transaction(fn)
+}
+
+// WithContext - The memory store makes no queries to trace, so the context is not used
+func (s *MemoryStore) WithContext(ctx context.Context) Store {
+	return s
+}
+
+// memoryTx is the Store passed to a transaction. Its own transactions are nested in the outer one and do not take
+// the transaction lock again.
+type memoryTx struct {
+	*MemoryStore
+}
+
+func (tx memoryTx) WithContext(ctx context.Context) Store {
+	return tx
+}
+
+func (tx memoryTx) Transaction(fn func(tx Store) error) error {
+	tx.mu.Lock()
+	saved := tx.data.clone()
+	tx.mu.Unlock()
+
+	if err := fn(tx); err != nil {
+		tx.mu.Lock()
+		tx.data = saved
+		tx.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// clone - Copy the data deeply enough that changes to the copy do not affect the original
+func (d memoryData) clone() memoryData {
+	c := memoryData{
+		lastID:        d.lastID,
+		users:         make(map[uint]models.User, len(d.users)),
+		userRoles:     make(map[uint][]uint, len(d.userRoles)),
+		organizations: make(map[uint]models.Organization, len(d.organizations)),
+		roles:         make(map[uint]models.Role, len(d.roles)),
+		refreshTokens: make(map[uint]models.RefreshToken, len(d.refreshTokens)),
+		clients:       make(map[uint]models.RegisteredClient, len(d.clients)),
+	}
+	for id, user := range d.users {
+		c.users[id] = user
+	}
+	for id, roles := range d.userRoles {
+		c.userRoles[id] = append([]uint(nil), roles...)
+	}
+	for id, org := range d.organizations {
+		c.organizations[id] = org
+	}
+	for id, role := range d.roles {
+		c.roles[id] = role
+	}
+	for id, token := range d.refreshTokens {
+		c.refreshTokens[id] = token
+	}
+	for id, client := range d.clients {
+		c.clients[id] = client
+	}
+	return c
+}
+
+func (d *memoryData) nextID() uint {
+	d.lastID++
+	return d.lastID
+}
+
+// sortedIDs - Return the keys of a map in ascending order
+func sortedIDs[T any](rows map[uint]T) []uint {
+	ids := make([]uint, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type memoryUsers struct{ s *MemoryStore }
+
+func (r memoryUsers) Create(user *models.User, role string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+
+	var held *models.Role
+	for _, id := range sortedIDs(d.roles) {
+		if d.roles[id].Name == role {
+			found := d.roles[id]
+			held = &found
+		}
+	}
+	if held == nil {
+		return ErrRoleNotFound
+	}
+	if r.taken(user, 0) {
+		return ErrDuplicate
+	}
+
+	user.ID = d.nextID()
+	user.Roles = []models.Role{*held}
+	stored := *user
+	stored.Roles, stored.EffectiveRoles = nil, nil
+	d.users[user.ID] = stored
+	d.userRoles[user.ID] = []uint{held.ID}
+	return nil
+}
+
+// taken - Report whether another user than the one with the given ID has the username or email address of user.
+// The lock must be held.
+func (r memoryUsers) taken(user *models.User, id uint) bool {
+	for _, other := range r.s.data.users {
+		if other.ID != id && (other.Username == user.Username || other.Email == user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryUsers) Get(id uint) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.data.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) GetByUsername(username string) (models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, user := range r.s.data.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) List(organizationID uint) ([]models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+
+	users := []models.User{}
+	for _, id := range sortedIDs(d.users) {
+		user := d.users[id]
+		if organizationID != 0 && user.OrganizationID != organizationID {
+			continue
+		}
+		user.Roles = []models.Role{}
+		for _, roleID := range d.userRoles[id] {
+			user.Roles = append(user.Roles, d.roles[roleID])
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r memoryUsers) IDs(organizationID uint) ([]uint, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	ids := []uint{}
+	for _, id := range sortedIDs(r.s.data.users) {
+		if organizationID == 0 || r.s.data.users[id].OrganizationID == organizationID {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r memoryUsers) Update(user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.data.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+	if r.taken(user, user.ID) {
+		return ErrDuplicate
+	}
+	stored := *user
+	stored.Roles, stored.EffectiveRoles = nil, nil
+	r.s.data.users[user.ID] = stored
+	return nil
+}
+
+func (r memoryUsers) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.data.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.s.data.users, id)
+	delete(r.s.data.userRoles, id)
+	return nil
+}
+
+type memoryOrganizations struct{ s *MemoryStore }
+
+func (r memoryOrganizations) Create(org *models.Organization) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.create(org)
+}
+
+// create - Store a new organization. The lock must be held.
+func (r memoryOrganizations) create(org *models.Organization) error {
+	d := &r.s.data
+	for _, other := range d.organizations {
+		if other.Slug == org.Slug {
+			return ErrDuplicate
+		}
+	}
+	org.ID = d.nextID()
+	if org.CreatedAt.IsZero() {
+		org.CreatedAt = time.Now()
+	}
+	d.organizations[org.ID] = *org
+	return nil
+}
+
+func (r memoryOrganizations) Get(id uint) (models.Organization, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	org, ok := r.s.data.organizations[id]
+	if !ok {
+		return models.Organization{}, ErrNotFound
+	}
+	return org, nil
+}
+
+func (r memoryOrganizations) Default() (models.Organization, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, org := range r.s.data.organizations {
+		if org.Slug == models.DefaultOrganizationSlug {
+			return org, nil
+		}
+	}
+	org := models.Organization{Name: "Default", Slug: models.DefaultOrganizationSlug}
+	return org, r.create(&org)
+}
+
+type memoryRoles struct{ s *MemoryStore }
+
+func (r memoryRoles) Create(role *models.Role) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+	for _, other := range d.roles {
+		if other.Name == role.Name {
+			return ErrDuplicate
+		}
+	}
+	role.ID = d.nextID()
+	if role.CreatedAt.IsZero() {
+		role.CreatedAt = time.Now()
+	}
+	stored := *role
+	stored.Permissions = nil
+	d.roles[role.ID] = stored
+	return nil
+}
+
+func (r memoryRoles) Get(name string) (models.Role, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, role := range r.s.data.roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return models.Role{}, ErrNotFound
+}
+
+func (r memoryRoles) ListByUser(userID uint) ([]models.Role, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+	ids := append([]uint(nil), d.userRoles[userID]...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	roles := []models.Role{}
+	for _, id := range ids {
+		roles = append(roles, d.roles[id])
+	}
+	return roles, nil
+}
+
+func (r memoryRoles) CountHolders(name string, organizationID uint) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+	var count int64
+	for userID, roleIDs := range d.userRoles {
+		if organizationID != 0 && d.users[userID].OrganizationID != organizationID {
+			continue
+		}
+		for _, id := range roleIDs {
+			if d.roles[id].Name == name {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+type memoryRefreshTokens struct{ s *MemoryStore }
+
+func (r memoryRefreshTokens) Create(token *models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+	for _, other := range d.refreshTokens {
+		if other.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	token.ID = d.nextID()
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	d.refreshTokens[token.ID] = *token
+	return nil
+}
+
+func (r memoryRefreshTokens) ListByUser(userID uint) ([]models.RefreshToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	tokens := []models.RefreshToken{}
+	for _, id := range sortedIDs(r.s.data.refreshTokens) {
+		if token := r.s.data.refreshTokens[id]; token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (r memoryRefreshTokens) RevokeByUsers(userIDs []uint, at time.Time) error {
+	users := make(map[uint]bool, len(userIDs))
+	for _, id := range userIDs {
+		users[id] = true
+	}
+	r.revoke(func(token models.RefreshToken) bool { return users[token.UserID] }, at)
+	return nil
+}
+
+func (r memoryRefreshTokens) RevokeAll(at time.Time) error {
+	r.revoke(func(models.RefreshToken) bool { return true }, at)
+	return nil
+}
+
+// revoke - Mark the tokens that match and are not revoked yet as revoked
+func (r memoryRefreshTokens) revoke(match func(models.RefreshToken) bool, at time.Time) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for id, token := range r.s.data.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			revokedAt := at
+			token.RevokedAt = &revokedAt
+			r.s.data.refreshTokens[id] = token
+		}
+	}
+}
+
+type memoryClients struct{ s *MemoryStore }
+
+func (r memoryClients) Create(client *models.RegisteredClient) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	d := &r.s.data
+	for _, other := range d.clients {
+		if other.ClientID == client.ClientID {
+			return ErrDuplicate
+		}
+	}
+	client.ID = d.nextID()
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+	d.clients[client.ID] = *client
+	return nil
+}
+
+func (r memoryClients) IDs(organizationID uint) ([]string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	ids := []string{}
+	for _, id := range sortedIDs(r.s.data.clients) {
+		if client := r.s.data.clients[id]; organizationID == 0 || client.OrganizationID == organizationID {
+			ids = append(ids, client.ClientID)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,11 @@
+package storage_test
+
+import (
+	"api-service/storage"
+	"api-service/storage/storagetest"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return storage.NewMemoryStore() })
+}
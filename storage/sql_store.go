@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"api-service/models"
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLStore implements the repositories with gorm. It works with PostgreSQL and SQLite; the database must be opened
+// with TranslateError, as db.Open does, so that unique violations are reported as ErrDuplicate.
+type SQLStore struct {
+	DB *gorm.DB
+}
+
+func (s *SQLStore) Users() UserRepository                 { return sqlUsers{s.DB} }
+func (s *SQLStore) Organizations() OrganizationRepository { return sqlOrganizations{s.DB} }
+func (s *SQLStore) Roles() RoleRepository                 { return sqlRoles{s.DB} }
+func (s *SQLStore) RefreshTokens() RefreshTokenRepository { return sqlRefreshTokens{s.DB} }
+func (s *SQLStore) Clients() ClientRepository             { return sqlClients{s.DB} }
+
+func (s *SQLStore) Transaction(fn func(tx Store) error) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(&SQLStore{DB: tx})
+	})
+}
+
+func (s *SQLStore) WithContext(ctx context.Context) Store {
+	return &SQLStore{DB: s.DB.WithContext(ctx)}
+}
+
+// translate - Map the errors of gorm to the errors of the package
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	default:
+		return err
+	}
+}
+
+// byOrganization - Restrict a query to an organization, unless organizationID is 0
+func byOrganization(organizationID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if organizationID == 0 {
+			return db
+		}
+		return db.Where("organization_id = ?", organizationID)
+	}
+}
+
+type sqlUsers struct{ db *gorm.DB }
+
+func (r sqlUsers) Create(user *models.User, role string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var held models.Role
+		if err := tx.Where("name = ?", role).First(&held).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRoleNotFound
+			}
+			return err
+		}
+		user.Roles = nil
+		if err := tx.Create(user).Error; err != nil {
+			return translate(err)
+		}
+		return tx.Model(user).Association("Roles").Append(&held)
+	})
+}
+
+func (r sqlUsers) Get(id uint) (models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return models.User{}, translate(err)
+	}
+	return user, nil
+}
+
+func (r sqlUsers) GetByUsername(username string) (models.User, error) {
+	var user models.User
+	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return models.User{}, translate(err)
+	}
+	return user, nil
+}
+
+func (r sqlUsers) List(organizationID uint) ([]models.User, error) {
+	users := []models.User{}
+	if err := r.db.Scopes(byOrganization(organizationID)).Preload("Roles").Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r sqlUsers) IDs(organizationID uint) ([]uint, error) {
+	ids := []uint{}
+	if err := r.db.Model(&models.User{}).Scopes(byOrganization(organizationID)).Order("id").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r sqlUsers) Update(user *models.User) error {
+	res := r.db.Model(user).Select("*").Omit("ID", "Roles").Updates(user)
+	if res.Error != nil {
+		return translate(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlUsers) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM group_members WHERE user_id = ?", id).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.User{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+type sqlOrganizations struct{ db *gorm.DB }
+
+func (r sqlOrganizations) Create(org *models.Organization) error {
+	return translate(r.db.Create(org).Error)
+}
+
+func (r sqlOrganizations) Get(id uint) (models.Organization, error) {
+	var org models.Organization
+	if err := r.db.First(&org, id).Error; err != nil {
+		return models.Organization{}, translate(err)
+	}
+	return org, nil
+}
+
+func (r sqlOrganizations) Default() (models.Organization, error) {
+	org := models.Organization{Name: "Default", Slug: models.DefaultOrganizationSlug}
+	if err := r.db.Where(models.Organization{Slug: org.Slug}).Attrs(org).FirstOrCreate(&org).Error; err != nil {
+		return models.Organization{}, translate(err)
+	}
+	return org, nil
+}
+
+type sqlRoles struct{ db *gorm.DB }
+
+func (r sqlRoles) Create(role *models.Role) error {
+	return translate(r.db.Omit("Permissions").Create(role).Error)
+}
+
+func (r sqlRoles) Get(name string) (models.Role, error) {
+	var role models.Role
+	if err := r.db.Where("name = ?", name).First(&role).Error; err != nil {
+		return models.Role{}, translate(err)
+	}
+	return role, nil
+}
+
+func (r sqlRoles) ListByUser(userID uint) ([]models.Role, error) {
+	roles := []models.Role{}
+	err := r.db.Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).Order("roles.id").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r sqlRoles) CountHolders(name string, organizationID uint) (int64, error) {
+	var count int64
+	query := r.db.Table("user_roles").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("roles.name = ?", name)
+	if organizationID != 0 {
+		query = query.Joins("JOIN users ON users.id = user_roles.user_id").Where("users.organization_id = ?", organizationID)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+type sqlRefreshTokens struct{ db *gorm.DB }
+
+func (r sqlRefreshTokens) Create(token *models.RefreshToken) error {
+	return translate(r.db.Create(token).Error)
+}
+
+func (r sqlRefreshTokens) ListByUser(userID uint) ([]models.RefreshToken, error) {
+	tokens := []models.RefreshToken{}
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r sqlRefreshTokens) RevokeByUsers(userIDs []uint, at time.Time) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id IN ? AND revoked_at IS NULL", userIDs).
+		Update("revoked_at", at).Error
+}
+
+func (r sqlRefreshTokens) RevokeAll(at time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", at).Error
+}
+
+type sqlClients struct{ db *gorm.DB }
+
+func (r sqlClients) Create(client *models.RegisteredClient) error {
+	return translate(r.db.Create(client).Error)
+}
+
+func (r sqlClients) IDs(organizationID uint) ([]string, error) {
+	ids := []string{}
+	if err := r.db.Model(&models.RegisteredClient{}).Scopes(byOrganization(organizationID)).Order("id").Pluck("client_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
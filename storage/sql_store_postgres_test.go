@@ -0,0 +1,15 @@
+//go:build postgres
+
+package storage_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/storage"
+	"api-service/storage/storagetest"
+	"testing"
+)
+
+// Run with go test -tags postgres and TEST_POSTGRES_URL set to a database the test may empty
+func TestSQLStorePostgres(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return &storage.SQLStore{DB: dbtest.Postgres(t)} })
+}
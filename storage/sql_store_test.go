@@ -0,0 +1,12 @@
+package storage_test
+
+import (
+	"api-service/db/dbtest"
+	"api-service/storage"
+	"api-service/storage/storagetest"
+	"testing"
+)
+
+func TestSQLStoreSQLite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return &storage.SQLStore{DB: dbtest.SQLite(t)} })
+}
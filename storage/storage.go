@@ -0,0 +1,91 @@
+package storage
+
+/**
+The storage package puts the database behind one repository per aggregate, so that services do not depend on a particular database. SQLStore implements the repositories with gorm for PostgreSQL and SQLite; MemoryStore keeps them in maps, for tests of services that should not need a database at all. The server always runs on SQLStore, whatever database.driver selects: most services still query gorm on the same tables, so a server on MemoryStore would keep part of its state in maps and the rest in the database. The storagetest package checks that every implementation behaves the same way.
+*/
+import (
+	"api-service/models"
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("record not found")
+	ErrDuplicate    = errors.New("record already exists")
+	ErrRoleNotFound = errors.New("role not found")
+)
+
+// Store gives access to the repositories of one database.
+type Store interface {
+	Users() UserRepository
+	Organizations() OrganizationRepository
+	Roles() RoleRepository
+	RefreshTokens() RefreshTokenRepository
+	Clients() ClientRepository
+
+	// Transaction runs fn with a Store whose changes are kept if fn returns nil and discarded otherwise.
+	// Transactions may be nested.
+	Transaction(fn func(tx Store) error) error
+
+	// WithContext returns a Store whose queries are made as part of ctx, such as the request being handled, so that
+	// they are traced in it.
+	WithContext(ctx context.Context) Store
+}
+
+// UserRepository stores user accounts. Usernames and email addresses are unique; an empty email address counts as a
+// value like any other.
+type UserRepository interface {
+	// Create stores a new user holding the named role and sets its ID and Roles. It returns ErrRoleNotFound for an
+	// unknown role and ErrDuplicate if the username or email address is taken.
+	Create(user *models.User, role string) error
+	// Get returns a user by ID, without their roles.
+	Get(id uint) (models.User, error)
+	// GetByUsername returns a user by username, without their roles.
+	GetByUsername(username string) (models.User, error)
+	// List returns the users of an organization, or of every organization for 0, ordered by ID and with the roles
+	// they hold directly.
+	List(organizationID uint) ([]models.User, error)
+	// IDs returns the IDs of the users of an organization, or of every organization for 0.
+	IDs(organizationID uint) ([]uint, error)
+	// Update saves every field of an existing user except the roles.
+	Update(user *models.User) error
+	// Delete removes a user together with their role assignments and group memberships.
+	Delete(id uint) error
+}
+
+// OrganizationRepository stores the organizations users belong to. Slugs are unique.
+type OrganizationRepository interface {
+	Create(org *models.Organization) error
+	Get(id uint) (models.Organization, error)
+	// Default returns the organization with models.DefaultOrganizationSlug, creating it if needed.
+	Default() (models.Organization, error)
+}
+
+// RoleRepository stores roles. Names are unique. Permissions are not stored through it.
+type RoleRepository interface {
+	Create(role *models.Role) error
+	Get(name string) (models.Role, error)
+	// ListByUser returns the roles assigned to a user ordered by ID.
+	ListByUser(userID uint) ([]models.Role, error)
+	// CountHolders counts the users of an organization, or of every organization for 0, holding the named role.
+	CountHolders(name string, organizationID uint) (int64, error)
+}
+
+// RefreshTokenRepository stores the server-side records of refresh tokens.
+type RefreshTokenRepository interface {
+	Create(token *models.RefreshToken) error
+	// ListByUser returns the refresh tokens of a user ordered by ID.
+	ListByUser(userID uint) ([]models.RefreshToken, error)
+	// RevokeByUsers marks the refresh tokens of the users that are not revoked yet as revoked at the given time.
+	RevokeByUsers(userIDs []uint, at time.Time) error
+	// RevokeAll marks every refresh token that is not revoked yet as revoked at the given time.
+	RevokeAll(at time.Time) error
+}
+
+// ClientRepository stores the OAuth2 clients registered by admins. Client IDs are unique.
+type ClientRepository interface {
+	Create(client *models.RegisteredClient) error
+	// IDs returns the client IDs of the clients of an organization, or of every organization for 0.
+	IDs(organizationID uint) ([]string, error)
+}
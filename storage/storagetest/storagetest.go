@@ -0,0 +1,425 @@
+package storagetest
+
+/**
+The storagetest package is the conformance suite of the storage package: every Store implementation must pass it, so that services behave the same on each of them. Run it from a test of the implementation:
+
+	func TestMemoryStore(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) storage.Store { return storage.NewMemoryStore() })
+	}
+
+newStore must return an empty store for every call, such as a fresh in-memory SQLite database with the schema migrated.
+*/
+import (
+	"api-service/models"
+	"api-service/storage"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Run - Run the conformance suite against the stores returned by newStore
+func Run(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Store)
+	}{
+		{"UserCreateAndGet", testUserCreateAndGet},
+		{"UserUnique", testUserUnique},
+		{"UserUnknownRole", testUserUnknownRole},
+		{"UserList", testUserList},
+		{"UserUpdate", testUserUpdate},
+		{"UserDelete", testUserDelete},
+		{"Organizations", testOrganizations},
+		{"Roles", testRoles},
+		{"RoleHolders", testRoleHolders},
+		{"RefreshTokens", testRefreshTokens},
+		{"Clients", testClients},
+		{"TransactionCommit", testTransactionCommit},
+		{"TransactionRollback", testTransactionRollback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// seed - Create a role and an organization for the users of a test
+func seed(t *testing.T, s storage.Store, role, slug string) models.Organization {
+	t.Helper()
+	if err := s.Roles().Create(&models.Role{Name: role}); err != nil && !errors.Is(err, storage.ErrDuplicate) {
+		t.Fatalf("create role %s: %v", role, err)
+	}
+	org := models.Organization{Name: slug, Slug: slug}
+	if err := s.Organizations().Create(&org); err != nil {
+		t.Fatalf("create organization %s: %v", slug, err)
+	}
+	return org
+}
+
+// createUser - Create a user holding the "user" role in an organization
+func createUser(t *testing.T, s storage.Store, username string, organizationID uint) models.User {
+	t.Helper()
+	user := models.User{Username: username, Email: username + "@example.com", Password: "hash", OrganizationID: organizationID}
+	if err := s.Users().Create(&user, "user"); err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return user
+}
+
+func roleNames(user models.User) []string {
+	names := []string{}
+	for _, role := range user.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+func testUserCreateAndGet(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	user := createUser(t, s, "alice", org.ID)
+	if user.ID == 0 {
+		t.Fatal("Create did not set the ID")
+	}
+	if got := roleNames(user); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Errorf("Create set roles %v, want [user]", got)
+	}
+
+	got, err := s.Users().Get(user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Username != "alice" || got.Email != "alice@example.com" || got.OrganizationID != org.ID {
+		t.Errorf("Get returned %+v", got)
+	}
+	if len(got.Roles) != 0 {
+		t.Errorf("Get returned roles %v, want none", roleNames(got))
+	}
+
+	byName, err := s.Users().GetByUsername("alice")
+	if err != nil || byName.ID != user.ID {
+		t.Errorf("GetByUsername returned %d, %v; want %d", byName.ID, err, user.ID)
+	}
+	if _, err := s.Users().Get(user.ID + 1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get of a missing user returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Users().GetByUsername("bob"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetByUsername of a missing user returned %v, want ErrNotFound", err)
+	}
+}
+
+func testUserUnique(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	createUser(t, s, "alice", org.ID)
+
+	sameName := models.User{Username: "alice", Email: "other@example.com", OrganizationID: org.ID}
+	if err := s.Users().Create(&sameName, "user"); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Create with a taken username returned %v, want ErrDuplicate", err)
+	}
+	sameEmail := models.User{Username: "bob", Email: "alice@example.com", OrganizationID: org.ID}
+	if err := s.Users().Create(&sameEmail, "user"); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Create with a taken email returned %v, want ErrDuplicate", err)
+	}
+	if ids, _ := s.Users().IDs(0); len(ids) != 1 {
+		t.Errorf("failed creates left %d users, want 1", len(ids))
+	}
+}
+
+func testUserUnknownRole(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	user := models.User{Username: "alice", Email: "alice@example.com", OrganizationID: org.ID}
+	if err := s.Users().Create(&user, "pilot"); !errors.Is(err, storage.ErrRoleNotFound) {
+		t.Errorf("Create with an unknown role returned %v, want ErrRoleNotFound", err)
+	}
+	if _, err := s.Users().GetByUsername("alice"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Create with an unknown role stored the user: %v", err)
+	}
+}
+
+func testUserList(t *testing.T, s storage.Store) {
+	acme := seed(t, s, "user", "acme")
+	globex := seed(t, s, "user", "globex")
+	alice := createUser(t, s, "alice", acme.ID)
+	bob := createUser(t, s, "bob", globex.ID)
+	carol := createUser(t, s, "carol", acme.ID)
+
+	users, err := s.Users().List(acme.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != alice.ID || users[1].ID != carol.ID {
+		t.Fatalf("List(acme) returned %+v, want alice and carol", users)
+	}
+	if got := roleNames(users[0]); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Errorf("List returned roles %v, want [user]", got)
+	}
+
+	all, err := s.Users().List(0)
+	if err != nil || len(all) != 3 {
+		t.Errorf("List(0) returned %d users, %v; want 3", len(all), err)
+	}
+	if ids, err := s.Users().IDs(globex.ID); err != nil || !reflect.DeepEqual(ids, []uint{bob.ID}) {
+		t.Errorf("IDs(globex) returned %v, %v; want [%d]", ids, err, bob.ID)
+	}
+	if ids, err := s.Users().IDs(globex.ID + 1000); err != nil || len(ids) != 0 {
+		t.Errorf("IDs of an empty organization returned %v, %v", ids, err)
+	}
+}
+
+func testUserUpdate(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	user := createUser(t, s, "alice", org.ID)
+	other := createUser(t, s, "bob", org.ID)
+
+	user.Mobile, user.Address, user.Token = "555-0100", "1 Main St", "token"
+	if err := s.Users().Update(&user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got, _ := s.Users().Get(user.ID)
+	if got.Mobile != "555-0100" || got.Address != "1 Main St" || got.Token != "token" {
+		t.Errorf("Update stored %+v", got)
+	}
+
+	// Zero values are saved too
+	user.Token = ""
+	if err := s.Users().Update(&user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := s.Users().Get(user.ID); got.Token != "" {
+		t.Errorf("Update kept token %q, want it cleared", got.Token)
+	}
+
+	// Updating does not touch the roles
+	users, _ := s.Users().List(org.ID)
+	if got := roleNames(users[0]); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Errorf("after Update the user holds %v, want [user]", got)
+	}
+
+	other.Email = "alice@example.com"
+	if err := s.Users().Update(&other); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Update to a taken email returned %v, want ErrDuplicate", err)
+	}
+	missing := models.User{ID: user.ID + 1000, Username: "nobody", Email: "nobody@example.com"}
+	if err := s.Users().Update(&missing); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Update of a missing user returned %v, want ErrNotFound", err)
+	}
+}
+
+func testUserDelete(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	user := createUser(t, s, "alice", org.ID)
+
+	if err := s.Users().Delete(user.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Users().Get(user.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+	if err := s.Users().Delete(user.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Delete of a missing user returned %v, want ErrNotFound", err)
+	}
+	// The username is free again
+	createUser(t, s, "alice", org.ID)
+}
+
+func testOrganizations(t *testing.T, s storage.Store) {
+	first, err := s.Organizations().Default()
+	if err != nil {
+		t.Fatalf("Default: %v", err)
+	}
+	if first.ID == 0 || first.Slug != models.DefaultOrganizationSlug {
+		t.Errorf("Default returned %+v", first)
+	}
+	second, err := s.Organizations().Default()
+	if err != nil || second.ID != first.ID {
+		t.Errorf("second Default returned %d, %v; want the same organization %d", second.ID, err, first.ID)
+	}
+
+	acme := models.Organization{Name: "Acme", Slug: "acme"}
+	if err := s.Organizations().Create(&acme); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, err := s.Organizations().Get(acme.ID); err != nil || got.Name != "Acme" {
+		t.Errorf("Get returned %+v, %v", got, err)
+	}
+	if err := s.Organizations().Create(&models.Organization{Name: "Other", Slug: "acme"}); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Create with a taken slug returned %v, want ErrDuplicate", err)
+	}
+	if _, err := s.Organizations().Get(acme.ID + 1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get of a missing organization returned %v, want ErrNotFound", err)
+	}
+}
+
+func testRoles(t *testing.T, s storage.Store) {
+	role := models.Role{Name: "auditor", Description: "Reads the audit log"}
+	if err := s.Roles().Create(&role); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	got, err := s.Roles().Get("auditor")
+	if err != nil || got.ID != role.ID || got.Description != "Reads the audit log" {
+		t.Errorf("Get returned %+v, %v", got, err)
+	}
+	if err := s.Roles().Create(&models.Role{Name: "auditor"}); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Create with a taken name returned %v, want ErrDuplicate", err)
+	}
+	if _, err := s.Roles().Get("pilot"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get of a missing role returned %v, want ErrNotFound", err)
+	}
+}
+
+func testRoleHolders(t *testing.T, s storage.Store) {
+	acme := seed(t, s, "user", "acme")
+	globex := seed(t, s, "user", "globex")
+	alice := createUser(t, s, "alice", acme.ID)
+	createUser(t, s, "bob", acme.ID)
+	createUser(t, s, "carol", globex.ID)
+
+	roles, err := s.Roles().ListByUser(alice.ID)
+	if err != nil || len(roles) != 1 || roles[0].Name != "user" {
+		t.Errorf("ListByUser returned %+v, %v", roles, err)
+	}
+	if roles, err := s.Roles().ListByUser(alice.ID + 100); err != nil || len(roles) != 0 {
+		t.Errorf("ListByUser of a missing user returned %+v, %v", roles, err)
+	}
+	for _, tt := range []struct {
+		role           string
+		organizationID uint
+		want           int64
+	}{
+		{"user", acme.ID, 2},
+		{"user", globex.ID, 1},
+		{"user", 0, 3},
+		{"admin", 0, 0},
+	} {
+		if got, err := s.Roles().CountHolders(tt.role, tt.organizationID); err != nil || got != tt.want {
+			t.Errorf("CountHolders(%q, %d) returned %d, %v, want %d", tt.role, tt.organizationID, got, err, tt.want)
+		}
+	}
+
+	if err := s.Users().Delete(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Roles().CountHolders("user", acme.ID); err != nil || got != 1 {
+		t.Errorf("CountHolders after Delete returned %d, %v, want 1", got, err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	alice := createUser(t, s, "alice", org.ID)
+	bob := createUser(t, s, "bob", org.ID)
+
+	earlier := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	tokens := []models.RefreshToken{
+		{UserID: alice.ID, FamilyID: "a", TokenHash: "a1", ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: alice.ID, FamilyID: "a", TokenHash: "a2", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &earlier},
+		{UserID: bob.ID, FamilyID: "b", TokenHash: "b1", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for i := range tokens {
+		if err := s.RefreshTokens().Create(&tokens[i]); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := s.RefreshTokens().Create(&models.RefreshToken{UserID: bob.ID, FamilyID: "b", TokenHash: "b1"}); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Create with a taken hash returned %v, want ErrDuplicate", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := s.RefreshTokens().RevokeByUsers([]uint{alice.ID}, now); err != nil {
+		t.Fatalf("RevokeByUsers: %v", err)
+	}
+	if err := s.RefreshTokens().RevokeByUsers(nil, now); err != nil {
+		t.Fatalf("RevokeByUsers without users: %v", err)
+	}
+	got, err := s.RefreshTokens().ListByUser(alice.ID)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("ListByUser returned %d tokens, %v; want 2", len(got), err)
+	}
+	if got[0].RevokedAt == nil || !got[0].RevokedAt.Equal(now) {
+		t.Errorf("RevokeByUsers set revoked_at %v, want %v", got[0].RevokedAt, now)
+	}
+	if got[1].RevokedAt == nil || !got[1].RevokedAt.Equal(earlier) {
+		t.Errorf("RevokeByUsers changed the revoked_at of a revoked token to %v, want %v", got[1].RevokedAt, earlier)
+	}
+	if got, _ := s.RefreshTokens().ListByUser(bob.ID); len(got) != 1 || got[0].RevokedAt != nil {
+		t.Errorf("RevokeByUsers revoked the token of another user")
+	}
+
+	if err := s.RefreshTokens().RevokeAll(now); err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	if got, _ := s.RefreshTokens().ListByUser(bob.ID); len(got) != 1 || got[0].RevokedAt == nil {
+		t.Errorf("RevokeAll left a token unrevoked")
+	}
+}
+
+func testClients(t *testing.T, s storage.Store) {
+	acme := seed(t, s, "user", "acme")
+	globex := seed(t, s, "user", "globex")
+	for _, client := range []models.RegisteredClient{
+		{ClientID: "c1", Name: "One", OrganizationID: acme.ID},
+		{ClientID: "c2", Name: "Two", OrganizationID: globex.ID},
+		{ClientID: "c3", Name: "Three", OrganizationID: acme.ID},
+	} {
+		if err := s.Clients().Create(&client); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := s.Clients().Create(&models.RegisteredClient{ClientID: "c1"}); !errors.Is(err, storage.ErrDuplicate) {
+		t.Errorf("Create with a taken client ID returned %v, want ErrDuplicate", err)
+	}
+	if ids, err := s.Clients().IDs(acme.ID); err != nil || !reflect.DeepEqual(ids, []string{"c1", "c3"}) {
+		t.Errorf("IDs(acme) returned %v, %v; want [c1 c3]", ids, err)
+	}
+	if ids, err := s.Clients().IDs(0); err != nil || len(ids) != 3 {
+		t.Errorf("IDs(0) returned %v, %v; want 3 clients", ids, err)
+	}
+}
+
+func testTransactionCommit(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	err := s.Transaction(func(tx storage.Store) error {
+		createUser(t, tx, "alice", org.ID)
+		return tx.Transaction(func(nested storage.Store) error {
+			createUser(t, nested, "bob", org.ID)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Transaction: %v", err)
+	}
+	if ids, _ := s.Users().IDs(org.ID); len(ids) != 2 {
+		t.Errorf("after commit there are %d users, want 2", len(ids))
+	}
+}
+
+func testTransactionRollback(t *testing.T, s storage.Store) {
+	org := seed(t, s, "user", "acme")
+	failure := errors.New("failure")
+
+	err := s.Transaction(func(tx storage.Store) error {
+		createUser(t, tx, "alice", org.ID)
+		// A failed nested transaction only discards its own changes
+		nestedErr := tx.Transaction(func(nested storage.Store) error {
+			createUser(t, nested, "bob", org.ID)
+			return failure
+		})
+		if !errors.Is(nestedErr, failure) {
+			t.Errorf("nested Transaction returned %v, want the error of fn", nestedErr)
+		}
+		if _, err := tx.Users().GetByUsername("bob"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("rolled back nested transaction kept bob: %v", err)
+		}
+		if _, err := tx.Users().GetByUsername("alice"); err != nil {
+			t.Errorf("rolled back nested transaction lost alice: %v", err)
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Transaction returned %v, want the error of fn", err)
+	}
+	if ids, _ := s.Users().IDs(0); len(ids) != 0 {
+		t.Errorf("after rollback there are %d users, want 0", len(ids))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// createUser - Create a user with the admin API and return their ID
+func (ts *testServer) createUser(adminToken, username, role string, organizationID uint) uint {
+	ts.t.Helper()
+	var user struct {
+		ID uint `json:"id"`
+	}
+	ts.expect(http.StatusOK, "POST", "/api/admin/users", adminToken, map[string]interface{}{
+		"username": username, "password": "Tenant-Passw0rd!", "email": username + "@example.com",
+		"role": role, "organization_id": organizationID,
+	}, &user)
+	return user.ID
+}
+
+func TestTenantsCannotReachEachOthersUsers(t *testing.T) {
+	ts := newTestServer(t)
+	root := ts.setupAdmin()
+	alice := ts.register("alice", "Alice-Passw0rd!")
+
+	// A second organization managed by bob, who holds every permission on users but is not a super admin
+	var org struct {
+		ID uint `json:"id"`
+	}
+	ts.expect(http.StatusCreated, "POST", "/api/admin/organizations", root, map[string]string{"name": "Acme", "slug": "acme"}, &org)
+	ts.expect(http.StatusCreated, "POST", "/api/admin/roles", root, map[string]interface{}{
+		"name": "user_manager",
+		"permissions": []string{"users:read", "users:create", "users:delete", "users:unlock", "tokens:revoke",
+			"mfa:reset", "roles:read", "roles:manage", "groups:read"},
+	}, nil)
+	ts.createUser(root, "bob", "user_manager", org.ID)
+	carol := ts.createUser(root, "carol", "user", org.ID)
+	bob := ts.login("bob", "Tenant-Passw0rd!")
+
+	// Lists only hold the users of bob's organization
+	for _, path := range []string{"/api/users", "/api/admin/users"} {
+		var users []struct {
+			Username       string `json:"username"`
+			OrganizationID uint   `json:"organization_id"`
+		}
+		ts.expect(http.StatusOK, "GET", path, bob, nil, &users)
+		if len(users) == 0 {
+			t.Fatalf("GET %s: no users", path)
+		}
+		for _, user := range users {
+			if user.OrganizationID != org.ID {
+				t.Errorf("GET %s: lists %s of organization %d", path, user.Username, user.OrganizationID)
+			}
+		}
+	}
+
+	// Every request on a user of the default organization answers as if the user did not exist
+	requests := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"GET", "/api/users/%d", nil},
+		{"DELETE", "/api/admin/users/%d", nil},
+		{"POST", "/api/admin/users/%d/unlock", nil},
+		{"POST", "/api/admin/users/%d/revoke", nil},
+		{"DELETE", "/api/admin/users/%d/mfa", nil},
+		{"GET", "/api/admin/users/%d/roles", nil},
+		{"PUT", "/api/admin/users/%d/roles", map[string][]string{"roles": {"user", "user_manager"}}},
+		{"GET", "/api/admin/users/%d/effective-roles", nil},
+	}
+	for _, req := range requests {
+		path := fmt.Sprintf(req.path, alice)
+		if resp := ts.request(req.method, path, bob, req.body); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s of another organization: status %d, want 404", req.method, path, resp.StatusCode)
+		}
+		// The same request on a user of bob's own organization is allowed, so the 404 is not a missing permission
+		if req.method != "DELETE" {
+			path := fmt.Sprintf(req.path, carol)
+			if resp := ts.request(req.method, path, bob, req.body); resp.StatusCode != http.StatusOK {
+				t.Errorf("%s %s of the same organization: status %d, want 200", req.method, path, resp.StatusCode)
+			}
+		}
+	}
+
+	// Alice is untouched
+	var roles []struct {
+		Name string `json:"name"`
+	}
+	ts.expect(http.StatusOK, "GET", fmt.Sprintf("/api/admin/users/%d/roles", alice), root, nil, &roles)
+	if len(roles) != 1 || roles[0].Name != "user" {
+		t.Fatalf("roles of alice %+v, want only user", roles)
+	}
+	ts.login("alice", "Alice-Passw0rd!")
+	ts.expect(http.StatusOK, "DELETE", fmt.Sprintf("/api/admin/users/%d", carol), bob, nil, nil)
+
+	// Users cannot be created in another organization either
+	resp := ts.request("POST", "/api/admin/users", bob, map[string]interface{}{
+		"username": "mallory", "password": "Tenant-Passw0rd!", "email": "mallory@example.com", "role": "user", "organization_id": 1,
+	})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("create a user in another organization: status %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestOnlySuperAdminsVerifyTheAuditChain(t *testing.T) {
+	ts := newTestServer(t)
+	root := ts.setupAdmin()
+
+	// Dave reads the audit log of their organization, but the chain spans every organization
+	ts.expect(http.StatusCreated, "POST", "/api/admin/roles", root, map[string]interface{}{
+		"name": "auditor", "permissions": []string{"audit:read"},
+	}, nil)
+	ts.createUser(root, "dave", "auditor", 0)
+	dave := ts.login("dave", "Tenant-Passw0rd!")
+	ts.expect(http.StatusOK, "GET", "/api/admin/audit", dave, nil, nil)
+	ts.expect(http.StatusForbidden, "GET", "/api/admin/audit/verify", dave, nil, nil)
+
+	var result struct {
+		Valid  bool   `json:"valid"`
+		Events uint64 `json:"events"`
+	}
+	ts.expect(http.StatusOK, "GET", "/api/admin/audit/verify", root, nil, &result)
+	if !result.Valid || result.Events == 0 {
+		t.Fatalf("verification %+v, want a valid chain of the events so far", result)
+	}
+}
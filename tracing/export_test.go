@@ -0,0 +1,220 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// testSpan - A span using every field the exporters write
+func testSpan() SpanData {
+	start := time.Unix(1700000000, 123456789)
+	return SpanData{
+		Name:         "GET /api/users/{id}",
+		Kind:         SpanKindServer,
+		TraceID:      TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:       SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		ParentSpanID: SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		Start:        start,
+		End:          start.Add(1500 * time.Millisecond),
+		Attributes: []Attribute{
+			String("http.route", "/api/users/{id}"),
+			Int("http.response.status_code", 500),
+			Int64("db.rows_affected", 1<<60),
+			Float64("ratio", 0.25),
+			Bool("cached", true),
+		},
+		Events: []Event{{
+			Name:       "exception",
+			Time:       start.Add(time.Second),
+			Attributes: []Attribute{String("exception.message", "connection refused")},
+		}},
+		Status:        StatusError,
+		StatusMessage: "Internal Server Error",
+	}
+}
+
+// decodeOTLP - Decode a message in the JSON encoding of OTLP, failing on any field or value the protobuf schema
+// does not define. OTLP/JSON departs from the protobuf JSON mapping only in writing trace and span IDs in hex
+// rather than base64, so the IDs are checked and converted first, as collectors do.
+func decodeOTLP(t *testing.T, data []byte, msg proto.Message) {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	doc = hexIDsToBase64(t, doc)
+	converted, _ := json.Marshal(doc)
+	if err := (protojson.UnmarshalOptions{}).Unmarshal(converted, msg); err != nil {
+		t.Fatalf("not an OTLP message: %v\n%s", err, data)
+	}
+}
+
+func hexIDsToBase64(t *testing.T, value interface{}) interface{} {
+	lengths := map[string]int{"traceId": 16, "spanId": 8, "parentSpanId": 8}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if length, isID := lengths[key]; isID {
+				id, err := hex.DecodeString(field.(string))
+				if err != nil || len(id) != length || field != strings.ToLower(field.(string)) {
+					t.Fatalf("%s %q is not %d bytes in lower case hex", key, field, length)
+				}
+				value[key] = base64.StdEncoding.EncodeToString(id)
+				continue
+			}
+			value[key] = hexIDsToBase64(t, field)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = hexIDsToBase64(t, item)
+		}
+	}
+	return value
+}
+
+// checkSpan - Compare a decoded OTLP span with testSpan
+func checkSpan(t *testing.T, got *tracepb.Span) {
+	t.Helper()
+	span := testSpan()
+	if !bytes.Equal(got.TraceId, span.TraceID[:]) || !bytes.Equal(got.SpanId, span.SpanID[:]) || !bytes.Equal(got.ParentSpanId, span.ParentSpanID[:]) {
+		t.Errorf("IDs %x %x %x", got.TraceId, got.SpanId, got.ParentSpanId)
+	}
+	if got.Name != span.Name || got.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("name %q, kind %v", got.Name, got.Kind)
+	}
+	if got.StartTimeUnixNano != uint64(span.Start.UnixNano()) || got.EndTimeUnixNano != uint64(span.End.UnixNano()) {
+		t.Errorf("times %d to %d", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+	attrs := make(map[string]interface{})
+	for _, attr := range got.Attributes {
+		switch value := attr.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			attrs[attr.Key] = value.StringValue
+		case *commonpb.AnyValue_IntValue:
+			attrs[attr.Key] = value.IntValue
+		case *commonpb.AnyValue_DoubleValue:
+			attrs[attr.Key] = value.DoubleValue
+		case *commonpb.AnyValue_BoolValue:
+			attrs[attr.Key] = value.BoolValue
+		default:
+			t.Errorf("attribute %s has a value of type %T", attr.Key, value)
+		}
+	}
+	want := map[string]interface{}{
+		"http.route":                "/api/users/{id}",
+		"http.response.status_code": int64(500),
+		"db.rows_affected":          int64(1 << 60),
+		"ratio":                     0.25,
+		"cached":                    true,
+	}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Errorf("attribute %s = %#v, want %#v", key, attrs[key], value)
+		}
+	}
+	if len(got.Events) != 1 || got.Events[0].Name != "exception" || got.Events[0].TimeUnixNano != uint64(span.Events[0].Time.UnixNano()) ||
+		len(got.Events[0].Attributes) != 1 || got.Events[0].Attributes[0].Value.GetStringValue() != "connection refused" {
+		t.Errorf("events %v", got.Events)
+	}
+	if got.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR || got.Status.GetMessage() != "Internal Server Error" {
+		t.Errorf("status %v", got.Status)
+	}
+}
+
+func TestOTLPExporterPostsExportTraceServiceRequests(t *testing.T) {
+	var request *http.Request
+	var body []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"partialSuccess":{}}`)
+	}))
+	defer collector.Close()
+
+	exporter := &OTLPExporter{
+		Endpoint:    collector.URL + "/",
+		ServiceName: "api-service",
+		Headers:     map[string]string{"Api-Key": "secret"},
+	}
+	if err := exporter.ExportSpans(context.Background(), []SpanData{testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Method != http.MethodPost || request.URL.Path != "/v1/traces" {
+		t.Fatalf("%s %s, want POST /v1/traces", request.Method, request.URL.Path)
+	}
+	if request.Header.Get("Content-Type") != "application/json" || request.Header.Get("Api-Key") != "secret" {
+		t.Fatalf("headers %v", request.Header)
+	}
+	var export collectortrace.ExportTraceServiceRequest
+	decodeOTLP(t, body, &export)
+	if len(export.ResourceSpans) != 1 || len(export.ResourceSpans[0].ScopeSpans) != 1 || len(export.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("export %v, want one span", &export)
+	}
+	resource := export.ResourceSpans[0].Resource
+	if len(resource.GetAttributes()) != 1 || resource.Attributes[0].Key != "service.name" || resource.Attributes[0].Value.GetStringValue() != "api-service" {
+		t.Errorf("resource %v", resource)
+	}
+	if scope := export.ResourceSpans[0].ScopeSpans[0].Scope; scope.GetName() != "api-service" {
+		t.Errorf("scope %v", scope)
+	}
+	checkSpan(t, export.ResourceSpans[0].ScopeSpans[0].Spans[0])
+}
+
+func TestOTLPExporterReportsRejectedExports(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := &OTLPExporter{Endpoint: collector.URL}
+	err := exporter.ExportSpans(context.Background(), []SpanData{testSpan()})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("error %v, want the status of the collector", err)
+	}
+}
+
+func TestStdoutExporterWritesOTLPSpans(t *testing.T) {
+	var out bytes.Buffer
+	exporter := &StdoutExporter{W: &out}
+	if err := exporter.ExportSpans(context.Background(), []SpanData{testSpan(), testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want one per span:\n%s", len(lines), out.String())
+	}
+	for _, line := range lines {
+		var span tracepb.Span
+		decodeOTLP(t, []byte(line), &span)
+		checkSpan(t, &span)
+	}
+}
+
+func TestRootSpansHaveNoParent(t *testing.T) {
+	span := testSpan()
+	span.ParentSpanID = SpanID{}
+	data, err := json.Marshal(otlpSpan(span))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "parentSpanId") {
+		t.Fatalf("root span written with a parent: %s", data)
+	}
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin traces every query made through gorm with a client span, named after the operation and the table, such
+// as "SELECT users". Queries join the trace of the context of the statement, so bind the connection to the request
+// with WithContext; queries made outside a trace, such as the seeding at startup, are not traced, so that each does
+// not start a trace of its own. The SQL is recorded without its parameters. Install it with
+// conn.Use(tracing.GormPlugin{}).
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("tracing:before_create", startQuery("INSERT")),
+		callbacks.Create().After("*").Register("tracing:after_create", endQuery("INSERT")),
+		callbacks.Query().Before("*").Register("tracing:before_query", startQuery("SELECT")),
+		callbacks.Query().After("*").Register("tracing:after_query", endQuery("SELECT")),
+		callbacks.Update().Before("*").Register("tracing:before_update", startQuery("UPDATE")),
+		callbacks.Update().After("*").Register("tracing:after_update", endQuery("UPDATE")),
+		callbacks.Delete().Before("*").Register("tracing:before_delete", startQuery("DELETE")),
+		callbacks.Delete().After("*").Register("tracing:after_delete", endQuery("DELETE")),
+		callbacks.Row().Before("*").Register("tracing:before_row", startQuery("ROWS")),
+		callbacks.Row().After("*").Register("tracing:after_row", endQuery("ROWS")),
+		callbacks.Raw().Before("*").Register("tracing:before_raw", startQuery("RAW")),
+		callbacks.Raw().After("*").Register("tracing:after_raw", endQuery("RAW")),
+	)
+}
+
+func startQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		// A span is stored either way, so that a statement reused for several queries never ends a stale one
+		if db.Statement.Context == nil || !SpanContextFromContext(db.Statement.Context).IsValid() {
+			db.InstanceSet(gormSpanKey, &Span{})
+			return
+		}
+		_, span := StartSpan(db.Statement.Context, SpanKindClient, operation,
+			String("db.system", db.Dialector.Name()),
+			String("db.operation.name", operation))
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endQuery(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(gormSpanKey)
+		if !ok {
+			return
+		}
+		span := value.(*Span)
+		if !span.IsRecording() {
+			return
+		}
+		if table := db.Statement.Table; table != "" {
+			span.SetName(operation + " " + table)
+			span.SetAttributes(String("db.collection.name", table))
+		}
+		span.SetAttributes(
+			String("db.query.text", db.Statement.SQL.String()),
+			Int64("db.rows_affected", db.RowsAffected))
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(db.Error)
+		}
+		span.End()
+	}
+}
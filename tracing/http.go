@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"api-service/logging"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+*
+InstrumentRouter
+
+func InstrumentRouter(router *mux.Router) func(http.Handler) http.Handler
+Description: Returns a middleware tracing every request with a server span, to wrap the handler serving the router. The span joins the trace of the traceparent header of the request, if any, and is named after the method and the template of the route that matched, such as "GET /api/users/{id}", or after the method alone for unmatched requests. The trace and span IDs are added to the records logged with the request context, so wrap the access log with it. Register every route before serving the wrapped handler.
+*/
+func InstrumentRouter(router *mux.Router) func(http.Handler) http.Handler {
+	// mux only knows the matched route inside its own handler, so a middleware there names the span
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				span := SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + template)
+				span.SetAttributes(String("http.route", template))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := Extract(r.Context(), r.Header)
+			ctx, span := StartSpan(ctx, SpanKindServer, r.Method,
+				String("http.request.method", r.Method),
+				String("url.path", r.URL.Path),
+				String("client.address", clientAddress(r)),
+				String("user_agent.original", r.UserAgent()))
+			if id := r.Header.Get("X-Request-ID"); id != "" {
+				span.SetAttributes(String("http.request.header.x-request-id", id))
+			}
+			if sc := span.SpanContext(); sc.IsValid() {
+				ctx = logging.ContextWith(ctx, slog.String("trace_id", sc.TraceID.String()), slog.String("span_id", sc.SpanID.String()))
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			span.SetAttributes(Int("http.response.status_code", recorder.status))
+			if recorder.status >= 500 {
+				span.SetStatus(StatusError, http.StatusText(recorder.status))
+			}
+			span.End()
+		})
+	}
+}
+
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
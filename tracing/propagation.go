@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// W3C Trace Context headers
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// maxTracestateLength is the longest tracestate header passed on; longer ones are dropped
+const maxTracestateLength = 512
+
+// Extract - Return a context holding the span context of the traceparent and tracestate headers of an incoming
+// request, so that the spans started in it join the trace of the caller. Invalid headers are ignored.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := parseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	sc.Remote = true
+	if state := header.Get(TracestateHeader); len(state) <= maxTracestateLength {
+		sc.TraceState = state
+	}
+	return ContextWithSpan(ctx, &Span{context: sc})
+}
+
+// Inject - Set the traceparent and tracestate headers of an outgoing request to the span of ctx, so that the
+// service called joins the trace
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	header.Set(TraceparentHeader, "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// parseTraceparent - Parse a traceparent header: version, trace ID, parent span ID and flags, in lower case hex
+// separated by dashes. Versions above 00 may append fields, which are ignored.
+func parseTraceparent(value string) (SpanContext, bool) {
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 || !isLowerHex(fields[0], 2) || fields[0] == "ff" || (fields[0] == "00" && len(fields) != 4) {
+		return SpanContext{}, false
+	}
+	if !isLowerHex(fields[1], 32) || !isLowerHex(fields[2], 16) || !isLowerHex(fields[3], 2) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	hex.Decode(sc.TraceID[:], []byte(fields[1]))
+	hex.Decode(sc.SpanID[:], []byte(fields[2]))
+	flags, _ := hex.DecodeString(fields[3])
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// The example of the W3C Trace Context recommendation
+const (
+	exampleTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	exampleTracestate  = "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7"
+)
+
+// setupInMemory - Record every span in an in-memory exporter until the test ends
+func setupInMemory(t *testing.T) (*Provider, *InMemoryExporter) {
+	t.Helper()
+	exporter := NewInMemoryExporter()
+	provider := Setup(Config{Exporter: exporter, SampleRatio: 1})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return provider, exporter
+}
+
+func TestExtractTraceparent(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, exampleTraceparent)
+	header.Set(TracestateHeader, exampleTracestate)
+
+	sc := SpanContextFromContext(Extract(context.Background(), header))
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("extracted trace %s, span %s", sc.TraceID, sc.SpanID)
+	}
+	if !sc.Sampled || !sc.Remote || sc.TraceState != exampleTracestate {
+		t.Fatalf("extracted %+v", sc)
+	}
+}
+
+func TestExtractIgnoresInvalidTraceparents(t *testing.T) {
+	for name, value := range map[string]string{
+		"empty":             "",
+		"upper case":        "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+		"version ff":        "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"zero trace ID":     "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"zero span ID":      "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"short trace ID":    "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"short span ID":     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01",
+		"not hex":           "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"missing flags":     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"extra field in 00": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(TraceparentHeader, value)
+			header.Set(TracestateHeader, exampleTracestate)
+			if sc := SpanContextFromContext(Extract(context.Background(), header)); sc.IsValid() || sc.TraceState != "" {
+				t.Fatalf("extracted %+v from %q", sc, value)
+			}
+		})
+	}
+}
+
+func TestExtractAcceptsLaterVersions(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be-like")
+	if sc := SpanContextFromContext(Extract(context.Background(), header)); sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("extracted %+v", sc)
+	}
+}
+
+func TestExtractDropsLongTracestates(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, exampleTraceparent)
+	header.Set(TracestateHeader, "congo="+strings.Repeat("a", maxTracestateLength))
+	if sc := SpanContextFromContext(Extract(context.Background(), header)); !sc.IsValid() || sc.TraceState != "" {
+		t.Fatalf("extracted %+v", sc)
+	}
+}
+
+func TestInjectPassesTheContextOn(t *testing.T) {
+	incoming := http.Header{}
+	incoming.Set(TraceparentHeader, exampleTraceparent)
+	incoming.Set(TracestateHeader, exampleTracestate)
+
+	// Without a provider the caller's context is passed on unchanged
+	outgoing := http.Header{}
+	ctx, _ := StartSpan(Extract(context.Background(), incoming), SpanKindServer, "GET /")
+	Inject(ctx, outgoing)
+	if outgoing.Get(TraceparentHeader) != exampleTraceparent || outgoing.Get(TracestateHeader) != exampleTracestate {
+		t.Fatalf("injected %v", outgoing)
+	}
+
+	// A recorded span joins the trace and becomes the parent of the next service
+	provider, exporter := setupInMemory(t)
+	ctx, span := StartSpan(Extract(context.Background(), incoming), SpanKindClient, "GET /userinfo")
+	outgoing = http.Header{}
+	Inject(ctx, outgoing)
+	span.End()
+	provider.ForceFlush(context.Background())
+
+	sc := span.SpanContext()
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + sc.SpanID.String() + "-01"; outgoing.Get(TraceparentHeader) != want {
+		t.Fatalf("injected traceparent %q, want %q", outgoing.Get(TraceparentHeader), want)
+	}
+	if outgoing.Get(TracestateHeader) != exampleTracestate {
+		t.Fatalf("injected tracestate %q", outgoing.Get(TracestateHeader))
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].ParentSpanID.String() != "00f067aa0ba902b7" || spans[0].TraceID != sc.TraceID {
+		t.Fatalf("recorded %+v, want a child of the caller's span", spans)
+	}
+}
+
+func TestInjectKeepsTheCallersSamplingDecision(t *testing.T) {
+	provider, exporter := setupInMemory(t)
+	incoming := http.Header{}
+	incoming.Set(TraceparentHeader, strings.TrimSuffix(exampleTraceparent, "01")+"00")
+
+	ctx, span := StartSpan(Extract(context.Background(), incoming), SpanKindServer, "GET /")
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	span.End()
+	provider.ForceFlush(context.Background())
+
+	if value := outgoing.Get(TraceparentHeader); !strings.HasPrefix(value, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(value, "-00") {
+		t.Fatalf("injected traceparent %q, want the trace unsampled", value)
+	}
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Fatalf("recorded %d spans of an unsampled trace", len(spans))
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	header := http.Header{}
+	Inject(context.Background(), header)
+	if len(header) != 0 {
+		t.Fatalf("injected %v outside a trace", header)
+	}
+}
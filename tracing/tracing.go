@@ -0,0 +1,410 @@
+package tracing
+
+/**
+The tracing package records traces of the work the service does: a span per HTTP request, named after its route, with child spans for token validation, service methods and database queries. Traces follow the W3C Trace Context and OpenTelemetry models, so that they join the traces of the callers of the service and can be sent to any OpenTelemetry collector. It implements the part of OpenTelemetry the service needs (spans, ratio sampling, traceparent propagation and the OTLP/HTTP JSON export) so that the service needs no OpenTelemetry library. http.go traces the router, gorm.go the database, propagation.go reads and writes the traceparent header and export.go holds the exporters.
+*/
+import (
+	"api-service/logging"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.For("tracing")
+
+// TraceID identifies a trace, every span of one request across services
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span that is passed to the spans started in it and to other services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool   // The trace is recorded; spans started in it are recorded too
+	Remote     bool   // The span was started by the caller of the service
+	TraceState string // The tracestate header received with a remote span, passed on unchanged
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind tells whether a span handles a request (server), makes one (client) or neither (internal). The values
+// are those of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the outcome of a span. The values are those of OTLP.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key and a string, int64, float64 or bool value describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attribute          { return Attribute{key, value} }
+func Int(key string, value int) Attribute         { return Attribute{key, int64(value)} }
+func Int64(key string, value int64) Attribute     { return Attribute{key, value} }
+func Float64(key string, value float64) Attribute { return Attribute{key, value} }
+func Bool(key string, value bool) Attribute       { return Attribute{key, value} }
+
+// Event is something that happened during a span, such as an error.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// Config selects where spans are exported and which traces are recorded.
+type Config struct {
+	Exporter SpanExporter
+	// SampleRatio is the fraction of the traces started by the service that are recorded, from 0 to 1. Traces
+	// started by a caller are recorded when the caller recorded them, whatever the ratio.
+	SampleRatio float64
+	// Spans are exported in batches of up to BatchSize spans, at least every BatchTimeout. Zero selects 512 spans
+	// and 5 seconds.
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
+// Provider records the spans ended while it is installed and exports them in the background.
+type Provider struct {
+	config   Config
+	queue    chan SpanData
+	flushes  chan chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// maxQueuedSpans bounds the spans waiting to be exported; spans ended while the queue is full are dropped
+const maxQueuedSpans = 2048
+
+var current atomic.Pointer[Provider]
+
+/*
+*
+Setup
+
+func Setup(cfg Config) *Provider
+Description: Installs a provider that records the sampled spans started from then on and exports them with cfg.Exporter. Until Setup is called, and after the provider is shut down, spans are not recorded, but the trace context received from callers is still passed on. Call Shutdown on the returned provider before exiting, so that the last spans are exported.
+*/
+func Setup(cfg Config) *Provider {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 5 * time.Second
+	}
+	p := &Provider{
+		config:  cfg,
+		queue:   make(chan SpanData, maxQueuedSpans),
+		flushes: make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.run()
+	current.Store(p)
+	return p
+}
+
+// ForceFlush - Export the spans ended so far, waiting until they are exported or ctx is done
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case p.flushes <- done:
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown - Stop recording spans, export the spans ended so far and shut the exporter down
+func (p *Provider) Shutdown(ctx context.Context) error {
+	current.CompareAndSwap(p, nil)
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.config.Exporter.Shutdown(ctx)
+}
+
+// run - Export the ended spans in batches until the provider is shut down
+func (p *Provider) run() {
+	ticker := time.NewTicker(p.config.BatchTimeout)
+	defer ticker.Stop()
+	defer close(p.stopped)
+
+	var batch []SpanData
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := p.config.Exporter.ExportSpans(ctx, batch); err != nil {
+			logger.Warn("Failed to export spans", "spans", len(batch), "error", err)
+		}
+		cancel()
+		batch = nil
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-p.queue:
+				batch = append(batch, span)
+				if len(batch) >= p.config.BatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-p.queue:
+			batch = append(batch, span)
+			if len(batch) >= p.config.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-p.flushes:
+			drain()
+			close(done)
+		case <-p.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (p *Provider) enqueue(span SpanData) {
+	select {
+	case p.queue <- span:
+	default:
+	}
+}
+
+// sample - Decide whether a new trace is recorded. The decision only depends on the trace ID, as in the
+// TraceIDRatioBased sampler of OpenTelemetry, so that services sampling at the same ratio agree.
+func (p *Provider) sample(traceID TraceID) bool {
+	switch ratio := p.config.SampleRatio; {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	default:
+		return binary.BigEndian.Uint64(traceID[8:])>>1 < uint64(ratio*(1<<63))
+	}
+}
+
+// Span is an operation within a trace. Spans that are not sampled are not recorded; their methods do nothing but
+// they still carry the trace context. A Span is safe for concurrent use.
+type Span struct {
+	context  SpanContext
+	provider *Provider // Nil unless the span is recorded
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// ContextWithSpan - Return a context whose spans are started as children of span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext - Return the span of ctx, or a span that records nothing
+func SpanFromContext(ctx context.Context) *Span {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span
+	}
+	return &Span{}
+}
+
+// SpanContextFromContext - Return the context of the span of ctx, which is not valid if ctx has no span
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	return SpanFromContext(ctx).context
+}
+
+// Start - Start an internal span as a child of the span of ctx, and return a context holding it. End the span when
+// the operation is done.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return StartSpan(ctx, SpanKindInternal, name, attrs...)
+}
+
+// StartSpan - Start a span of the given kind as a child of the span of ctx, or as the root of a new trace
+func StartSpan(ctx context.Context, kind SpanKind, name string, attrs ...Attribute) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	provider := current.Load()
+	if provider == nil {
+		// Nothing is recorded, but the context received from a caller is passed on
+		span := &Span{context: parent}
+		return ContextWithSpan(ctx, span), span
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, TraceState: parent.TraceState, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.TraceState = ""
+		sc.Sampled = provider.sample(sc.TraceID)
+	}
+	sc.SpanID = newSpanID()
+
+	span := &Span{context: sc}
+	if sc.Sampled {
+		span.provider = provider
+		span.data = SpanData{
+			Name:       name,
+			Kind:       kind,
+			TraceID:    sc.TraceID,
+			SpanID:     sc.SpanID,
+			Start:      time.Now(),
+			Attributes: append([]Attribute(nil), attrs...),
+		}
+		if parent.IsValid() {
+			span.data.ParentSpanID = parent.SpanID
+		}
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// SpanContext - Return the context passed to the children of the span
+func (s *Span) SpanContext() SpanContext {
+	return s.context
+}
+
+// IsRecording - Report whether the span is recorded, so that costly attributes can be skipped when it is not
+func (s *Span) IsRecording() bool {
+	return s.provider != nil
+}
+
+// SetName - Rename the span, such as when the route of a request is known
+func (s *Span) SetName(name string) {
+	s.update(func(data *SpanData) { data.Name = name })
+}
+
+// SetAttributes - Add attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	s.update(func(data *SpanData) { data.Attributes = append(data.Attributes, attrs...) })
+}
+
+// AddEvent - Record that something happened during the span
+func (s *Span) AddEvent(name string, attrs ...Attribute) {
+	s.update(func(data *SpanData) {
+		data.Events = append(data.Events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+	})
+}
+
+// SetStatus - Set the outcome of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.update(func(data *SpanData) {
+		data.Status = code
+		data.StatusMessage = message
+	})
+}
+
+// RecordError - Record an error as an exception event and mark the span as failed
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.AddEvent("exception",
+		String("exception.type", fmt.Sprintf("%T", err)),
+		String("exception.message", err.Error()))
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End - End the span and queue it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s.provider == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.provider.enqueue(data)
+}
+
+// EndErr - End the span, recording *err if it is not nil. Defer it with a pointer to the error result of a function:
+//
+//	defer span.EndErr(&err)
+func (s *Span) EndErr(err *error) {
+	if err != nil {
+		s.RecordError(*err)
+	}
+	s.End()
+}
+
+func (s *Span) update(fn func(data *SpanData)) {
+	if s.provider == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		fn(&s.data)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"api-service/tracing"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRequestQueriesAreTraced(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	provider := tracing.Setup(tracing.Config{Exporter: exporter, SampleRatio: 1})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	ts := newTestServer(t)
+	token := ts.setupAdmin()
+	ts.expect(http.StatusOK, "GET", "/api/profile/mfa", token, nil, nil)
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queries of each request, by the name of its server span
+	spans := exporter.Spans()
+	requests := make(map[tracing.TraceID]string)
+	for _, span := range spans {
+		if span.Kind == tracing.SpanKindServer {
+			requests[span.TraceID] = span.Name
+		}
+	}
+	ids := make(map[tracing.SpanID]bool)
+	for _, span := range spans {
+		ids[span.SpanID] = true
+	}
+	queries := make(map[string]map[string]bool)
+	for _, span := range spans {
+		if span.Kind != tracing.SpanKindClient || span.Attribute("db.system") == nil {
+			continue
+		}
+		if !ids[span.ParentSpanID] {
+			t.Errorf("query %s has no parent span", span.Name)
+		}
+		request := requests[span.TraceID]
+		if queries[request] == nil {
+			queries[request] = make(map[string]bool)
+		}
+		queries[request][span.Name] = true
+	}
+
+	for request, names := range map[string][]string{
+		"POST /setup":          {"INSERT users"},
+		"POST /login":          {"SELECT login_lockouts", "SELECT mfa_enrollments", "INSERT refresh_tokens"},
+		"GET /api/profile/mfa": {"SELECT token_revocations", "SELECT mfa_enrollments"},
+	} {
+		for _, name := range names {
+			if !queries[request][name] {
+				t.Errorf("%s: query %s not traced, got %v", request, name, queries[request])
+			}
+		}
+	}
+	if names := queries[""]; len(names) > 0 {
+		t.Errorf("queries outside a request: %v", names)
+	}
+}
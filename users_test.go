@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// assertNoSecrets - Fail unless the JSON user objects carry neither a password hash nor a stored token
+func assertNoSecrets(t *testing.T, what, body string) {
+	t.Helper()
+	var users []map[string]interface{}
+	if strings.HasPrefix(strings.TrimSpace(body), "{") {
+		var user map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &user); err != nil {
+			t.Fatalf("%s: decode %s: %v", what, body, err)
+		}
+		users = append(users, user)
+	} else if err := json.Unmarshal([]byte(body), &users); err != nil {
+		t.Fatalf("%s: decode %s: %v", what, body, err)
+	}
+	if len(users) == 0 {
+		t.Fatalf("%s: no users in %s", what, body)
+	}
+	for _, user := range users {
+		for _, field := range []string{"password", "token"} {
+			if _, ok := user[field]; ok {
+				t.Errorf("%s: user %v has the field %q", what, user["username"], field)
+			}
+		}
+	}
+	if strings.Contains(body, "$2a$") {
+		t.Errorf("%s: the response contains a bcrypt hash: %s", what, body)
+	}
+}
+
+func TestUserResponsesCarryNoSecrets(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.setupAdmin()
+
+	resp := ts.request("POST", "/register", "", map[string]string{
+		"username": "alice", "password": "Alice-Passw0rd!", "email": "alice@example.com",
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: status %d", resp.StatusCode)
+	}
+	body := readBody(t, resp)
+	assertNoSecrets(t, "register", body)
+	var alice struct {
+		ID uint `json:"id"`
+	}
+	json.Unmarshal([]byte(body), &alice)
+	token := ts.login("alice", "Alice-Passw0rd!")
+
+	for _, path := range []string{"/api/users", "/api/users/" + strconv.Itoa(int(alice.ID)), "/api/admin/users"} {
+		resp := ts.request("GET", path, admin, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		assertNoSecrets(t, path, readBody(t, resp))
+	}
+	resp = ts.request("GET", "/api/profile", token, nil)
+	assertNoSecrets(t, "/api/profile", readBody(t, resp))
+}
+
+func TestRegistrationIgnoresPrivilegedFields(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.setupAdmin()
+
+	var user struct {
+		ID              uint    `json:"id"`
+		Role            string  `json:"role"`
+		OrganizationID  uint    `json:"organization_id"`
+		EmailVerifiedAt *string `json:"email_verified_at"`
+	}
+	ts.expect(http.StatusCreated, "POST", "/register", "", map[string]interface{}{
+		"id": 99, "username": "mallory", "password": "Mallory-Passw0rd!", "email": "mallory@example.com",
+		"role": "admin", "organization_id": 42, "email_verified_at": "2020-01-01T00:00:00Z", "token": "forged",
+	}, &user)
+	if user.ID == 99 || user.Role != "user" || user.OrganizationID == 42 || user.EmailVerifiedAt != nil {
+		t.Fatalf("registered %+v", user)
+	}
+	var roles []struct {
+		Name string `json:"name"`
+	}
+	ts.expect(http.StatusOK, "GET", "/api/admin/users/"+strconv.Itoa(int(user.ID))+"/roles", admin, nil, &roles)
+	if len(roles) != 1 || roles[0].Name != "user" {
+		t.Fatalf("roles %+v, want only user", roles)
+	}
+}
@@ -5,47 +5,61 @@ The utils package provides utility functions to handle JWT (JSON Web Token) gene
 
 */
 import (
-	"api-service/config"
 	"api-service/models"
 	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-/* This variable holds the secret key used for signing and verifying JWT tokens. It should ideally be stored in an environment variable for security purposes.*/
-var jwtKey = []byte("your_secret_key")
+/* This variable holds the key manager used for signing and verifying JWT tokens. It is set up in main.go from PEM files or generated keys.*/
+var Keys *KeyManager
+
+// AccessTokenTTL is the lifetime of the access JWT. Clients renew it through /token/refresh.
+const AccessTokenTTL = 15 * time.Minute
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
 
 /*
 *
 This function extracts the JWT token from the Authorization header in the HTTP request, validates it, and retrieves the username from the token claims.
 */
 func GetUserIDFromRequest(r *http.Request) (string, error) {
-	tokenStr := r.Header.Get("Authorization")
+	tokenStr := BearerToken(r)
 	if tokenStr == "" {
 		return "", errors.New("missing token")
 	}
 
-	token, _ := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JWTSecret), nil
-	})
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username := claims["username"].(string)
-		print(username)
-		return username, nil
+	claims, err := ParseToken(tokenStr)
+	if err != nil {
+		return "", err
 	}
+	return claims.Username, nil
+}
 
-	return "", errors.New("invalid token")
+// This function returns the token from the Authorization header. The "Bearer " prefix is optional.
+func BearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return header
 }
 
 type contextKey string
 
 const UserKey contextKey = "user_id"
 const userContextKey = contextKey("user")
+const claimsContextKey = contextKey("claims")
+const clientContextKey = contextKey("client")
 const RoleKey contextKey = "role"
 
 // This function retrieves the role of the user from the request context.
@@ -57,37 +71,166 @@ func GetUserRoleFromRequest(r *http.Request) (string, error) {
 	return role, nil
 }
 
-// This function generates a JWT token for the authenticated user based on their email, role, and username.
+// This function generates a JWT token for the authenticated user based on their email, roles, and username. The roles must be loaded into user.Roles; when the effective roles inherited from groups are computed into user.EffectiveRoles, those are embedded instead. Every token carries a unique ID (jti) and its issue time so that it can be revoked.
 func GenerateJWT(user models.User) (string, error) {
+	return GenerateClientJWT(user, "", "")
+}
+
+// This function generates a JWT token for a user who signed in to an OAuth2 client. The token records the client and the scopes the user granted to it, and carries none of the user's roles: the client is only given what the scopes allow, which JWTMiddleware enforces by accepting such tokens only on the routes that opt in.
+func GenerateClientJWT(user models.User, clientID, scope string) (string, error) {
+	jti, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := &models.JWTClaims{
 		Email:    user.Email,
 		Role:     user.Role,
+		Roles:    user.EffectiveRoleNames(),
 		Username: user.Username,
+		Region:   user.Region,
+		TenantID: user.OrganizationID,
+		ClientID: clientID,
+		Scope:    scope,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+			Id:        jti,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
 		},
 	}
+	if clientID != "" {
+		claims.Role, claims.Roles = "", nil
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return SignClaims(claims)
 }
 
-// This function validates a JWT token and returns the user claims embedded in it (email, role).
-func ValidateToken(tokenString string) (*models.User, error) {
+// This function generates a JWT token for an OAuth2 client acting on its own behalf (client credentials grant). The client ID is the subject and the token carries no user claims; the tenant is the organization the client was registered in.
+func GenerateServiceJWT(clientID, scope string, tenantID uint) (string, error) {
+	jti, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &models.JWTClaims{
+		ClientID: clientID,
+		Scope:    scope,
+		TenantID: tenantID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+		},
+	}
+
+	return SignClaims(claims)
+}
+
+// This function generates a short-lived single-purpose token, such as the "mfa_pending" challenge returned by /login when the user still has to enter a second factor. Purpose tokens are rejected by ParseToken and therefore by JWTMiddleware.
+func GeneratePurposeJWT(user models.User, purpose string, ttl time.Duration) (string, error) {
+	jti, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &models.JWTClaims{
+		Username: user.Username,
+		Purpose:  purpose,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+
+	return SignClaims(claims)
+}
+
+// This function signs arbitrary claims, such as an ID token, with the active signing key.
+func SignClaims(claims jwt.Claims) (string, error) {
+	if Keys == nil {
+		return "", errors.New("signing keys not configured")
+	}
+	return Keys.Sign(claims)
+}
+
+// This function verifies the signature and expiry of an access token and returns all of its claims. The verification key is looked up by the kid header of the token. Single-purpose tokens are rejected, and so are OpenID Connect ID tokens, which are signed with the same keys: they are meant for the client named in their audience, and carry no token ID to revoke them by.
+func ParseToken(tokenString string) (*models.JWTClaims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" || claims.Audience != "" || claims.Id == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// This function verifies a single-purpose token created by GeneratePurposeJWT and checks that it was issued for the given purpose.
+func ParsePurposeToken(tokenString, purpose string) (*models.JWTClaims, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func parseClaims(tokenString string) (*models.JWTClaims, error) {
+	if Keys == nil {
+		return nil, errors.New("signing keys not configured")
+	}
 	claims := &models.JWTClaims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys.Keyfunc)
 
+	// A token that is only expired is told apart, since that is the normal end of a token rather than an attack
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired {
+		return nil, ErrTokenExpired
+	}
 	if err != nil || !token.Valid {
-		return nil, errors.New("invalid token")
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// This function validates a JWT token and returns the user claims embedded in it (email, role).
+func ValidateToken(tokenString string) (*models.User, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
 	}
 
+	return UserFromClaims(claims), nil
+}
+
+// This function builds the user stored in the request context from the claims of a validated token.
+func UserFromClaims(claims *models.JWTClaims) *models.User {
+	id, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	names := claims.RoleNames()
+	roles := make([]models.Role, len(names))
+	for i, name := range names {
+		roles[i] = models.Role{Name: name}
+	}
 	return &models.User{
-		Email: claims.Email,
-		Role:  claims.Role,
-	}, nil
+		ID:             uint(id),
+		Email:          claims.Email,
+		Role:           claims.Role,
+		Roles:          roles,
+		Username:       claims.Username,
+		Region:         claims.Region,
+		OrganizationID: claims.TenantID,
+	}
 }
 
 // This function stores user data in the context of the current HTTP request. This is typically used by middleware to make user details available throughout the request lifecycle.
@@ -103,3 +246,31 @@ func GetUserFromContext(ctx context.Context) (*models.User, error) {
 	}
 	return user, nil
 }
+
+// This function stores the claims of the validated token in the context, so handlers can reach the token ID (jti) and expiry.
+func ContextWithClaims(ctx context.Context, claims *models.JWTClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// This function retrieves the token claims from the request context, where they were previously stored by middleware.
+func GetClaimsFromContext(ctx context.Context) (*models.JWTClaims, error) {
+	claims, ok := ctx.Value(claimsContextKey).(*models.JWTClaims)
+	if !ok {
+		return nil, errors.New("no claims found in context")
+	}
+	return claims, nil
+}
+
+// This function stores the OAuth2 client that made the request in the context.
+func ContextWithClient(ctx context.Context, client *models.ClientIdentity) context.Context {
+	return context.WithValue(ctx, clientContextKey, client)
+}
+
+// This function retrieves the OAuth2 client from the request context. It fails for tokens that were not issued to a client.
+func GetClientFromContext(ctx context.Context) (*models.ClientIdentity, error) {
+	client, ok := ctx.Value(clientContextKey).(*models.ClientIdentity)
+	if !ok {
+		return nil, errors.New("no client found in context")
+	}
+	return client, nil
+}
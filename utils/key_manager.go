@@ -0,0 +1,358 @@
+package utils
+
+/**
+The key manager owns the asymmetric keys used to sign and verify JWT tokens. Keys are either loaded from PEM files or generated at startup, every key is identified by a key ID (kid) that is written into the token header, and the public halves are published as a JSON Web Key Set so that other services can verify tokens offline.
+
+Generated keys only live in the memory of the process. Every replica of the service therefore signs with keys of its own and rejects the tokens of the others, and a restart invalidates every token issued before it. Deployments with more than one replica, or whose tokens must survive a restart, load the same keys from PEM files on every replica instead; those keys are not rotated, new keys are rolled out by listing them after the signing key and moving them to the front once every verifier knows them.
+*/
+import (
+	"api-service/logging"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var logger = logging.For("utils")
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a private key together with its key ID and algorithm. A key with a zero RetireAt is the active signing key or a key loaded from file; a retired key is only kept to verify tokens it signed until RetireAt.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetireAt  time.Time
+}
+
+// JWK is the public part of a signing key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type KeyManager struct {
+	mu        sync.RWMutex
+	algorithm string
+	overlap   time.Duration
+	active    *SigningKey
+	keys      map[string]*SigningKey
+}
+
+/*
+NewKeyManager creates a key manager that generates keys with the given algorithm. Overlap is how long a key stays published and accepted for verification after it has been rotated out; it is raised to AccessTokenTTL if shorter, so that no token outlives its key.
+*/
+func NewKeyManager(algorithm string, overlap time.Duration) (*KeyManager, error) {
+	if _, err := signingMethod(algorithm); err != nil {
+		return nil, err
+	}
+	if overlap < AccessTokenTTL {
+		overlap = AccessTokenTTL
+	}
+	return &KeyManager{
+		algorithm: algorithm,
+		overlap:   overlap,
+		keys:      make(map[string]*SigningKey),
+	}, nil
+}
+
+// LoadPEMFile loads a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key. The first key loaded becomes the active signing key; further keys are only used for verification.
+func (km *KeyManager) LoadPEMFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	signer, err := parsePrivateKeyPEM(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	key, err := newSigningKey(signer)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.keys[key.ID] = key
+	if km.active == nil {
+		km.active = key
+	}
+	return nil
+}
+
+// Rotate generates a new active signing key. The previous active key keeps verifying tokens for the overlap window.
+func (km *KeyManager) Rotate() error {
+	signer, err := generateKey(km.algorithm)
+	if err != nil {
+		return err
+	}
+	key, err := newSigningKey(signer)
+	if err != nil {
+		return err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	now := time.Now()
+	if km.active != nil {
+		km.active.RetireAt = now.Add(km.overlap)
+	}
+	km.active = key
+	km.keys[key.ID] = key
+
+	// Forget keys whose overlap window has passed
+	for id, k := range km.keys {
+		if !k.RetireAt.IsZero() && now.After(k.RetireAt) {
+			delete(km.keys, id)
+		}
+	}
+	return nil
+}
+
+// StartRotation rotates the signing key every interval until the returned stop function is called.
+func (km *KeyManager) StartRotation(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := km.Rotate(); err != nil {
+					logger.Error("Failed to rotate signing key", "error", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// ActiveKey returns the key new tokens are signed with.
+func (km *KeyManager) ActiveKey() (*SigningKey, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if km.active == nil {
+		return nil, errors.New("no signing key available")
+	}
+	return km.active, nil
+}
+
+// VerificationKey returns the public key for a key ID, provided the key has not been retired.
+func (km *KeyManager) VerificationKey(kid string) (*SigningKey, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	key, ok := km.keys[kid]
+	if !ok || (!key.RetireAt.IsZero() && time.Now().After(key.RetireAt)) {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// JWKS returns the public keys that are currently accepted for verification.
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	now := time.Now()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range km.keys {
+		if !key.RetireAt.IsZero() && now.After(key.RetireAt) {
+			continue
+		}
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
+
+// Sign signs the claims with the active key and writes its key ID into the token header.
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	key, err := km.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+	method, _ := signingMethod(key.Algorithm)
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header. The algorithm in the header must match the key, which rules out algorithm confusion attacks.
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := km.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// JWK returns the public part of the key.
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+	switch pub := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	}
+	return jwk
+}
+
+func newSigningKey(signer crypto.Signer) (*SigningKey, error) {
+	alg, err := algorithmFor(signer)
+	if err != nil {
+		return nil, err
+	}
+	key := &SigningKey{Algorithm: alg, Private: signer, CreatedAt: time.Now()}
+	key.ID = thumbprint(key.JWK())
+	return key, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, which is used as the key ID.
+func thumbprint(jwk JWK) string {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func algorithmFor(signer crypto.Signer) (string, error) {
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return "", errors.New("RSA keys must be at least 2048 bits")
+		}
+		return AlgRS256, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", errors.New("only P-256 EC keys are supported")
+		}
+		return AlgES256, nil
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
+	}
+	return "", errors.New("unsupported key type")
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgES256:
+		return jwt.SigningMethodES256, nil
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported key type")
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// writePEM - Write a private key in PKCS#8 to a file of the test's temporary directory
+func writePEM(t *testing.T, signer crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// publicKeyOf - Rebuild the public key of a JWK the way a verifier reading the JWKS would
+func publicKeyOf(t *testing.T, jwk JWK) crypto.PublicKey {
+	t.Helper()
+	decode := func(value string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatalf("%q is not base64url without padding: %v", value, err)
+		}
+		return data
+	}
+	switch {
+	case jwk.Kty == "RSA" && jwk.Alg == AlgRS256:
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(decode(jwk.N)), E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64())}
+	case jwk.Kty == "EC" && jwk.Alg == AlgES256 && jwk.Crv == "P-256":
+		x, y := decode(jwk.X), decode(jwk.Y)
+		if len(x) != 32 || len(y) != 32 {
+			t.Fatalf("coordinates of %d and %d bytes, want 32", len(x), len(y))
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case jwk.Kty == "OKP" && jwk.Alg == AlgEdDSA && jwk.Crv == "Ed25519":
+		return ed25519.PublicKey(decode(jwk.X))
+	}
+	t.Fatalf("unexpected JWK %+v", jwk)
+	return nil
+}
+
+// verify - Parse a token with the key manager, returning why it was rejected
+func verify(km *KeyManager, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, km.Keyfunc)
+	return err
+}
+
+func sign(t *testing.T, km *KeyManager) string {
+	t.Helper()
+	token, err := km.Sign(&jwt.StandardClaims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeysSignWithTheirAlgorithm(t *testing.T) {
+	rsaKey, _ := generateKey(AlgRS256)
+	ecKey, _ := generateKey(AlgES256)
+	edKey, _ := generateKey(AlgEdDSA)
+
+	for name, test := range map[string]struct {
+		configured string
+		load       crypto.Signer
+		want       string
+	}{
+		"generated RS256": {configured: AlgRS256, want: AlgRS256},
+		"generated ES256": {configured: AlgES256, want: AlgES256},
+		"generated EdDSA": {configured: AlgEdDSA, want: AlgEdDSA},
+		"RSA file":        {configured: AlgEdDSA, load: rsaKey, want: AlgRS256},
+		"EC file":         {configured: AlgRS256, load: ecKey, want: AlgES256},
+		"Ed25519 file":    {configured: AlgRS256, load: edKey, want: AlgEdDSA},
+	} {
+		t.Run(name, func(t *testing.T) {
+			km, err := NewKeyManager(test.configured, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.load != nil {
+				err = km.LoadPEMFile(writePEM(t, test.load))
+			} else {
+				err = km.Rotate()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			active, err := km.ActiveKey()
+			if err != nil || active.Algorithm != test.want {
+				t.Fatalf("active key %+v, %v, want %s", active, err, test.want)
+			}
+			token := sign(t, km)
+			parsed, _ := jwt.Parse(token, km.Keyfunc)
+			if parsed.Header["alg"] != test.want || parsed.Header["kid"] != active.ID {
+				t.Fatalf("header %v, want alg %s and kid %s", parsed.Header, test.want, active.ID)
+			}
+			if err := verify(km, token); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoadPEMFileRejectsWeakKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	km, _ := NewKeyManager(AlgRS256, 0)
+	for _, signer := range []crypto.Signer{weak, p384} {
+		if err := km.LoadPEMFile(writePEM(t, signer)); err == nil {
+			t.Errorf("loaded a %T", signer.Public())
+		}
+	}
+	if _, err := km.ActiveKey(); err == nil {
+		t.Fatal("a rejected key became the signing key")
+	}
+}
+
+func TestLoadPEMFileKeepsTheFirstKeySigning(t *testing.T) {
+	first, _ := generateKey(AlgES256)
+	second, _ := generateKey(AlgEdDSA)
+	km, _ := NewKeyManager(AlgRS256, 0)
+	for _, signer := range []crypto.Signer{first, second} {
+		if err := km.LoadPEMFile(writePEM(t, signer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	active, _ := km.ActiveKey()
+	if active.Algorithm != AlgES256 {
+		t.Fatalf("signing with %s, want the first key", active.Algorithm)
+	}
+	if keys := km.JWKS().Keys; len(keys) != 2 {
+		t.Fatalf("%d keys published, want both", len(keys))
+	}
+	// The same file always yields the same key ID, so that replicas loading it agree
+	again, _ := NewKeyManager(AlgRS256, 0)
+	if err := again.LoadPEMFile(writePEM(t, first)); err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := again.ActiveKey(); key.ID != active.ID {
+		t.Fatalf("key ID %s, want %s", key.ID, active.ID)
+	}
+}
+
+func TestRotationKeepsVerifyingDuringTheOverlap(t *testing.T) {
+	km, err := NewKeyManager(AlgES256, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if km.overlap != AccessTokenTTL {
+		t.Fatalf("overlap %v, want it raised to the access token lifetime %v", km.overlap, AccessTokenTTL)
+	}
+	if err := km.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := km.ActiveKey()
+	oldToken := sign(t, km)
+
+	before := time.Now()
+	if err := km.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := km.ActiveKey()
+	if current.ID == old.ID {
+		t.Fatal("rotation kept the signing key")
+	}
+	if retire := old.RetireAt.Sub(before); retire < AccessTokenTTL || retire > AccessTokenTTL+time.Minute {
+		t.Fatalf("old key retires in %v, want %v", retire, AccessTokenTTL)
+	}
+
+	// Within the overlap both keys verify and are published
+	if err := verify(km, oldToken); err != nil {
+		t.Fatalf("token of the rotated-out key: %v", err)
+	}
+	if err := verify(km, sign(t, km)); err != nil {
+		t.Fatal(err)
+	}
+	if keys := km.JWKS().Keys; len(keys) != 2 {
+		t.Fatalf("%d keys published during the overlap, want 2", len(keys))
+	}
+
+	// Past it the old key is neither accepted nor published, and the next rotation forgets it
+	km.mu.Lock()
+	old.RetireAt = time.Now().Add(-time.Second)
+	km.mu.Unlock()
+	if err := verify(km, oldToken); err == nil {
+		t.Fatal("token of a retired key accepted")
+	}
+	if keys := km.JWKS().Keys; len(keys) != 1 || keys[0].Kid != current.ID {
+		t.Fatalf("published %+v, want only the active key", keys)
+	}
+	if err := km.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, known := km.keys[old.ID]; known || len(km.keys) != 2 {
+		t.Fatalf("%d keys kept after the overlap, want the active and the previous one", len(km.keys))
+	}
+}
+
+func TestJWKSPublishesVerifiableKeys(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			km, _ := NewKeyManager(alg, 0)
+			if err := km.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			token := sign(t, km)
+
+			keys := km.JWKS().Keys
+			if len(keys) != 1 {
+				t.Fatalf("published %d keys", len(keys))
+			}
+			jwk := keys[0]
+			if jwk.Use != "sig" || jwk.Kid != thumbprint(jwk) {
+				t.Fatalf("JWK %+v, want use sig and its thumbprint as kid", jwk)
+			}
+			// A verifier using only the published key accepts the token
+			public := publicKeyOf(t, jwk)
+			if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil }); err != nil {
+				t.Fatalf("token rejected with the published key: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejectsOtherAlgorithms(t *testing.T) {
+	km, _ := NewKeyManager(AlgRS256, 0)
+	if err := km.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	active, _ := km.ActiveKey()
+	claims := &jwt.StandardClaims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	// HS256 keyed with the public key, the classic algorithm confusion attack
+	der, _ := x509.MarshalPKIXPublicKey(active.Private.Public())
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = active.ID
+	token, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(km, token); err == nil {
+		t.Fatal("HS256 token accepted")
+	}
+
+	// A token without a known kid
+	other, _ := NewKeyManager(AlgRS256, 0)
+	if err := other.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(km, sign(t, other)); err == nil {
+		t.Fatal("token of an unknown key accepted")
+	}
+}
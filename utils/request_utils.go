@@ -0,0 +1,35 @@
+package utils
+
+/**
+Helpers for the details of an HTTP request that are recorded in the audit log: the client IP address and the request ID.
+*/
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request. Clients and proxies may set it; otherwise RequestIDMiddleware generates one.
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDContextKey = contextKey("request_id")
+
+// This function stores the ID of the current request in the context.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// This function returns the ID of the current request, or an empty string if RequestIDMiddleware did not run.
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// This function returns the IP address of the client that sent the request, taken from the connection.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
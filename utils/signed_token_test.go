@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSigningKey = []byte("0123456789abcdef0123456789abcdef")
+
+type testPayload struct {
+	UserID uint   `json:"uid"`
+	Email  string `json:"email"`
+}
+
+func TestSignedTokenRoundTrip(t *testing.T) {
+	token, err := SignToken(testSigningKey, "email_verification", testPayload{UserID: 7, Email: "alice@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload testPayload
+	if err := VerifySignedToken(testSigningKey, "email_verification", token, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.UserID != 7 || payload.Email != "alice@example.com" {
+		t.Fatalf("payload %+v", payload)
+	}
+}
+
+// reencode - Change the envelope of a token and encode it again, keeping the original signature
+func reencode(t *testing.T, token string, change func(envelope *signedEnvelope)) string {
+	t.Helper()
+	body, sig, _ := strings.Cut(token, ".")
+	data, _ := base64.RawURLEncoding.DecodeString(body)
+	var envelope signedEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	change(&envelope)
+	data, _ = json.Marshal(envelope)
+	return base64.RawURLEncoding.EncodeToString(data) + "." + sig
+}
+
+func TestVerifySignedTokenRejectsTamperedTokens(t *testing.T) {
+	token, err := SignToken(testSigningKey, "email_verification", testPayload{UserID: 7, Email: "alice@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := SignToken(testSigningKey, "email_verification", testPayload{UserID: 1, Email: "mallory@example.com"}, time.Hour)
+	body, _, _ := strings.Cut(token, ".")
+	_, otherSig, _ := strings.Cut(other, ".")
+
+	for name, tampered := range map[string]string{
+		"other user": reencode(t, token, func(e *signedEnvelope) {
+			e.Payload = json.RawMessage(`{"uid":1,"email":"alice@example.com"}`)
+		}),
+		"other address": reencode(t, token, func(e *signedEnvelope) {
+			e.Payload = json.RawMessage(`{"uid":7,"email":"mallory@example.com"}`)
+		}),
+		"later expiry":     reencode(t, token, func(e *signedEnvelope) { e.ExpiresAt += 3600 }),
+		"other purpose":    reencode(t, token, func(e *signedEnvelope) { e.Purpose = "invitation" }),
+		"other signature":  body + "." + otherSig,
+		"no signature":     body,
+		"empty signature":  body + ".",
+		"invalid encoding": body + ".!!!",
+		"empty":            "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var payload testPayload
+			if err := VerifySignedToken(testSigningKey, "email_verification", tampered, &payload); !errors.Is(err, ErrInvalidSignedToken) {
+				t.Fatalf("error %v, want %v", err, ErrInvalidSignedToken)
+			}
+		})
+	}
+}
+
+func TestVerifySignedTokenChecksKeyPurposeAndExpiry(t *testing.T) {
+	token, err := SignToken(testSigningKey, "email_verification", testPayload{UserID: 7}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload testPayload
+	if err := VerifySignedToken([]byte("another key of thirty-two bytes!"), "email_verification", token, &payload); !errors.Is(err, ErrInvalidSignedToken) {
+		t.Fatalf("other key: error %v", err)
+	}
+	if err := VerifySignedToken(testSigningKey, "invitation", token, &payload); !errors.Is(err, ErrInvalidSignedToken) {
+		t.Fatalf("other purpose: error %v", err)
+	}
+
+	expired, err := SignToken(testSigningKey, "email_verification", testPayload{UserID: 7}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignedToken(testSigningKey, "email_verification", expired, &payload); !errors.Is(err, ErrInvalidSignedToken) {
+		t.Fatalf("expired: error %v", err)
+	}
+	// A token is expired from the second it expires in
+	expiring, err := SignToken(testSigningKey, "email_verification", testPayload{UserID: 7}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignedToken(testSigningKey, "email_verification", expiring, &payload); !errors.Is(err, ErrInvalidSignedToken) {
+		t.Fatalf("expiring now: error %v", err)
+	}
+}
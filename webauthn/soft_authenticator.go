@@ -0,0 +1,151 @@
+package webauthn
+
+/**
+SoftAuthenticator is a software passkey authenticator holding ES256 credentials in memory. It produces the same JSON a browser returns from navigator.credentials.create/get, which lets tests and scripts run both ceremonies against the service without a browser or hardware key.
+*/
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+type softCredential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	userHandle string
+	signCount  uint32
+}
+
+type SoftAuthenticator struct {
+	Origin       string
+	UserVerified bool // Report user verification (the UV flag), as a platform authenticator with a PIN or biometric would
+
+	credentials []*softCredential
+}
+
+// NewSoftAuthenticator creates an authenticator that answers ceremonies for the given origin.
+func NewSoftAuthenticator(origin string) *SoftAuthenticator {
+	return &SoftAuthenticator{Origin: origin, UserVerified: true}
+}
+
+// Create answers registration options with a new ES256 credential and "none" attestation.
+func (a *SoftAuthenticator) Create(opts CreationOptions) (AttestationResponse, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return AttestationResponse{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return AttestationResponse{}, err
+	}
+	cred := &softCredential{id: id, key: key, userHandle: opts.User.ID}
+
+	coseKey, err := encodeCBOR(map[interface{}]interface{}{
+		1:  2,
+		3:  AlgES256,
+		-1: 1,
+		-2: padTo32(key.X.Bytes()),
+		-3: padTo32(key.Y.Bytes()),
+	})
+	if err != nil {
+		return AttestationResponse{}, err
+	}
+	attested := make([]byte, 16, 16+2+len(id)+len(coseKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey...)
+	authData := a.authenticatorData(opts.RP.ID, flagAttestedCredential, cred.signCount, attested)
+
+	attestation, err := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return AttestationResponse{}, err
+	}
+	clientData, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return AttestationResponse{}, err
+	}
+	a.credentials = append(a.credentials, cred)
+
+	var resp AttestationResponse
+	resp.ID = EncodeID(id)
+	resp.RawID = resp.ID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = EncodeID(clientData)
+	resp.Response.AttestationObject = EncodeID(attestation)
+	resp.Response.Transports = []string{"internal"}
+	return resp, nil
+}
+
+// Get answers login options with an assertion from the first matching credential. With no allowed credentials any credential for the RP works, like a discoverable passkey.
+func (a *SoftAuthenticator) Get(opts RequestOptions) (AssertionResponse, error) {
+	cred := a.find(opts.AllowCredentials)
+	if cred == nil {
+		return AssertionResponse{}, errors.New("softauthn: no matching credential")
+	}
+	cred.signCount++
+	authData := a.authenticatorData(opts.RPID, 0, cred.signCount, nil)
+	clientData, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return AssertionResponse{}, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return AssertionResponse{}, err
+	}
+
+	var resp AssertionResponse
+	resp.ID = EncodeID(cred.id)
+	resp.RawID = resp.ID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = EncodeID(clientData)
+	resp.Response.AuthenticatorData = EncodeID(authData)
+	resp.Response.Signature = EncodeID(sig)
+	resp.Response.UserHandle = cred.userHandle
+	return resp, nil
+}
+
+func (a *SoftAuthenticator) find(allow []CredentialDescriptor) *softCredential {
+	if len(allow) == 0 && len(a.credentials) > 0 {
+		return a.credentials[len(a.credentials)-1]
+	}
+	for _, descriptor := range allow {
+		for _, cred := range a.credentials {
+			if EncodeID(cred.id) == descriptor.ID {
+				return cred
+			}
+		}
+	}
+	return nil
+}
+
+func (a *SoftAuthenticator) authenticatorData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags |= flagUserPresent
+	if a.UserVerified {
+		flags |= flagUserVerified
+	}
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+func (a *SoftAuthenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.Origin})
+}
+
+func padTo32(b []byte) []byte {
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return out
+}
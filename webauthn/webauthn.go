@@ -0,0 +1,539 @@
+package webauthn
+
+/**
+The webauthn package implements the relying party side of the WebAuthn registration and assertion ceremonies (https://www.w3.org/TR/webauthn-2/) for passkeys. It creates the options handed to navigator.credentials.create/get and verifies what the browser sends back: client data, authenticator data, "none" and "packed" attestation, and assertion signatures for ES256, EdDSA and RS256 credentials.
+*/
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// COSE algorithm identifiers of the supported credential keys
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagBackupEligible     = 0x08
+	flagBackupState        = 0x10
+	flagAttestedCredential = 0x40
+)
+
+var (
+	ErrChallengeMismatch = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch    = errors.New("webauthn: origin not allowed")
+	ErrRPIDMismatch      = errors.New("webauthn: relying party ID mismatch")
+	ErrUserNotPresent    = errors.New("webauthn: user presence flag not set")
+	ErrUserNotVerified   = errors.New("webauthn: user verification required")
+	ErrBadSignature      = errors.New("webauthn: signature verification failed")
+	ErrSignCount         = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+)
+
+// Config describes the relying party.
+type Config struct {
+	RPID                    string   // Domain the credentials are scoped to, e.g. "example.com"
+	RPName                  string   // Human readable name shown by the authenticator
+	Origins                 []string // Allowed origins, e.g. "https://app.example.com"
+	Timeout                 time.Duration
+	RequireUserVerification bool
+}
+
+// Credential is what the relying party stores about a registered passkey.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE encoded public key
+	Algorithm      int
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	UserVerified   bool
+	BackupEligible bool
+	BackupState    bool
+}
+
+// UserEntity identifies the account a passkey is created for. ID is an opaque user handle.
+type UserEntity struct {
+	ID          string `json:"id"` // base64url
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialDescriptor references an existing credential.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"` // base64url
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CreationOptions are passed to navigator.credentials.create({publicKey: ...}).
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User             UserEntity `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+// RequestOptions are passed to navigator.credentials.get({publicKey: ...}).
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the JSON form (PublicKeyCredential.toJSON()) of a newly created credential.
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form (PublicKeyCredential.toJSON()) of an assertion.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	raw          []byte
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewCreationOptions builds registration options with a fresh challenge. Existing credentials of the user are excluded so that an authenticator is not registered twice.
+func (c *Config) NewCreationOptions(user UserEntity, exclude []CredentialDescriptor) (CreationOptions, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return CreationOptions{}, err
+	}
+	opts := CreationOptions{
+		Challenge:          challenge,
+		User:               user,
+		Timeout:            c.Timeout.Milliseconds(),
+		Attestation:        "none",
+		ExcludeCredentials: exclude,
+	}
+	if opts.ExcludeCredentials == nil {
+		opts.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	opts.RP.ID = c.RPID
+	opts.RP.Name = c.RPName
+	for _, alg := range []int{AlgES256, AlgEdDSA, AlgRS256} {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = c.userVerification()
+	return opts, nil
+}
+
+// NewRequestOptions builds login options with a fresh challenge. Without allowed credentials the authenticator offers any discoverable passkey for the relying party.
+func (c *Config) NewRequestOptions(allow []CredentialDescriptor) (RequestOptions, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return RequestOptions{}, err
+	}
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          c.Timeout.Milliseconds(),
+		RPID:             c.RPID,
+		AllowCredentials: allow,
+		UserVerification: c.userVerification(),
+	}, nil
+}
+
+// VerifyRegistration checks a registration response against the challenge of its ceremony and returns the new credential.
+func (c *Config) VerifyRegistration(resp AttestationResponse, challenge string) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, errors.New("webauthn: unexpected credential type")
+	}
+	rawClientData, err := decodeB64(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyClientData(rawClientData, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := decodeB64(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	item, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: malformed attestation object")
+	}
+	format, _ := attestation["fmt"].(string)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredential == 0 {
+		return nil, errors.New("webauthn: attested credential data missing")
+	}
+	rawID, err := decodeB64(resp.RawID)
+	if err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return nil, errors.New("webauthn: credential ID mismatch")
+	}
+
+	pub, alg, err := parseCOSEKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, errors.New("webauthn: none attestation must have an empty statement")
+		}
+	case "packed":
+		if err := verifyPackedAttestation(statement, signed, pub, alg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported attestation format %q", format)
+	}
+
+	return &Credential{
+		ID:             authData.credentialID,
+		PublicKey:      authData.publicKey,
+		Algorithm:      alg,
+		SignCount:      authData.signCount,
+		AAGUID:         authData.aaguid,
+		Transports:     resp.Response.Transports,
+		UserVerified:   authData.flags&flagUserVerified != 0,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+		BackupState:    authData.flags&flagBackupState != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a login response against the challenge of its ceremony and the stored credential. It returns the credential with the updated signature counter and flags.
+func (c *Config) VerifyAssertion(resp AssertionResponse, challenge string, stored Credential) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, errors.New("webauthn: unexpected credential type")
+	}
+	rawClientData, err := decodeB64(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyClientData(rawClientData, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := decodeB64(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	sig, err := decodeB64(resp.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+	pub, alg, err := parseCOSEKey(stored.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := verifySignature(pub, alg, signed, sig); err != nil {
+		return nil, err
+	}
+
+	// Authenticators that keep a counter must increase it on every use (section 6.1.1)
+	if (authData.signCount != 0 || stored.SignCount != 0) && authData.signCount <= stored.SignCount {
+		return nil, ErrSignCount
+	}
+
+	updated := stored
+	updated.SignCount = authData.signCount
+	updated.UserVerified = authData.flags&flagUserVerified != 0
+	updated.BackupState = authData.flags&flagBackupState != 0
+	return &updated, nil
+}
+
+// CredentialID decodes the credential ID of a response.
+func CredentialID(rawID string) ([]byte, error) {
+	return decodeB64(rawID)
+}
+
+// EncodeID encodes credential IDs and user handles the way they appear in options and responses.
+func EncodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// DecodeID decodes a base64url value, with or without padding.
+func DecodeID(value string) ([]byte, error) {
+	return decodeB64(value)
+}
+
+func (c *Config) userVerification() string {
+	if c.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+func (c *Config) verifyClientData(raw []byte, ceremony, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return errors.New("webauthn: malformed client data")
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("webauthn: unexpected client data type %q", data.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(challenge)) != 1 {
+		return ErrChallengeMismatch
+	}
+	if data.CrossOrigin {
+		return ErrOriginMismatch
+	}
+	for _, origin := range c.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return ErrOriginMismatch
+}
+
+func (c *Config) verifyAuthenticatorData(data *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+	if data.flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+	if c.RequireUserVerification && data.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	data := &authenticatorData{
+		raw:       raw,
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.flags&flagAttestedCredential == 0 {
+		return data, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: attested credential data too short")
+	}
+	data.aaguid = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.New("webauthn: credential ID truncated")
+	}
+	data.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	_, remaining, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, err
+	}
+	data.publicKey = rest[:len(rest)-len(remaining)]
+	return data, nil
+}
+
+// parseCOSEKey converts a COSE_Key (RFC 9053) into a Go public key.
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int, error) {
+	item, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	key, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("webauthn: malformed COSE key")
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	crv, _ := key[int64(-1)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256 && crv == 1:
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("webauthn: malformed EC2 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("webauthn: EC2 point not on curve")
+		}
+		return pub, AlgES256, nil
+	case kty == 1 && alg == AlgEdDSA && crv == 6:
+		x, _ := key[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("webauthn: malformed OKP key")
+		}
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("webauthn: malformed RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, AlgRS256, nil
+	}
+	return nil, 0, fmt.Errorf("webauthn: unsupported COSE key (kty %d, alg %d)", kty, alg)
+}
+
+func verifySignature(pub crypto.PublicKey, alg int, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	switch alg {
+	case AlgES256:
+		if key, ok := pub.(*ecdsa.PublicKey); ok && ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	case AlgEdDSA:
+		if key, ok := pub.(ed25519.PublicKey); ok && ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	case AlgRS256:
+		if key, ok := pub.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// verifyPackedAttestation verifies a "packed" statement (section 8.2). With an x5c chain the signature is checked against the attestation certificate; the chain itself is not checked against a trust store since the service requests no attestation. Without x5c the statement is a self attestation signed by the credential key.
+func verifyPackedAttestation(statement map[interface{}]interface{}, signed []byte, credKey crypto.PublicKey, credAlg int) error {
+	alg, _ := statement["alg"].(int64)
+	sig, _ := statement["sig"].([]byte)
+	if len(sig) == 0 {
+		return errors.New("webauthn: packed attestation without signature")
+	}
+
+	chain, hasChain := statement["x5c"].([]interface{})
+	if !hasChain {
+		if int(alg) != credAlg {
+			return errors.New("webauthn: self attestation algorithm mismatch")
+		}
+		return verifySignature(credKey, credAlg, signed, sig)
+	}
+
+	if len(chain) == 0 {
+		return errors.New("webauthn: empty attestation certificate chain")
+	}
+	der, _ := chain[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	if cert.IsCA {
+		return errors.New("webauthn: attestation certificate must not be a CA")
+	}
+	return verifySignature(cert.PublicKey, int(alg), signed, sig)
+}
+
+func newChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeB64(value string) ([]byte, error) {
+	value = strings.TrimRight(value, "=")
+	if b, err := base64.RawURLEncoding.DecodeString(value); err == nil {
+		return b, nil
+	}
+	b, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("webauthn: invalid base64 value")
+	}
+	return b, nil
+}
@@ -0,0 +1,190 @@
+package webauthn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const testOrigin = "https://app.example.com"
+
+func testConfig() *Config {
+	return &Config{RPID: "example.com", RPName: "Example", Origins: []string{testOrigin}, Timeout: time.Minute}
+}
+
+// register - Run a registration ceremony with the authenticator and return the verified credential
+func register(t *testing.T, c *Config, a *SoftAuthenticator) *Credential {
+	t.Helper()
+	opts, err := c.NewCreationOptions(UserEntity{ID: EncodeID([]byte("user-1")), Name: "alice"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Create(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := c.VerifyRegistration(resp, opts.Challenge)
+	if err != nil {
+		t.Fatalf("verify registration: %v", err)
+	}
+	return cred
+}
+
+// assert - Get an assertion of the credential from the authenticator and return it with its challenge
+func assert(t *testing.T, c *Config, a *SoftAuthenticator, cred *Credential) (AssertionResponse, string) {
+	t.Helper()
+	opts, err := c.NewRequestOptions([]CredentialDescriptor{{Type: "public-key", ID: EncodeID(cred.ID)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Get(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, opts.Challenge
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	c := testConfig()
+	a := NewSoftAuthenticator(testOrigin)
+	cred := register(t, c, a)
+	if cred.Algorithm != AlgES256 || !cred.UserVerified || cred.SignCount != 0 {
+		t.Fatalf("registered credential %+v", cred)
+	}
+
+	for want := uint32(1); want <= 2; want++ {
+		resp, challenge := assert(t, c, a, cred)
+		updated, err := c.VerifyAssertion(resp, challenge, *cred)
+		if err != nil {
+			t.Fatalf("verify assertion %d: %v", want, err)
+		}
+		if updated.SignCount != want {
+			t.Fatalf("sign count %d, want %d", updated.SignCount, want)
+		}
+		cred = updated
+	}
+}
+
+func TestRegistrationRejectsForeignCeremonies(t *testing.T) {
+	c := testConfig()
+	tests := []struct {
+		name   string
+		origin string
+		change func(*CreationOptions) string // Changes the options before the authenticator sees them; returns the expected challenge
+		want   error
+	}{
+		{"wrong origin", "https://evil.example.net", nil, ErrOriginMismatch},
+		{"wrong RP ID", testOrigin, func(o *CreationOptions) string { o.RP.ID = "evil.example.net"; return o.Challenge }, ErrRPIDMismatch},
+		{"wrong challenge", testOrigin, func(o *CreationOptions) string { sent := o.Challenge; o.Challenge = "b3RoZXI"; return sent }, ErrChallengeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := c.NewCreationOptions(UserEntity{ID: EncodeID([]byte("user-1")), Name: "alice"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge := opts.Challenge
+			if tt.change != nil {
+				challenge = tt.change(&opts)
+			}
+			resp, err := NewSoftAuthenticator(tt.origin).Create(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.VerifyRegistration(resp, challenge); !errors.Is(err, tt.want) {
+				t.Fatalf("error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionRejectsBadSignature(t *testing.T) {
+	c := testConfig()
+	a := NewSoftAuthenticator(testOrigin)
+	cred := register(t, c, a)
+	other := register(t, c, a)
+
+	// An assertion of another credential does not verify with the stored key
+	resp, challenge := assert(t, c, a, other)
+	if _, err := c.VerifyAssertion(resp, challenge, *cred); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("assertion by another key: error %v, want %v", err, ErrBadSignature)
+	}
+
+	// Raising the signature counter after signing breaks the signature
+	resp, challenge = assert(t, c, a, cred)
+	authData, _ := DecodeID(resp.Response.AuthenticatorData)
+	authData[36]++
+	resp.Response.AuthenticatorData = EncodeID(authData)
+	if _, err := c.VerifyAssertion(resp, challenge, *cred); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered authenticator data: error %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestAssertionRejectsCounterRegression(t *testing.T) {
+	c := testConfig()
+	a := NewSoftAuthenticator(testOrigin)
+	cred := register(t, c, a)
+
+	resp, challenge := assert(t, c, a, cred)
+	updated, err := c.VerifyAssertion(resp, challenge, *cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same assertion replayed against the updated credential does not increase the counter
+	if _, err := c.VerifyAssertion(resp, challenge, *updated); !errors.Is(err, ErrSignCount) {
+		t.Fatalf("replayed assertion: error %v, want %v", err, ErrSignCount)
+	}
+
+	// A clone of the authenticator lags behind the counter the relying party has seen
+	ahead := *updated
+	ahead.SignCount = 10
+	resp, challenge = assert(t, c, a, cred)
+	if _, err := c.VerifyAssertion(resp, challenge, ahead); !errors.Is(err, ErrSignCount) {
+		t.Fatalf("counter regression: error %v, want %v", err, ErrSignCount)
+	}
+}
+
+func TestAssertionRejectsForeignCeremonies(t *testing.T) {
+	c := testConfig()
+	a := NewSoftAuthenticator(testOrigin)
+	cred := register(t, c, a)
+
+	a.Origin = "https://evil.example.net"
+	resp, challenge := assert(t, c, a, cred)
+	if _, err := c.VerifyAssertion(resp, challenge, *cred); !errors.Is(err, ErrOriginMismatch) {
+		t.Fatalf("wrong origin: error %v, want %v", err, ErrOriginMismatch)
+	}
+	a.Origin = testOrigin
+
+	opts, err := c.NewRequestOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.RPID = "evil.example.net"
+	resp, err = a.Get(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyAssertion(resp, opts.Challenge, *cred); !errors.Is(err, ErrRPIDMismatch) {
+		t.Fatalf("wrong RP ID: error %v, want %v", err, ErrRPIDMismatch)
+	}
+
+	resp, _ = assert(t, c, a, cred)
+	_, otherChallenge := assert(t, c, a, cred)
+	if _, err := c.VerifyAssertion(resp, otherChallenge, *cred); !errors.Is(err, ErrChallengeMismatch) {
+		t.Fatalf("wrong challenge: error %v, want %v", err, ErrChallengeMismatch)
+	}
+}
+
+func TestAssertionRequiresUserVerification(t *testing.T) {
+	c := testConfig()
+	c.RequireUserVerification = true
+	a := NewSoftAuthenticator(testOrigin)
+	cred := register(t, c, a)
+
+	a.UserVerified = false
+	resp, challenge := assert(t, c, a, cred)
+	if _, err := c.VerifyAssertion(resp, challenge, *cred); !errors.Is(err, ErrUserNotVerified) {
+		t.Fatalf("error %v, want %v", err, ErrUserNotVerified)
+	}
+}